@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// alertLogKeep is how many rotated alert logs are kept
+const alertLogKeep = 5
+
+// alertLogEntry is one line of the alert log
+type alertLogEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Host      string    `json:"host"`
+	GPU       int       `json:"gpu"`
+	Alias     string    `json:"alias,omitempty"`
+	Rule      string    `json:"rule"`
+	Metric    string    `json:"metric"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Severity  string    `json:"severity"`
+	State     string    `json:"state"`
+}
+
+// alertLog appends alert transitions to a rotating JSON lines file from a
+// background goroutine, so a slow disk never holds up the sampler.
+// Critical entries are synced to disk before the next one is written.
+type alertLog struct {
+	file  *rotatingFile
+	queue chan alertLogEntry
+	done  chan struct{}
+	errCh chan error
+}
+
+func openAlertLog(path string, maxMB int) (*alertLog, error) {
+	file, err := openRotatingFile(path, nil, int64(maxMB)*1024*1024, alertLogKeep)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open alert log: %v", err)
+	}
+	l := &alertLog{
+		file:  file,
+		queue: make(chan alertLogEntry, 256),
+		done:  make(chan struct{}),
+		errCh: make(chan error, 1),
+	}
+	go l.run()
+	return l, nil
+}
+
+func (l *alertLog) add(e alertLogEntry) {
+	select {
+	case l.queue <- e:
+	default:
+		debugLog.Warn("alert log queue full, dropping entry", "rule", e.Rule, "gpu", e.GPU)
+	}
+}
+
+// err returns a write failure once, when writing starts failing; it stays
+// quiet until a write has succeeded again
+func (l *alertLog) err() error {
+	select {
+	case err := <-l.errCh:
+		return err
+	default:
+		return nil
+	}
+}
+
+func (l *alertLog) run() {
+	defer close(l.done)
+	failing := false
+	for e := range l.queue {
+		line, err := json.Marshal(e)
+		if err == nil {
+			err = l.file.writeLine(line)
+		}
+		if err == nil && e.Severity == "critical" {
+			err = l.file.file.Sync()
+		}
+		if err != nil {
+			debugLog.Warn("alert log write failed", "err", err)
+			if !failing {
+				select {
+				case l.errCh <- fmt.Errorf("alert log write failed: %v", err):
+				default:
+				}
+			}
+		}
+		failing = err != nil
+	}
+}
+
+// close writes the queued entries and closes the file
+func (l *alertLog) close() error {
+	close(l.queue)
+	<-l.done
+	return l.file.close()
+}
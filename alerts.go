@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// alertMetrics maps rule metric names to GPUMetrics fields
+var alertMetrics = map[string]func(GPUMetrics) float64{
+	"gfx_util":  func(m GPUMetrics) float64 { return m.GFXUtil },
+	"power":     func(m GPUMetrics) float64 { return m.Power },
+	"gpu_temp":  func(m GPUMetrics) float64 { return m.GPUTemp },
+	"mem_temp":  func(m GPUMetrics) float64 { return m.MemTemp },
+	"gfx_clock": func(m GPUMetrics) float64 { return m.GFXClock },
+	"mem_util":  func(m GPUMetrics) float64 { return m.MemUtil },
+	"mem_clock": func(m GPUMetrics) float64 { return m.MemClock },
+	"vram_used": func(m GPUMetrics) float64 { return m.VRAMUsed },
+	"vram_percent": func(m GPUMetrics) float64 {
+		if m.VRAMTotal == 0 {
+			return 0
+		}
+		return m.VRAMUsed / m.VRAMTotal * 100
+	},
+}
+
+var alertOps = map[string]func(a, b float64) bool{
+	">":  func(a, b float64) bool { return a > b },
+	">=": func(a, b float64) bool { return a >= b },
+	"<":  func(a, b float64) bool { return a < b },
+	"<=": func(a, b float64) bool { return a <= b },
+}
+
+// AlertEvent is the JSON payload POSTed to the webhook. Text makes the
+// message readable in Slack and Teams incoming webhooks.
+type AlertEvent struct {
+	Text      string    `json:"text"`
+	Hostname  string    `json:"hostname"`
+	GPU       int       `json:"gpu"`
+	Alias     string    `json:"alias,omitempty"`
+	Rule      string    `json:"rule"`
+	Metric    string    `json:"metric"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Severity  string    `json:"severity"`
+	State     string    `json:"state"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// alertNotifier delivers alert events without blocking the sampler
+type alertNotifier interface {
+	send(AlertEvent)
+	// err reports a delivery problem that should be shown to the user
+	err() error
+	close()
+}
+
+type alertKey struct {
+	host string
+	rule int
+	gpu  int
+}
+
+// alertPhase is where a rule stands for one GPU: ok, pending while its
+// condition holds for less than the rule's sustain duration, then firing
+// until the value is back past the clear threshold, which resolves it
+// back to ok
+type alertPhase int
+
+const (
+	alertOK alertPhase = iota
+	alertPending
+	alertFiring
+)
+
+type alertState struct {
+	phase        alertPhase
+	since        time.Time // when the condition started holding, or the rule fired
+	value        float64   // the latest value, for the UI
+	lastNotified time.Time
+}
+
+// step advances the state with the rule's value at t. It returns the
+// transition to report, named for the phase entered: "pending" when the
+// condition starts holding, "ok" when it stops before the sustain duration,
+// "firing", "resolved" when a firing rule clears, or "" for none. Without a
+// sustain duration the rule fires at once and only "firing" is reported. A
+// firing rule only resolves once the value is past the clear threshold and
+// it has fired for at least the rule's min_firing, so a value hovering at
+// the threshold doesn't flap.
+func (st *alertState) step(rule AlertRule, value float64, t time.Time) string {
+	op := alertOps[rule.Op]
+	switch st.phase {
+	case alertFiring:
+		if op(value, *rule.ClearThreshold) || t.Sub(st.since) < rule.MinFiring {
+			return ""
+		}
+		st.phase = alertOK
+		return "resolved"
+	case alertPending:
+		if !op(value, rule.Threshold) {
+			st.phase = alertOK
+			return "ok"
+		}
+		if t.Sub(st.since) < rule.Sustain {
+			return ""
+		}
+	default:
+		if !op(value, rule.Threshold) {
+			return ""
+		}
+		st.phase, st.since = alertPending, t
+		if rule.Sustain > 0 {
+			return "pending"
+		}
+	}
+	st.phase, st.since = alertFiring, t
+	return "firing"
+}
+
+// Alerter evaluates the configured rules against every sample and sends
+// notifications when a rule starts (or stops) firing. It belongs to the
+// goroutine writing the sinks, which also asks it what is firing.
+type Alerter struct {
+	rules     []AlertRule
+	host      string
+	states    map[alertKey]*alertState
+	notifiers []alertNotifier
+	log       *alertLog // every transition, nil without alerts.log_path
+	bell      BellConfig
+	lastBell  map[int]time.Time // by rule, whichever GPU rang it
+	bells     bellRequest       // rung by the UI once no input is open
+}
+
+func newAlerter(cfg AlertsConfig, desktop bool) (*Alerter, error) {
+	a := &Alerter{
+		rules:    cfg.Rules,
+		bell:     cfg.Bell,
+		lastBell: make(map[int]time.Time),
+		host:     hostname(),
+		states:   make(map[alertKey]*alertState),
+	}
+	if cfg.LogPath != "" {
+		var err error
+		if a.log, err = openAlertLog(cfg.LogPath, cfg.LogMaxMB); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.WebhookURL != "" {
+		a.notifiers = append(a.notifiers, newWebhookNotifier(cfg.WebhookURL))
+	}
+	if desktop {
+		a.notifiers = append(a.notifiers, newDesktopNotifier())
+	}
+	return a, nil
+}
+
+func (a *Alerter) Write(s Sample) error {
+	for i, global := range a.rules {
+		for _, m := range s.GPUs {
+			rule := global.forGPU(m.ID, m.BDF, m.ASIC)
+			// A card without HBM has no memory temperature to compare
+			if rule.Metric == "mem_temp" && !m.hasMemTemp() {
+				continue
+			}
+			key := alertKey{host: s.Host, rule: i, gpu: m.ID}
+			state, ok := a.states[key]
+			if !ok {
+				state = &alertState{}
+				a.states[key] = state
+			}
+			value := alertMetrics[rule.Metric](m)
+			state.value = value
+			transition := state.step(rule, value, s.Time)
+			if transition == "" {
+				continue
+			}
+			debugLog.Info("alert "+transition, "host", s.Host, "gpu", m.ID, "rule", rule.Name, "value", value, "severity", rule.Severity)
+			if a.log != nil {
+				a.log.add(alertLogEntry{Timestamp: zoned(s.Time), Host: sampleHost(s, a.host), GPU: m.ID, Alias: m.Alias, Rule: rule.Name,
+					Metric: rule.Metric, Value: value, Threshold: rule.Threshold, Severity: rule.Severity, State: transition})
+			}
+			// Only firing and resolving ring the bell and notify
+			if transition != "firing" && transition != "resolved" {
+				continue
+			}
+			firing := transition == "firing"
+			if mode := a.bell.mode(rule.Severity); firing && mode != bellNone && s.Time.Sub(a.lastBell[i]) >= rule.Cooldown {
+				a.lastBell[i] = s.Time
+				a.bells.add(mode)
+			}
+			if !firing && !rule.NotifyResolved {
+				continue
+			}
+			// The cooldown only limits repeated firing notifications
+			if firing && s.Time.Sub(state.lastNotified) < rule.Cooldown {
+				continue
+			}
+			if firing {
+				state.lastNotified = s.Time
+			}
+			a.notify(sampleHost(s, a.host), rule, m.GPUDevice, value, firing, s.Time)
+		}
+	}
+	var first error
+	if a.log != nil {
+		first = a.log.err()
+	}
+	for _, n := range a.notifiers {
+		if err := n.err(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// takeBells returns the bells rung since the last call
+func (a *Alerter) takeBells() bellRequest {
+	b := a.bells
+	a.bells = bellRequest{}
+	return b
+}
+
+// firingAlert is a rule firing on a GPU, as the UI shows it
+type firingAlert struct {
+	rule  AlertRule
+	value float64
+}
+
+// firing lists the rules firing on a host's GPU, in config order
+func (a *Alerter) firing(host string, gpu int) []firingAlert {
+	var out []firingAlert
+	for i, rule := range a.rules {
+		if st := a.states[alertKey{host: host, rule: i, gpu: gpu}]; st != nil && st.phase == alertFiring {
+			out = append(out, firingAlert{rule: rule, value: st.value})
+		}
+	}
+	return out
+}
+
+func (a *Alerter) notify(host string, rule AlertRule, gpu GPUDevice, value float64, firing bool, t time.Time) {
+	state := "resolved"
+	if firing {
+		state = "firing"
+	}
+	e := AlertEvent{
+		Text: fmt.Sprintf("[%s] %s %s: %s %s %s %g (now %.1f)",
+			state, host, gpu.name(), rule.Name, rule.Metric, rule.Op, rule.Threshold, value),
+		Hostname:  host,
+		GPU:       gpu.ID,
+		Alias:     gpu.Alias,
+		Rule:      rule.Name,
+		Metric:    rule.Metric,
+		Value:     value,
+		Threshold: rule.Threshold,
+		Severity:  rule.Severity,
+		State:     state,
+		Timestamp: zoned(t),
+	}
+	for _, n := range a.notifiers {
+		n.send(e)
+	}
+}
+
+func (a *Alerter) Close() error {
+	for _, n := range a.notifiers {
+		n.close()
+	}
+	if a.log != nil {
+		return a.log.close()
+	}
+	return nil
+}
+
+const webhookRetries = 3
+
+// webhookNotifier POSTs alert events from a background goroutine
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+	queue  chan AlertEvent
+	done   chan struct{}
+	errCh  chan error
+	last   error
+}
+
+func newWebhookNotifier(url string) *webhookNotifier {
+	w := &webhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan AlertEvent, 64),
+		done:   make(chan struct{}),
+		errCh:  make(chan error, 1),
+	}
+	go w.run()
+	return w
+}
+
+func (w *webhookNotifier) send(e AlertEvent) {
+	select {
+	case w.queue <- e:
+	default:
+		debugLog.Warn("webhook queue full, dropping alert", "rule", e.Rule, "gpu", e.GPU)
+	}
+}
+
+// err returns the outcome of the most recent delivery
+func (w *webhookNotifier) err() error {
+	select {
+	case w.last = <-w.errCh:
+	default:
+	}
+	return w.last
+}
+
+func (w *webhookNotifier) run() {
+	defer close(w.done)
+	for e := range w.queue {
+		err := w.post(e)
+		if err != nil {
+			debugLog.Warn("webhook failed", "err", err)
+		}
+		// Keep only the latest result
+		select {
+		case <-w.errCh:
+		default:
+		}
+		w.errCh <- err
+	}
+}
+
+func (w *webhookNotifier) post(e AlertEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode/100 == 2 {
+				return nil
+			}
+			err = fmt.Errorf("status %s", resp.Status)
+		}
+		if attempt == webhookRetries-1 {
+			return fmt.Errorf("alert webhook failed: %v", err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// close delivers queued alerts, waiting at most a few seconds
+func (w *webhookNotifier) close() {
+	close(w.queue)
+	select {
+	case <-w.done:
+	case <-time.After(5 * time.Second):
+	}
+}
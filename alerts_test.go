@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAlertStateStep(t *testing.T) {
+	clearAt := func(v float64) *float64 { return &v }
+	hot := AlertRule{Name: "hot", Metric: "gpu_temp", Op: ">", Threshold: 90, ClearThreshold: clearAt(85), Sustain: 3 * time.Second}
+	for _, tc := range []struct {
+		name   string
+		rule   func(AlertRule) AlertRule
+		values []float64 // a second apart
+		want   []string
+	}{
+		{
+			name:   "pending, firing, resolved",
+			values: []float64{80, 95, 95, 95, 95, 88, 84, 80},
+			want:   []string{"", "pending", "", "", "firing", "", "resolved", ""},
+		},
+		{
+			name:   "pending back to ok",
+			values: []float64{95, 95, 80, 80, 95},
+			want:   []string{"pending", "", "ok", "", "pending"},
+		},
+		{
+			name:   "sustain restarts after ok",
+			values: []float64{95, 95, 80, 95, 95, 95, 95},
+			want:   []string{"pending", "", "ok", "pending", "", "", "firing"},
+		},
+		{
+			name:   "no sustain fires at once",
+			rule:   func(r AlertRule) AlertRule { r.Sustain = 0; return r },
+			values: []float64{80, 95, 80},
+			want:   []string{"", "firing", "resolved"},
+		},
+		{
+			// Below the threshold but above the clear threshold keeps firing
+			name:   "clear threshold hysteresis",
+			rule:   func(r AlertRule) AlertRule { r.Sustain = 0; return r },
+			values: []float64{91, 89, 91, 86, 85, 89, 91},
+			want:   []string{"firing", "", "", "", "resolved", "", "firing"},
+		},
+		{
+			name:   "min firing",
+			rule:   func(r AlertRule) AlertRule { r.Sustain, r.MinFiring = 0, 3*time.Second; return r },
+			values: []float64{95, 80, 80, 80, 80},
+			want:   []string{"firing", "", "", "resolved", ""},
+		},
+		{
+			name: "below threshold",
+			rule: func(r AlertRule) AlertRule {
+				r.Op, r.Threshold, r.ClearThreshold, r.Sustain = "<", 500, clearAt(525), time.Second
+				return r
+			},
+			values: []float64{600, 400, 400, 510, 530},
+			want:   []string{"", "pending", "firing", "", "resolved"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rule := hot
+			if tc.rule != nil {
+				rule = tc.rule(rule)
+			}
+			var st alertState
+			got := make([]string, len(tc.values))
+			for i, v := range tc.values {
+				got[i] = st.step(rule, v, t0.Add(time.Duration(i)*time.Second))
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("transitions %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// readAlertLog is the entries of an alert log file
+func readAlertLog(t *testing.T, path string) []alertLogEntry {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []alertLogEntry
+	for line := range strings.Lines(string(data)) {
+		var e alertLogEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("alert log line %q: %v", line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// gpuSample is a sample of GPUs 0 and 1 at temperatures a and b, the
+// seconds after t0
+func gpuSample(sec int, a, b float64) Sample {
+	return Sample{Host: "node1", Time: t0.Add(time.Duration(sec) * time.Second), GPUs: []GPUMetrics{
+		{GPUDevice: GPUDevice{ID: 0}, GPUTemp: a},
+		{GPUDevice: GPUDevice{ID: 1}, GPUTemp: b},
+	}}
+}
+
+func TestAlertLogPending(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.jsonl")
+	clearAt := 85.0
+	a, err := newAlerter(AlertsConfig{LogPath: path, Rules: []AlertRule{
+		{Name: "hot", Metric: "gpu_temp", Op: ">", Threshold: 90, ClearThreshold: &clearAt, Sustain: 2 * time.Second, Severity: "warning"},
+	}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GPU 0 gets hot enough to fire; GPU 1 cools off while pending
+	for _, s := range []Sample{gpuSample(0, 95, 95), gpuSample(1, 95, 80), gpuSample(2, 95, 80), gpuSample(3, 80, 80)} {
+		if err := a.Write(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	type logged struct {
+		sec   int
+		gpu   int
+		state string
+	}
+	var got []logged
+	for _, e := range readAlertLog(t, path) {
+		got = append(got, logged{int(e.Timestamp.Sub(t0) / time.Second), e.GPU, e.State})
+	}
+	want := []logged{{0, 0, "pending"}, {0, 1, "pending"}, {1, 1, "ok"}, {2, 0, "firing"}, {3, 0, "resolved"}}
+	if !slices.Equal(got, want) {
+		t.Errorf("logged %v, want %v", got, want)
+	}
+}
+
+// recordingNotifier keeps the events it is sent
+type recordingNotifier struct{ events []AlertEvent }
+
+func (n *recordingNotifier) send(e AlertEvent) { n.events = append(n.events, e) }
+func (n *recordingNotifier) err() error        { return nil }
+func (n *recordingNotifier) close()            {}
+
+// TestAlertReplay feeds a temperature hovering around the threshold through
+// a rule from a config file and checks the exact log and notifications
+func TestAlertReplay(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "alerts.jsonl")
+	configPath := filepath.Join(dir, "config.toml")
+	config := `[alerts]
+log_path = "` + logPath + `"
+
+[[alerts.rules]]
+name = "hot"
+metric = "gpu_temp"
+op = ">"
+threshold = 90
+sustain = "2s"
+min_firing = "3s"
+severity = "critical"
+cooldown = "10s"
+notify_resolved = true
+`
+	if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, _, err := loadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := newAlerter(cfg.Alerts, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier := &recordingNotifier{}
+	a.notifiers = append(a.notifiers, notifier)
+
+	// One sample a second; the clear threshold defaults to 85.5
+	series := []float64{89, 91, 90.5, 92, 88, 85, 85, 91, 89, 91, 91, 91, 80, 80, 80}
+	for i, v := range series {
+		s := Sample{Host: "node1", Time: t0.Add(time.Duration(i) * time.Second), GPUs: []GPUMetrics{{GPUDevice: GPUDevice{ID: 3}, GPUTemp: v}}}
+		if err := a.Write(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entry := func(sec int, state string) alertLogEntry {
+		return alertLogEntry{Timestamp: t0.Add(time.Duration(sec) * time.Second), Host: "node1", GPU: 3, Rule: "hot",
+			Metric: "gpu_temp", Value: series[sec], Threshold: 90, Severity: "critical", State: state}
+	}
+	want := []alertLogEntry{
+		entry(1, "pending"),
+		entry(3, "firing"),   // held for the 2s sustain
+		entry(6, "resolved"), // 88 is above the clear threshold, 85 at 5s too soon after firing
+		entry(7, "pending"),
+		entry(8, "ok"),
+		entry(9, "pending"),
+		entry(11, "firing"),
+		entry(14, "resolved"), // min_firing holds it until 14s
+	}
+	got := readAlertLog(t, logPath)
+	for i := range got {
+		got[i].Timestamp = got[i].Timestamp.UTC()
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("alert log:\n%v\nwant:\n%v", got, want)
+	}
+
+	// The second firing is within the cooldown of the first notification
+	var notified []string
+	for _, e := range notifier.events {
+		notified = append(notified, fmt.Sprintf("%s@%v", e.State, e.Timestamp.Sub(t0)))
+	}
+	if want := []string{"firing@3s", "resolved@6s", "resolved@14s"}; !slices.Equal(notified, want) {
+		t.Errorf("notified %v, want %v", notified, want)
+	}
+}
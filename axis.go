@@ -0,0 +1,79 @@
+package main
+
+import (
+	"image"
+	"strings"
+	"time"
+
+	ui "github.com/gizak/termui/v3"
+)
+
+// axisSteps are the spacings time axis ticks are rounded to
+var axisSteps = []time.Duration{
+	time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second, 15 * time.Second, 30 * time.Second,
+	time.Minute, 2 * time.Minute, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 2 * time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour, 24 * time.Hour,
+}
+
+// minTickGap is how many columns apart ticks are at least, so their labels
+// have room
+const minTickGap = 10
+
+// columnSpan is how much time one column of a chart stands for: a sample
+// interval, or several when the history is downsampled
+func columnSpan(interval time.Duration, history *GPUHistory, columns int) time.Duration {
+	if history.maxLen <= columns {
+		return interval
+	}
+	return interval * time.Duration(history.maxLen) / time.Duration(columns)
+}
+
+// axisLabel is an age as a tick label: -30s, -5m, -1h30m
+func axisLabel(age time.Duration) string {
+	if age == 0 {
+		return "now"
+	}
+	s := age.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return "-" + s
+}
+
+// drawTimeAxis labels the bottom border of a chart with how long ago its
+// columns were sampled, now being the column at newest. Each label ends
+// next to a ┴ at its column. On narrow charts ticks are spaced further
+// apart, and a label that would run into the next is left out.
+func drawTimeAxis(buf *ui.Buffer, rect image.Rectangle, left, newest int, span time.Duration) {
+	if span <= 0 {
+		return
+	}
+	// Whole hours past the longest step
+	step := (span * minTickGap).Truncate(time.Hour) + time.Hour
+	for _, s := range axisSteps {
+		if s >= span*minTickGap {
+			step = s
+			break
+		}
+	}
+	y := rect.Max.Y - 1
+	style := ui.NewStyle(ui.ColorWhite)
+	room := rect.Max.X - 1 // the first column of the label drawn last, or the corner
+	for age := time.Duration(0); ; age += step {
+		x := left + newest - int(age/span)
+		label := axisLabel(age)
+		start := x - len(label)
+		if start <= rect.Min.X {
+			return
+		}
+		if x >= room {
+			continue
+		}
+		buf.SetString(label, style, image.Pt(start, y))
+		buf.SetCell(ui.NewCell('┴', style), image.Pt(x, y))
+		room = start - 1
+	}
+}
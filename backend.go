@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// backendFailover is how many samples in a row the active backend may fail
+// before the next candidate is tried
+const backendFailover = 5
+
+// metricsBackend is a way of reading GPU metrics, probed before it is used
+type metricsBackend struct {
+	name    string
+	probe   func(r commandRunner) error
+	collect func(r commandRunner) (Sample, error)
+}
+
+// metricsBackends are the candidates in order of preference: amd-smi has
+// processes and everything else, sysfs only needs the driver
+var metricsBackends = []metricsBackend{
+	{"amd-smi", probeSMI, collectSample},
+	{"sysfs", probeSysfs, collectSysfs},
+}
+
+func probeSMI(r commandRunner) error {
+	_, err := runCommand(r, "amd-smi", "version")
+	return err
+}
+
+func probeSysfs(r commandRunner) error {
+	out, err := runCommand(r, "sh", "-c", sysfsQuery)
+	if err != nil {
+		return err
+	}
+	_, err = parseSysfs(out)
+	return err
+}
+
+// backendNames lists the --backend values
+func backendNames() []string {
+	names := []string{"auto"}
+	for _, b := range metricsBackends {
+		names = append(names, b.name)
+	}
+	return names
+}
+
+// backendSelector picks a host's metrics backend and fails over once to
+// the next candidate when the active one keeps failing. The sampler calls
+// collect while the UI reads status.
+type backendSelector struct {
+	mu         sync.Mutex
+	candidates []metricsBackend
+	active     int
+	rejected   []string          // why candidates were passed over, e.g. "amd-smi: not found"
+	failures   int               // samples in a row the active backend failed
+	failedOver string            // what happened when it did, empty until then
+	vendors    []vendorCollector // other vendors' GPUs to add (--vendors)
+	vendorErr  string            // what the last try at that ran into
+}
+
+// newBackendSelector starts with the first candidate, or only the named
+// one when name isn't "auto" or empty
+func newBackendSelector(name string) *backendSelector {
+	s := &backendSelector{candidates: metricsBackends}
+	for _, b := range metricsBackends {
+		if b.name == name {
+			s.candidates = []metricsBackend{b}
+		}
+	}
+	return s
+}
+
+// choose probes the candidates in order and keeps the first that works.
+// probe may replace a backend's own probe, e.g. with the startup checks
+// that explain an amd-smi problem better. The error lists every reason.
+func (s *backendSelector) choose(r commandRunner, probe func(metricsBackend) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for i, b := range s.candidates {
+		err := probe(b)
+		if err == nil {
+			s.active = i
+			debugLog.Info("metrics backend", "backend", b.name, "rejected", s.rejected)
+			return nil
+		}
+		debugLog.Warn("metrics backend rejected", "backend", b.name, "err", err)
+		s.rejected = append(s.rejected, b.name+": "+shortReason(b.name, err))
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// shortReason keeps the first clause of an error for the footer, without
+// repeating the backend's name
+func shortReason(name string, err error) string {
+	reason, _, _ := strings.Cut(oneLine(err.Error()), ": ")
+	return strings.TrimPrefix(reason, name+" ")
+}
+
+func (s *backendSelector) name() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.candidates[s.active].name
+}
+
+// collect reads a sample from the active backend
+func (s *backendSelector) collect(r commandRunner) (Sample, error) {
+	s.mu.Lock()
+	b := s.candidates[s.active]
+	s.mu.Unlock()
+	sample, err := b.collect(r)
+	var problems []string
+	if err == nil {
+		problems = addVendors(r, &sample, s.vendors)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		s.failures = 0
+		// The AMD GPUs are fine without the others
+		vendorErr := strings.Join(problems, ", ")
+		if vendorErr != s.vendorErr && vendorErr != "" {
+			debugLog.Warn("GPUs of other vendors unavailable", "problems", vendorErr)
+		}
+		s.vendorErr = vendorErr
+		return sample, nil
+	}
+	s.failures++
+	if s.failures < backendFailover || s.failedOver != "" {
+		return sample, err
+	}
+	// Only once: backends taking turns failing would hide the problem
+	s.failedOver = fmt.Sprintf("%s kept failing", b.name)
+	for i, next := range s.candidates {
+		if i == s.active {
+			continue
+		}
+		if perr := next.probe(r); perr != nil {
+			debugLog.Warn("metrics backend rejected", "backend", next.name, "err", perr)
+			continue
+		}
+		debugLog.Warn("metrics backend failed over", "from", b.name, "to", next.name, "err", err)
+		s.failedOver = fmt.Sprintf("%s kept failing, switched to %s", b.name, next.name)
+		s.active, s.failures = i, 0
+		return sample, err
+	}
+	debugLog.Warn("metrics backend failover found no alternative", "backend", b.name, "err", err)
+	return sample, err
+}
+
+// status describes the active backend and why the others weren't used,
+// e.g. "backend sysfs (amd-smi: not found)". It is empty while the first
+// choice is in use without trouble.
+func (s *backendSelector) status() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active == 0 && len(s.rejected) == 0 && s.failedOver == "" {
+		return s.vendorErr
+	}
+	return s.describe()
+}
+
+// describe is status that always names the backend
+func (s *backendSelector) describe() string {
+	text := "backend " + s.candidates[s.active].name
+	var why []string
+	if s.failedOver != "" {
+		why = append(why, s.failedOver)
+	}
+	why = append(why, s.rejected...)
+	if s.vendorErr != "" {
+		why = append(why, s.vendorErr)
+	}
+	if len(why) > 0 {
+		text += " (" + strings.Join(why, "; ") + ")"
+	}
+	return text
+}
+
+func (s *backendSelector) info() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.describe()
+}
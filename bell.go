@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"time"
+)
+
+// Terminal bells an alert can ring
+const (
+	bellNone    = "none"
+	bellAudible = "audible"
+	bellVisual  = "visual"
+)
+
+// visualBellFlash is how long the screen stays inverted
+const visualBellFlash = 150 * time.Millisecond
+
+// bellRequest collects the bells rung during a tick, so several rules
+// firing at once ring once
+type bellRequest struct {
+	audible, visual bool
+}
+
+func (b *bellRequest) add(mode string) {
+	switch mode {
+	case bellAudible:
+		b.audible = true
+	case bellVisual:
+		b.visual = true
+	}
+}
+
+// terminalBell rings bells on the terminal termui draws on. It belongs to
+// the UI goroutine, so its writes can't land in the middle of a redraw.
+type terminalBell struct {
+	out io.Writer
+	// flashed fires when a visual bell is due to end; nil otherwise
+	flashed <-chan time.Time
+}
+
+// ring sounds BEL and starts an inverse flash (DECSCNM) as requested
+func (t *terminalBell) ring(b bellRequest) {
+	if b.audible {
+		io.WriteString(t.out, "\a")
+	}
+	if b.visual && t.flashed == nil {
+		io.WriteString(t.out, "\x1b[?5h")
+		t.flashed = time.After(visualBellFlash)
+	}
+}
+
+// endFlash turns the screen back to normal video
+func (t *terminalBell) endFlash() {
+	io.WriteString(t.out, "\x1b[?5l")
+	t.flashed = nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	ui "github.com/gizak/termui/v3"
+	"github.com/gizak/termui/v3/widgets"
+)
+
+var runCSVHeader = []string{"start", "end", "host", "gpu", "samples", "avg_util", "min_util", "max_util",
+	"avg_power", "energy_j", "peak_temp", "peak_vram"}
+
+// maxBenchMarks bounds the bracket boundaries kept for the charts; older
+// ones have scrolled off by the time a few runs went by
+const maxBenchMarks = 8
+
+// benchGPU sums up one GPU's samples inside a bracket
+type benchGPU struct {
+	count    int
+	utilSum  float64
+	utilMin  float64
+	utilMax  float64
+	powerSum float64
+	energy   float64 // joules, power integrated over the sample intervals
+	last     time.Time
+	temp     float64
+	vram     float64
+}
+
+type benchKey struct {
+	host string
+	gpu  int
+}
+
+// benchRun is what a bracket recorded, summarized as samples arrive
+type benchRun struct {
+	start, end time.Time // first and last sample
+	gpus       map[benchKey]*benchGPU
+	order      []benchKey
+}
+
+// observe adds a sample's GPUs. Partial rows may lack values and are left
+// out, as in the session totals.
+func (r *benchRun) observe(host string, s Sample) {
+	if r.start.IsZero() {
+		r.start = s.Time
+	}
+	r.end = s.Time
+	for _, m := range s.GPUs {
+		if m.Partial {
+			continue
+		}
+		key := benchKey{host: host, gpu: m.ID}
+		g, ok := r.gpus[key]
+		if !ok {
+			g = &benchGPU{utilMin: m.GFXUtil, utilMax: m.GFXUtil}
+			r.gpus[key] = g
+			r.order = append(r.order, key)
+		}
+		if !g.last.IsZero() {
+			g.energy += m.Power * s.Time.Sub(g.last).Seconds()
+		}
+		g.last = s.Time
+		g.count++
+		g.utilSum += m.GFXUtil
+		g.utilMin = min(g.utilMin, m.GFXUtil)
+		g.utilMax = max(g.utilMax, m.GFXUtil)
+		g.powerSum += m.Power
+		g.temp = max(g.temp, m.GPUTemp)
+		g.vram = max(g.vram, m.VRAMUsed)
+	}
+}
+
+// benchBracket is the 'B' benchmark bracket: while open, samples go into
+// a run; closing it shows the run's statistics in a popup that any key
+// closes, and appends them to the runs log. It belongs to the UI
+// goroutine.
+type benchBracket struct {
+	run     *benchRun // nil while no bracket is open
+	marks   []time.Time
+	host    string // for samples of the local host
+	logPath string // empty without --log-csv
+	report  *widgets.Paragraph
+	shown   bool
+	lines   int
+}
+
+func newBenchBracket(logPath string) *benchBracket {
+	b := &benchBracket{host: hostname(), report: widgets.NewParagraph()}
+	if logPath != "" {
+		b.logPath = runLogPath(logPath)
+	}
+	b.report.BorderStyle = ui.NewStyle(ui.ColorMagenta)
+	return b
+}
+
+// runLogPath derives the runs log name from the GPU log name,
+// e.g. run.csv -> run.runs.csv
+func runLogPath(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + ".runs" + ext
+}
+
+// toggle opens a bracket, or closes the open one and returns its run
+func (b *benchBracket) toggle() *benchRun {
+	if b.run == nil {
+		b.run = &benchRun{gpus: map[benchKey]*benchGPU{}}
+		return nil
+	}
+	run := b.run
+	b.run = nil
+	if !run.end.IsZero() {
+		b.mark(run.end)
+	}
+	return run
+}
+
+// observe feeds a host's sample into the open bracket. Its boundaries are
+// marked at the first and last sample, so they line up with the charts.
+func (b *benchBracket) observe(s Sample) {
+	if b.run == nil {
+		return
+	}
+	first := b.run.start.IsZero()
+	b.run.observe(sampleHost(s, b.host), s)
+	if first {
+		b.mark(b.run.start)
+	}
+}
+
+func (b *benchBracket) mark(t time.Time) {
+	b.marks = append(b.marks, t)
+	if len(b.marks) > maxBenchMarks {
+		b.marks = b.marks[len(b.marks)-maxBenchMarks:]
+	}
+}
+
+// show fills the popup with a run's statistics
+func (b *benchBracket) show(run *benchRun, multi bool) {
+	b.report.Title = "Benchmark run (any key closes)"
+	lines := []string{"no samples were recorded"}
+	if len(run.order) > 0 {
+		b.report.Title = fmt.Sprintf("Benchmark run %s – %s, %v (any key closes)",
+			zoned(run.start).Format("15:04:05"), zoned(run.end).Format("15:04:05"), run.end.Sub(run.start).Round(time.Second))
+		lines = []string{fmt.Sprintf("[%-16s %8s %8s %8s %9s %10s %9s %10s](mod:bold)",
+			"GPU", "AVG UTIL", "MIN", "MAX", "AVG POWER", "ENERGY", "PEAK TEMP", "PEAK VRAM")}
+		for _, key := range run.order {
+			g := run.gpus[key]
+			label := fmt.Sprintf("GPU %d", key.gpu)
+			if multi {
+				label = key.host + ": " + label
+			}
+			n := float64(g.count)
+			lines = append(lines, fmt.Sprintf("%-16s %7.1f%% %7.1f%% %7.1f%% %7.1f W %7.1f kJ %7.0f°C %7.0f MB",
+				label, g.utilSum/n, g.utilMin, g.utilMax, g.powerSum/n, g.energy/1000, g.temp, g.vram))
+		}
+	}
+	b.report.Text = strings.Join(lines, "\n")
+	b.lines = len(lines)
+	b.shown = true
+}
+
+func (b *benchBracket) layout(width, height int) {
+	w, ht := min(100, width), min(b.lines+2, height-1)
+	b.report.SetRect((width-w)/2, (height-ht)/2, (width+w)/2, (height-ht)/2+ht)
+}
+
+// writeRunLog appends one row per GPU of a run to the runs log
+func (b *benchBracket) writeRunLog(run *benchRun) error {
+	if b.logPath == "" || len(run.order) == 0 {
+		return nil
+	}
+	rf, err := openRotatingFile(b.logPath, runCSVHeader, 0, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open runs log: %v", err)
+	}
+	start, end := zoned(run.start).Format(time.RFC3339), zoned(run.end).Format(time.RFC3339)
+	rows := make([][]string, 0, len(run.order))
+	for _, key := range run.order {
+		g := run.gpus[key]
+		n := float64(g.count)
+		rows = append(rows, []string{start, end, key.host, strconv.Itoa(key.gpu), strconv.Itoa(g.count),
+			formatFloat(g.utilSum / n), formatFloat(g.utilMin), formatFloat(g.utilMax),
+			formatFloat(g.powerSum / n), formatFloat(g.energy), formatFloat(g.temp), formatFloat(g.vram)})
+	}
+	err = rf.writeRows(rows)
+	if cerr := rf.close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("runs log write failed: %v", err)
+	}
+	return nil
+}
+
+// markedChart is a GPU chart with the bracket boundaries and the GPU's
+// events drawn over it, and a time axis along its bottom border unless
+// interval is 0
+type markedChart struct {
+	*widgets.SparklineGroup
+	host     *hostView
+	slot     int
+	bench    *benchBracket
+	interval time.Duration
+}
+
+func (c *markedChart) Draw(buf *ui.Buffer) {
+	c.SparklineGroup.Draw(buf)
+	if c.slot >= len(c.host.histories) {
+		return
+	}
+	// Every metric's history has the same timestamps
+	history := c.host.histories[c.slot][0]
+	top := c.Inner.Min.Y
+	if c.Sparklines[0].Title != "" {
+		top++
+	}
+	if !c.host.placeholder {
+		drawEvents(buf, c.Inner, top, history, c.host.columns, c.host.events.byGPU[c.host.ids[c.slot]])
+	}
+	if c.interval > 0 {
+		newest := min(c.host.columns, c.Inner.Dx()) - 1
+		drawTimeAxis(buf, c.Rectangle, c.Inner.Min.X, newest, columnSpan(c.interval, history, c.host.columns))
+	}
+	style := ui.NewStyle(ui.ColorMagenta)
+	for _, t := range c.bench.marks {
+		col := history.chartColumn(t, c.host.columns)
+		if col < 0 || col >= c.Inner.Dx() {
+			continue
+		}
+		for y := top; y < c.Inner.Max.Y; y++ {
+			buf.SetCell(ui.NewCell('│', style), image.Pt(c.Inner.Min.X+col, y))
+		}
+	}
+}
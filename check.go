@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Nagios plugin exit codes
+const (
+	checkOK       = 0
+	checkWarning  = 1
+	checkCritical = 2
+	checkUnknown  = 3
+)
+
+var checkStateNames = [...]string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// Default temperature thresholds in °C, also used to color chart titles.
+// HBM is specified to run hotter than the GPU hotspot.
+const (
+	defaultWarnTemp    = 90
+	defaultCritTemp    = 100
+	defaultWarnMemTemp = 95
+	defaultCritMemTemp = 105
+)
+
+type checkThresholds struct {
+	warnTemp, critTemp       float64
+	warnMemTemp, critMemTemp float64
+	warnVRAM, critVRAM       float64
+}
+
+// runCheck implements `mi-top check`: one collection, one status line with
+// perfdata, and an exit code Nagios and Icinga understand.
+func runCheck(args []string, stdout io.Writer) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	var t checkThresholds
+	fs.Float64Var(&t.warnTemp, "warn-temp", defaultWarnTemp, "warning threshold for GPU temperature (C)")
+	fs.Float64Var(&t.critTemp, "crit-temp", defaultCritTemp, "critical threshold for GPU temperature (C)")
+	fs.Float64Var(&t.warnMemTemp, "warn-mem-temp", defaultWarnMemTemp, "warning threshold for HBM temperature (C), ignored on cards without it")
+	fs.Float64Var(&t.critMemTemp, "crit-mem-temp", defaultCritMemTemp, "critical threshold for HBM temperature (C)")
+	fs.Float64Var(&t.warnVRAM, "warn-vram", 85, "warning threshold for VRAM usage (%)")
+	fs.Float64Var(&t.critVRAM, "crit-vram", 95, "critical threshold for VRAM usage (%)")
+	timeout := fs.Duration("timeout", 10*time.Second, "give up and report UNKNOWN after this long")
+	remote := fs.String("remote", "", "check user@host over ssh instead of the local machine")
+	sshKeyFile := fs.String("ssh-key", "", "identity file for --remote")
+	if err := fs.Parse(args); err != nil {
+		return checkUnknown
+	}
+	if t.warnTemp > t.critTemp || t.warnMemTemp > t.critMemTemp || t.warnVRAM > t.critVRAM {
+		fmt.Fprintln(stdout, "AMDGPU UNKNOWN - warning thresholds must not exceed critical thresholds")
+		return checkUnknown
+	}
+	var r commandRunner = smiRunner
+	if *remote != "" {
+		r = newSSHRunner(*remote, *sshKeyFile)
+	}
+
+	type result struct {
+		sample Sample
+		err    error
+	}
+	// collectProcesses stays off: the check only reads metrics
+	done := make(chan result, 1)
+	go func() {
+		s, err := collectSample(r)
+		done <- result{s, err}
+	}()
+	var res result
+	select {
+	case res = <-done:
+	case <-time.After(*timeout):
+		fmt.Fprintf(stdout, "AMDGPU UNKNOWN - amd-smi did not answer within %v\n", *timeout)
+		return checkUnknown
+	}
+	if res.err != nil {
+		fmt.Fprintf(stdout, "AMDGPU UNKNOWN - %s\n", oneLine(res.err.Error()))
+		return checkUnknown
+	}
+	if len(res.sample.GPUs) == 0 {
+		fmt.Fprintln(stdout, "AMDGPU UNKNOWN - no GPUs reported")
+		return checkUnknown
+	}
+	state, line := evaluateCheck(res.sample.GPUs, t)
+	fmt.Fprintln(stdout, line)
+	return state
+}
+
+// grade maps a value to a check state
+func grade(v, warn, crit float64) int {
+	switch {
+	case v >= crit:
+		return checkCritical
+	case v >= warn:
+		return checkWarning
+	}
+	return checkOK
+}
+
+// evaluateCheck builds the status line and returns the worst state seen
+func evaluateCheck(gpus []GPUMetrics, t checkThresholds) (int, string) {
+	state := checkOK
+	var problems, perf []string
+	for _, m := range gpus {
+		if s := grade(m.GPUTemp, t.warnTemp, t.critTemp); s != checkOK {
+			problems = append(problems, fmt.Sprintf("gpu%d temp %.0fC", m.ID, m.GPUTemp))
+			state = max(state, s)
+		}
+		if m.hasMemTemp() {
+			if s := grade(m.MemTemp, t.warnMemTemp, t.critMemTemp); s != checkOK {
+				problems = append(problems, fmt.Sprintf("gpu%d hbm temp %.0fC", m.ID, m.MemTemp))
+				state = max(state, s)
+			}
+		}
+		vram := 0.0
+		if m.VRAMTotal > 0 {
+			vram = m.VRAMUsed / m.VRAMTotal * 100
+		}
+		if s := grade(vram, t.warnVRAM, t.critVRAM); s != checkOK {
+			problems = append(problems, fmt.Sprintf("gpu%d vram %.0f%%", m.ID, vram))
+			state = max(state, s)
+		}
+		perf = append(perf,
+			fmt.Sprintf("gpu%d_util=%s%%;;;0;100", m.ID, formatFloat(m.GFXUtil)),
+			fmt.Sprintf("gpu%d_temp=%s;%s;%s", m.ID, formatFloat(m.GPUTemp), formatFloat(t.warnTemp), formatFloat(t.critTemp)),
+			fmt.Sprintf("gpu%d_vram=%.1f%%;%s;%s;0;100", m.ID, vram, formatFloat(t.warnVRAM), formatFloat(t.critVRAM)),
+			fmt.Sprintf("gpu%d_power=%s", m.ID, formatFloat(m.Power)),
+		)
+		if m.hasMemTemp() {
+			perf = append(perf, fmt.Sprintf("gpu%d_mem_temp=%s;%s;%s", m.ID, formatFloat(m.MemTemp), formatFloat(t.warnMemTemp), formatFloat(t.critMemTemp)))
+		}
+	}
+	summary := fmt.Sprintf("%d GPUs", len(gpus))
+	if len(problems) > 0 {
+		summary = strings.Join(problems, ", ")
+	}
+	return state, fmt.Sprintf("AMDGPU %s - %s | %s", checkStateNames[state], summary, strings.Join(perf, " "))
+}
+
+// oneLine keeps multi-line tool errors on the single line Nagios reads
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
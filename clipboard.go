@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// copyToClipboard puts text on the system clipboard. The OSC 52 escape
+// sequence asks the terminal to do it, which also works over ssh; a local
+// wl-copy or xclip is used as well when there is a display to talk to,
+// for terminals that ignore OSC 52. It fails when neither way exists.
+func copyToClipboard(text string) error {
+	sent := false
+	if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 && os.Getenv("TERM") != "dumb" {
+		// tmux takes the sequence itself with set-clipboard on and passes
+		// it on to the outer terminal
+		_, err := os.Stdout.WriteString("\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(text)) + "\a")
+		sent = err == nil
+	}
+	if tool := clipboardTool(); tool != nil {
+		cmd := exec.Command(tool[0], tool[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		if err := cmd.Run(); err != nil {
+			debugLog.Warn("clipboard tool failed", "tool", tool[0], "err", err)
+		} else {
+			sent = true
+		}
+	}
+	if !sent {
+		return fmt.Errorf("no clipboard: needs a terminal with OSC 52 support, wl-copy or xclip")
+	}
+	return nil
+}
+
+// clipboardTool returns the command line of a local clipboard tool that
+// can reach the current display, or nil
+func clipboardTool() []string {
+	candidates := [][]string{}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		candidates = append(candidates, []string{"wl-copy"})
+	}
+	if os.Getenv("DISPLAY") != "" {
+		candidates = append(candidates, []string{"xclip", "-selection", "clipboard"})
+	}
+	for _, c := range candidates {
+		if _, err := exec.LookPath(c[0]); err == nil {
+			return c
+		}
+	}
+	return nil
+}
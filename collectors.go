@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ui "github.com/gizak/termui/v3"
+	"github.com/gizak/termui/v3/widgets"
+)
+
+// Exec collectors are site-specific scripts that print "name value" lines
+// each tick. Their series are shown in a table below the GPU charts and
+// run in their own goroutines, so a hung or broken script only marks
+// itself as failing.
+
+const (
+	defaultCollectorTimeout = 5 * time.Second
+	collectorTrendLen       = 30
+)
+
+// CollectorConfig is one [[collectors]] entry in the config file
+type CollectorConfig struct {
+	Name    string        `toml:"name"`
+	Command string        `toml:"command"`
+	Timeout time.Duration `toml:"timeout"`
+}
+
+type collectorSeries struct {
+	values []float64 // oldest first, at most collectorTrendLen
+}
+
+type execCollector struct {
+	cfg    CollectorConfig
+	mu     sync.Mutex
+	series map[string]*collectorSeries
+	err    error
+}
+
+func newExecCollectors(configs []CollectorConfig) []*execCollector {
+	collectors := make([]*execCollector, len(configs))
+	for i, cfg := range configs {
+		collectors[i] = &execCollector{cfg: cfg, series: map[string]*collectorSeries{}}
+	}
+	return collectors
+}
+
+// parseCollectorOutput reads "name value" lines, skipping blanks and # comments
+func parseCollectorOutput(out []byte) (map[string]float64, int) {
+	values := map[string]float64{}
+	bad := 0
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			bad++
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			bad++
+			continue
+		}
+		values[fields[0]] = v
+	}
+	return values, bad
+}
+
+func (c *execCollector) collect() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.cfg.Timeout)
+	defer cancel()
+	start := time.Now()
+	out, err := exec.CommandContext(ctx, "/bin/sh", "-c", c.cfg.Command).Output()
+	var stderr []byte
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		stderr = exitErr.Stderr
+	}
+	logExec("", "/bin/sh", []string{"-c", c.cfg.Command}, start, out, stderr, err)
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %v", c.cfg.Timeout)
+	}
+	values, bad := parseCollectorOutput(out)
+	if bad > 0 {
+		debugLog.Warn("collector printed malformed lines", "collector", c.cfg.Name, "lines", bad)
+	}
+	if err == nil && len(values) == 0 {
+		err = fmt.Errorf("no \"name value\" lines in output")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+	if err != nil {
+		return
+	}
+	for name, v := range values {
+		s := c.series[name]
+		if s == nil {
+			s = &collectorSeries{}
+			c.series[name] = s
+		}
+		s.values = append(s.values, v)
+		if len(s.values) > collectorTrendLen {
+			s.values = s.values[1:]
+		}
+	}
+}
+
+// runCollectors starts one goroutine per collector until stop is closed
+func runCollectors(collectors []*execCollector, interval time.Duration, stop <-chan struct{}) {
+	for _, c := range collectors {
+		go func(c *execCollector) {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				c.collect()
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+				}
+			}
+		}(c)
+	}
+}
+
+var trendBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// trendline draws values as a row of block characters scaled to their range
+func trendline(values []float64) string {
+	if len(values) == 0 {
+		return ""
+	}
+	lo, hi := values[0], values[0]
+	for _, v := range values {
+		lo, hi = min(lo, v), max(hi, v)
+	}
+	var b strings.Builder
+	for _, v := range values {
+		i := 0
+		if hi > lo {
+			i = int((v - lo) / (hi - lo) * float64(len(trendBlocks)-1))
+		}
+		b.WriteRune(trendBlocks[i])
+	}
+	return b.String()
+}
+
+func newCollectorTable() *widgets.Table {
+	table := widgets.NewTable()
+	table.Title = "Collectors"
+	table.TextStyle = ui.NewStyle(ui.ColorWhite)
+	table.RowSeparator = false
+	table.BorderStyle = ui.NewStyle(ui.ColorWhite)
+	return table
+}
+
+// updateCollectorTable shows the latest value and trend of every series
+func updateCollectorTable(table *widgets.Table, collectors []*execCollector) {
+	rows := [][]string{{"SERIES", "VALUE", "TREND"}}
+	table.RowStyles = map[int]ui.Style{0: ui.NewStyle(ui.ColorWhite, ui.ColorClear, ui.ModifierBold)}
+	for _, c := range collectors {
+		c.mu.Lock()
+		if c.err != nil {
+			table.RowStyles[len(rows)] = ui.NewStyle(ui.ColorRed)
+			rows = append(rows, []string{c.cfg.Name, "failing", c.err.Error()})
+		}
+		names := make([]string, 0, len(c.series))
+		for name := range c.series {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			values := c.series[name].values
+			rows = append(rows, []string{
+				c.cfg.Name + "/" + name,
+				formatFloat(values[len(values)-1]),
+				trendline(values),
+			})
+		}
+		c.mu.Unlock()
+	}
+	table.Rows = rows
+}
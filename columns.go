@@ -0,0 +1,226 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// tableColumns are the process table's columns in their default order.
+// The host column only shows with several hosts.
+var tableColumns = []string{"host", "gpu", "name", "pid", "mem", "vram", "vram_pct", "gtt", "gtt_pct", "cpu", "gfx"}
+
+// sortColumnIDs maps each sort column (columns) to the table column it
+// sorts by, the one '<' and '>' resize
+var sortColumnIDs = []string{"gpu", "name", "pid", "gfx", "vram", "vram_pct"}
+
+// Truncation sides: the part of a value too wide for its column that is
+// cut off. Middle keeps a few leading cells and as much of the end as fits,
+// where paths and commands keep their basename.
+const (
+	truncateTail   = "tail"
+	truncateHead   = "head"
+	truncateMiddle = "middle"
+)
+
+// middleHead is the most cells a middle cut keeps from the start
+const middleHead = 8
+
+// minColumnWidth keeps room for a character and the ellipsis
+const minColumnWidth = 3
+
+// columnStep is how many display cells '<' and '>' take off or add
+const columnStep = 4
+
+// tableColumn is a process table column as laid out for display
+type tableColumn struct {
+	id       string
+	maxWidth int // display cells, 0 for as wide as the values
+	truncate string
+}
+
+// newTableLayout lays the columns out from the [columns] config; columns
+// it doesn't mention keep their defaults
+func newTableLayout(cfg ColumnsConfig) []tableColumn {
+	order := cfg.Order
+	if len(order) == 0 {
+		order = tableColumns
+	}
+	layout := make([]tableColumn, len(order))
+	for i, id := range order {
+		c := cfg.column(id)
+		truncate := truncateTail
+		if id == "name" {
+			truncate = truncateMiddle
+		}
+		layout[i] = tableColumn{id: id, maxWidth: c.Width, truncate: cmp.Or(c.Truncate, truncate)}
+	}
+	return layout
+}
+
+// resizeColumn changes the width of the column the list is sorted by,
+// starting from its width on screen. Widening past the widest value has
+// no effect until a wider one shows up.
+func (v *processView) resizeColumn(delta int) {
+	id := sortColumnIDs[v.selectedColumn]
+	i := slices.IndexFunc(v.layout, func(c tableColumn) bool { return c.id == id })
+	if i < 0 || i >= len(v.widths) || v.widths[i] == 0 {
+		return
+	}
+	width := max(v.widths[i]+delta, minColumnWidth)
+	v.layout[i].maxWidth = width
+	v.resized[id] = width
+}
+
+// setColumnWidths restores widths set with '<' and '>' in an earlier
+// session over those of the config
+func (v *processView) setColumnWidths(widths map[string]int) {
+	for i, c := range v.layout {
+		if w, ok := widths[c.id]; ok {
+			v.layout[i].maxWidth = w
+			v.resized[c.id] = w
+		}
+	}
+}
+
+// column returns the settings of one column
+func (c ColumnsConfig) column(id string) ColumnConfig {
+	switch id {
+	case "host":
+		return c.Host
+	case "gpu":
+		return c.GPU
+	case "name":
+		return c.Name
+	case "pid":
+		return c.PID
+	case "mem":
+		return c.Mem
+	case "vram":
+		return c.VRAM
+	case "vram_pct":
+		return c.VRAMPct
+	case "gtt_pct":
+		return c.GTTPct
+	case "gtt":
+		return c.GTT
+	case "cpu":
+		return c.CPU
+	case "gfx":
+		return c.GFX
+	}
+	return ColumnConfig{}
+}
+
+func (c ColumnsConfig) validate() error {
+	seen := map[string]bool{}
+	for _, id := range c.Order {
+		if !slices.Contains(tableColumns, id) {
+			return fmt.Errorf("columns: unknown column %q", id)
+		}
+		if seen[id] {
+			return fmt.Errorf("columns: %s is listed twice", id)
+		}
+		seen[id] = true
+	}
+	for _, id := range tableColumns {
+		col := c.column(id)
+		if col.Width != 0 && col.Width < minColumnWidth {
+			return fmt.Errorf("columns.%s: width must be at least %d", id, minColumnWidth)
+		}
+		switch col.Truncate {
+		case "", truncateTail, truncateHead, truncateMiddle:
+		default:
+			return fmt.Errorf("columns.%s: unknown truncate %q (use tail, head or middle)", id, col.Truncate)
+		}
+	}
+	return nil
+}
+
+// columnWidths works out each column's width in display cells from the
+// widest host, GPU alias and name; 0 hides a column. GTT% only shows with gttSize,
+// when some GPU reported its GTT size. Columns no GPU's backend fills,
+// going by caps, are hidden too.
+func columnWidths(layout []tableColumn, widths []int, hostWidth, gpuWidth, nameWidth, pidWidth int, gttSize bool, caps gpuCaps) []int {
+	widths = widths[:0]
+	for _, c := range layout {
+		var w int
+		switch c.id {
+		case "host":
+			w = hostWidth
+		case "gpu":
+			w = max(len("[GPU]"), gpuWidth)
+		case "name":
+			w = nameWidth
+		case "pid":
+			w = len("PID: ") + pidWidth
+		case "mem":
+			w = len("MEM: 0000.0 MB")
+		case "cpu":
+			if caps.has(capProcessCPU) {
+				w = len("MEM: 0000.0 MB")
+			}
+		case "gtt":
+			if caps.has(capGTT) {
+				w = len("MEM: 0000.0 MB")
+			}
+		case "vram":
+			w = len("VRAM: 0000.0 MB")
+		case "vram_pct":
+			w = len("VRAM%: 100.0")
+		case "gtt_pct":
+			if gttSize {
+				w = len("GTT%: 100.0")
+			}
+		case "gfx":
+			if caps.has(capProcessUsage) {
+				w = len("GFX: 000.0%")
+			}
+		}
+		if c.maxWidth > 0 && w > 0 {
+			w = min(w, c.maxWidth)
+		}
+		// The label stays whole
+		if c.id == "pid" {
+			w = max(w, len("PID: ")+1)
+		}
+		widths = append(widths, w)
+	}
+	return widths
+}
+
+// truncate cuts s down to width display cells on the given side, marking
+// the cut with "…". A cut result is exactly width cells wide; a wide
+// character split by the cut leaves a space.
+func truncate(s string, width int, side string) string {
+	sw := runewidth.StringWidth(s)
+	if sw <= width {
+		return s
+	}
+	switch side {
+	case truncateHead:
+		return runewidth.TruncateLeft(s, sw-width+1, "…")
+	case truncateMiddle:
+		return ellipsizeMiddle(s, sw, width)
+	}
+	return runewidth.FillRight(runewidth.Truncate(s, width, "…"), width)
+}
+
+// ellipsizeMiddle keeps up to middleHead cells of s and fills the rest of
+// width from its end, which starts at a '/' when one is close enough:
+// "/opt/conda/envs/train/bin/python" in 24 cells is "/opt/c…/train/bin/python"
+// rather than ending in the middle of a directory name.
+func ellipsizeMiddle(s string, sw, width int) string {
+	budget := width - 1
+	tailWidth := budget - min(middleHead, budget/4)
+	tail := runewidth.TruncateLeft(s, sw-tailWidth, "")
+	if i := strings.IndexByte(tail, '/'); i > 0 && i <= tailWidth/2 {
+		tail = tail[i:]
+	}
+	headWidth := budget - runewidth.StringWidth(tail)
+	head := runewidth.FillRight(runewidth.Truncate(s, headWidth, ""), headWidth)
+	return head + "…" + tail
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// TestTruncateWidth cuts names on each side to every width from the
+// smallest a column gets up to their own: the result is exactly that many
+// display cells, with the ellipsis only when something was cut
+func TestTruncateWidth(t *testing.T) {
+	names := []string{
+		"/opt/conda/envs/train/bin/python",
+		"python3 -m torch.distributed.run --nproc_per_node=8 train.py",
+		"训练任务/模型/推理服务器",
+		"🚀rocket🔥fire🚀rocket",
+		"日本/a/中文/b/한국어",
+		"x",
+	}
+	for _, side := range []string{truncateHead, truncateTail, truncateMiddle} {
+		for _, name := range names {
+			sw := runewidth.StringWidth(name)
+			for width := minColumnWidth; width <= sw+2; width++ {
+				got := truncate(name, width, side)
+				if sw <= width {
+					if got != name {
+						t.Errorf("%s %q to %d: %q, want it whole", side, name, width, got)
+					}
+					continue
+				}
+				if w := runewidth.StringWidth(got); w != width {
+					t.Errorf("%s %q to %d: %q is %d cells", side, name, width, got, w)
+				}
+				if strings.Count(got, "…") != 1 {
+					t.Errorf("%s %q to %d: %q, want one ellipsis", side, name, width, got)
+				}
+			}
+		}
+	}
+}
+
+func TestTruncateSides(t *testing.T) {
+	const path = "/opt/conda/envs/train/bin/python"
+	for _, tc := range []struct {
+		side, want string
+	}{
+		{truncateTail, "/opt/conda/envs/train/b…"},
+		{truncateHead, "…a/envs/train/bin/python"},
+		{truncateMiddle, "/opt/c…/train/bin/python"},
+	} {
+		if got := truncate(path, 24, tc.side); got != tc.want {
+			t.Errorf("%s: %q, want %q", tc.side, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,659 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Config is the optional TOML configuration file given with --config
+type Config struct {
+	Interval time.Duration `toml:"interval"`
+	// HistoryMaxAge is how old the last run's chart history may be to be
+	// restored; 0, the default, neither saves nor restores it
+	HistoryMaxAge time.Duration `toml:"history_max_age"`
+	// ChartHistory is how far back the charts reach. Longer than they are
+	// wide, they fit several samples per column by Downsample: max, mean,
+	// minmax or lttb.
+	ChartHistory time.Duration     `toml:"chart_history"`
+	Downsample   string            `toml:"downsample"`
+	Smoothing    float64           `toml:"smoothing"` // EMA weight of the newest sample, 0 for raw samples
+	TimeAxis     bool              `toml:"time_axis"` // label the charts' bottom border with sample ages
+	Sort         string            `toml:"sort"`
+	Alerts       AlertsConfig      `toml:"alerts"`
+	Collectors   []CollectorConfig `toml:"collectors"`
+	ClockLimit   ClockLimitConfig  `toml:"clock_limited"`
+	Idle         IdleConfig        `toml:"idle"`
+	Quiet        QuietConfig       `toml:"quiet"`
+	Columns      ColumnsConfig     `toml:"columns"`
+	Thresholds   ThresholdsConfig  `toml:"thresholds"`
+	// Aliases names GPUs by bus address or UUID
+	Aliases map[string]string `toml:"aliases"`
+	// Capabilities corrects what was detected for some GPUs
+	Capabilities []CapabilityOverride `toml:"capabilities"`
+	// Theme is "default", or "monochrome" to leave out the colors that only
+	// grade magnitudes. NO_COLOR in the environment selects monochrome.
+	Theme string `toml:"theme"`
+	// Keys rebinds actions, each to a key or a list of keys
+	Keys map[string][]string `toml:"keys"`
+}
+
+// ColumnsConfig lays out the process table: which columns show in what
+// order, and per column how wide it gets and how longer values are cut
+type ColumnsConfig struct {
+	Order []string     `toml:"order"`
+	Host  ColumnConfig `toml:"host"`
+	GPU   ColumnConfig `toml:"gpu"`
+	Name  ColumnConfig `toml:"name"`
+	PID   ColumnConfig `toml:"pid"`
+	Mem   ColumnConfig `toml:"mem"`
+	VRAM  ColumnConfig `toml:"vram"`
+	// VRAMPct is the process's share of its GPU's VRAM
+	VRAMPct ColumnConfig `toml:"vram_pct"`
+	// GTTPct is the process's share of its GPU's GTT size
+	GTTPct ColumnConfig `toml:"gtt_pct"`
+	GTT    ColumnConfig `toml:"gtt"`
+	CPU    ColumnConfig `toml:"cpu"`
+	GFX    ColumnConfig `toml:"gfx"`
+}
+
+// ColumnConfig limits a column to Width display cells (0 for no limit),
+// cutting off the Truncate part of longer values: tail, head or middle
+type ColumnConfig struct {
+	Width    int    `toml:"width"`
+	Truncate string `toml:"truncate"`
+}
+
+// ThresholdsConfig holds the warning and critical levels the display
+// grades values by. Process VRAM is a percentage of the GPU's VRAM, GTT a
+// percentage of the GTT size, temperatures are in °C.
+type ThresholdsConfig struct {
+	ProcessVRAMWarn float64 `toml:"process_vram_warn"`
+	ProcessVRAMCrit float64 `toml:"process_vram_crit"`
+	GTTWarn         float64 `toml:"gtt_warn"`
+	GTTCrit         float64 `toml:"gtt_crit"`
+	GPUTempWarn     float64 `toml:"gpu_temp_warn"`
+	GPUTempCrit     float64 `toml:"gpu_temp_crit"`
+	MemTempWarn     float64 `toml:"mem_temp_warn"`
+	MemTempCrit     float64 `toml:"mem_temp_crit"`
+	// GPUs overrides the temperature thresholds for some GPUs
+	GPUs []ThresholdOverride `toml:"gpus"`
+}
+
+// ThresholdOverride sets temperature thresholds for the GPUs it scopes
+// (see gpuScope); unset values keep those of wider scopes
+type ThresholdOverride struct {
+	GPU         *int     `toml:"gpu"`
+	BDF         string   `toml:"bdf"`
+	ASIC        string   `toml:"asic"`
+	GPUTempWarn *float64 `toml:"gpu_temp_warn"`
+	GPUTempCrit *float64 `toml:"gpu_temp_crit"`
+	MemTempWarn *float64 `toml:"mem_temp_warn"`
+	MemTempCrit *float64 `toml:"mem_temp_crit"`
+}
+
+func (o ThresholdOverride) scope() gpuScope {
+	return gpuScope{gpu: o.GPU, bdf: o.BDF, asic: o.ASIC}
+}
+
+// CapabilityOverride shows or hides capabilities, such as mem_temp, of
+// the GPUs it scopes (see gpuScope), whatever was detected
+type CapabilityOverride struct {
+	GPU  *int     `toml:"gpu"`
+	BDF  string   `toml:"bdf"`
+	ASIC string   `toml:"asic"`
+	Show []string `toml:"show"`
+	Hide []string `toml:"hide"`
+}
+
+func (o CapabilityOverride) scope() gpuScope {
+	return gpuScope{gpu: o.GPU, bdf: o.BDF, asic: o.ASIC}
+}
+
+// forGPU returns the thresholds with the overrides scoped to a GPU applied
+func (t ThresholdsConfig) forGPU(id int, bdf, asic string) ThresholdsConfig {
+	set := func(dst *float64, v *float64) {
+		if v != nil {
+			*dst = *v
+		}
+	}
+	for _, o := range inScope(t.GPUs, ThresholdOverride.scope, id, bdf, asic) {
+		set(&t.GPUTempWarn, o.GPUTempWarn)
+		set(&t.GPUTempCrit, o.GPUTempCrit)
+		set(&t.MemTempWarn, o.MemTempWarn)
+		set(&t.MemTempCrit, o.MemTempCrit)
+	}
+	return t
+}
+
+// Bounds for the refresh period: faster than this amd-smi can't keep up,
+// slower and the charts stop being useful
+const (
+	minInterval = 100 * time.Millisecond
+	maxInterval = 5 * time.Minute
+)
+
+type AlertsConfig struct {
+	WebhookURL string        `toml:"webhook_url"`
+	Cooldown   time.Duration `toml:"cooldown"`
+	// LogPath gets a JSON line for every alert transition, rotated once
+	// it exceeds LogMaxMB
+	LogPath  string      `toml:"log_path"`
+	LogMaxMB int         `toml:"log_max_mb"`
+	Bell     BellConfig  `toml:"bell"`
+	Rules    []AlertRule `toml:"rules"`
+}
+
+// BellConfig picks the terminal bell each severity rings when a rule
+// starts firing: "audible", "visual" or "none"
+type BellConfig struct {
+	Info     string `toml:"info"`
+	Warning  string `toml:"warning"`
+	Critical string `toml:"critical"`
+}
+
+// mode returns the bell for a severity
+func (b BellConfig) mode(severity string) string {
+	switch severity {
+	case "info":
+		return b.Info
+	case "warning":
+		return b.Warning
+	}
+	return b.Critical
+}
+
+// AlertRule fires when Metric compared with Op against Threshold has held
+// for Sustain. It resolves once the comparison against ClearThreshold no
+// longer holds, after firing for at least MinFiring. Overrides change the
+// thresholds for some GPUs.
+type AlertRule struct {
+	Name      string        `toml:"name"`
+	Metric    string        `toml:"metric"`
+	Op        string        `toml:"op"`
+	Threshold float64       `toml:"threshold"`
+	Sustain   time.Duration `toml:"sustain"`
+	// ClearThreshold defaults to 5% of the threshold on the resolved side
+	ClearThreshold *float64        `toml:"clear_threshold"`
+	MinFiring      time.Duration   `toml:"min_firing"`
+	Overrides      []AlertOverride `toml:"overrides"`
+	Severity       string          `toml:"severity"`
+	Cooldown       time.Duration   `toml:"cooldown"`
+	NotifyResolved bool            `toml:"notify_resolved"`
+}
+
+// AlertOverride sets a rule's thresholds for the GPUs it scopes (see
+// gpuScope). A threshold without a clear threshold gets the default 5%
+// short of it.
+type AlertOverride struct {
+	GPU            *int     `toml:"gpu"`
+	BDF            string   `toml:"bdf"`
+	ASIC           string   `toml:"asic"`
+	Threshold      *float64 `toml:"threshold"`
+	ClearThreshold *float64 `toml:"clear_threshold"`
+}
+
+func (o AlertOverride) scope() gpuScope {
+	return gpuScope{gpu: o.GPU, bdf: o.BDF, asic: o.ASIC}
+}
+
+// forGPU returns the rule with the overrides scoped to a GPU applied
+func (r AlertRule) forGPU(id int, bdf, asic string) AlertRule {
+	for _, o := range inScope(r.Overrides, AlertOverride.scope, id, bdf, asic) {
+		if o.Threshold != nil {
+			r.Threshold = *o.Threshold
+		}
+		if o.ClearThreshold != nil {
+			r.ClearThreshold = o.ClearThreshold
+		}
+	}
+	return r
+}
+
+// defaultClearThreshold is 5% short of the threshold on the side where the
+// rule doesn't fire
+func defaultClearThreshold(op string, threshold float64) *float64 {
+	clear := threshold + 0.05*math.Abs(threshold)
+	if op == ">" || op == ">=" {
+		clear = threshold - 0.05*math.Abs(threshold)
+	}
+	return &clear
+}
+
+// checkClearThreshold makes sure clearing takes the value back across the
+// threshold
+func checkClearThreshold(op string, threshold, clear float64) error {
+	below := op == ">" || op == ">="
+	if below && clear > threshold || !below && clear < threshold {
+		return fmt.Errorf("clear_threshold %g is on the firing side of threshold %g", clear, threshold)
+	}
+	return nil
+}
+
+// ClockLimitConfig tunes the "clock-limited" title hint: GFX utilization
+// above Util percent while the clock stays below Clock percent of the
+// GPU's maximum, for Samples samples in a row
+type ClockLimitConfig struct {
+	Util    float64 `toml:"util"`
+	Clock   float64 `toml:"clock"`
+	Samples int     `toml:"samples"`
+}
+
+// IdleConfig dims a GPU's chart once its GFX utilization has stayed below
+// Util percent, with no processes on it, for Window. A zero Window turns
+// dimming off.
+type IdleConfig struct {
+	Util   float64       `toml:"util"`
+	Window time.Duration `toml:"window"`
+}
+
+// QuietConfig tunes quiet mode (--quiet, 'z'), which only shows the
+// charts and critical alerts. HideProcesses leaves out the process list
+// too; processes are still collected for the outputs.
+type QuietConfig struct {
+	HideProcesses bool `toml:"hide_processes"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Interval:   time.Second,
+		Downsample: string(downsampleMax),
+		TimeAxis:   true,
+		Sort:       "usage,desc",
+		Alerts: AlertsConfig{Cooldown: 5 * time.Minute, LogMaxMB: 10,
+			Bell: BellConfig{Info: bellNone, Warning: bellNone, Critical: bellAudible}},
+		ClockLimit: ClockLimitConfig{Util: 90, Clock: 70, Samples: 5},
+		Idle:       IdleConfig{Util: 1, Window: 3 * time.Minute},
+		Thresholds: ThresholdsConfig{ProcessVRAMWarn: 10, ProcessVRAMCrit: 50, GTTWarn: 50, GTTCrit: 80,
+			GPUTempWarn: defaultWarnTemp, GPUTempCrit: defaultCritTemp, MemTempWarn: defaultWarnMemTemp, MemTempCrit: defaultCritMemTemp},
+		Theme: "default",
+	}
+}
+
+// defaultConfigTemplate is printed by --write-default-config. Every setting
+// is commented out at its default value.
+const defaultConfigTemplate = `# mi-top configuration
+#
+# Looked up in this order; the first file found wins:
+#   --config PATH
+#   $XDG_CONFIG_HOME/mi-top/config.toml (~/.config/mi-top/config.toml)
+#   /etc/mi-top/config.toml
+# Command-line flags override values set here.
+
+# Refresh period, between 100ms and 5m.
+# interval = "1s"
+
+# Set this to keep the charts' history and the session peaks across runs:
+# they are saved on exit to $XDG_STATE_HOME/mi-top/history.json, and
+# restored for the same GPUs (by UUID or bus address) on the next start if
+# the file isn't older than this. --fresh starts without it. Off by default.
+# history_max_age = "1h"
+
+# How far back the charts reach. By default they keep one sample per
+# column, so their span follows the terminal width; a longer history is
+# fitted in by combining the samples of each column with downsample:
+#   max     the highest sample, so short spikes stay visible
+#   mean    the average, smoothing spikes away
+#   minmax  the highest samples above the lowest, in two rows
+#   lttb    the sample that best keeps the shape of the line
+# 'd' switches between them while mi-top runs.
+# chart_history = "0s"
+# downsample = "max"
+
+# Smooth the charts and the utilization in their titles with an exponential
+# moving average that weights the newest sample by this much, between 0 and
+# 1. Only the display is smoothed: exports and recordings keep the raw
+# samples. 0 is off; 'e' turns it on and off, at 0.3 if this is 0.
+# smoothing = 0.0
+
+# Label the bottom border of each chart with how long ago its columns were
+# sampled ("-2m", "now"). false leaves a plain border.
+# time_axis = true
+
+# Process list sort as column[,asc|desc]; columns are gpu, name, pid, usage,
+# vram and vram% (share of the GPU's VRAM). The sort, filters and open views of the last session are
+# restored over this from $XDG_STATE_HOME/mi-top/state.json unless mi-top
+# runs with --fresh.
+# sort = "usage,desc"
+
+# "monochrome" leaves out the colors that only grade magnitudes, such as
+# the process memory cells. Setting NO_COLOR in the environment does too.
+# theme = "default"
+
+[alerts]
+# Slack/Discord-compatible webhook that receives alert notifications.
+# webhook_url = "https://hooks.example.com/..."
+
+# Minimum time between repeated notifications for the same rule and GPU.
+# cooldown = "5m"
+
+# Append every alert transition, notified or not, to this file as JSON
+# lines with the state entered: pending, ok (no longer pending), firing or
+# resolved. Critical ones are synced to disk right away. The file is rotated
+# once it exceeds log_max_mb, keeping 5 old copies.
+# log_path = "/var/log/mi-top/alerts.jsonl"
+# log_max_mb = 10
+
+# The terminal bell rung when a rule of each severity starts firing, so a
+# tmux pane in the background gets flagged: "audible" (BEL), "visual" (a
+# brief inverse flash) or "none". It rings at most once per cooldown for a
+# rule, and waits while the filter line is open.
+# [alerts.bell]
+# info = "none"
+# warning = "none"
+# critical = "audible"
+
+# One [[alerts.rules]] table per rule. Metrics: gfx_util, power, gpu_temp,
+# mem_temp, gfx_clock, mem_util, mem_clock, vram_used, vram_percent.
+# [[alerts.rules]]
+# name = "hot"
+# metric = "gpu_temp"
+# op = ">="              # >, >=, < or <=
+# threshold = 95
+# sustain = "60s"        # fire only once the condition held this long
+# clear_threshold = 90   # resolve only past this; default 5% short of threshold
+# min_firing = "30s"     # stay firing at least this long
+# Other thresholds for some GPUs, scoped like [[thresholds.gpus]]
+# overrides = [{ asic = "*MI300X*", threshold = 110 }, { gpu = 3, threshold = 90 }]
+# severity = "critical"  # info, warning or critical
+# cooldown = "10m"
+# notify_resolved = true
+
+# A busy GPU running well below its maximum clock is marked "clock-limited",
+# a throttle hint for amd-smi releases that don't report throttle reasons.
+[clock_limited]
+# util = 90      # GFX utilization above this percentage
+# clock = 70     # while the clock is below this percentage of the maximum
+# samples = 5    # for this many samples in a row
+
+# Charts of idle GPUs are dimmed so the busy ones stand out.
+[idle]
+# util = 1        # GFX utilization below this percentage, with no processes
+# window = "3m"   # for this long; "0s" turns dimming off
+
+# Quiet mode (--quiet, or 'z') is for wall dashboards: no footer, title
+# badges or selected row, and only critical alerts are marked.
+[quiet]
+# hide_processes = false  # leave out the process list as well
+
+# Warning and critical levels values are colored by.
+[thresholds]
+# Process MEM and VRAM cells turn yellow and red when a process takes this
+# much of its GPU's VRAM, in percent.
+# process_vram_warn = 10
+# process_vram_crit = 50
+# GTT% cells and the GTT total in the footer turn yellow and red at this
+# share of the GTT size, the system memory a GPU may map.
+# gtt_warn = 50
+# gtt_crit = 80
+# Chart titles turn yellow and red at these GPU (hotspot) and HBM
+# temperatures, in °C.
+# gpu_temp_warn = 90
+# gpu_temp_crit = 100
+# mem_temp_warn = 95
+# mem_temp_crit = 105
+
+# Temperature thresholds for some GPUs, picked by gpu (index), bdf (bus
+# address) or asic (name pattern like "*MI100*"). Index and bus address
+# win over an ASIC pattern, which wins over the values above. The GPU info
+# panel ('s') shows each GPU's effective thresholds.
+# [[thresholds.gpus]]
+# asic = "*MI300X*"
+# gpu_temp_warn = 100
+# gpu_temp_crit = 110
+
+# Names for GPUs, shown in chart titles, the process list, alerts and the
+# outputs, by bus address or UUID, which unlike the index stay the same
+# across reboots. A bus address names that GPU on every host; a UUID also
+# tells hosts apart. Other GPUs keep their index.
+[aliases]
+# "0000:03:00.0" = "render"
+# "GPU-4c6b8e2a1f0d3b97" = "train-a"
+
+# Capabilities are detected: a metric N/A in each of a GPU's first few
+# samples, such as the memory temperature of GDDR cards, is left out of
+# its chart title, and process columns no backend fills are hidden. Scoped
+# like [[thresholds.gpus]], show and hide correct that with power,
+# mem_temp, mem_util, gtt, process_usage (the gfx column), process_cpu,
+# xgmi, media and junction_temp.
+# [[capabilities]]
+# asic = "*Radeon*"
+# hide = ["mem_util"]
+
+# The process table's columns in order: host (only shown with several
+# hosts), gpu, name, pid, mem, vram, vram_pct (share of the GPU's VRAM),
+# gtt, gtt_pct (share of the GTT size, only shown when the driver reports
+# it), cpu and gfx. Columns left out are hidden. On APUs, whose VRAM is a
+# small carve-out, vram_pct counts VRAM and GTT against both, and vram
+# shows the GTT when the gtt column is left out.
+[columns]
+# order = ["gpu", "name", "pid", "mem", "vram", "vram_pct", "gtt", "gtt_pct", "cpu", "gfx"]
+
+# Per column, the widest it gets in display cells and the part of a longer
+# value that is cut off: tail, head, or middle to keep the start and the
+# end (the default for names, so paths keep their basename). At runtime
+# '<' and '>' narrow and widen the column the list is sorted by; those
+# widths are remembered.
+# [columns.name]
+# width = 40
+# truncate = "middle"
+
+# Rebind keys: action = "key" or ["key", ...], replacing the action's
+# default keys. Press '?' for the actions and their current keys. Keys are
+# characters or F1-F12, Up, Down, Left, Right, Home, End, PageUp, PageDown,
+# Insert, Delete, Tab, Enter, Escape, Space, Backspace and Ctrl-a to Ctrl-z.
+# A key bound to two actions is an error. The fallbacks j/k, Ctrl-n/Ctrl-p
+# (rows), h/l (sort column) and 1-6 (sort by column) work on terminals
+# without arrow keys, unless bound here to something else.
+[keys]
+# quit = ["q", "Ctrl-c"]
+# processes = "p"
+# copy = "y"
+
+# Exec collectors run a shell command every interval. It prints one
+# "name value" pair per line; the series appear in a table below the charts.
+# [[collectors]]
+# name = "coolant"
+# command = "/usr/local/bin/coolant-temps"
+# timeout = "5s"
+`
+
+// configSearchPath lists the files tried when --config isn't given
+func configSearchPath() []string {
+	var paths []string
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, ".config")
+		}
+	}
+	if dir != "" {
+		paths = append(paths, filepath.Join(dir, "mi-top", "config.toml"))
+	}
+	return append(paths, "/etc/mi-top/config.toml")
+}
+
+// findConfig returns the config file to load, or "" when there is none.
+// An explicit path must exist; the search path entries are optional.
+func findConfig(explicit string) string {
+	if explicit != "" {
+		return explicit
+	}
+	for _, path := range configSearchPath() {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// loadConfig reads a config file on top of the defaults. Unknown keys are
+// returned as warnings.
+func loadConfig(path string) (*Config, []string, error) {
+	cfg := defaultConfig()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read config: %v", err)
+	}
+	table, err := parseTOML(string(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	warnings, err := decodeTOML(table, cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, warnings, nil
+}
+
+func (c *Config) validate() error {
+	if c.Interval < minInterval || c.Interval > maxInterval {
+		return fmt.Errorf("interval %v is outside %v to %v", c.Interval, minInterval, maxInterval)
+	}
+	if c.HistoryMaxAge < 0 {
+		return fmt.Errorf("history_max_age must not be negative")
+	}
+	if c.ChartHistory < 0 {
+		return fmt.Errorf("chart_history must not be negative")
+	}
+	if _, err := parseDownsample(c.Downsample); err != nil {
+		return err
+	}
+	if c.Smoothing < 0 || c.Smoothing > 1 {
+		return fmt.Errorf("smoothing %v is outside 0 to 1", c.Smoothing)
+	}
+	seen := map[string]bool{}
+	for i := range c.Collectors {
+		col := &c.Collectors[i]
+		if col.Name == "" || col.Command == "" {
+			return fmt.Errorf("collector %d: name and command are required", i+1)
+		}
+		if seen[col.Name] {
+			return fmt.Errorf("collector %d: duplicate name %q", i+1, col.Name)
+		}
+		seen[col.Name] = true
+		if col.Timeout == 0 {
+			col.Timeout = defaultCollectorTimeout
+		}
+	}
+	if cl := c.ClockLimit; cl.Util < 0 || cl.Util > 100 || cl.Clock < 0 || cl.Clock > 100 {
+		return fmt.Errorf("clock_limited: util and clock are percentages from 0 to 100")
+	}
+	if c.ClockLimit.Samples < 1 {
+		return fmt.Errorf("clock_limited: samples must be at least 1")
+	}
+	if c.Idle.Util < 0 || c.Idle.Util > 100 {
+		return fmt.Errorf("idle: util is a percentage from 0 to 100")
+	}
+	if c.Idle.Window < 0 {
+		return fmt.Errorf("idle: window must not be negative")
+	}
+	if t := c.Thresholds; t.ProcessVRAMWarn < 0 || t.ProcessVRAMWarn > t.ProcessVRAMCrit || t.ProcessVRAMCrit > 100 {
+		return fmt.Errorf("thresholds: process_vram_warn and process_vram_crit are percentages from 0 to 100, warn first")
+	}
+	if t := c.Thresholds; t.GTTWarn < 0 || t.GTTWarn > t.GTTCrit || t.GTTCrit > 100 {
+		return fmt.Errorf("thresholds: gtt_warn and gtt_crit are percentages from 0 to 100, warn first")
+	}
+	for i, o := range c.Thresholds.GPUs {
+		if err := o.scope().validate(); err != nil {
+			return fmt.Errorf("thresholds.gpus %d: %v", i+1, err)
+		}
+	}
+	for id, alias := range c.Aliases {
+		if strings.TrimSpace(alias) == "" {
+			return fmt.Errorf("aliases: empty alias for %q", id)
+		}
+	}
+	for i, o := range c.Capabilities {
+		if err := o.scope().validate(); err != nil {
+			return fmt.Errorf("capabilities %d: %v", i+1, err)
+		}
+		for _, keys := range [][]string{o.Show, o.Hide} {
+			if _, err := parseCaps(keys); err != nil {
+				return fmt.Errorf("capabilities %d: %v", i+1, err)
+			}
+		}
+	}
+	if c.Theme != "default" && c.Theme != "monochrome" {
+		return fmt.Errorf("unknown theme %q (use default or monochrome)", c.Theme)
+	}
+	if err := c.Columns.validate(); err != nil {
+		return err
+	}
+	if _, err := newKeymap(c.Keys, false); err != nil {
+		return err
+	}
+	if c.Sort != "" {
+		if _, _, err := parseSort(c.Sort); err != nil {
+			return err
+		}
+	}
+	if c.Alerts.LogMaxMB < 0 {
+		return fmt.Errorf("alerts: log_max_mb must not be negative")
+	}
+	for _, mode := range []string{c.Alerts.Bell.Info, c.Alerts.Bell.Warning, c.Alerts.Bell.Critical} {
+		if mode != bellNone && mode != bellAudible && mode != bellVisual {
+			return fmt.Errorf("alerts.bell: unknown bell %q (use audible, visual or none)", mode)
+		}
+	}
+	for i := range c.Alerts.Rules {
+		rule := &c.Alerts.Rules[i]
+		if _, ok := alertMetrics[rule.Metric]; !ok {
+			return fmt.Errorf("alert rule %d: unknown metric %q", i+1, rule.Metric)
+		}
+		if rule.Op == "" {
+			rule.Op = ">"
+		}
+		if _, ok := alertOps[rule.Op]; !ok {
+			return fmt.Errorf("alert rule %d: unknown op %q", i+1, rule.Op)
+		}
+		if rule.Severity == "" {
+			rule.Severity = "warning"
+		}
+		if _, ok := notifyUrgency[rule.Severity]; !ok {
+			return fmt.Errorf("alert rule %d: unknown severity %q (use info, warning or critical)", i+1, rule.Severity)
+		}
+		if rule.Name == "" {
+			rule.Name = rule.Metric
+		}
+		if rule.Cooldown == 0 {
+			rule.Cooldown = c.Alerts.Cooldown
+		}
+		if rule.Sustain < 0 || rule.MinFiring < 0 {
+			return fmt.Errorf("alert rule %d: sustain and min_firing must not be negative", i+1)
+		}
+		if rule.ClearThreshold == nil {
+			rule.ClearThreshold = defaultClearThreshold(rule.Op, rule.Threshold)
+		}
+		if err := checkClearThreshold(rule.Op, rule.Threshold, *rule.ClearThreshold); err != nil {
+			return fmt.Errorf("alert rule %d: %v", i+1, err)
+		}
+		for j := range rule.Overrides {
+			o := &rule.Overrides[j]
+			if err := o.scope().validate(); err != nil {
+				return fmt.Errorf("alert rule %d override %d: %v", i+1, j+1, err)
+			}
+			if o.Threshold != nil && o.ClearThreshold == nil {
+				o.ClearThreshold = defaultClearThreshold(rule.Op, *o.Threshold)
+			}
+			// Checked against the GPU's effective threshold, which may come
+			// from a wider override; this catches the plain mistakes
+			threshold := rule.Threshold
+			if o.Threshold != nil {
+				threshold = *o.Threshold
+			}
+			if o.ClearThreshold != nil {
+				if err := checkClearThreshold(rule.Op, threshold, *o.ClearThreshold); err != nil {
+					return fmt.Errorf("alert rule %d override %d: %v", i+1, j+1, err)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	ui "github.com/gizak/termui/v3"
+	"github.com/gizak/termui/v3/widgets"
+)
+
+// controlAction is a change to a GPU setting, carried out by amd-smi
+type controlAction struct {
+	host   *hostView
+	gpu    int
+	prompt string   // question shown in the confirmation dialog
+	args   []string // amd-smi arguments
+	// done runs on the UI goroutine after a successful change
+	done func()
+}
+
+// controller carries out GPU setting changes. It only exists with
+// --enable-control; without it the panels have no controller and never
+// build a command that changes anything. Every change waits for a y/N
+// confirmation and then runs in the background, reporting back on results.
+type controller struct {
+	dialog  *widgets.Paragraph
+	pending *controlAction
+	status  string // outcome of the last change, shown in the footer
+	results chan controlResult
+}
+
+type controlResult struct {
+	action controlAction
+	err    error
+}
+
+func newController() *controller {
+	c := &controller{results: make(chan controlResult, 1)}
+	c.dialog = widgets.NewParagraph()
+	c.dialog.Title = "Confirm"
+	c.dialog.BorderStyle = ui.NewStyle(ui.ColorRed)
+	c.dialog.TextStyle = ui.NewStyle(ui.ColorWhite, ui.ColorClear, ui.ModifierBold)
+	return c
+}
+
+// confirming reports whether the dialog is open; a nil controller never is
+func (c *controller) confirming() bool {
+	return c != nil && c.pending != nil
+}
+
+// ask opens the confirmation dialog for an action
+func (c *controller) ask(a controlAction) {
+	c.pending = &a
+	c.dialog.Text = a.prompt + "  [y/N]"
+}
+
+// handleEvent answers the open dialog; anything but y cancels
+func (c *controller) handleEvent(e ui.Event) {
+	if e.ID == "y" || e.ID == "Y" {
+		c.run(*c.pending)
+	} else {
+		c.status = "cancelled"
+	}
+	c.pending = nil
+}
+
+func (c *controller) run(a controlAction) {
+	c.status = "applying…"
+	debugLog.Info("control", "host", a.host.name, "gpu", a.gpu, "args", a.args)
+	go func() {
+		_, err := runCommand(a.host.runner, "amd-smi", a.args...)
+		c.results <- controlResult{action: a, err: err}
+	}()
+}
+
+// finish records the outcome of a change
+func (c *controller) finish(r controlResult) {
+	if r.err == nil {
+		c.status = fmt.Sprintf("GPU %d: applied", r.action.gpu)
+		if r.action.done != nil {
+			r.action.done()
+		}
+		return
+	}
+	debugLog.Warn("control failed", "gpu", r.action.gpu, "args", r.action.args, "err", r.err)
+	c.status = "failed: " + oneLine(r.err.Error())
+	if text := strings.ToLower(r.err.Error()); strings.Contains(text, "permission") || strings.Contains(text, "no_perm") {
+		c.status = "permission denied: changing GPU settings needs root"
+	}
+}
+
+// layout centers the confirmation dialog on the screen
+func (c *controller) layout(width, height int) {
+	w := min(72, width)
+	c.dialog.SetRect((width-w)/2, height/2-2, (width+w)/2, height/2+1)
+}
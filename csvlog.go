@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	gpuCSVHeader = []string{"timestamp", "host", "gpu", "gfx_util", "power", "gpu_temp", "mem_temp",
+		"gfx_clock", "mem_util", "mem_clock", "vram_used", "vram_total"}
+	processCSVHeader = []string{"timestamp", "host", "gpu", "name", "pid", "gfx_usage",
+		"vram_mb", "gtt_mb", "cpu_mb", "total_mb"}
+)
+
+// rotatingFile is an append-only file that is rotated once it grows past maxBytes,
+// keeping up to keep old copies as path.1 … path.N. Files without a header
+// take raw lines through writeLine instead of CSV rows.
+type rotatingFile struct {
+	path     string
+	header   []string
+	maxBytes int64
+	keep     int
+	file     *os.File
+	writer   *csv.Writer
+	size     int64
+}
+
+func openRotatingFile(path string, header []string, maxBytes int64, keep int) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, header: header, maxBytes: maxBytes, keep: keep}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	if rf.size > 0 && header != nil && !hasHeader(path, header) {
+		// Rows with other columns would be appended under the old header
+		if keep == 0 {
+			rf.close()
+			return nil, fmt.Errorf("%s has other columns than this version writes; move it aside", path)
+		}
+		if err := rf.rotate(); err != nil {
+			rf.close()
+			return nil, err
+		}
+	}
+	return rf, nil
+}
+
+// hasHeader reports whether the CSV file at path starts with header
+func hasHeader(path string, header []string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	first, err := csv.NewReader(f).Read()
+	return err == nil && slices.Equal(first, header)
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.file = f
+	rf.writer = csv.NewWriter(rf)
+	rf.size = info.Size()
+	// Only write a header into a fresh file
+	if rf.size == 0 && rf.header != nil {
+		rf.writer.Write(rf.header)
+	}
+	return nil
+}
+
+// Write implements io.Writer so the csv.Writer can track the file size.
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) writeRows(rows [][]string) error {
+	if rf.maxBytes > 0 && rf.size >= rf.maxBytes {
+		if err := rf.rotate(); err != nil {
+			return err
+		}
+	}
+	rf.writer.WriteAll(rows)
+	if err := rf.writer.Error(); err != nil {
+		// A failed bufio.Writer stays failed, start over so the next tick can retry
+		rf.writer = csv.NewWriter(rf)
+		return err
+	}
+	return nil
+}
+
+// writeLine appends one line as is, rotating first like writeRows
+func (rf *rotatingFile) writeLine(line []byte) error {
+	if rf.maxBytes > 0 && rf.size >= rf.maxBytes {
+		if err := rf.rotate(); err != nil {
+			return err
+		}
+	}
+	_, err := rf.Write(append(line, '\n'))
+	return err
+}
+
+func (rf *rotatingFile) rotate() error {
+	if err := rf.close(); err != nil {
+		return err
+	}
+	if rf.keep > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", rf.path, rf.keep))
+		for i := rf.keep - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+		}
+		if err := os.Rename(rf.path, rf.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(rf.path); err != nil {
+		return err
+	}
+	return rf.open()
+}
+
+func (rf *rotatingFile) close() error {
+	if rf.file == nil {
+		return nil
+	}
+	rf.writer.Flush()
+	err := rf.writer.Error()
+	if cerr := rf.file.Close(); err == nil {
+		err = cerr
+	}
+	rf.file = nil
+	return err
+}
+
+// CSVLogger records every sample to a GPU CSV file and a sibling process CSV file.
+type CSVLogger struct {
+	host      string
+	gpus      *rotatingFile
+	processes *rotatingFile
+}
+
+// processLogPath derives the process log name from the GPU log name,
+// e.g. run.csv -> run.processes.csv
+func processLogPath(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + ".processes" + ext
+}
+
+func newCSVLogger(path string, maxMB int, keep int) (*CSVLogger, error) {
+	maxBytes := int64(maxMB) * 1024 * 1024
+	gpus, err := openRotatingFile(path, gpuCSVHeader, maxBytes, keep)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CSV log: %v", err)
+	}
+	processes, err := openRotatingFile(processLogPath(path), processCSVHeader, maxBytes, keep)
+	if err != nil {
+		gpus.close()
+		return nil, fmt.Errorf("failed to open process CSV log: %v", err)
+	}
+	return &CSVLogger{host: hostname(), gpus: gpus, processes: processes}, nil
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// Write appends one row per GPU and one row per process. Rows are flushed
+// every call so a crash loses at most the current tick.
+func (l *CSVLogger) Write(s Sample) error {
+	ts := zoned(s.Time).Format(time.RFC3339)
+	host := sampleHost(s, l.host)
+	gpuRows := make([][]string, 0, len(s.GPUs))
+	for _, m := range s.GPUs {
+		gpuRows = append(gpuRows, []string{ts, host, strconv.Itoa(m.ID),
+			formatFloat(m.GFXUtil), formatFloat(m.Power), formatFloat(m.GPUTemp), formatFloat(m.MemTemp),
+			formatFloat(m.GFXClock), formatFloat(m.MemUtil), formatFloat(m.MemClock),
+			formatFloat(m.VRAMUsed), formatFloat(m.VRAMTotal)})
+	}
+	if err := l.gpus.writeRows(gpuRows); err != nil {
+		return fmt.Errorf("CSV log write failed: %v", err)
+	}
+	procRows := make([][]string, 0, len(s.Processes))
+	for _, p := range s.Processes {
+		procRows = append(procRows, []string{ts, host, strconv.Itoa(p.GPU), p.Name, strconv.Itoa(p.Pid),
+			formatFloat(p.UsagePercent),
+			formatFloat(mib(p.VRAMBytes)), formatFloat(mib(p.GTTBytes)), formatFloat(mib(p.CPUBytes)), formatFloat(mib(p.TotalBytes))})
+	}
+	if err := l.processes.writeRows(procRows); err != nil {
+		return fmt.Errorf("CSV process log write failed: %v", err)
+	}
+	return nil
+}
+
+// Close flushes and closes both log files.
+func (l *CSVLogger) Close() error {
+	err := l.gpus.close()
+	if perr := l.processes.close(); err == nil {
+		err = perr
+	}
+	return err
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCSVHost writes the host of each sample into both logs, and moves a
+// log with the old columns aside rather than appending under its header
+func TestCSVHost(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "run.csv")
+	old := "timestamp,gpu,gfx_util\n2024-01-01T00:00:00Z,0,5\n"
+	if err := os.WriteFile(path, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := newCSVLogger(path, 100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sample := Sample{
+		Host:      "node-b",
+		Time:      t0,
+		GPUs:      []GPUMetrics{{GPUDevice: GPUDevice{ID: 3}, GFXUtil: 40}},
+		Processes: []ProcessInfo{{GPU: 3, Name: "train", Pid: 4242}},
+	}
+	if err := l.Write(sample); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		path, header, row string
+	}{
+		{path, strings.Join(gpuCSVHeader, ","), ",node-b,3,40,"},
+		{processLogPath(path), strings.Join(processCSVHeader, ","), ",node-b,3,train,4242,"},
+	} {
+		data, err := os.ReadFile(tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) != 2 || lines[0] != tc.header || !strings.Contains(lines[1], tc.row) {
+			t.Errorf("%s:\n%s\nwant the header and a row with %q", filepath.Base(tc.path), data, tc.row)
+		}
+	}
+	if data, err := os.ReadFile(path + ".1"); err != nil || string(data) != old {
+		t.Errorf("the old log was not kept as is: %q, %v", data, err)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// debugLog never writes to the terminal while the TUI owns it; it is
+// discarded unless --log-file is given.
+var debugLog = slog.New(slog.DiscardHandler)
+
+// execLogLines is how much of a command's output is kept at debug level
+const execLogLines = 5
+
+func openDebugLog(path, level string) (io.Closer, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid --log-level %q (use debug, info, warn or error)", level)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	debugLog = slog.New(slog.NewTextHandler(f, &slog.HandlerOptions{Level: lvl}))
+	return f, nil
+}
+
+// logExec records one SMI tool invocation: how long it took, how it exited
+// and, at debug level, the start of what it printed.
+func logExec(host, name string, args []string, start time.Time, out, stderr []byte, err error) {
+	attrs := []any{
+		"cmd", strings.Join(append([]string{name}, args...), " "),
+		"duration", time.Since(start).Round(time.Millisecond),
+	}
+	if host != "" {
+		attrs = append(attrs, "host", host)
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		attrs = append(attrs, "exit", exitErr.ExitCode())
+		if stderr := bytes.TrimSpace(stderr); len(stderr) > 0 {
+			attrs = append(attrs, "stderr", firstLines(stderr, execLogLines))
+		}
+	case err != nil:
+		attrs = append(attrs, "err", err)
+	default:
+		attrs = append(attrs, "exit", 0)
+	}
+	if err != nil {
+		debugLog.Warn("exec failed", attrs...)
+		return
+	}
+	debugLog.Debug("exec", append(attrs, "output", firstLines(out, execLogLines))...)
+}
+
+func firstLines(b []byte, n int) string {
+	lines := strings.SplitN(string(b), "\n", n+1)
+	if len(lines) > n {
+		lines = append(lines[:n], "…")
+	}
+	return strings.Join(lines, "\n")
+}
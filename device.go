@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// gpuCaps is the set of things a GPU has that not every vendor's or
+// generation's does. The UI asks for a capability rather than checking
+// the vendor, so a new backend only has to say what its devices have.
+type gpuCaps uint16
+
+const (
+	capJunctionTemp gpuCaps = 1 << iota // hotspot sensor; without it the temperature is edge or core
+	capGTT                              // GTT size and per-process GTT use
+	capXGMI                             // XGMI links to peer GPUs
+	capMedia                            // video encode and decode engines
+	capPower                            // board power
+	capMemTemp                          // memory (HBM) temperature sensor
+	capMemBusy                          // memory controller activity
+	capProcessUsage                     // per-process GFX usage
+	capProcessCPU                       // per-process system memory
+)
+
+// readCaps are the capabilities a reading has when the metric wasn't N/A.
+// The rest are the backend's and the same in every sample.
+const readCaps = capPower | capMemTemp | capMemBusy
+
+// capNames name capabilities in the config (key) and the GPU info panel
+var capNames = []struct {
+	cap  gpuCaps
+	key  string
+	name string
+}{
+	{capJunctionTemp, "junction_temp", "junction temp"},
+	{capGTT, "gtt", "GTT"},
+	{capXGMI, "xgmi", "XGMI"},
+	{capMedia, "media", "media"},
+	{capPower, "power", "power"},
+	{capMemTemp, "mem_temp", "memory temp"},
+	{capMemBusy, "mem_util", "memory activity"},
+	{capProcessUsage, "process_usage", "process usage"},
+	{capProcessCPU, "process_cpu", "process CPU memory"},
+}
+
+func (c gpuCaps) has(cap gpuCaps) bool {
+	return c&cap == cap
+}
+
+// String lists the capabilities shown in the GPU info panel, the ones
+// that set devices apart: XGMI and media engines
+func (c gpuCaps) String() string {
+	var names []string
+	for _, n := range capNames {
+		if c.has(n.cap) && n.cap&(capXGMI|capMedia) != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// parseCaps reads capability keys as in [[capabilities]]
+func parseCaps(keys []string) (gpuCaps, error) {
+	var caps gpuCaps
+	for _, key := range keys {
+		found := false
+		for _, n := range capNames {
+			if n.key == key {
+				caps, found = caps|n.cap, true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown capability %q", key)
+		}
+	}
+	return caps, nil
+}
+
+// capProbeSamples is how many samples in a row a metric may be N/A
+// before its GPU counts as not having it
+const capProbeSamples = 5
+
+// capDetector learns which metrics a host's GPUs report. A GPU is assumed
+// to have them all for its first samples, so titles of those that do
+// don't change once that's known; a metric that shows up later is back.
+type capDetector struct {
+	seen    map[int]gpuCaps // readCaps of each GPU's samples so far, by ID
+	samples map[int]int
+}
+
+// apply returns the GPUs with the capabilities detected so far and the
+// [[capabilities]] overrides in place of those of this reading
+func (d *capDetector) apply(gpus []GPUMetrics, overrides []CapabilityOverride) []GPUMetrics {
+	if d.seen == nil {
+		d.seen, d.samples = map[int]gpuCaps{}, map[int]int{}
+	}
+	out := make([]GPUMetrics, len(gpus))
+	for i, m := range gpus {
+		d.seen[m.ID] |= m.Caps & readCaps
+		d.samples[m.ID]++
+		m.Caps = m.Caps&^readCaps | d.seen[m.ID]
+		if d.samples[m.ID] < capProbeSamples {
+			m.Caps |= readCaps
+		}
+		for _, o := range inScope(overrides, CapabilityOverride.scope, m.ID, m.BDF, m.ASIC) {
+			// Checked when the config was loaded
+			show, _ := parseCaps(o.Show)
+			hide, _ := parseCaps(o.Hide)
+			m.Caps = (m.Caps | show) &^ hide
+		}
+		out[i] = m
+	}
+	return out
+}
+
+// GPUDevice is a GPU's identity, whichever vendor's tool reads it, and
+// what it can report. Every sample's metrics carry their device.
+type GPUDevice struct {
+	ID     int    `json:"id"`               // index, unique on its host
+	Vendor string `json:"vendor,omitempty"` // amd, nvidia or intel with several --vendors, empty otherwise
+	BDF    string `json:"bdf,omitempty"`    // PCIe bus address, the stable identity
+	UUID   string `json:"uuid,omitempty"`
+	Serial string `json:"serial,omitempty"` // board serial, for asset tracking
+	ASIC   string `json:"asic,omitempty"`   // market name, for matching per-GPU thresholds
+	Alias  string `json:"alias,omitempty"`  // from [aliases], by bus address or UUID
+	// APUs have a small VRAM carve-out and allocate mostly from the GTT
+	APU  bool    `json:"apu,omitempty"`
+	Caps gpuCaps `json:"-"`
+}
+
+// name is how the UI refers to the GPU: "GPU 3", or "render (GPU 3)"
+// with an alias
+func (d GPUDevice) name() string {
+	if d.Alias == "" {
+		return fmt.Sprintf("GPU %d", d.ID)
+	}
+	return fmt.Sprintf("%s (GPU %d)", d.Alias, d.ID)
+}
+
+// amd reports whether a device is read by the AMD backends
+func (d GPUDevice) amd() bool {
+	return d.Vendor == "" || d.Vendor == vendorAMD
+}
+
+// unionCaps is what some GPU of the hosts has, or everything while no
+// GPU is known
+func unionCaps(hosts []*hostView) gpuCaps {
+	var caps gpuCaps
+	known := false
+	for _, h := range hosts {
+		for _, m := range h.lastGPUs {
+			caps, known = caps|m.Caps, true
+		}
+	}
+	if !known {
+		return ^gpuCaps(0)
+	}
+	return caps
+}
+
+// gpuAliases maps lowercase bus addresses and UUIDs to the names given in
+// [aliases]. Those identify a GPU whatever its index after a reboot.
+type gpuAliases map[string]string
+
+func newGPUAliases(config map[string]string) gpuAliases {
+	a := make(gpuAliases, len(config))
+	for id, alias := range config {
+		a[strings.ToLower(strings.TrimSpace(id))] = alias
+	}
+	return a
+}
+
+// label names a sample's GPUs and their processes' GPUs. A UUID wins over
+// a bus address; GPUs with neither in the config keep their index.
+func (a gpuAliases) label(s *Sample) {
+	if len(a) == 0 {
+		return
+	}
+	byID := map[int]string{}
+	for i := range s.GPUs {
+		m := &s.GPUs[i]
+		m.Alias = a[strings.ToLower(m.BDF)]
+		if alias, ok := a[strings.ToLower(m.UUID)]; ok && m.UUID != "" {
+			m.Alias = alias
+		}
+		byID[m.ID] = m.Alias
+	}
+	for i := range s.Processes {
+		s.Processes[i].GPUAlias = byID[s.Processes[i].GPU]
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ui "github.com/gizak/termui/v3"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// render draws d in a w by h buffer and returns it as text: the runes of
+// each row, then the row again with a letter for the style of each cell,
+// and the styles the letters stand for, so a change of color shows too
+func render(d ui.Drawable, w, h int) string {
+	d.SetRect(0, 0, w, h)
+	buf := ui.NewBuffer(image.Rect(0, 0, w, h))
+	d.Draw(buf)
+	var text, styles strings.Builder
+	letters := map[ui.Style]byte{}
+	var legend []string
+	for y := range h {
+		for x := range w {
+			cell := buf.GetCell(image.Pt(x, y))
+			letter, ok := letters[cell.Style]
+			if !ok {
+				letter = byte('a' + len(letters))
+				letters[cell.Style] = letter
+				legend = append(legend, fmt.Sprintf("%c: fg %d bg %d mod %d", letter, cell.Style.Fg, cell.Style.Bg, cell.Style.Modifier))
+			}
+			text.WriteRune(cell.Rune)
+			styles.WriteByte(letter)
+		}
+		text.WriteByte('\n')
+		styles.WriteByte('\n')
+	}
+	return text.String() + "\n" + styles.String() + "\n" + strings.Join(legend, "\n") + "\n"
+}
+
+// checkGolden compares got with testdata/name.golden, or writes it there
+// with -update
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.MkdirAll("testdata", 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if got != string(want) {
+		t.Errorf("rendering differs from %s:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+// amdGPU is a GPU as amd-smi reports it
+func amdGPU(id int, bdf string) GPUMetrics {
+	return GPUMetrics{
+		GPUDevice: GPUDevice{ID: id, BDF: bdf, ASIC: "AMD Instinct MI210",
+			Caps: capJunctionTemp | capGTT | capProcessUsage | capProcessCPU | capPower | capMemTemp | capMemBusy},
+		Power: 301, GPUTemp: 64, MemTemp: 71, GFXUtil: 87, GFXClock: 1650, MemUtil: 35, MemClock: 1600,
+		VRAMUsed: 40960, VRAMTotal: 65520,
+	}
+}
+
+// nvidiaGPU is a GPU as nvidia-smi reports it: no GTT or process usage
+func nvidiaGPU(id int, bdf string) GPUMetrics {
+	return GPUMetrics{
+		GPUDevice: GPUDevice{ID: id, Vendor: vendorNVIDIA, BDF: bdf, ASIC: "NVIDIA A100",
+			Caps: capMedia | capPower | capMemTemp | capMemBusy},
+		Power: 250, GPUTemp: 58, MemTemp: 66, GFXUtil: 93, GFXClock: 1410, MemUtil: 41, MemClock: 1215,
+		VRAMUsed: 30000, VRAMTotal: 81920,
+	}
+}
+
+var goldenProcesses = []ProcessInfo{
+	{GPU: 0, Pid: 4242, Name: "python3 train.py", UsagePercent: 85.5, VRAMBytes: 32 << 30, GTTBytes: 512 << 20, CPUBytes: 64 << 20, TotalBytes: 32<<30 + 576<<20},
+	{GPU: 1, Pid: 977, Name: "llama-server", UsagePercent: 12, VRAMBytes: 8 << 30, GTTBytes: 16 << 20, TotalBytes: 8<<30 + 16<<20},
+	{GPU: 1, Pid: 31337, Name: "rocm-bandwidth-test", UsagePercent: 0, VRAMBytes: 256 << 20, TotalBytes: 256 << 20},
+}
+
+func goldenHost(gpus []GPUMetrics) *hostView {
+	h := newHostView("", nil)
+	h.addGPUs(gpuIDs(gpus), 30, 62, false)
+	h.lastGPUs = gpus
+	return h
+}
+
+// TestGoldenProcessList renders the process list; the GTT and CPU memory
+// columns are only there for GPUs that report them
+func TestGoldenProcessList(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		gpus []GPUMetrics
+	}{
+		{"processes_amd", []GPUMetrics{amdGPU(0, "0000:03:00.0"), amdGPU(1, "0000:83:00.0")}},
+		{"processes_nvidia", []GPUMetrics{nvidiaGPU(0, "0000:17:00.0"), nvidiaGPU(1, "0000:65:00.0")}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			v := newProcessView(3, true, false) // by usage, highest first
+			v.setGPUTotals([]*hostView{goldenHost(tc.gpus)})
+			processes := goldenProcesses
+			if !tc.gpus[0].Caps.has(capGTT) {
+				processes = nil
+				for _, p := range goldenProcesses {
+					p.GTTBytes, p.CPUBytes, p.UsagePercent = 0, 0, 0
+					processes = append(processes, p)
+				}
+			}
+			v.update(processes)
+			checkGolden(t, tc.name, render(v.list, 180, 7))
+		})
+	}
+}
+
+// TestGoldenStaticPanel renders the GPU info panel, which gets a FEATURES
+// column only when some GPU has XGMI links or media engines
+func TestGoldenStaticPanel(t *testing.T) {
+	static := func(bdf string, caps gpuCaps) StaticInfo {
+		return StaticInfo{BDF: bdf, MaxClock: 1700, PowerProfile: "BOOTUP_DEFAULT", PerfLevel: "auto", NUMANode: -1,
+			DeviceInfo: DeviceInfo{VRAM: VRAMInfo{Type: "HBM2E", Vendor: "SAMSUNG", BitWidth: 4096}, Serial: "692251001234",
+				UUID: "e3ff74a1-0000-1000-8000-000000000001", ASIC: "AMD Instinct MI210", Caps: caps}}
+	}
+	for _, tc := range []struct {
+		name string
+		caps gpuCaps
+	}{
+		{"static_amd", 0},
+		{"static_xgmi", capXGMI | capMedia},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := goldenHost([]GPUMetrics{amdGPU(0, "0000:03:00.0"), amdGPU(1, "0000:83:00.0")})
+			h.static = map[int]StaticInfo{0: static("0000:03:00.0", tc.caps), 1: static("0000:83:00.0", tc.caps)}
+			p := newStaticPanel(nil)
+			p.thresholds = defaultConfig().Thresholds
+			p.update([]*hostView{h}, false)
+			checkGolden(t, tc.name, render(p.table, 200, 5))
+		})
+	}
+}
+
+// TestGoldenChart renders a GPU's utilization chart with its title
+func TestGoldenChart(t *testing.T) {
+	m := amdGPU(0, "0000:03:00.0")
+	h := goldenHost([]GPUMetrics{m})
+	for n := range 20 {
+		m.GFXUtil = float64(n * 5)
+		h.record(0, t0.Add(time.Duration(n)*time.Second), m)
+	}
+	h.redraw(0, 0)
+	h.charts[0].Title = h.chartTitle(m, 47.5, false)
+	checkGolden(t, "chart_amd", render(h.charts[0], 62, 10))
+}
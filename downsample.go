@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// downsampleMode is how a chart fits a history longer than it is wide
+// (chart_history) into its columns
+type downsampleMode string
+
+const (
+	downsampleMax  downsampleMode = "max"  // the highest sample, so spikes stay visible
+	downsampleMean downsampleMode = "mean" // the average, which smooths spikes away
+	// downsampleMinMax charts the highest samples above the lowest, in two
+	// rows, like a candlestick chart without the bodies
+	downsampleMinMax downsampleMode = "minmax"
+	// downsampleLTTB picks the sample of each column that keeps the shape
+	// of the line (largest triangle three buckets)
+	downsampleLTTB downsampleMode = "lttb"
+	// downsampleMin is the lower row of minmax
+	downsampleMin downsampleMode = "min"
+)
+
+// downsampleModes are the modes in the order 'd' cycles through them; the
+// first is the default
+var downsampleModes = []downsampleMode{downsampleMax, downsampleMean, downsampleMinMax, downsampleLTTB}
+
+// maxHistoryPoints bounds the samples kept per chart metric and GPU
+const maxHistoryPoints = 100_000
+
+func parseDownsample(s string) (downsampleMode, error) {
+	mode := downsampleMode(s)
+	if !slices.Contains(downsampleModes, mode) {
+		names := make([]string, len(downsampleModes))
+		for i, m := range downsampleModes {
+			names[i] = string(m)
+		}
+		return "", fmt.Errorf("unknown downsampling %q (want %s)", s, strings.Join(names, ", "))
+	}
+	return mode, nil
+}
+
+// next is the mode after m in downsampleModes
+func (m downsampleMode) next() downsampleMode {
+	return downsampleModes[(slices.Index(downsampleModes, m)+1)%len(downsampleModes)]
+}
+
+// historyPoints is how many samples the charts keep: one per column, or
+// enough to reach back chart_history if that is more
+func historyPoints(columns int, history, interval time.Duration) int {
+	return min(max(columns, int(history/interval)), maxHistoryPoints)
+}
+
+// at is the k-th recorded sample, oldest first
+func (gh *GPUHistory) at(k int) float64 {
+	if gh.count < gh.maxLen {
+		return gh.values[k]
+	}
+	return gh.values[(gh.index+k)%gh.maxLen]
+}
+
+// filledColumns is how many of a chart's columns the recorded samples
+// take up, at the rate a full history would be shown
+func (gh *GPUHistory) filledColumns(columns int) int {
+	return (gh.count*columns + gh.maxLen - 1) / gh.maxLen
+}
+
+// downsample is fillChart for a chart columns wide, which fits a longer
+// history in by reducing the samples of each column with mode. Columns
+// the history doesn't reach yet are blank, as with fillChart.
+func (gh *GPUHistory) downsample(dst []float64, columns int, mode downsampleMode) []float64 {
+	if gh.maxLen <= columns {
+		return gh.fillChart(dst)
+	}
+	if cap(dst) < columns {
+		dst = make([]float64, 0, columns)
+	}
+	dst = dst[:0]
+	filled := gh.filledColumns(columns)
+	for range columns - filled {
+		dst = append(dst, chartBlank)
+	}
+	if mode == downsampleLTTB {
+		return gh.appendLTTB(dst, filled)
+	}
+	for c := range filled {
+		lo, hi := c*gh.count/filled, (c+1)*gh.count/filled
+		v := gh.at(lo)
+		var sum float64
+		for k := lo; k < hi; k++ {
+			switch x := gh.at(k); mode {
+			case downsampleMin:
+				v = min(v, x)
+			case downsampleMean:
+				sum += x
+			default:
+				v = max(v, x)
+			}
+		}
+		if mode == downsampleMean {
+			v = sum / float64(hi-lo)
+		}
+		dst = append(dst, v)
+	}
+	return dst
+}
+
+// appendLTTB appends n of the recorded samples, picked by largest triangle
+// three buckets: the first and the last, and from each bucket in between
+// the one spanning the largest triangle with the sample picked before it
+// and the mean of the next bucket
+func (gh *GPUHistory) appendLTTB(dst []float64, n int) []float64 {
+	if n < 3 || gh.count <= n {
+		for c := range n {
+			dst = append(dst, gh.at(c*gh.count/n))
+		}
+		return dst
+	}
+	// The buckets divide up the samples between the first and the last
+	bucket := func(b int) (int, int) {
+		return 1 + b*(gh.count-2)/(n-2), 1 + (b+1)*(gh.count-2)/(n-2)
+	}
+	picked := 0
+	dst = append(dst, gh.at(0))
+	for b := range n - 2 {
+		lo, hi := bucket(b)
+		// The mean of the next bucket, or the last sample after the last one
+		nextX, nextY := float64(gh.count-1), gh.at(gh.count-1)
+		if b < n-3 {
+			nlo, nhi := bucket(b + 1)
+			nextX, nextY = 0, 0
+			for k := nlo; k < nhi; k++ {
+				nextX += float64(k)
+				nextY += gh.at(k)
+			}
+			nextX /= float64(nhi - nlo)
+			nextY /= float64(nhi - nlo)
+		}
+		px, py := float64(picked), gh.at(picked)
+		best, bestArea := lo, -1.0
+		for k := lo; k < hi; k++ {
+			area := math.Abs((px-nextX)*(gh.at(k)-py) - (px-float64(k))*(nextY-py))
+			if area > bestArea {
+				best, bestArea = k, area
+			}
+		}
+		picked = best
+		dst = append(dst, gh.at(picked))
+	}
+	return append(dst, gh.at(gh.count-1))
+}
+
+// chartColumn is the column of a chart columns wide that the sample taken
+// at or right after t is drawn in, or -1 once t has scrolled out
+func (gh *GPUHistory) chartColumn(t time.Time, columns int) int {
+	col := gh.column(t)
+	if col < 0 || gh.maxLen <= columns {
+		return col
+	}
+	filled := gh.filledColumns(columns)
+	k := col - (gh.maxLen - gh.count)
+	// Sample k is in the column whose bucket [c*count/filled, (c+1)*count/filled) holds it
+	return columns - filled + ((k+1)*filled-1)/gh.count
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// spikyHistory is a full history of n samples at 10 with a spike to 100
+// at each of spikes and a dip to 0 at each of dips
+func spikyHistory(n int, spikes, dips []int) *GPUHistory {
+	h := newGPUHistory(n)
+	for i := range n {
+		v := 10.0
+		switch {
+		case slices.Contains(spikes, i):
+			v = 100
+		case slices.Contains(dips, i):
+			v = 0
+		}
+		h.add(t0.Add(time.Duration(i)*time.Second), v)
+	}
+	return h
+}
+
+func count(values []float64, v float64) int {
+	n := 0
+	for _, x := range values {
+		if x == v {
+			n++
+		}
+	}
+	return n
+}
+
+func TestDownsampleLength(t *testing.T) {
+	for _, mode := range slices.Concat(downsampleModes, []downsampleMode{downsampleMin}) {
+		for _, tc := range []struct {
+			added, blank int
+		}{
+			{0, 50},
+			{300, 35}, // 300 of 1000 samples fill 15 of 50 columns
+			{1000, 0},
+			{2500, 0},
+		} {
+			h := filledHistory(1000, tc.added)
+			got := h.downsample(nil, 50, mode)
+			if len(got) != 50 {
+				t.Errorf("%s with %d samples: %d columns, want 50", mode, tc.added, len(got))
+				continue
+			}
+			if n := count(got, chartBlank); n != tc.blank {
+				t.Errorf("%s with %d samples: %d blank columns, want %d", mode, tc.added, n, tc.blank)
+			}
+		}
+	}
+}
+
+func TestDownsampleShortHistory(t *testing.T) {
+	// A history no longer than the chart is charted sample for sample
+	h := filledHistory(40, 25)
+	for _, mode := range downsampleModes {
+		if got, want := h.downsample(nil, 50, mode), h.fillChart(nil); !slices.Equal(got, want) {
+			t.Errorf("%s: %v, want %v", mode, got, want)
+		}
+	}
+}
+
+func TestDownsampleSpikes(t *testing.T) {
+	spikes, dips := []int{17, 333, 334, 901}, []int{500, 777}
+	h := spikyHistory(1000, spikes, dips)
+	const columns = 50 // 20 samples a column
+
+	// max keeps every spike, one column each (333 and 334 share one)
+	maxes := h.downsample(nil, columns, downsampleMax)
+	if n := count(maxes, 100); n != 3 {
+		t.Errorf("max shows %d spikes, want 3: %v", n, maxes)
+	}
+	if slices.Min(maxes) != 10 {
+		t.Errorf("max shows a dip: %v", maxes)
+	}
+
+	// minmax keeps the spikes in its upper row and the dips in its lower
+	if mins := h.downsample(nil, columns, downsampleMin); count(mins, 0) != 2 || slices.Max(mins) != 10 {
+		t.Errorf("min row %v, want the 2 dips and no spike", mins)
+	}
+
+	// mean flattens them
+	means := h.downsample(nil, columns, downsampleMean)
+	if got := slices.Max(means); got >= 100 || got <= 10 {
+		t.Errorf("mean peaks at %v, want between 10 and 100", got)
+	}
+	if got, want := means[16], (100+100+18*10)/20.0; got != want {
+		t.Errorf("mean of the column with 2 spikes = %v, want %v", got, want)
+	}
+
+	// lttb keeps the first and last samples and the shape: every spike
+	// and dip alone in its bucket
+	lttb := h.downsample(nil, columns, downsampleLTTB)
+	if lttb[0] != h.at(0) || lttb[columns-1] != h.at(h.count-1) {
+		t.Errorf("lttb starts at %v and ends at %v, want %v and %v", lttb[0], lttb[columns-1], h.at(0), h.at(h.count-1))
+	}
+	if count(lttb, 100) < 3 || count(lttb, 0) != 2 {
+		t.Errorf("lttb lost a spike or dip: %v", lttb)
+	}
+}
+
+func TestDownsampleLTTBEnds(t *testing.T) {
+	// Partly filled, with distinct first and last samples
+	h := filledHistory(1000, 640)
+	got := h.downsample(nil, 50, downsampleLTTB)
+	filled := got[count(got, chartBlank):]
+	if filled[0] != 1 || filled[len(filled)-1] != 640 {
+		t.Errorf("lttb spans %v to %v, want 1 to 640", filled[0], filled[len(filled)-1])
+	}
+	if !slices.IsSorted(filled) {
+		t.Errorf("lttb reorders a rising series: %v", filled)
+	}
+}
+
+func TestParseDownsample(t *testing.T) {
+	for _, mode := range downsampleModes {
+		if got, err := parseDownsample(string(mode)); err != nil || got != mode {
+			t.Errorf("parseDownsample(%q) = %q, %v", mode, got, err)
+		}
+	}
+	if _, err := parseDownsample("median"); err == nil {
+		t.Error("parseDownsample accepted median")
+	}
+	// 'd' cycles through every mode and back
+	mode := downsampleModes[0]
+	for range downsampleModes {
+		mode = mode.next()
+	}
+	if mode != downsampleModes[0] {
+		t.Errorf("cycling ended at %s", mode)
+	}
+}
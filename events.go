@@ -0,0 +1,203 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"image"
+	"slices"
+	"strings"
+	"time"
+
+	ui "github.com/gizak/termui/v3"
+	"github.com/gizak/termui/v3/widgets"
+)
+
+// chartEventKind is what happened at a moment marked on a GPU's chart
+type chartEventKind int
+
+const (
+	eventProcessStart chartEventKind = iota
+	eventProcessExit
+	eventAlert
+	eventReset
+)
+
+// chartEventMarks are the marker of each kind, drawn at the top of its
+// chart column, and its color and meaning for the legend
+var chartEventMarks = []struct {
+	mark   rune
+	color  ui.Color
+	legend string
+}{
+	eventProcessStart: {'+', ui.ColorGreen, "process started"},
+	eventProcessExit:  {'-', ui.ColorCyan, "process exited"},
+	eventAlert:        {'!', ui.ColorRed, "alert fired"},
+	eventReset:        {'R', ui.ColorYellow, "GPU reset"},
+}
+
+// maxChartEvents is how many events each GPU keeps; older ones go first
+const maxChartEvents = 200
+
+type chartEvent struct {
+	at     time.Time
+	kind   chartEventKind
+	detail string
+}
+
+// chartEvents finds the events in a host's samples and keeps them by GPU
+type chartEvents struct {
+	byGPU    map[int][]chartEvent     // by GPU ID, oldest first
+	pids     map[gpuProcessKey]string // names of the last sample's processes, nil until known
+	alerting map[int][]string         // names of the rules firing on each GPU
+	gone     map[int]bool             // GPUs missing from the samples since
+}
+
+type gpuProcessKey struct{ gpu, pid int }
+
+func (ev *chartEvents) add(gpu int, e chartEvent) {
+	if ev.byGPU == nil {
+		ev.byGPU = map[int][]chartEvent{}
+	}
+	events := append(ev.byGPU[gpu], e)
+	if len(events) > maxChartEvents {
+		events = slices.Delete(events, 0, len(events)-maxChartEvents)
+	}
+	ev.byGPU[gpu] = events
+}
+
+// observeProcesses marks the processes that started or exited since the
+// last sample. ok is false when the sample has no process list, which
+// forgets them: nothing is marked across a gap.
+func (ev *chartEvents) observeProcesses(t time.Time, processes []ProcessInfo, ok bool) {
+	if !ok {
+		ev.pids = nil
+		return
+	}
+	pids := make(map[gpuProcessKey]string, len(processes))
+	for _, p := range processes {
+		key := gpuProcessKey{p.GPU, p.Pid}
+		pids[key] = p.Name
+		if _, seen := ev.pids[key]; !seen && ev.pids != nil {
+			ev.add(p.GPU, chartEvent{at: t, kind: eventProcessStart, detail: fmt.Sprintf("%s (PID %d) started", p.Name, p.Pid)})
+		}
+	}
+	var exited []gpuProcessKey
+	for key := range ev.pids {
+		if _, ok := pids[key]; !ok {
+			exited = append(exited, key)
+		}
+	}
+	slices.SortFunc(exited, func(a, b gpuProcessKey) int { return cmp.Or(cmp.Compare(a.gpu, b.gpu), cmp.Compare(a.pid, b.pid)) })
+	for _, key := range exited {
+		ev.add(key.gpu, chartEvent{at: t, kind: eventProcessExit, detail: fmt.Sprintf("%s (PID %d) exited", ev.pids[key], key.pid)})
+	}
+	ev.pids = pids
+}
+
+// observeGPUs marks a GPU that comes back after missing from samples, as
+// a reset does; amd-smi reports no reset count to go by. last is the GPUs
+// of the previous sample.
+func (ev *chartEvents) observeGPUs(t time.Time, last, gpus []GPUMetrics) {
+	if ev.gone == nil {
+		ev.gone = map[int]bool{}
+	}
+	for _, m := range last {
+		if !slices.ContainsFunc(gpus, func(g GPUMetrics) bool { return g.ID == m.ID }) {
+			ev.gone[m.ID] = true
+		}
+	}
+	for _, m := range gpus {
+		if ev.gone[m.ID] {
+			delete(ev.gone, m.ID)
+			ev.add(m.ID, chartEvent{at: t, kind: eventReset, detail: "GPU is back after missing from samples"})
+		}
+	}
+}
+
+// observeAlerts marks the rules that started firing since the last sample
+func (ev *chartEvents) observeAlerts(t time.Time, a *Alerter, host string, gpus []GPUMetrics) {
+	if ev.alerting == nil {
+		ev.alerting = map[int][]string{}
+	}
+	for _, m := range gpus {
+		var names []string
+		for _, f := range a.firing(host, m.ID) {
+			names = append(names, f.rule.Name)
+			if !slices.Contains(ev.alerting[m.ID], f.rule.Name) {
+				ev.add(m.ID, chartEvent{at: t, kind: eventAlert, detail: fmt.Sprintf("%s %.1f (%s)", f.rule.Name, f.value, f.rule.Severity)})
+			}
+		}
+		ev.alerting[m.ID] = names
+	}
+}
+
+// drawEvents marks a GPU's chart at the columns of its events.
+// Events are placed by time, so the marks scroll with the history and
+// follow it through resizes and downsampling. The mark goes in the top
+// row; below it a dotted line only fills blank cells to keep the bars
+// readable.
+func drawEvents(buf *ui.Buffer, inner image.Rectangle, top int, history *GPUHistory, columns int, events []chartEvent) {
+	for _, e := range events {
+		col := history.chartColumn(e.at, columns)
+		if col < 0 || col >= inner.Dx() {
+			continue
+		}
+		mark := chartEventMarks[e.kind]
+		style := ui.NewStyle(mark.color, ui.ColorClear, ui.ModifierBold)
+		x := inner.Min.X + col
+		buf.SetCell(ui.NewCell(mark.mark, style), image.Pt(x, top))
+		for y := top + 1; y < inner.Max.Y; y++ {
+			if buf.GetCell(image.Pt(x, y)).Rune == ' ' {
+				buf.SetCell(ui.NewCell('┊', ui.NewStyle(mark.color)), image.Pt(x, y))
+			}
+		}
+	}
+}
+
+// newEventTable is the event log toggled with 'E'; its title is the
+// legend of the chart marks
+func newEventTable() *widgets.Table {
+	legend := make([]string, len(chartEventMarks))
+	for i, m := range chartEventMarks {
+		legend[i] = fmt.Sprintf("%c %s", m.mark, m.legend)
+	}
+	table := widgets.NewTable()
+	table.Title = "Events: " + strings.Join(legend, ", ")
+	table.TextStyle = ui.NewStyle(ui.ColorWhite)
+	table.RowSeparator = false
+	table.BorderStyle = ui.NewStyle(ui.ColorWhite)
+	return table
+}
+
+// updateEventTable lists every GPU's events, newest first, in the colors
+// of their marks
+func updateEventTable(table *widgets.Table, hosts []*hostView, multi bool) {
+	type row struct {
+		gpu string
+		chartEvent
+	}
+	var events []row
+	for _, h := range hosts {
+		for _, id := range h.ids {
+			name := fmt.Sprintf("%sGPU %d", h.titlePrefix(multi), id)
+			if i := slices.IndexFunc(h.lastGPUs, func(m GPUMetrics) bool { return m.ID == id }); i >= 0 {
+				name = h.titlePrefix(multi) + h.lastGPUs[i].name()
+			}
+			for _, e := range h.events.byGPU[id] {
+				events = append(events, row{name, e})
+			}
+		}
+	}
+	slices.SortStableFunc(events, func(a, b row) int { return b.at.Compare(a.at) })
+	rows := [][]string{{"TIME", "GPU", "EVENT"}}
+	table.RowStyles = map[int]ui.Style{0: ui.NewStyle(ui.ColorWhite, ui.ColorClear, ui.ModifierBold)}
+	for _, e := range events {
+		table.RowStyles[len(rows)] = ui.NewStyle(chartEventMarks[e.kind].color)
+		rows = append(rows, []string{zoned(e.at).Format("15:04:05"), e.gpu, fmt.Sprintf("%c %s", chartEventMarks[e.kind].mark, e.detail)})
+	}
+	if len(events) == 0 {
+		rows = append(rows, []string{"", "", "no events yet"})
+	}
+	table.Rows = rows
+	fitColumns(table)
+}
@@ -0,0 +1,150 @@
+// Command grpc-client is a tiny consumer of mi-top's gRPC API. It calls
+// GetSnapshot once, or with -follow streams every sample via Subscribe.
+//
+//	mi-top --grpc=:7070 &
+//	go run ./examples/grpc-client -addr localhost:7070 -follow
+//
+// Generated stubs from proto/mitop.proto work just as well; this example
+// decodes the few fields it prints by hand to stay dependency free.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"time"
+)
+
+type gpu struct {
+	id                         int64
+	util, power, temp, vramUse float64
+}
+
+// fields walks a protobuf message calling fn for every field
+func fields(msg []byte, fn func(num int, wireType int, varint uint64, data []byte)) error {
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return fmt.Errorf("bad field key")
+		}
+		msg = msg[n:]
+		num, wireType := int(key>>3), int(key&7)
+		switch wireType {
+		case 0:
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return fmt.Errorf("bad varint")
+			}
+			msg = msg[n:]
+			fn(num, wireType, v, nil)
+		case 1:
+			if len(msg) < 8 {
+				return fmt.Errorf("short fixed64")
+			}
+			fn(num, wireType, binary.LittleEndian.Uint64(msg), nil)
+			msg = msg[8:]
+		case 2:
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return fmt.Errorf("bad length")
+			}
+			fn(num, wireType, 0, msg[n:n+int(l)])
+			msg = msg[n+int(l):]
+		default:
+			return fmt.Errorf("unsupported wire type %d", wireType)
+		}
+	}
+	return nil
+}
+
+func printSnapshot(msg []byte) error {
+	var ts int64
+	var host string
+	var gpus []gpu
+	err := fields(msg, func(num, _ int, v uint64, data []byte) {
+		switch num {
+		case 1:
+			ts = int64(v)
+		case 2:
+			host = string(data)
+		case 3:
+			var g gpu
+			fields(data, func(num, _ int, v uint64, _ []byte) {
+				f := math.Float64frombits(v)
+				switch num {
+				case 1:
+					g.id = int64(v)
+				case 2:
+					g.power = f
+				case 3:
+					g.temp = f
+				case 5:
+					g.util = f
+				case 9:
+					g.vramUse = f
+				}
+			})
+			gpus = append(gpus, g)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s %s\n", time.Unix(0, ts).Format(time.TimeOnly), host)
+	for _, g := range gpus {
+		fmt.Printf("  GPU %d  %5.1f%%  %6.1f W  %5.1f °C  %8.0f MB\n", g.id, g.util, g.power, g.temp, g.vramUse)
+	}
+	return nil
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:7070", "mi-top gRPC address")
+	follow := flag.Bool("follow", false, "stream samples with Subscribe instead of one GetSnapshot")
+	flag.Parse()
+
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: &protocols}}
+
+	method := "GetSnapshot"
+	if *follow {
+		method = "Subscribe"
+	}
+	// An empty request message is a 5 byte frame header with length 0
+	req, err := http.NewRequest(http.MethodPost, "http://"+*addr+"/mitop.v1.Monitor/"+method, bytes.NewReader(make([]byte, 5)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	header := make([]byte, 5)
+	for {
+		if _, err := io.ReadFull(resp.Body, header); err != nil {
+			break
+		}
+		msg := make([]byte, binary.BigEndian.Uint32(header[1:]))
+		if _, err := io.ReadFull(resp.Body, msg); err != nil {
+			log.Fatal(err)
+		}
+		if err := printSnapshot(msg); err != nil {
+			log.Fatal(err)
+		}
+	}
+	status := resp.Trailer.Get("Grpc-Status")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+	}
+	if status != "0" {
+		log.Fatalf("rpc failed: status %s %s", status, resp.Trailer.Get("Grpc-Message")+resp.Header.Get("Grpc-Message"))
+	}
+}
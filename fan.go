@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	ui "github.com/gizak/termui/v3"
+	"github.com/gizak/termui/v3/widgets"
+)
+
+// FanInfo is one GPU's fan as `amd-smi metric --fan` reports it
+type FanInfo struct {
+	GPU     int     `json:"gpu"`
+	Percent float64 `json:"percent"`
+	RPM     float64 `json:"rpm"`
+	Passive bool    `json:"passive,omitempty"` // no fan reading, e.g. a passively cooled card
+}
+
+// collectFans adds the fan query to every sample while the fan panel is
+// open, like collectProcesses
+var collectFans atomic.Bool
+
+func getFanInfo(r commandRunner) ([]FanInfo, error) {
+	stream, err := r.Start("amd-smi", "metric", "--fan", "--csv")
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute amd-smi: %w", err)
+	}
+	fans := parseFanInfo(stream)
+	if err := stream.Close(); err != nil {
+		return nil, fmt.Errorf("failed to execute amd-smi: %w", err)
+	}
+	return fans, nil
+}
+
+// parseFanInfo reads `amd-smi metric --fan --csv`. Columns are found by
+// name; usage is the fan percentage, derived from speed/max when missing.
+func parseFanInfo(r io.Reader) []FanInfo {
+	var fans []FanInfo
+	readSMIRecords(r, "fan", func(header, record []string) {
+		field := func(name string) (float64, bool) {
+			for i, h := range header {
+				if strings.TrimSpace(h) == name && i < len(record) {
+					v, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(record[i]), "%")), 64)
+					return v, err == nil
+				}
+			}
+			return 0, false
+		}
+		id, ok := field("gpu")
+		if !ok {
+			debugLog.Debug("amd-smi fan: skipping row without GPU id", "record", record)
+			return
+		}
+		fan := FanInfo{GPU: int(id)}
+		rpm, hasRPM := field("rpm")
+		fan.RPM = rpm
+		usage, hasUsage := field("usage")
+		if hasUsage {
+			fan.Percent = usage
+		} else if speed, ok := field("speed"); ok {
+			if top, ok := field("max"); ok && top > 0 {
+				fan.Percent, hasUsage = speed/top*100, true
+			}
+		}
+		fan.Passive = !hasRPM && !hasUsage
+		fans = append(fans, fan)
+	})
+	return fans
+}
+
+// fanRow is a GPU shown in the fan panel; host is nil for rows that can't
+// be controlled
+type fanRow struct {
+	host *hostView
+	gpu  int
+}
+
+// fanAction builds the change for a row; percent < 0 returns the fan to
+// automatic control
+func fanAction(row fanRow, percent int) controlAction {
+	gpu := strconv.Itoa(row.gpu)
+	if percent < 0 {
+		return controlAction{host: row.host, gpu: row.gpu,
+			prompt: fmt.Sprintf("Return GPU %d fan to automatic control?", row.gpu),
+			args:   []string{"reset", "--gpu", gpu, "--fans"}}
+	}
+	return controlAction{host: row.host, gpu: row.gpu,
+		prompt: fmt.Sprintf("Set GPU %d fan to %d%% (manual)?", row.gpu, percent),
+		args:   []string{"set", "--gpu", gpu, "--fan", strconv.Itoa(percent) + "%"}}
+}
+
+// fanPanel shows fan speed, RPM and the hotspot temperature fan curves
+// follow. With a controller (--enable-control), digits pick a manual
+// percentage and 'a' returns to automatic control. It belongs to the UI
+// goroutine.
+type fanPanel struct {
+	table    *widgets.Table
+	control  *controller
+	selected int
+	rows     []fanRow
+}
+
+func newFanPanel(control *controller) *fanPanel {
+	p := &fanPanel{control: control}
+	p.table = widgets.NewTable()
+	p.table.TextStyle = ui.NewStyle(ui.ColorWhite)
+	p.table.RowSeparator = false
+	p.table.BorderStyle = ui.NewStyle(ui.ColorWhite)
+	return p
+}
+
+// update refreshes the table from each host's latest sample
+func (p *fanPanel) update(hosts []*hostView, multi bool) {
+	p.table.Title = "Fans (read-only; start with --enable-control to change them)"
+	if p.control != nil {
+		p.table.Title = "Fans (↑/↓ select, 1-9/0 set 10-100%, a auto)"
+	}
+	rows := [][]string{{"GPU", "FAN", "RPM", "TRACKING"}}
+	p.table.RowStyles = map[int]ui.Style{0: ui.NewStyle(ui.ColorWhite, ui.ColorClear, ui.ModifierBold)}
+	p.rows = p.rows[:0]
+	for _, h := range hosts {
+		if h.fanErr != nil {
+			p.table.RowStyles[len(rows)] = ui.NewStyle(ui.ColorRed)
+			rows = append(rows, []string{strings.TrimSuffix(h.titlePrefix(multi), ": "), "unavailable", oneLine(h.fanErr.Error()), ""})
+			p.rows = append(p.rows, fanRow{})
+			continue
+		}
+		temps := make(map[int]float64, len(h.lastGPUs))
+		for _, m := range h.lastGPUs {
+			temps[m.ID] = m.GPUTemp
+		}
+		for _, fan := range h.fans {
+			if fan.Passive {
+				rows = append(rows, []string{fmt.Sprintf("%sGPU %d", h.titlePrefix(multi), fan.GPU), "N/A (passive)", "", ""})
+				p.rows = append(p.rows, fanRow{})
+				continue
+			}
+			rows = append(rows, []string{
+				fmt.Sprintf("%sGPU %d", h.titlePrefix(multi), fan.GPU),
+				fmt.Sprintf("%.0f%%", fan.Percent),
+				fmt.Sprintf("%.0f", fan.RPM),
+				fmt.Sprintf("hotspot %.0f°C", temps[fan.GPU]),
+			})
+			p.rows = append(p.rows, fanRow{host: h, gpu: fan.GPU})
+		}
+	}
+	if len(p.rows) == 0 {
+		rows = append(rows, []string{"waiting for fan data…", "", "", ""})
+	}
+	p.selected = min(p.selected, max(len(p.rows)-1, 0))
+	if p.control != nil && len(p.rows) > 0 {
+		p.table.RowStyles[p.selected+1] = ui.NewStyle(ui.ColorBlack, ui.ColorGreen)
+	}
+	p.table.Rows = rows
+}
+
+// handleEvent reports whether the panel consumed the key
+func (p *fanPanel) handleEvent(e ui.Event, action keyAction) bool {
+	if p.control == nil {
+		return false
+	}
+	switch {
+	case action == actionUp:
+		p.selected = max(p.selected-1, 0)
+	case action == actionDown:
+		p.selected = min(p.selected+1, max(len(p.rows)-1, 0))
+	case len(e.ID) == 1 && strings.Contains("a0123456789", e.ID):
+		if p.selected >= len(p.rows) || p.rows[p.selected].host == nil {
+			return true
+		}
+		percent := -1
+		if e.ID != "a" {
+			percent, _ = strconv.Atoi(e.ID)
+			// 0 is the key after 9, so it means 100%
+			if percent == 0 {
+				percent = 10
+			}
+			percent *= 10
+		}
+		p.control.ask(fanAction(p.rows[p.selected], percent))
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	ui "github.com/gizak/termui/v3"
+	"github.com/mattn/go-runewidth"
+)
+
+// processFilter picks the processes the list shows ('/'). A pattern
+// written as /.../ is a Go regexp; anything else matches as a
+// case-insensitive substring. Either is tried on the name, the command
+// line and the PID.
+type processFilter struct {
+	pattern string
+	re      *regexp.Regexp
+	err     error // the regexp doesn't compile; nothing is filtered
+}
+
+func newProcessFilter(pattern string) processFilter {
+	f := processFilter{pattern: pattern}
+	if expr, ok := regexpPattern(pattern); ok {
+		f.re, f.err = regexp.Compile(expr)
+	}
+	return f
+}
+
+// regexpPattern returns the expression of a /.../ pattern
+func regexpPattern(pattern string) (string, bool) {
+	if len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		return pattern[1 : len(pattern)-1], true
+	}
+	return "", false
+}
+
+// active reports whether the filter hides anything
+func (f processFilter) active() bool {
+	return f.pattern != "" && f.err == nil
+}
+
+func (f processFilter) match(p ProcessInfo, cmdline string) bool {
+	if !f.active() {
+		return true
+	}
+	pid := strconv.Itoa(p.Pid)
+	if f.re != nil {
+		return f.re.MatchString(p.Name) || f.re.MatchString(cmdline) || f.re.MatchString(pid)
+	}
+	pattern := strings.ToLower(f.pattern)
+	return strings.Contains(strings.ToLower(p.Name), pattern) ||
+		strings.Contains(strings.ToLower(cmdline), pattern) || strings.Contains(pid, pattern)
+}
+
+// ranges returns the byte ranges of s the filter matches, for
+// highlighting. Matches that are empty or contain termui style markup
+// characters are left out, since tagging them would garble the row.
+func (f processFilter) ranges(s string) [][2]int {
+	if !f.active() {
+		return nil
+	}
+	var found [][2]int
+	if f.re != nil {
+		for _, m := range f.re.FindAllStringIndex(s, -1) {
+			found = append(found, [2]int{m[0], m[1]})
+		}
+	} else if lower := strings.ToLower(s); len(lower) == len(s) {
+		// Offsets into the lowered string only hold while lowering
+		// keeps every byte length
+		pattern := strings.ToLower(f.pattern)
+		for i := 0; ; {
+			j := strings.Index(lower[i:], pattern)
+			if j < 0 {
+				break
+			}
+			found = append(found, [2]int{i + j, i + j + len(pattern)})
+			i += j + len(pattern)
+		}
+	}
+	ranges := found[:0]
+	for _, r := range found {
+		if r[0] < r[1] && !strings.ContainsAny(s[r[0]:r[1]], "[]()") {
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges
+}
+
+// procCmdline returns a local process's command line with spaces between
+// the arguments, or "" once it has exited
+func procCmdline(pid int) string {
+	data, err := os.ReadFile(procDir + "/" + strconv.Itoa(pid) + "/cmdline")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.ReplaceAll(string(data), "\x00", " "))
+}
+
+// filterInput is the line the filter is typed on, drawn over the footer
+// while it is open. Text is drawn as is, since a pattern may look like
+// termui style markup. It belongs to the UI goroutine.
+type filterInput struct {
+	ui.Block
+	text   string
+	err    error
+	active bool
+}
+
+func newFilterInput() *filterInput {
+	in := &filterInput{Block: *ui.NewBlock()}
+	in.Border = false
+	return in
+}
+
+// handleKey edits the pattern and reports whether it changed. Enter
+// closes the line keeping the filter, Escape clears it.
+func (in *filterInput) handleKey(id string) bool {
+	switch id {
+	case "<Enter>":
+		in.active = false
+		return false
+	case "<Escape>":
+		in.active = false
+		in.text = ""
+	case "<Backspace>", "<C-<Backspace>>":
+		if in.text == "" {
+			return false
+		}
+		runes := []rune(in.text)
+		in.text = string(runes[:len(runes)-1])
+	case "<Space>":
+		in.text += " "
+	default:
+		if len([]rune(id)) != 1 {
+			return false
+		}
+		in.text += id
+	}
+	return true
+}
+
+func (in *filterInput) Draw(buf *ui.Buffer) {
+	in.Block.Draw(buf)
+	at := in.Inner.Min
+	buf.Fill(ui.NewCell(' ', ui.NewStyle(ui.ColorWhite)), in.Inner)
+	buf.SetString("filter: "+in.text+"█", ui.NewStyle(ui.ColorWhite), at)
+	if in.err != nil {
+		x := at.X + runewidth.StringWidth("filter: "+in.text+"█  ")
+		buf.SetString(fmt.Sprintf("invalid pattern: %v", in.err), ui.NewStyle(ui.ColorRed), image.Pt(x, at.Y))
+	}
+}
@@ -1,16 +1,18 @@
 module mi-top
 
-go 1.21
+// Go 1.24 serves cleartext HTTP/2 through http.Protocols, which the gRPC
+// server (grpc.go) needs without golang.org/x/net
+go 1.24
 
 require (
 	github.com/gizak/termui/v3 v3.1.0
+	github.com/mattn/go-runewidth v0.0.15
 	github.com/prometheus/client_golang v1.18.0
 )
 
 require (
 	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
-	github.com/mattn/go-runewidth v0.0.15 // indirect
 	github.com/mitchellh/go-wordwrap v1.0.1 // indirect
 	github.com/nsf/termbox-go v1.1.1 // indirect
 	github.com/prometheus/client_model v0.5.0 // indirect
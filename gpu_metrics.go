@@ -2,142 +2,437 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
-	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// GPUMetrics is one reading of a device
 type GPUMetrics struct {
-	ID        int
-	Power     float64
-	GPUTemp   float64
-	MemTemp   float64
-	GFXUtil   float64
-	GFXClock  float64
-	MemUtil   float64
-	MemClock  float64
-	VRAMUsed  float64
-	VRAMTotal float64
+	GPUDevice
+	Power     float64 `json:"power"`
+	GPUTemp   float64 `json:"gpu_temp"`
+	MemTemp   float64 `json:"mem_temp"`
+	GFXUtil   float64 `json:"gfx_util"`
+	GFXClock  float64 `json:"gfx_clock"`
+	MemUtil   float64 `json:"mem_util"`
+	MemClock  float64 `json:"mem_clock"`
+	VRAMUsed  float64 `json:"vram_used"`
+	VRAMTotal float64 `json:"vram_total"`
+	Partial   bool    `json:"partial,omitempty"` // some columns were missing and read as zero
+	// An APU's GTT, the system memory it maps, in MB
+	GTTUsed  float64 `json:"gtt_used,omitempty"`
+	GTTTotal float64 `json:"gtt_total,omitempty"`
 }
 
+// memory is the GPU-addressable memory in MB: the VRAM, or for an APU the
+// carve-out and the GTT together
+func (m GPUMetrics) memory() (used, total float64) {
+	if m.APU {
+		return m.VRAMUsed + m.GTTUsed, m.VRAMTotal + m.GTTTotal
+	}
+	return m.VRAMUsed, m.VRAMTotal
+}
+
+// hasMemTemp reports whether the card has a memory temperature sensor.
+// GDDR cards print N/A, which reads as zero.
+func (m GPUMetrics) hasMemTemp() bool {
+	return m.MemTemp > 0
+}
+
+// ProcessInfo holds raw values; formatting happens where they are shown
 type ProcessInfo struct {
-	GPU      int
-	Name     string
-	PID      string
-	GTTMem   float64
-	CPUMem   float64
-	VRAMMem  float64
-	TotalMem float64
-	GFXUsage string
-}
-
-func getGPUMetrics() ([]GPUMetrics, error) {
-	cmd := exec.Command("amd-smi", "monitor", "--csv")
-	output, err := cmd.Output()
+	Host         string  `json:"host,omitempty"`
+	GPU          int     `json:"gpu"`
+	BDF          string  `json:"bdf,omitempty"`
+	GPUAlias     string  `json:"gpu_alias,omitempty"`
+	Name         string  `json:"name"`
+	Pid          int     `json:"pid"`
+	UsagePercent float64 `json:"gfx_usage"`
+	GTTBytes     uint64  `json:"gtt_bytes"`
+	CPUBytes     uint64  `json:"cpu_bytes"`
+	VRAMBytes    uint64  `json:"vram_bytes"`
+	TotalBytes   uint64  `json:"total_bytes"`
+	// Group is the root PID of the process tree group the process belongs
+	// to, 0 for none: its topmost GPU-using ancestor, or the launcher it
+	// shares with other GPU processes. Only local processes are grouped.
+	Group     int    `json:"-"`
+	GroupName string `json:"-"`
+}
+
+// mib converts a byte count to MB for display and the MB-based outputs
+func mib(b uint64) float64 {
+	return float64(b) / 1024 / 1024
+}
+
+func getGPUMetrics(r commandRunner) ([]GPUMetrics, error) {
+	stream, err := r.Start("amd-smi", "monitor", "--csv")
 	if err != nil {
 		return nil, err
 	}
+	metrics := parseGPUMetrics(stream)
+	// A failed command's partial output is not trusted
+	if err := stream.Close(); err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// newSMICSVReader reads amd-smi's CSV, which is not always well formed:
+// rows vary in length and names may contain stray quotes. Quoted fields
+// with commas are handled by encoding/csv; there is no line length limit.
+func newSMICSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+	return reader
+}
+
+// readSMIRecords calls fn for every data row after the header. Malformed
+// rows are logged and skipped.
+func readSMIRecords(r io.Reader, what string, fn func(header, record []string)) error {
+	reader := newSMICSVReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read CSV header: %v", err)
+	}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			debugLog.Warn("amd-smi "+what+": skipping malformed row", "err", err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read CSV record: %v", err)
+		}
+		fn(header, record)
+	}
+}
 
+// parseGPUMetrics reads `amd-smi monitor --csv` output
+func parseGPUMetrics(r io.Reader) []GPUMetrics {
 	var metrics []GPUMetrics
-	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	var layout []int
+	readSMIRecords(r, "monitor", func(header, fields []string) {
+		if layout == nil {
+			layout = monitorLayout(header)
+		}
+		if m, ok := parseMonitorRecord(fields, layout); ok {
+			metrics = append(metrics, m)
+		}
+	})
+	return metrics
+}
 
-	// Skip header line
-	scanner.Scan()
+// monitorColumns are the `amd-smi monitor --csv` columns mi-top reads, by
+// header name and by their position in amd-smi 24.x output. "mem" is memory
+// controller activity (how busy VRAM is), not the share of VRAM in use:
+// newer amd-smi releases print that separately as vram_percent.
+var monitorColumns = []struct {
+	index  int
+	header string
+	name   string
+	field  func(*GPUMetrics) *float64
+	cap    gpuCaps // the reading has when the value isn't N/A
+}{
+	{1, "power_usage", "power", func(m *GPUMetrics) *float64 { return &m.Power }, capPower},
+	{2, "hotspot_temperature", "gpu_temp", func(m *GPUMetrics) *float64 { return &m.GPUTemp }, 0},
+	{3, "memory_temperature", "mem_temp", func(m *GPUMetrics) *float64 { return &m.MemTemp }, capMemTemp},
+	{4, "gfx", "gfx_util", func(m *GPUMetrics) *float64 { return &m.GFXUtil }, 0},
+	{5, "gfx_clk", "gfx_clock", func(m *GPUMetrics) *float64 { return &m.GFXClock }, 0},
+	{6, "mem", "mem_util", func(m *GPUMetrics) *float64 { return &m.MemUtil }, capMemBusy},
+	{7, "mem_clk", "mem_clock", func(m *GPUMetrics) *float64 { return &m.MemClock }, 0},
+	{15, "vram_used", "vram_used", func(m *GPUMetrics) *float64 { return &m.VRAMUsed }, 0},
+	{16, "vram_total", "vram_total", func(m *GPUMetrics) *float64 { return &m.VRAMTotal }, 0},
+}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		fields := strings.Split(line, ",")
+// monitorLayout finds the field index of each monitor column from the
+// header, since releases add and drop columns between them. A header that
+// names none of them falls back to the amd-smi 24.x positions; a column the
+// header lacks is -1 and reads as zero.
+func monitorLayout(header []string) []int {
+	names := make(map[string]int, len(header))
+	for i, h := range header {
+		names[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	layout := make([]int, len(monitorColumns))
+	found := 0
+	for j, col := range monitorColumns {
+		layout[j] = -1
+		if i, ok := names[col.header]; ok {
+			layout[j] = i
+			found++
+		}
+	}
+	if found == 0 {
+		debugLog.Warn("amd-smi monitor: unknown header, using fixed column positions", "header", header)
+		for j, col := range monitorColumns {
+			layout[j] = col.index
+		}
+	}
+	for j, col := range monitorColumns {
+		if layout[j] < 0 {
+			debugLog.Debug("amd-smi monitor: column not reported", "column", col.header)
+		}
+	}
+	return layout
+}
 
-		if len(fields) < 17 {
+// parseMonitorRecord parses one data row of `amd-smi monitor --csv` laid
+// out as monitorLayout found. A GPU in reset or a virtual function may
+// print fewer columns; whatever is there is used and the row is marked
+// partial so it keeps its chart.
+func parseMonitorRecord(fields []string, layout []int) (GPUMetrics, bool) {
+	id, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+	if err != nil {
+		debugLog.Warn("amd-smi monitor: skipping row without GPU id", "record", fields)
+		return GPUMetrics{}, false
+	}
+	m := GPUMetrics{GPUDevice: GPUDevice{ID: id, Caps: capJunctionTemp | capGTT | capProcessUsage | capProcessCPU}}
+	for j, col := range monitorColumns {
+		i := layout[j]
+		if i < 0 {
 			continue
 		}
-
-		id, _ := strconv.Atoi(fields[0])
-		power, _ := strconv.ParseFloat(fields[1], 64)
-		gpuTemp, _ := strconv.ParseFloat(fields[2], 64)
-		memTemp, _ := strconv.ParseFloat(fields[3], 64)
-		gfxUtil, _ := strconv.ParseFloat(fields[4], 64)
-		gfxClock, _ := strconv.ParseFloat(fields[5], 64)
-		memUtil, _ := strconv.ParseFloat(fields[6], 64)
-		memClock, _ := strconv.ParseFloat(fields[7], 64)
-		vramUsed, _ := strconv.ParseFloat(fields[15], 64)
-		vramTotal, _ := strconv.ParseFloat(fields[16], 64)
-
-		metrics = append(metrics, GPUMetrics{
-			ID:        id,
-			Power:     power,
-			GPUTemp:   gpuTemp,
-			MemTemp:   memTemp,
-			GFXUtil:   gfxUtil,
-			GFXClock:  gfxClock,
-			MemUtil:   memUtil,
-			MemClock:  memClock,
-			VRAMUsed:  vramUsed,
-			VRAMTotal: vramTotal,
-		})
+		if i >= len(fields) {
+			m.Partial = true
+			continue
+		}
+		v, ok := parseMetric(fields[i], col.name, id)
+		*col.field(&m) = v
+		if ok {
+			m.Caps |= col.cap
+		}
 	}
-
-	return metrics, nil
+	if m.Partial {
+		debugLog.Debug("amd-smi monitor: partial row", "gpu", id, "fields", len(fields))
+	}
+	return m, true
 }
 
-func getProcessInfo() ([]ProcessInfo, error) {
-	cmd := exec.Command("amd-smi", "process", "--csv")
-	output, err := cmd.Output()
+// parseMetric converts one monitor field. Values amd-smi can't read, such as
+// N/A, become zero and false, and are logged at debug level.
+func parseMetric(field, name string, gpu int) (float64, bool) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute amd-smi: %v", err)
+		debugLog.Debug("amd-smi monitor: unparsable value", "gpu", gpu, "metric", name, "value", field)
 	}
+	return v, err == nil
+}
 
-	// Create CSV reader
-	reader := csv.NewReader(strings.NewReader(string(output)))
+// parseBytes reads a memory field in bytes; N/A and other junk become zero
+func parseBytes(field string) uint64 {
+	field = strings.TrimSpace(field)
+	if n, err := strconv.ParseUint(field, 10, 64); err == nil {
+		return n
+	}
+	f, err := strconv.ParseFloat(field, 64)
+	if err != nil || f < 0 {
+		return 0
+	}
+	return uint64(f)
+}
 
-	// Read header line
-	_, err = reader.Read()
+func getProcessInfo(r commandRunner) ([]ProcessInfo, error) {
+	stream, err := r.Start("amd-smi", "process", "--csv")
 	if err != nil {
-		return nil, fmt.Errorf("failed to read CSV header: %v", err)
+		return nil, fmt.Errorf("failed to execute amd-smi: %w", err)
+	}
+	processes, parseErr := parseProcessInfo(stream)
+	if err := stream.Close(); err != nil {
+		return nil, fmt.Errorf("failed to execute amd-smi: %w", err)
 	}
+	return processes, parseErr
+}
 
+// parseProcessInfo reads `amd-smi process --csv` output. Names are kept
+// exactly as printed, including commas, quotes and surrounding spaces.
+func parseProcessInfo(r io.Reader) ([]ProcessInfo, error) {
 	var processes []ProcessInfo
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break
+	err := readSMIRecords(r, "process", func(_, record []string) {
+		// Skip if no process detected
+		if len(record) > 1 && strings.Contains(record[1], "No running processes detected") {
+			return
 		}
-		if err != nil {
-			return nil, fmt.Errorf("failed to read CSV record: %v", err)
+		if len(record) < 9 {
+			debugLog.Warn("amd-smi process: skipping short row", "fields", len(record), "want", 9, "record", record)
+			return
 		}
 
-		// Skip if no process detected
-		if strings.Contains(record[1], "No running processes detected") {
-			continue
+		// Some driver versions leave the GPU blank; corroborateProcessGPUs
+		// looks it up
+		gpuID, err := strconv.Atoi(strings.TrimSpace(record[0]))
+		if err != nil {
+			debugLog.Debug("amd-smi process: row without GPU id", "record", record)
+			gpuID = unattributed
 		}
 
-		// Parse GPU ID
-		gpuID, err := strconv.Atoi(record[0])
+		pid, err := strconv.Atoi(strings.TrimSpace(record[4]))
 		if err != nil {
-			continue
+			debugLog.Debug("amd-smi process: skipping row without PID", "record", record)
+			return
+		}
+		usage, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(record[6]), "%"), 64)
+		if err != nil {
+			debugLog.Debug("amd-smi process: unparsable usage", "pid", pid, "value", record[6])
 		}
-
-		// Convert memory values from bytes to MB
-		vramMem, _ := strconv.ParseFloat(record[2], 64)
-		cpuMem, _ := strconv.ParseFloat(record[5], 64)
-		gttMem, _ := strconv.ParseFloat(record[7], 64)
-		totalMem, _ := strconv.ParseFloat(record[8], 64)
 
 		process := ProcessInfo{
-			GPU:      gpuID,
-			Name:     record[3],
-			PID:      record[4],
-			GFXUsage: record[6] + "%",
-			VRAMMem:  vramMem / 1024 / 1024, // Convert bytes to MB
-			CPUMem:   cpuMem / 1024 / 1024,
-			GTTMem:   gttMem / 1024 / 1024,
-			TotalMem: totalMem / 1024 / 1024,
+			GPU:          gpuID,
+			Name:         record[3],
+			Pid:          pid,
+			UsagePercent: usage,
+			VRAMBytes:    parseBytes(record[2]),
+			CPUBytes:     parseBytes(record[5]),
+			GTTBytes:     parseBytes(record[7]),
+			TotalBytes:   parseBytes(record[8]),
 		}
 		processes = append(processes, process)
+	})
+	if err != nil {
+		return nil, err
 	}
-
 	return processes, nil
 }
+
+// Sample is everything collected during one tick
+type Sample struct {
+	Host       string        `json:"host,omitempty"` // empty for the local machine
+	Time       time.Time     `json:"timestamp"`
+	GPUs       []GPUMetrics  `json:"gpus"`
+	Processes  []ProcessInfo `json:"processes"`
+	ProcessErr error         `json:"-"`
+	Fans       []FanInfo     `json:"fans,omitempty"` // only while the fan panel is open
+	FanErr     error         `json:"-"`
+}
+
+// collectProcesses gates the slow `amd-smi process` query. It is read by the
+// sampler goroutines and toggled from the UI, hence atomic.
+var collectProcesses atomic.Bool
+
+// combinedMarker separates the two outputs of combinedQuery
+const combinedMarker = "--mi-top-processes--"
+
+// combinedQuery runs both amd-smi queries from one shell, so a tick costs
+// one exec, and one ssh round trip for remote hosts, instead of two. amd-smi
+// itself has no stable CSV layout for metrics and processes together.
+var combinedQuery = "amd-smi monitor --csv && echo " + combinedMarker + " && amd-smi process --csv"
+
+// splitQuery remembers the runners the combined query failed on, e.g.
+// hosts without a POSIX shell, which use two separate calls from then on.
+// Trying both every tick would run a broken amd-smi twice.
+var splitQuery sync.Map
+
+// collectSample gathers GPU metrics and process information. The two queries
+// fail independently: a process error is kept on the sample, a metrics error
+// is returned.
+func collectSample(r commandRunner) (Sample, error) {
+	start := time.Now()
+	sample := Sample{Time: start}
+	mode, execs := "metrics", 1
+	var err error
+	if !collectProcesses.Load() {
+		sample.GPUs, err = getGPUMetrics(r)
+	} else if _, split := splitQuery.Load(r); !split {
+		mode = "combined"
+		ok, cerr := collectCombined(r, &sample)
+		switch {
+		case ok:
+		case errors.Is(cerr, errTimedOut) || errors.Is(cerr, errRemoteDown):
+			// The separate calls would only wait out the same hang
+			err = fmt.Errorf("amd-smi: %w", cerr)
+		default:
+			debugLog.Info("combined amd-smi query failed, using separate calls", "err", cerr)
+			splitQuery.Store(r, true)
+			mode, execs = "split", 3
+			err = collectSplit(r, &sample)
+		}
+	} else {
+		mode, execs = "split", 2
+		err = collectSplit(r, &sample)
+	}
+	if sample.ProcessErr == nil {
+		corroborateProcessGPUs(r, &sample)
+	}
+	if collectFans.Load() && err == nil {
+		sample.Fans, sample.FanErr = getFanInfo(r)
+		execs++
+	}
+	debugLog.Debug("sample collected", "mode", mode, "execs", execs, "elapsed", time.Since(start))
+	return sample, err
+}
+
+func collectSplit(r commandRunner, sample *Sample) error {
+	metrics, err := getGPUMetrics(r)
+	sample.GPUs = metrics
+	sample.Processes, sample.ProcessErr = getProcessInfo(r)
+	return err
+}
+
+// collectCombined runs combinedQuery. It reports false, with the reason,
+// if the metrics part didn't complete, leaving the caller to retry with
+// separate calls.
+func collectCombined(r commandRunner, sample *Sample) (bool, error) {
+	stream, err := r.Start("sh", "-c", combinedQuery)
+	if err != nil {
+		return false, err
+	}
+	section := &markerReader{r: bufio.NewReader(stream), marker: combinedMarker}
+	metrics := parseGPUMetrics(section)
+	// Skip to the marker even if the metrics parser stopped early
+	io.Copy(io.Discard, section)
+	if !section.found {
+		if err := stream.Close(); err != nil {
+			return false, err
+		}
+		return false, fmt.Errorf("no %s line in output", combinedMarker)
+	}
+	processes, parseErr := parseProcessInfo(section.r)
+	if err := stream.Close(); err != nil {
+		sample.GPUs = metrics
+		sample.ProcessErr = fmt.Errorf("failed to execute amd-smi: %w", err)
+		return true, nil
+	}
+	sample.GPUs = metrics
+	sample.Processes, sample.ProcessErr = processes, parseErr
+	return true, nil
+}
+
+// markerReader reads lines from r up to, not including, a marker line
+type markerReader struct {
+	r      *bufio.Reader
+	marker string
+	found  bool
+	line   []byte
+}
+
+func (m *markerReader) Read(p []byte) (int, error) {
+	if m.found {
+		return 0, io.EOF
+	}
+	if len(m.line) == 0 {
+		line, err := m.r.ReadBytes('\n')
+		if string(bytes.TrimRight(line, "\r\n")) == m.marker {
+			m.found = true
+			return 0, io.EOF
+		}
+		if len(line) == 0 {
+			return 0, err
+		}
+		m.line = line
+	}
+	n := copy(p, m.line)
+	m.line = m.line[n:]
+	return n, nil
+}
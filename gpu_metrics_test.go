@@ -0,0 +1,292 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeRunner answers each command line with canned output; other commands
+// fail as if the tool weren't installed
+type fakeRunner map[string]string
+
+func (r fakeRunner) Start(name string, args ...string) (io.ReadCloser, error) {
+	out, ok := r[strings.Join(append([]string{name}, args...), " ")]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, exec.ErrNotFound)
+	}
+	return io.NopCloser(strings.NewReader(out)), nil
+}
+
+// processHeader is the header of `amd-smi process --csv`
+const processHeader = "gpu,process_info,vram_mem,name,pid,cpu_mem,gfx_usage,gtt_mem,total_mem\n"
+
+// monitorHeader is the header of `amd-smi monitor --csv` in amd-smi 24.x
+const monitorHeader = "gpu,power_usage,hotspot_temperature,memory_temperature,gfx,gfx_clk,mem,mem_clk," +
+	"encoder,decoder,single_bit_ecc,double_bit_ecc,pcie_replay,pcie_bw,vram_free,vram_used,vram_total\n"
+
+func processRow(gpu, name string, pid int) string {
+	return fmt.Sprintf("%s,N/A,%d,%s,%d,4096,%d,8192,%d\n", gpu, pid<<20, name, pid, pid%100, pid<<20+12288)
+}
+
+func monitorRow(id int) string {
+	return fmt.Sprintf("%d,300,65,70,%d,1700,35,1600,0,0,0,0,0,N/A,24560,%d,65520\n", id, 10*id, 1000*id)
+}
+
+// largeProcessOutput is `amd-smi process --csv` for n processes spread over
+// 8 GPUs, every 1000th named with a command line longer than any
+// bufio.Scanner default
+func largeProcessOutput(n int) string {
+	var b strings.Builder
+	b.WriteString(processHeader)
+	long := strings.Repeat("--arg=value ", 20000)
+	for pid := 1; pid <= n; pid++ {
+		name := fmt.Sprintf("worker-%d", pid)
+		if pid%1000 == 0 {
+			name = "python3 " + long
+		}
+		b.WriteString(processRow(fmt.Sprint(pid%8), name, pid))
+	}
+	return b.String()
+}
+
+func checkLargeProcesses(t *testing.T, processes []ProcessInfo, n int) {
+	t.Helper()
+	if len(processes) != n {
+		t.Fatalf("%d processes, want %d", len(processes), n)
+	}
+	for i, p := range processes {
+		pid := i + 1
+		if p.Pid != pid || p.GPU != pid%8 || p.VRAMBytes != uint64(pid)<<20 || p.TotalBytes != uint64(pid)<<20+12288 {
+			t.Fatalf("process %d = %+v", i, p)
+		}
+		if want := 8 + 20000*len("--arg=value "); pid%1000 == 0 && len(p.Name) != want {
+			t.Fatalf("PID %d name is %d bytes, want %d", pid, len(p.Name), want)
+		}
+	}
+}
+
+// TestLargeOutput parses several megabytes of amd-smi output, streamed from
+// the command, with the separate calls and with the combined query
+func TestLargeOutput(t *testing.T) {
+	const n = 40000
+	processes := largeProcessOutput(n)
+	if len(processes) < 8<<20 {
+		t.Fatalf("fixture is only %d bytes", len(processes))
+	}
+	var monitor strings.Builder
+	monitor.WriteString(monitorHeader)
+	for id := range 8 {
+		monitor.WriteString(monitorRow(id))
+	}
+	r := fakeRunner{
+		"amd-smi process --csv":  processes,
+		"amd-smi monitor --csv":  monitor.String(),
+		"sh -c " + combinedQuery: monitor.String() + combinedMarker + "\n" + processes,
+	}
+
+	t.Run("split", func(t *testing.T) {
+		got, err := getProcessInfo(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkLargeProcesses(t, got, n)
+	})
+	t.Run("combined", func(t *testing.T) {
+		var sample Sample
+		if ok, err := collectCombined(r, &sample); !ok || err != nil || sample.ProcessErr != nil {
+			t.Fatalf("combined query: %v, %v, %v", ok, err, sample.ProcessErr)
+		}
+		if len(sample.GPUs) != 8 || sample.GPUs[7].VRAMUsed != 7000 {
+			t.Errorf("GPUs %+v", sample.GPUs)
+		}
+		checkLargeProcesses(t, sample.Processes, n)
+	})
+}
+
+// TestProcessNames parses names that trip up naive CSV splitting; each
+// must come out exactly, with the fields after it in their places
+func TestProcessNames(t *testing.T) {
+	for _, tc := range []struct {
+		field, name string
+	}{
+		{"python3", "python3"},
+		{`"my,app"`, "my,app"},
+		{`"a,b,c,d,e"`, "a,b,c,d,e"},
+		{`"say ""hi"""`, `say "hi"`},
+		{`it's "odd`, `it's "odd`},
+		{`"quoted, with ""both"", here"`, `quoted, with "both", here`},
+		{"训练任务-ñ-🚀", "训练任务-ñ-🚀"},
+		{`"日本語,テスト"`, "日本語,テスト"},
+		{"  padded  ", "  padded  "},
+		{`"  padded, quoted  "`, "  padded, quoted  "},
+		{"tab\there", "tab\there"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out := processHeader + processRow("3", tc.field, 4242) + processRow("5", "after", 977)
+			got, err := parseProcessInfo(strings.NewReader(out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []ProcessInfo{
+				{GPU: 3, Name: tc.name, Pid: 4242, UsagePercent: 42, VRAMBytes: 4242 << 20, CPUBytes: 4096, GTTBytes: 8192, TotalBytes: 4242<<20 + 12288},
+				{GPU: 5, Name: "after", Pid: 977, UsagePercent: 77, VRAMBytes: 977 << 20, CPUBytes: 4096, GTTBytes: 8192, TotalBytes: 977<<20 + 12288},
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("parsed %+v\nwant %+v", got, want)
+			}
+		})
+	}
+}
+
+// TestMonitorQuotedFields reads monitor rows with quoted values the way
+// the process parser reads names, keeping the columns in place
+func TestMonitorQuotedFields(t *testing.T) {
+	out := monitorHeader +
+		`0,"300","65",70,"12",1700,35,1600,"N/A, busy",0,0,0,0,N/A,24560,1000,65520` + "\n" +
+		monitorRow(1)
+	got := parseGPUMetrics(strings.NewReader(out))
+	if len(got) != 2 {
+		t.Fatalf("%d GPUs, want 2", len(got))
+	}
+	if m := got[0]; m.Power != 300 || m.GPUTemp != 65 || m.GFXUtil != 12 || m.VRAMUsed != 1000 || m.VRAMTotal != 65520 {
+		t.Errorf("GPU 0 = %+v", m)
+	}
+	if m := got[1]; m.ID != 1 || m.GFXUtil != 10 || m.VRAMUsed != 1000 {
+		t.Errorf("GPU 1 = %+v", m)
+	}
+}
+
+// TestPartialMonitorRows keeps GPUs whose rows stop short, with the values
+// they have; only a full row isn't partial
+func TestPartialMonitorRows(t *testing.T) {
+	full := strings.Split(strings.TrimSuffix(monitorRow(2), "\n"), ",")
+	if len(full) != 17 {
+		t.Fatalf("full row has %d fields", len(full))
+	}
+	for _, tc := range []struct {
+		fields  int
+		partial bool
+		want    GPUMetrics
+	}{
+		// GPU, power, hotspot and memory temperature, gfx
+		{5, true, GPUMetrics{Power: 300, GPUTemp: 65, MemTemp: 70, GFXUtil: 20}},
+		// ...clocks, memory activity and the first engines
+		{10, true, GPUMetrics{Power: 300, GPUTemp: 65, MemTemp: 70, GFXUtil: 20, GFXClock: 1700, MemUtil: 35, MemClock: 1600}},
+		{17, false, GPUMetrics{Power: 300, GPUTemp: 65, MemTemp: 70, GFXUtil: 20, GFXClock: 1700, MemUtil: 35, MemClock: 1600, VRAMUsed: 2000, VRAMTotal: 65520}},
+	} {
+		t.Run(fmt.Sprint(tc.fields), func(t *testing.T) {
+			out := monitorHeader + strings.Join(full[:tc.fields], ",") + "\n" + monitorRow(3)
+			got := parseGPUMetrics(strings.NewReader(out))
+			if len(got) != 2 || got[0].ID != 2 || got[1].ID != 3 {
+				t.Fatalf("parsed %+v, want GPUs 2 and 3", got)
+			}
+			m := got[0]
+			if m.Partial != tc.partial {
+				t.Errorf("partial = %v, want %v", m.Partial, tc.partial)
+			}
+			m.GPUDevice, m.Partial = GPUDevice{}, false
+			if m != tc.want {
+				t.Errorf("GPU 2 = %+v\nwant %+v", m, tc.want)
+			}
+			if got[1].Partial || got[1].VRAMTotal != 65520 {
+				t.Errorf("the full row after it: %+v", got[1])
+			}
+		})
+	}
+}
+
+// TestMemUtilColumn reads memory activity from two amd-smi releases: 24.x,
+// whose "mem" is the only memory percentage, and a later one that adds an
+// xcp column ahead of it and the share of VRAM in use as vram_percent.
+// MemUtil must be the activity in both, never the VRAM share.
+func TestMemUtilColumn(t *testing.T) {
+	for _, tc := range []struct {
+		name, out string
+	}{
+		{"24.x", monitorHeader + "0,300,65,70,88,1700,35,1600,0,0,0,0,0,N/A,24560,40960,65520\n"},
+		{"25.x", "gpu,xcp,power_usage,hotspot_temperature,memory_temperature,gfx,gfx_clk,mem,mem_clk,encoder,decoder," +
+			"vclock,dclock,single_bit_ecc,double_bit_ecc,pcie_replay,vram_used,vram_free,vram_total,vram_percent\n" +
+			"0,0,300,65,70,88,1700,35,1600,0,0,N/A,N/A,0,0,0,40960,24560,65520,62.5\n"},
+		// Without a header mi-top knows, the 24.x positions are assumed
+		{"unknown header", "a,b,c,d,e,f,g,h,i,j,k,l,m,n,o,p,q\n0,300,65,70,88,1700,35,1600,0,0,0,0,0,N/A,24560,40960,65520\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := parseGPUMetrics(strings.NewReader(tc.out))
+			if len(got) != 1 {
+				t.Fatalf("%d GPUs, want 1", len(got))
+			}
+			m := got[0]
+			if m.MemUtil != 35 || !m.Caps.has(capMemBusy) {
+				t.Errorf("MemUtil = %v (reported %v), want the memory activity 35", m.MemUtil, m.Caps.has(capMemBusy))
+			}
+			if m.GFXUtil != 88 || m.MemClock != 1600 || m.VRAMUsed != 40960 || m.VRAMTotal != 65520 {
+				t.Errorf("GPU = %+v", m)
+			}
+			h := goldenHost(got)
+			if title := h.chartTitle(m, 0, false); !strings.Contains(title, "MemBusy: 35%") {
+				t.Errorf("title %q doesn't show MemBusy: 35%%", title)
+			}
+		})
+	}
+}
+
+// countingRunner counts the commands run, failing those in fail
+type countingRunner struct {
+	*fakeRunner
+	fail  map[string]error
+	execs map[string]int
+}
+
+func (r *countingRunner) Start(name string, args ...string) (io.ReadCloser, error) {
+	line := strings.Join(append([]string{name}, args...), " ")
+	r.execs[line]++
+	if err, ok := r.fail[line]; ok {
+		return nil, err
+	}
+	return r.fakeRunner.Start(name, args...)
+}
+
+// TestCombinedFallback runs the separate calls after the combined query
+// fails, and keeps to them, but not when it hung: they would hang too
+func TestCombinedFallback(t *testing.T) {
+	collectProcesses.Store(true)
+	defer collectProcesses.Store(false)
+	monitor, process := "amd-smi monitor --csv", "amd-smi process --csv"
+	for _, tc := range []struct {
+		name  string
+		err   error
+		split int // amd-smi monitor calls over three ticks
+	}{
+		{"no shell", errors.New("exit status 127: sh: not found"), 3},
+		{"timeout", fmt.Errorf("sh %w after 10s", errTimedOut), 0},
+		{"remote down", fmt.Errorf("%w: node-a: timed out", errRemoteDown), 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &countingRunner{
+				fakeRunner: &fakeRunner{monitor: monitorHeader + monitorRow(0), process: processHeader},
+				fail:       map[string]error{"sh -c " + combinedQuery: tc.err},
+				execs:      map[string]int{},
+			}
+			for range 3 {
+				sample, err := collectSample(r)
+				if tc.split > 0 && (err != nil || len(sample.GPUs) != 1) {
+					t.Fatalf("split sample: %+v, %v", sample, err)
+				}
+				if tc.split == 0 && !errors.Is(err, tc.err) {
+					t.Fatalf("error %v, want %v", err, tc.err)
+				}
+			}
+			if combined := r.execs["sh -c "+combinedQuery]; tc.split > 0 && combined != 1 || tc.split == 0 && combined != 3 {
+				t.Errorf("combined query ran %d times", combined)
+			}
+			if got := r.execs[monitor]; got != tc.split {
+				t.Errorf("amd-smi monitor ran %d times, want %d", got, tc.split)
+			}
+		})
+	}
+}
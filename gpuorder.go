@@ -0,0 +1,125 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gizak/termui/v3/widgets"
+)
+
+// --gpu-order values besides a list of GPU indices
+const (
+	gpuOrderIndex = "index"
+	gpuOrderBDF   = "bdf"
+	gpuOrderBusy  = "busy"
+)
+
+// busyReorder is how often --gpu-order=busy orders the charts again, by
+// the mean utilization over that long
+const busyReorder = 10 * time.Second
+
+// busyMargin is how many points more utilized a GPU has to be to move
+// ahead of another, so that GPUs about as busy don't keep trading places
+const busyMargin = 10.0
+
+// gpuOrder is the order of a host's GPU charts (--gpu-order)
+type gpuOrder struct {
+	by   string
+	list []int // GPU indices in chart order with a list; the rest follow
+}
+
+func parseGPUOrder(s string) (gpuOrder, error) {
+	switch s {
+	case gpuOrderIndex, gpuOrderBDF, gpuOrderBusy:
+		return gpuOrder{by: s}, nil
+	}
+	o := gpuOrder{}
+	for _, field := range strings.Split(s, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || id < 0 {
+			return gpuOrder{}, fmt.Errorf("unknown order %q (want index, bdf, busy or GPU indices such as 3,1,0,2)", s)
+		}
+		if slices.Contains(o.list, id) {
+			return gpuOrder{}, fmt.Errorf("GPU %d is listed twice", id)
+		}
+		o.list = append(o.list, id)
+	}
+	if len(o.list) == 0 {
+		return gpuOrder{}, errors.New("empty order")
+	}
+	return o, nil
+}
+
+// order puts the charts in o's order, moving their histories with them,
+// and reports whether that changed anything. GPUs without a bus address
+// yet, or missing from a list, go last by index. The busiest come first
+// at most every busyReorder, as of now.
+func (h *hostView) order(o gpuOrder, now time.Time) bool {
+	if h.placeholder {
+		return false
+	}
+	order := slices.Clone(h.ids)
+	switch {
+	case o.by == gpuOrderIndex:
+		slices.Sort(order)
+	case o.by == gpuOrderBDF:
+		slices.SortFunc(order, func(a, b int) int {
+			ba, bb := h.static[a].BDF, h.static[b].BDF
+			if (ba == "") != (bb == "") {
+				if ba == "" {
+					return 1
+				}
+				return -1
+			}
+			if c := strings.Compare(ba, bb); c != 0 {
+				return c
+			}
+			return cmp.Compare(a, b)
+		})
+	case o.by == gpuOrderBusy:
+		// A replay seeking back starts over
+		if since := now.Sub(h.reordered); since >= 0 && since < busyReorder {
+			return false
+		}
+		h.reordered = now
+		busy := make(map[int]float64, len(order))
+		for i, id := range h.ids {
+			busy[id] = h.histories[i][0].mean(now.Add(-busyReorder))
+		}
+		// Insertion sort from the current order, only passing GPUs by more
+		// than the margin
+		for i := 1; i < len(order); i++ {
+			for j := i; j > 0 && busy[order[j]] > busy[order[j-1]]+busyMargin; j-- {
+				order[j], order[j-1] = order[j-1], order[j]
+			}
+		}
+	default:
+		rank := func(id int) int {
+			if i := slices.Index(o.list, id); i >= 0 {
+				return i
+			}
+			return len(o.list)
+		}
+		slices.SortFunc(order, func(a, b int) int {
+			return cmp.Or(cmp.Compare(rank(a), rank(b)), cmp.Compare(a, b))
+		})
+	}
+	if slices.Equal(order, h.ids) {
+		return false
+	}
+	charts := make([]*widgets.SparklineGroup, len(order))
+	histories := make([][]*GPUHistory, len(order))
+	for i, id := range order {
+		charts[i], histories[i] = h.charts[h.slots[id]], h.histories[h.slots[id]]
+	}
+	h.ids, h.charts, h.histories = order, charts, histories
+	for i, id := range h.ids {
+		h.slots[id] = i
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	graphiteDialTimeout  = 2 * time.Second
+	graphiteWriteTimeout = 2 * time.Second
+	graphiteMaxBackoff   = time.Minute
+)
+
+// GraphiteSink sends every sample over TCP in Graphite's plaintext protocol.
+// A background goroutine owns the connection; while it is down batches are
+// dropped and reconnects are attempted with exponential backoff.
+type GraphiteSink struct {
+	addr   string
+	prefix string
+	host   string
+	queue  chan []byte
+	done   chan struct{}
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+func newGraphiteSink(addr, prefix string) (*GraphiteSink, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid --graphite address %q: %v", addr, err)
+	}
+	if prefix != "" {
+		prefix = strings.TrimSuffix(prefix, ".") + "."
+	}
+	s := &GraphiteSink{
+		addr:   addr,
+		prefix: prefix,
+		host:   hostname(),
+		queue:  make(chan []byte, 1),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// graphiteBatch renders all metrics of one sample as a single payload
+func (s *GraphiteSink) graphiteBatch(sample Sample) []byte {
+	var b strings.Builder
+	ts := sample.Time.Unix()
+	host := strings.ReplaceAll(sampleHost(sample, s.host), ".", "_")
+	for _, m := range sample.GPUs {
+		path := fmt.Sprintf("%s%s.gpu%d.", s.prefix, host, m.ID)
+		for _, metric := range []struct {
+			name  string
+			value float64
+		}{
+			{"util", m.GFXUtil},
+			{"power", m.Power},
+			{"temp", m.GPUTemp},
+			{"mem_temp", m.MemTemp},
+			{"gfx_clock", m.GFXClock},
+			{"mem_util", m.MemUtil},
+			{"mem_clock", m.MemClock},
+			{"vram_used", m.VRAMUsed},
+			{"vram_total", m.VRAMTotal},
+		} {
+			fmt.Fprintf(&b, "%s%s %s %d\n", path, metric.name, formatFloat(metric.value), ts)
+		}
+	}
+	return []byte(b.String())
+}
+
+func (s *GraphiteSink) Write(sample Sample) error {
+	select {
+	case s.queue <- s.graphiteBatch(sample):
+	default:
+		// Previous batch still in flight; drop this one
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
+
+func (s *GraphiteSink) Close() error {
+	close(s.queue)
+	<-s.done
+	return nil
+}
+
+func (s *GraphiteSink) setErr(err error) {
+	s.mu.Lock()
+	s.lastErr = err
+	s.mu.Unlock()
+}
+
+func (s *GraphiteSink) run() {
+	defer close(s.done)
+	var conn net.Conn
+	var nextDial time.Time
+	backoff := time.Second
+	for batch := range s.queue {
+		if conn == nil {
+			if time.Now().Before(nextDial) {
+				continue
+			}
+			c, err := net.DialTimeout("tcp", s.addr, graphiteDialTimeout)
+			if err != nil {
+				s.setErr(fmt.Errorf("graphite connect failed: %v", err))
+				nextDial = time.Now().Add(backoff)
+				backoff = min(backoff*2, graphiteMaxBackoff)
+				continue
+			}
+			conn = c
+			backoff = time.Second
+		}
+		conn.SetWriteDeadline(time.Now().Add(graphiteWriteTimeout))
+		if _, err := conn.Write(batch); err != nil {
+			s.setErr(fmt.Errorf("graphite write failed: %v", err))
+			conn.Close()
+			conn = nil
+			continue
+		}
+		s.setErr(nil)
+	}
+	if conn != nil {
+		conn.Close()
+	}
+}
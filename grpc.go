@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A dependency free gRPC server for the Monitor service in proto/mitop.proto.
+// It speaks gRPC over cleartext HTTP/2 and encodes protobuf by hand, which is
+// manageable for a handful of flat messages.
+
+const (
+	grpcSubscribeQueue = 8
+
+	grpcOK            = 0
+	grpcUnavailable   = 14
+	grpcUnimplemented = 12
+)
+
+// protobuf wire format helpers
+type protoBuffer []byte
+
+func (b *protoBuffer) varint(v uint64) {
+	*b = binary.AppendUvarint(*b, v)
+}
+
+func (b *protoBuffer) tag(field int, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (b *protoBuffer) int(field int, v int64) {
+	if v != 0 {
+		b.tag(field, 0)
+		b.varint(uint64(v))
+	}
+}
+
+func (b *protoBuffer) double(field int, v float64) {
+	if v != 0 {
+		b.tag(field, 1)
+		*b = binary.LittleEndian.AppendUint64(*b, math.Float64bits(v))
+	}
+}
+
+func (b *protoBuffer) bytes(field int, v []byte) {
+	b.tag(field, 2)
+	b.varint(uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *protoBuffer) string(field int, v string) {
+	if v != "" {
+		b.bytes(field, []byte(v))
+	}
+}
+
+func encodeGpuSample(m GPUMetrics) []byte {
+	var b protoBuffer
+	b.int(1, int64(m.ID))
+	b.double(2, m.Power)
+	b.double(3, m.GPUTemp)
+	b.double(4, m.MemTemp)
+	b.double(5, m.GFXUtil)
+	b.double(6, m.GFXClock)
+	b.double(7, m.MemUtil)
+	b.double(8, m.MemClock)
+	b.double(9, m.VRAMUsed)
+	b.double(10, m.VRAMTotal)
+	return b
+}
+
+func encodeProcessSample(p ProcessInfo) []byte {
+	var b protoBuffer
+	b.int(1, int64(p.GPU))
+	b.string(2, p.Name)
+	b.string(3, strconv.Itoa(p.Pid))
+	b.double(4, p.UsagePercent)
+	b.double(5, mib(p.VRAMBytes))
+	b.double(6, mib(p.GTTBytes))
+	b.double(7, mib(p.CPUBytes))
+	b.double(8, mib(p.TotalBytes))
+	b.string(9, p.Host)
+	return b
+}
+
+func encodeSnapshot(s Sample) []byte {
+	var b protoBuffer
+	if !s.Time.IsZero() {
+		b.int(1, s.Time.UnixNano())
+	}
+	b.string(2, s.Host)
+	for _, m := range s.GPUs {
+		b.bytes(3, encodeGpuSample(m))
+	}
+	for _, p := range s.Processes {
+		b.bytes(4, encodeProcessSample(p))
+	}
+	return b
+}
+
+// GRPCServer serves GetSnapshot and Subscribe. Samples reach it through the
+// same broadcaster as the WebSocket stream, so both see identical data.
+type GRPCServer struct {
+	server *http.Server
+	hub    *broadcaster
+	addr   net.Addr
+
+	mu     sync.RWMutex
+	latest Sample
+}
+
+func newGRPCServer(addr string, hub *broadcaster) (*GRPCServer, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start gRPC server: %v", err)
+	}
+	s := &GRPCServer{hub: hub, addr: ln.Addr()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mitop.v1.Monitor/GetSnapshot", s.handleGetSnapshot)
+	mux.HandleFunc("/mitop.v1.Monitor/Subscribe", s.handleSubscribe)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		grpcFinish(w, grpcUnimplemented, "unknown method "+r.URL.Path)
+	})
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	s.server = &http.Server{Handler: mux, Protocols: &protocols, ReadHeaderTimeout: 5 * time.Second}
+	go s.server.Serve(ln)
+	return s, nil
+}
+
+func (s *GRPCServer) Write(sample Sample) error {
+	s.mu.Lock()
+	s.latest = sample
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *GRPCServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+// grpcStart validates the request and sends the response headers
+func grpcStart(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		grpcFinish(w, grpcUnimplemented, "gRPC requires HTTP/2 and application/grpc")
+		return false
+	}
+	// The request messages are empty; drain the body
+	io.Copy(io.Discard, r.Body)
+	w.WriteHeader(http.StatusOK)
+	// A subscriber sees the stream open before the first sample
+	w.(http.Flusher).Flush()
+	return true
+}
+
+func grpcWriteMessage(w http.ResponseWriter, msg []byte) error {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	if _, err := w.Write(append(frame, msg...)); err != nil {
+		return err
+	}
+	w.(http.Flusher).Flush()
+	return nil
+}
+
+func grpcFinish(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set("Grpc-Message", message)
+	}
+}
+
+func (s *GRPCServer) handleGetSnapshot(w http.ResponseWriter, r *http.Request) {
+	if !grpcStart(w, r) {
+		return
+	}
+	s.mu.RLock()
+	latest := s.latest
+	s.mu.RUnlock()
+	if latest.Time.IsZero() {
+		grpcFinish(w, grpcUnavailable, "no sample collected yet")
+		return
+	}
+	if err := grpcWriteMessage(w, encodeSnapshot(latest)); err != nil {
+		return
+	}
+	grpcFinish(w, grpcOK, "")
+}
+
+func (s *GRPCServer) handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	if !grpcStart(w, r) {
+		return
+	}
+	debugLog.Info("gRPC subscriber connected", "remote", r.RemoteAddr)
+	defer debugLog.Info("gRPC subscriber disconnected", "remote", r.RemoteAddr)
+	sub := s.hub.subscribe(grpcSubscribeQueue)
+	defer s.hub.unsubscribe(sub)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case sample, ok := <-sub.C:
+			if !ok {
+				grpcFinish(w, grpcUnavailable, "server shutting down")
+				return
+			}
+			if err := grpcWriteMessage(w, encodeSnapshot(sample)); err != nil {
+				return
+			}
+		}
+	}
+}
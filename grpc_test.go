@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// protoField is a field of a message in proto/mitop.proto
+type protoField struct {
+	name     string
+	typ      string
+	repeated bool
+}
+
+// protoSchema reads the messages of proto/mitop.proto by field number, so
+// the tests decode what a client generated from it would
+func protoSchema(t *testing.T) map[string]map[uint64]protoField {
+	t.Helper()
+	f, err := os.Open("proto/mitop.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	messageRE := regexp.MustCompile(`^message (\w+) \{`)
+	fieldRE := regexp.MustCompile(`^\s*(repeated\s+)?(\w+)\s+(\w+)\s*=\s*(\d+);`)
+	schema := map[string]map[uint64]protoField{}
+	var message string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := messageRE.FindStringSubmatch(line); m != nil {
+			message = m[1]
+			schema[message] = map[uint64]protoField{}
+			continue
+		}
+		if strings.HasPrefix(line, "}") {
+			message = ""
+			continue
+		}
+		if m := fieldRE.FindStringSubmatch(line); m != nil && message != "" {
+			var num uint64
+			fmt.Sscan(m[4], &num)
+			schema[message][num] = protoField{name: m[3], typ: m[2], repeated: m[1] != ""}
+		}
+	}
+	return schema
+}
+
+// decodeProto decodes a message strictly by the schema: every field must
+// be declared, with the wire type of its declared type. Values are int64,
+// float64, string or a nested map; repeated fields are slices.
+func decodeProto(schema map[string]map[uint64]protoField, message string, msg []byte) (map[string]any, error) {
+	fields, ok := schema[message]
+	if !ok {
+		return nil, fmt.Errorf("unknown message %s", message)
+	}
+	out := map[string]any{}
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return nil, fmt.Errorf("%s: bad field key", message)
+		}
+		msg = msg[n:]
+		f, ok := fields[key>>3]
+		if !ok {
+			return nil, fmt.Errorf("%s: undeclared field %d", message, key>>3)
+		}
+		var v any
+		switch wireType := key & 7; {
+		case (f.typ == "int32" || f.typ == "int64") && wireType == 0:
+			x, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return nil, fmt.Errorf("%s.%s: bad varint", message, f.name)
+			}
+			v, msg = int64(x), msg[n:]
+		case f.typ == "double" && wireType == 1:
+			if len(msg) < 8 {
+				return nil, fmt.Errorf("%s.%s: short double", message, f.name)
+			}
+			v, msg = math.Float64frombits(binary.LittleEndian.Uint64(msg)), msg[8:]
+		case wireType == 2 && f.typ != "double" && !strings.HasPrefix(f.typ, "int"):
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return nil, fmt.Errorf("%s.%s: bad length", message, f.name)
+			}
+			data := msg[n : n+int(l)]
+			msg = msg[n+int(l):]
+			if f.typ == "string" {
+				v = string(data)
+			} else {
+				nested, err := decodeProto(schema, f.typ, data)
+				if err != nil {
+					return nil, err
+				}
+				v = nested
+			}
+		default:
+			return nil, fmt.Errorf("%s.%s: wire type %d for %s", message, f.name, wireType, f.typ)
+		}
+		if f.repeated {
+			list, _ := out[f.name].([]any)
+			out[f.name] = append(list, v)
+		} else {
+			out[f.name] = v
+		}
+	}
+	return out, nil
+}
+
+// grpcCall calls a method of the Monitor service over cleartext HTTP/2, as
+// a gRPC client does, and returns its response stream
+func grpcCall(t *testing.T, ctx context.Context, s *GRPCServer, method string) *http.Response {
+	t.Helper()
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: &protocols}}
+	// The request is an empty message in its frame
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+s.addr.String()+"/mitop.v1.Monitor/"+method, bytes.NewReader(make([]byte, 5)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("%s answered over HTTP/%d", method, resp.ProtoMajor)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/grpc" {
+		t.Fatalf("%s Content-Type = %q", method, ct)
+	}
+	return resp
+}
+
+// readGRPCMessage reads one length-prefixed message of a response
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, fmt.Errorf("compressed message")
+	}
+	msg := make([]byte, binary.BigEndian.Uint32(header[1:]))
+	_, err := io.ReadFull(r, msg)
+	return msg, err
+}
+
+// testSample is what the fake provider collects
+var testSample = Sample{
+	Host: "node1",
+	Time: time.Unix(1700000000, 123456789),
+	GPUs: []GPUMetrics{
+		{GPUDevice: GPUDevice{ID: 0}, Power: 250.5, GPUTemp: 61, MemTemp: 70, GFXUtil: 98, GFXClock: 2100, MemUtil: 40, MemClock: 1300, VRAMUsed: 40960, VRAMTotal: 65536},
+		{GPUDevice: GPUDevice{ID: 2}, GPUTemp: 35, VRAMTotal: 65536},
+	},
+	Processes: []ProcessInfo{
+		{GPU: 0, Pid: 4242, Name: "python3 train.py", UsagePercent: 97.5, VRAMBytes: 40 << 30, GTTBytes: 2 << 20, TotalBytes: 40<<30 + 2<<20},
+	},
+}
+
+// testSnapshot is testSample as decoded from a Snapshot; zero values are
+// not sent
+var testSnapshot = map[string]any{
+	"timestamp_unix_nano": int64(1700000000123456789),
+	"host":                "node1",
+	"gpus": []any{
+		map[string]any{"power": 250.5, "gpu_temp": 61.0, "mem_temp": 70.0, "gfx_util": 98.0, "gfx_clock": 2100.0,
+			"mem_util": 40.0, "mem_clock": 1300.0, "vram_used": 40960.0, "vram_total": 65536.0},
+		map[string]any{"id": int64(2), "gpu_temp": 35.0, "vram_total": 65536.0},
+	},
+	"processes": []any{
+		map[string]any{"name": "python3 train.py", "pid": "4242", "gfx_usage": 97.5, "vram_mem": 40960.0, "gtt_mem": 2.0, "total_mem": 40962.0},
+	},
+}
+
+func startGRPCServer(t *testing.T) (*GRPCServer, *broadcaster) {
+	t.Helper()
+	hub := newBroadcaster()
+	s, err := newGRPCServer("127.0.0.1:0", hub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, hub
+}
+
+func TestGRPCGetSnapshot(t *testing.T) {
+	schema := protoSchema(t)
+	s, _ := startGRPCServer(t)
+	ctx := context.Background()
+
+	// Before the first sample there is nothing to send
+	resp := grpcCall(t, ctx, s, "GetSnapshot")
+	if _, err := readGRPCMessage(resp.Body); err != io.EOF {
+		t.Fatalf("GetSnapshot before a sample: %v, want no message", err)
+	}
+	if status := resp.Trailer.Get("Grpc-Status"); status != "14" {
+		t.Errorf("Grpc-Status = %q, want 14 (unavailable)", status)
+	}
+
+	s.Write(testSample)
+	resp = grpcCall(t, ctx, s, "GetSnapshot")
+	msg, err := readGRPCMessage(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeProto(schema, "Snapshot", msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, testSnapshot) {
+		t.Errorf("snapshot = %v\nwant %v", got, testSnapshot)
+	}
+	if _, err := readGRPCMessage(resp.Body); err != io.EOF {
+		t.Errorf("GetSnapshot sent more than one message: %v", err)
+	}
+	if status := resp.Trailer.Get("Grpc-Status"); status != "0" {
+		t.Errorf("Grpc-Status = %q, want 0", status)
+	}
+}
+
+func TestGRPCSubscribe(t *testing.T) {
+	schema := protoSchema(t)
+	s, hub := startGRPCServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resp := grpcCall(t, ctx, s, "Subscribe")
+	// The subscription starts when the handler runs; publish until the
+	// stream delivers
+	go func() {
+		for ctx.Err() == nil {
+			hubSink{hub}.Write(testSample)
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	for range 3 {
+		msg, err := readGRPCMessage(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := decodeProto(schema, "Snapshot", msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, testSnapshot) {
+			t.Fatalf("snapshot = %v\nwant %v", got, testSnapshot)
+		}
+	}
+}
+
+func TestGRPCSubscribeEndsOnShutdown(t *testing.T) {
+	s, hub := startGRPCServer(t)
+	resp := grpcCall(t, context.Background(), s, "Subscribe")
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, resp.Body)
+		done <- err
+	}()
+	// Closing the hub ends the subscription, or refuses it if the handler
+	// gets there later
+	hub.close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream didn't end")
+	}
+	if status := resp.Trailer.Get("Grpc-Status"); status != "14" {
+		t.Errorf("Grpc-Status = %q, want 14 (unavailable)", status)
+	}
+}
+
+func TestGRPCUnknownMethod(t *testing.T) {
+	s, _ := startGRPCServer(t)
+	resp := grpcCall(t, context.Background(), s, "Reboot")
+	io.Copy(io.Discard, resp.Body)
+	// An error without messages is sent in the headers alone
+	if status := resp.Header.Get("Grpc-Status"); status != "12" {
+		t.Errorf("Grpc-Status = %q, want 12 (unimplemented)", status)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// logError reports a problem on stderr and in the debug log. Used only when
+// no TUI owns the terminal.
+func logError(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.Print(msg)
+	debugLog.Error(msg)
+}
+
+func writePIDFile(path string) error {
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// runHeadless runs the sampler without a terminal UI, feeding every sink
+// until SIGTERM or SIGINT. The caller closes the sinks, which flushes them.
+// Under a systemd Type=notify unit it reports readiness after the first
+// successful collection and pings the watchdog after every collection.
+func runHeadless(h *hostView, sinks []Sink, interval time.Duration) error {
+	if *pidFile != "" {
+		if err := writePIDFile(*pidFile); err != nil {
+			return fmt.Errorf("failed to write pid file: %v", err)
+		}
+		defer os.Remove(*pidFile)
+	}
+	if len(sinks) == 0 {
+		logError("headless mode without any output configured; samples are discarded")
+	}
+	sd, err := newSystemdNotifier()
+	if err != nil {
+		return err
+	}
+	if sd != nil {
+		defer sd.close()
+		defer sd.notify("STOPPING=1")
+		if sd.watchdog > 0 && interval > sd.watchdog/2 {
+			logError("interval %v is more than half of the systemd watchdog timeout %v; raise WatchdogSec", interval, sd.watchdog)
+		}
+	}
+	ready := false
+	debugLog.Info("headless sampler started", "sinks", len(sinks), "interval", interval, "systemd", sd != nil)
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(sigCh)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	// Static info labels exported samples with each GPU's bus address
+	static := newStaticCache(h.name, h.runner)
+	// Only log an error when it changes so a persistent failure doesn't flood the log
+	var lastErr string
+	report := func(err error) {
+		msg := ""
+		if err != nil {
+			msg = err.Error()
+		}
+		if msg != lastErr {
+			if err != nil {
+				logError("%v", err)
+			} else {
+				logError("recovered")
+			}
+			lastErr = msg
+		}
+	}
+	for {
+		select {
+		case sig := <-sigCh:
+			debugLog.Info("shutting down", "signal", sig)
+			return nil
+		case <-ticker.C:
+			sample, err := h.backend.collect(h.runner)
+			sample.Host = h.name
+			// amd-smi answered, even if with an error, so the collector isn't hung
+			if sd != nil && sd.watchdog > 0 {
+				if err := sd.notify("WATCHDOG=1"); err != nil {
+					debugLog.Warn("watchdog ping failed", "err", err)
+				}
+			}
+			if err != nil {
+				report(fmt.Errorf("failed to get GPU metrics: %v", err))
+				continue
+			}
+			if sd != nil && !ready {
+				if err := sd.notify("READY=1"); err != nil {
+					logError("%v", err)
+				}
+				ready = true
+			}
+			static.update(sample, false)
+			static.label(&sample)
+			h.aliases.label(&sample)
+			report(writeSinks(sinks, sample))
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	ui "github.com/gizak/termui/v3"
+	"github.com/gizak/termui/v3/widgets"
+)
+
+// helpOverlay lists the effective key bindings over the screen. Any key
+// closes it. It belongs to the UI goroutine.
+type helpOverlay struct {
+	text  *widgets.Paragraph
+	shown bool
+	lines int
+}
+
+// newHelpOverlay renders k once; the keymap doesn't change after startup.
+// Replay bindings are only listed while replaying.
+func newHelpOverlay(k *keymap, replay bool) *helpOverlay {
+	h := &helpOverlay{text: widgets.NewParagraph()}
+	h.text.Title = "Keys (any key closes)"
+	h.text.BorderStyle = ui.NewStyle(ui.ColorCyan)
+	var lines []string
+	for _, b := range k.bindings {
+		if b.replay && !replay {
+			continue
+		}
+		keys := k.keys(b.action)
+		if keys == "" {
+			keys = "(unbound)"
+		}
+		lines = append(lines, fmt.Sprintf("[%-14s](fg:yellow) %-10s %s", keys, b.action, b.help))
+	}
+	// Panel keys only mean something while their panel is open
+	lines = append(lines, "",
+		"Fan panel with --enable-control: 1-9 and 0 set 10-100%, a automatic",
+		"GPU info panel with --enable-control: c next power profile")
+	h.text.Text = strings.Join(lines, "\n")
+	h.lines = len(lines)
+	return h
+}
+
+func (h *helpOverlay) layout(width, height int) {
+	w, ht := min(80, width), min(h.lines+2, height-1)
+	h.text.SetRect((width-w)/2, (height-ht)/2, (width+w)/2, (height-ht)/2+ht)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// historyVersion is bumped when savedHistory changes incompatibly
+const historyVersion = 1
+
+// savedHistory is the chart history and session totals the terminal UI
+// leaves behind on exit, so the next run starts with some context
+type savedHistory struct {
+	Version int         `json:"version"`
+	Saved   time.Time   `json:"saved"`
+	Hosts   []savedHost `json:"hosts"`
+}
+
+type savedHost struct {
+	Name         string     `json:"name,omitempty"` // empty for the local machine
+	SessionStart time.Time  `json:"session_start"`
+	GPUs         []savedGPU `json:"gpus"`
+}
+
+// savedGPU is one GPU's history, keyed by UUID and bus address rather
+// than index, which can change between runs
+type savedGPU struct {
+	UUID    string       `json:"uuid,omitempty"`
+	BDF     string       `json:"bdf,omitempty"`
+	Times   []time.Time  `json:"times"`
+	Values  [][]float64  `json:"values"` // per chart metric, like Times
+	Session savedSession `json:"session"`
+}
+
+type savedSession struct {
+	Count   int       `json:"count"`
+	UtilSum float64   `json:"util_sum"`
+	Energy  float64   `json:"energy"` // joules
+	Temp    savedPeak `json:"temp"`
+	MemTemp savedPeak `json:"mem_temp"`
+	Power   savedPeak `json:"power"`
+	Util    savedPeak `json:"util"`
+	VRAM    savedPeak `json:"vram"`
+}
+
+type savedPeak struct {
+	Value float64   `json:"value"`
+	At    time.Time `json:"at"`
+}
+
+func savePeak(p peak) savedPeak {
+	return savedPeak{Value: p.value, At: p.at}
+}
+
+func (p savedPeak) peak() peak {
+	return peak{value: p.Value, at: p.At}
+}
+
+// historyPath is history.json next to the state file, or "" without a home
+func historyPath() string {
+	path := statePath()
+	if path == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(path), "history.json")
+}
+
+// matches reports whether a saved GPU is the device d: the same UUID, or
+// the same bus address when either lacks a UUID
+func (g savedGPU) matches(d GPUDevice) bool {
+	if g.UUID != "" && d.UUID != "" {
+		return g.UUID == d.UUID
+	}
+	return g.BDF != "" && g.BDF == d.BDF
+}
+
+// encodeHistory collects the hosts' chart histories and session totals.
+// GPUs with neither UUID nor bus address can't be matched next time and
+// are left out.
+func encodeHistory(hosts []*hostView, now time.Time) savedHistory {
+	saved := savedHistory{Version: historyVersion, Saved: now}
+	for _, h := range hosts {
+		if h.placeholder {
+			continue
+		}
+		host := savedHost{Name: h.name, SessionStart: h.sessionStart}
+		for _, m := range h.lastGPUs {
+			i, ok := h.slot(m.ID)
+			if !ok || m.UUID == "" && m.BDF == "" {
+				continue
+			}
+			g := savedGPU{UUID: m.UUID, BDF: m.BDF, Times: h.histories[i][0].getTimes()}
+			for _, history := range h.histories[i] {
+				g.Values = append(g.Values, history.getData())
+			}
+			if s, ok := h.session[m.ID]; ok {
+				g.Session = savedSession{Count: s.count, UtilSum: s.utilSum, Energy: s.energy,
+					Temp: savePeak(s.temp), MemTemp: savePeak(s.memTemp), Power: savePeak(s.power),
+					Util: savePeak(s.util), VRAM: savePeak(s.vram)}
+			}
+			host.GPUs = append(host.GPUs, g)
+		}
+		saved.Hosts = append(saved.Hosts, host)
+	}
+	return saved
+}
+
+// saveHistory writes the hosts' history through a temporary file
+func saveHistory(path string, hosts []*hostView, now time.Time) error {
+	data, err := json.Marshal(encodeHistory(hosts, now))
+	if err != nil {
+		return err
+	}
+	if err := writeStateFile(path, data); err != nil {
+		return fmt.Errorf("failed to save history: %v", err)
+	}
+	return nil
+}
+
+// loadHistory reads the history file. A missing file is no history; one
+// older than maxAge, from another version or that doesn't parse is an
+// error for the caller to log and ignore.
+func loadHistory(path string, maxAge time.Duration, now time.Time) (*savedHistory, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %v", err)
+	}
+	var saved savedHistory
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if saved.Version != historyVersion {
+		return nil, fmt.Errorf("%s: unknown version %d", path, saved.Version)
+	}
+	if age := now.Sub(saved.Saved); age > maxAge {
+		return nil, fmt.Errorf("%s is %v old, more than %v", path, age.Round(time.Second), maxAge)
+	}
+	for _, host := range saved.Hosts {
+		for _, g := range host.GPUs {
+			if len(g.Values) != len(chartMetrics) {
+				return nil, fmt.Errorf("%s: %d chart metrics, want %d", path, len(g.Values), len(chartMetrics))
+			}
+			for _, values := range g.Values {
+				if len(values) != len(g.Times) {
+					return nil, fmt.Errorf("%s: %d values for %d times", path, len(values), len(g.Times))
+				}
+			}
+		}
+	}
+	return &saved, nil
+}
+
+// restoreHistory preloads the charts and session totals of the GPUs in a
+// host's first sample from what the last run saved, then forgets it. Saved
+// GPUs that aren't in the sample are ignored with a log line.
+func (h *hostView) restoreHistory(gpus []GPUMetrics) {
+	if h.saved == nil {
+		return
+	}
+	saved := h.saved
+	h.saved = nil
+	restored := false
+	for _, m := range gpus {
+		i, ok := h.slot(m.ID)
+		j := slices.IndexFunc(saved.GPUs, func(g savedGPU) bool { return g.matches(m.GPUDevice) })
+		if !ok || j < 0 {
+			continue
+		}
+		g := saved.GPUs[j]
+		saved.GPUs = slices.Delete(saved.GPUs, j, j+1)
+		for k, values := range g.Values {
+			history := newGPUHistory(h.histories[i][k].maxLen)
+			for n, v := range values {
+				history.add(g.Times[n], v)
+			}
+			h.histories[i][k] = history
+		}
+		// Energy is integrated from the next sample on, not across the restart
+		s := g.Session
+		h.session[m.ID] = &sessionStats{count: s.Count, utilSum: s.UtilSum, energy: s.Energy,
+			temp: s.Temp.peak(), memTemp: s.MemTemp.peak(), power: s.Power.peak(), util: s.Util.peak(), vram: s.VRAM.peak()}
+		restored = true
+		debugLog.Info("history restored", "host", h.name, "gpu", m.ID, "bdf", g.BDF, "samples", len(g.Times))
+	}
+	if restored && !saved.SessionStart.IsZero() {
+		h.sessionStart = saved.SessionStart
+	}
+	for _, g := range saved.GPUs {
+		debugLog.Info("saved history ignored: device not found", "host", h.name, "uuid", g.UUID, "bdf", g.BDF)
+	}
+}
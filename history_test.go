@@ -0,0 +1,210 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// filledHistory is a history of maxLen with the values 1..n added a
+// second apart
+func filledHistory(maxLen, n int) *GPUHistory {
+	h := newGPUHistory(maxLen)
+	for i := 1; i <= n; i++ {
+		h.add(t0.Add(time.Duration(i)*time.Second), float64(i))
+	}
+	return h
+}
+
+func values(from, to int) []float64 {
+	var v []float64
+	for i := from; i <= to; i++ {
+		v = append(v, float64(i))
+	}
+	return v
+}
+
+func blanks(n int) []float64 {
+	return slices.Repeat([]float64{chartBlank}, n)
+}
+
+func TestHistoryData(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		added int
+		data  []float64
+		chart []float64
+	}{
+		{"empty", 0, []float64{}, blanks(5)},
+		{"partial", 3, values(1, 3), append(blanks(2), values(1, 3)...)},
+		{"full", 5, values(1, 5), values(1, 5)},
+		{"wrapped", 8, values(4, 8), values(4, 8)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := filledHistory(5, tc.added)
+			if h.Len() != len(tc.data) {
+				t.Errorf("Len() = %d, want %d", h.Len(), len(tc.data))
+			}
+			if got := h.getData(); !slices.Equal(got, tc.data) {
+				t.Errorf("getData() = %v, want %v", got, tc.data)
+			}
+			if got := h.chartData(); !slices.Equal(got, tc.chart) {
+				t.Errorf("chartData() = %v, want %v", got, tc.chart)
+			}
+			times := h.getTimes()
+			if len(times) != len(tc.data) {
+				t.Fatalf("getTimes() has %d times, want %d", len(times), len(tc.data))
+			}
+			for i, v := range tc.data {
+				if want := t0.Add(time.Duration(v) * time.Second); !times[i].Equal(want) {
+					t.Errorf("getTimes()[%d] = %v, want %v", i, times[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestHistoryFillChartReusesBuffer(t *testing.T) {
+	h := filledHistory(5, 8)
+	buf := make([]float64, 0, 5)
+	got := h.fillChart(buf)
+	if &got[0] != &buf[:1][0] {
+		t.Error("fillChart allocated although the buffer was large enough")
+	}
+	if !slices.Equal(got, values(4, 8)) {
+		t.Errorf("fillChart() = %v, want %v", got, values(4, 8))
+	}
+}
+
+func TestHistoryResized(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		maxLen, added int
+		newLen        int
+	}{
+		{"grow partial", 5, 3, 8},
+		{"grow wrapped", 5, 12, 8},
+		{"shrink partial", 8, 3, 5},
+		{"shrink full", 8, 8, 5},
+		{"shrink wrapped", 8, 20, 5},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			old := filledHistory(tc.maxLen, tc.added)
+			data, times := old.getData(), old.getTimes()
+			r := old.resized(tc.newLen)
+			keep := min(len(data), tc.newLen)
+			wantData, wantTimes := data[len(data)-keep:], times[len(times)-keep:]
+			if r.maxLen != tc.newLen {
+				t.Errorf("maxLen = %d, want %d", r.maxLen, tc.newLen)
+			}
+			if got := r.getData(); !slices.Equal(got, wantData) {
+				t.Errorf("getData() = %v, want the tail %v", got, wantData)
+			}
+			if got := r.getTimes(); !slices.EqualFunc(got, wantTimes, time.Time.Equal) {
+				t.Errorf("getTimes() = %v, want the tail %v", got, wantTimes)
+			}
+			// New samples go after the kept ones
+			r.add(t0.Add(time.Hour), -1)
+			if got := r.getData(); got[len(got)-1] != -1 || len(got) > 1 && got[len(got)-2] != wantData[len(wantData)-1] {
+				t.Errorf("after add: %v", got)
+			}
+		})
+	}
+}
+
+// BenchmarkHistoryGetData compares copying a full history into a new slice
+// with appending it to a buffer kept between ticks, as the charts do
+func BenchmarkHistoryGetData(b *testing.B) {
+	h := filledHistory(600, 1000)
+	b.Run("new", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			h.getData()
+		}
+	})
+	b.Run("reused", func(b *testing.B) {
+		b.ReportAllocs()
+		var buf []float64
+		for b.Loop() {
+			buf = h.appendData(buf[:0])
+		}
+	})
+	b.Run("chart", func(b *testing.B) {
+		b.ReportAllocs()
+		var buf []float64
+		for b.Loop() {
+			buf = h.fillChart(buf)
+		}
+	})
+}
+
+// TestHistoryRoundTrip saves the charts of one run and restores them in
+// the next, where the GPUs have swapped indices
+func TestHistoryRoundTrip(t *testing.T) {
+	gpus := []GPUMetrics{
+		{GPUDevice: GPUDevice{ID: 0, UUID: "gpu-a", BDF: "0000:03:00.0", Caps: capMemBusy | capMemTemp}},
+		{GPUDevice: GPUDevice{ID: 1, BDF: "0000:83:00.0"}},
+	}
+	h := newHostView("", nil)
+	h.addGPUs(gpuIDs(gpus), 10, 80, false)
+	// GPU 0 wraps its history, GPU 1 only fills part of it
+	for n := range 15 {
+		at := t0.Add(time.Duration(n) * time.Second)
+		for _, m := range gpus {
+			if m.ID == 1 && n < 11 {
+				continue
+			}
+			m.GFXUtil, m.MemUtil, m.MemTemp, m.Power = float64(n), float64(2*n), 40+float64(n), 100
+			i, _ := h.slot(m.ID)
+			h.record(i, at, m)
+			h.addSession(at, m)
+		}
+	}
+	h.lastGPUs = gpus
+	saveAt := t0.Add(20 * time.Second)
+	path := filepath.Join(t.TempDir(), "history.json")
+	if err := saveHistory(path, []*hostView{h}, saveAt); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := loadHistory(path, time.Minute, saveAt.Add(30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := []GPUMetrics{
+		{GPUDevice: GPUDevice{ID: 0, BDF: "0000:83:00.0"}},
+		{GPUDevice: GPUDevice{ID: 1, UUID: "gpu-a", BDF: "0000:03:00.0"}},
+	}
+	restored := newHostView("", nil)
+	restored.addGPUs(gpuIDs(next), 10, 80, false)
+	restored.saved = &saved.Hosts[0]
+	restored.restoreHistory(next)
+
+	for _, pair := range [][2]int{{0, 1}, {1, 0}} {
+		before, after := h.histories[pair[0]], restored.histories[pair[1]]
+		for k := range chartMetrics {
+			if got, want := after[k].getData(), before[k].getData(); !slices.Equal(got, want) {
+				t.Errorf("GPU %d metric %d restored as GPU %d: values %v, want %v", pair[0], k, pair[1], got, want)
+			}
+			if got, want := after[k].getTimes(), before[k].getTimes(); !slices.EqualFunc(got, want, time.Time.Equal) {
+				t.Errorf("GPU %d metric %d restored as GPU %d: times %v, want %v", pair[0], k, pair[1], got, want)
+			}
+		}
+		s, want := restored.session[pair[1]], h.session[pair[0]]
+		if s == nil || s.count != want.count || s.utilSum != want.utilSum || s.energy != want.energy || !s.temp.at.Equal(want.temp.at) {
+			t.Errorf("GPU %d session restored as %+v, want %+v", pair[0], s, want)
+		}
+	}
+
+	// Too old a file isn't restored
+	if _, err := loadHistory(path, time.Minute, saveAt.Add(2*time.Minute)); err == nil {
+		t.Error("loaded history older than its max age")
+	}
+	// and nothing is kept unless configured
+	if age := defaultConfig().HistoryMaxAge; age != 0 {
+		t.Errorf("history_max_age defaults to %v, want off", age)
+	}
+}
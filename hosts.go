@@ -0,0 +1,574 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	ui "github.com/gizak/termui/v3"
+	"github.com/gizak/termui/v3/widgets"
+	"github.com/mattn/go-runewidth"
+)
+
+// stringList is a flag that may be repeated or given comma separated values
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
+
+// readHostsFile reads one ssh target per line, ignoring blanks and # comments
+func readHostsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hosts file: %v", err)
+	}
+	defer f.Close()
+	var hosts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hosts = append(hosts, line)
+	}
+	return hosts, scanner.Err()
+}
+
+// targetHost strips the user from an ssh target, e.g. root@node1 -> node1
+func targetHost(target string) string {
+	if i := strings.LastIndex(target, "@"); i >= 0 {
+		return target[i+1:]
+	}
+	return target
+}
+
+var unreachableStyle = ui.NewStyle(ui.Color(244))
+
+// hostView holds the charts of one monitored host. Charts are ordered by
+// GPU ID and looked up by ID, since IDs can have gaps (0, 2, 5) when devices
+// are masked or partitioned.
+type hostView struct {
+	name         string // empty for the local machine
+	runner       commandRunner
+	backend      *backendSelector
+	aliases      gpuAliases
+	ids          []int // GPU ID of each chart
+	slots        map[int]int
+	charts       []*widgets.SparklineGroup
+	histories    [][]*GPUHistory // per chart, one for each of chartMetrics
+	columns      int             // chart width in samples; longer histories are downsampled
+	downsample   downsampleMode
+	smoothing    float64     // EMA weight of the newest sample, 0 to chart raw samples
+	smoothed     *GPUHistory // scratch for smoothing, reused between charts
+	events       chartEvents // marked on the charts
+	placeholder  bool        // a single chart standing in until the GPUs are known
+	reordered    time.Time   // when --gpu-order=busy last ordered the charts
+	reachable    bool
+	warning      string // why the last sample failed or wasn't stored, for the footer
+	last         Sample
+	lastGPUs     []GPUMetrics  // latest metrics, for the fan panel
+	processes    []ProcessInfo // latest processes, for the VRAM bars
+	fans         []FanInfo
+	fanErr       error
+	static       map[int]StaticInfo // by GPU ID
+	caps         capDetector        // which metrics each GPU reports
+	limitedRuns  map[int]int        // consecutive clock-limited samples by GPU ID
+	idleSince    map[int]time.Time
+	idle         map[int]bool          // GPUs whose charts are dimmed, by GPU ID
+	session      map[int]*sessionStats // by GPU ID
+	sessionStart time.Time
+	saved        *savedHost // the last run's history, until the first sample
+	procPeaks    map[processPeakKey]*processPeak
+	// refreshStatic asks the sampler to query static info again, e.g.
+	// after a setting was changed
+	refreshStatic atomic.Bool
+}
+
+func newHostView(name string, runner commandRunner) *hostView {
+	return &hostView{name: name, runner: runner, backend: newBackendSelector("auto"), slots: map[int]int{}, reachable: true,
+		limitedRuns: map[int]int{}, idleSince: map[int]time.Time{}, idle: map[int]bool{}, session: map[int]*sessionStats{}, sessionStart: time.Now(),
+		procPeaks: map[processPeakKey]*processPeak{}}
+}
+
+func gpuIDs(metrics []GPUMetrics) []int {
+	ids := make([]int, len(metrics))
+	for i, m := range metrics {
+		ids[i] = m.ID
+	}
+	return ids
+}
+
+func newGPUChart(title string, columns, width int) *widgets.SparklineGroup {
+	sparkline := widgets.NewSparkline()
+	sparkline.LineColor = ui.ColorGreen
+	sparkline.TitleStyle = ui.NewStyle(ui.ColorWhite)
+	sparkline.MaxVal = 100
+	// One column per data point, blank until samples arrive
+	sparkline.Data = newGPUHistory(columns).chartData()
+	spGroup := widgets.NewSparklineGroup()
+	spGroup.Title = title
+	spGroup.Sparklines = []*widgets.Sparkline{sparkline}
+	spGroup.BorderStyle = ui.NewStyle(ui.ColorWhite)
+	spGroup.BorderLeft = true
+	spGroup.BorderRight = true
+	spGroup.BorderTop = true
+	spGroup.BorderBottom = true
+	// Set minimum height
+	spGroup.SetRect(0, 0, width, 10)
+	return spGroup
+}
+
+// titlePrefix labels charts with the host name when several hosts are shown
+func (h *hostView) titlePrefix(multi bool) string {
+	if !multi || h.name == "" {
+		return ""
+	}
+	return h.name + ": "
+}
+
+// addGPUs creates charts for GPU IDs not seen before, replacing the
+// placeholder if there is one. A GPU missing from one sample keeps its
+// chart. It reports whether the layout has to be rebuilt.
+func (h *hostView) addGPUs(ids []int, dataPoints, width int, multi bool) bool {
+	var added []int
+	for _, id := range ids {
+		if _, ok := h.slots[id]; !ok || h.placeholder {
+			added = append(added, id)
+		}
+	}
+	if len(added) == 0 {
+		return false
+	}
+	if h.placeholder {
+		h.ids, h.charts, h.histories = nil, nil, nil
+		h.placeholder = false
+	}
+	h.columns = calculateDataPoints(width)
+	for _, id := range added {
+		// Insert in ID order
+		i := sort.SearchInts(h.ids, id)
+		h.ids = slices.Insert(h.ids, i, id)
+		h.charts = slices.Insert(h.charts, i, newGPUChart(fmt.Sprintf("%sGPU %d", h.titlePrefix(multi), id), h.columns, width))
+		h.histories = slices.Insert(h.histories, i, newChartHistories(dataPoints))
+	}
+	h.slots = make(map[int]int, len(h.ids))
+	for i, id := range h.ids {
+		h.slots[id] = i
+	}
+	return true
+}
+
+// setPlaceholder shows one empty chart for a host whose GPUs are unknown
+func (h *hostView) setPlaceholder(dataPoints, width int, multi bool) {
+	h.ids = []int{-1}
+	h.slots = map[int]int{}
+	h.columns = calculateDataPoints(width)
+	h.charts = []*widgets.SparklineGroup{newGPUChart(strings.TrimSuffix(h.titlePrefix(multi), ": "), h.columns, width)}
+	h.histories = [][]*GPUHistory{newChartHistories(dataPoints)}
+	h.placeholder = true
+}
+
+// slot returns the chart index for a GPU ID
+func (h *hostView) slot(id int) (int, bool) {
+	i, ok := h.slots[id]
+	return i, ok
+}
+
+// chartMetrics are what a chart can plot; 'm' cycles through them
+var chartMetrics = []struct {
+	label string // shown in the chart, empty for the default
+	color ui.Color
+	max   float64
+	value func(GPUMetrics) float64
+}{
+	{"", ui.ColorGreen, 100, func(m GPUMetrics) float64 { return m.GFXUtil }},
+	{"memory activity", ui.ColorCyan, 100, func(m GPUMetrics) float64 {
+		if !m.Caps.has(capMemBusy) {
+			return chartBlank
+		}
+		return m.MemUtil
+	}},
+	// GDDR cards report no memory temperature and get a blank chart
+	{"HBM temperature (°C)", ui.ColorMagenta, 120, func(m GPUMetrics) float64 {
+		if !m.Caps.has(capMemTemp) || !m.hasMemTemp() {
+			return chartBlank
+		}
+		return m.MemTemp
+	}},
+}
+
+// chartTitle sums up a GPU's reading, leaving out the metrics it doesn't
+// have
+func (h *hostView) chartTitle(m GPUMetrics, avgUtil float64, multi bool) string {
+	// An APU's carve-out alone says little: most of its memory is GTT
+	mem, tag := "VRAM", ""
+	if m.Vendor != "" {
+		tag = " " + strings.ToUpper(m.Vendor)
+	}
+	if m.APU {
+		mem, tag = "Mem (carve-out + GTT)", tag+" APU"
+	}
+	var readings []string
+	if m.Caps.has(capPower) {
+		readings = append(readings, fmt.Sprintf("%0.1fW", m.Power))
+	}
+	readings = append(readings, fmt.Sprintf("%0.1f°C", m.GPUTemp))
+	if m.Caps.has(capMemTemp) && m.hasMemTemp() {
+		readings = append(readings, fmt.Sprintf("HBM: %0.0f°C", m.MemTemp))
+	}
+	// Smoothed like the chart, so the number agrees with it
+	util, smoothed := m.GFXUtil, ""
+	if i, ok := h.slot(m.ID); ok && h.smoothing > 0 {
+		h.smoothed = h.histories[i][0].smooth(h.smoothing, h.smoothed)
+		util, smoothed = h.smoothed.latest(), "smoothed, "
+	}
+	readings = append(readings, fmt.Sprintf("%0.1f%% Util (%savg %0.1f%%)", util, smoothed, avgUtil), fmt.Sprintf("%0.0f MHz", m.GFXClock))
+	if m.Caps.has(capMemBusy) {
+		readings = append(readings, fmt.Sprintf("MemBusy: %0.0f%%", m.MemUtil))
+	}
+	used, total := m.memory()
+	readings = append(readings, fmt.Sprintf("%s: %0.0f/%0.0f MB", mem, used, total))
+	return fmt.Sprintf("%s%s%s%s - %s", h.titlePrefix(multi), m.name(), tag, h.numaSuffix(m.ID), strings.Join(readings, ", "))
+}
+
+func newChartHistories(dataPoints int) []*GPUHistory {
+	histories := make([]*GPUHistory, len(chartMetrics))
+	for i := range histories {
+		histories[i] = newGPUHistory(dataPoints)
+	}
+	return histories
+}
+
+// record adds a GPU's values to the histories of chart i
+func (h *hostView) record(i int, t time.Time, m GPUMetrics) {
+	for j, metric := range chartMetrics {
+		h.histories[i][j].add(t, min(metric.value(m), metric.max))
+	}
+}
+
+// redraw refills chart i from the history of the charted metric, smoothed
+// if that is on. With minmax it has a second row for the lowest samples.
+func (h *hostView) redraw(i, metric int) {
+	chart := h.charts[i]
+	spark := chart.Sparklines[0]
+	spark.LineColor = chartMetrics[metric].color
+	if h.idle[h.ids[i]] {
+		spark.LineColor = idleColor
+	}
+	var notes []string
+	if h.downsample != downsampleModes[0] {
+		notes = append(notes, string(h.downsample))
+	}
+	history := h.histories[i][metric]
+	if h.smoothing > 0 {
+		h.smoothed = history.smooth(h.smoothing, h.smoothed)
+		history = h.smoothed
+		notes = append(notes, "smoothed")
+	}
+	spark.Title = chartMetrics[metric].label
+	if len(notes) > 0 {
+		spark.Title = strings.TrimSpace(spark.Title + " (" + strings.Join(notes, ", ") + ")")
+	}
+	spark.MaxVal = chartMetrics[metric].max
+	if h.downsample != downsampleMinMax {
+		chart.Sparklines = chart.Sparklines[:1]
+		spark.Data = history.downsample(spark.Data, h.columns, h.downsample)
+		return
+	}
+	spark.Data = history.downsample(spark.Data, h.columns, downsampleMax)
+	if len(chart.Sparklines) == 1 {
+		chart.Sparklines = append(chart.Sparklines, widgets.NewSparkline())
+	}
+	low := chart.Sparklines[1]
+	low.LineColor, low.TitleStyle, low.MaxVal = spark.LineColor, spark.TitleStyle, spark.MaxVal
+	low.Title = "min"
+	low.Data = history.downsample(low.Data, h.columns, downsampleMin)
+}
+
+// resetHistory drops all samples and events, keeping the chart width
+func (h *hostView) resetHistory() {
+	for i := range h.histories {
+		h.histories[i] = newChartHistories(h.histories[i][0].maxLen)
+	}
+	h.events = chartEvents{}
+}
+
+// resize keeps the newest dataPoints samples of every chart
+func (h *hostView) resize(dataPoints, width, metric int) {
+	h.columns = calculateDataPoints(width)
+	for i := range h.charts {
+		for j := range h.histories[i] {
+			h.histories[i][j] = h.histories[i][j].resized(dataPoints)
+		}
+		h.charts[i].SetRect(0, 0, width, 10)
+		h.redraw(i, metric)
+	}
+}
+
+// clockLimited reports whether a GPU has been busy yet well below its top
+// clock for long enough to suspect throttling. It stands in for throttle
+// reasons, which older amd-smi releases don't report.
+func (h *hostView) clockLimited(m GPUMetrics, c ClockLimitConfig) bool {
+	maxClock := h.static[m.ID].MaxClock
+	if maxClock > 0 && m.GFXUtil > c.Util && m.GFXClock < maxClock*c.Clock/100 {
+		h.limitedRuns[m.ID]++
+	} else {
+		h.limitedRuns[m.ID] = 0
+	}
+	return h.limitedRuns[m.ID] >= c.Samples
+}
+
+// gpuProcesses summarizes the processes on one GPU for its chart title
+type gpuProcesses struct {
+	count int
+	top   ProcessInfo // largest VRAM user
+}
+
+func summarizeProcesses(processes []ProcessInfo) map[int]gpuProcesses {
+	perGPU := make(map[int]gpuProcesses)
+	for _, p := range processes {
+		g := perGPU[p.GPU]
+		if g.count == 0 || p.VRAMBytes > g.top.VRAMBytes {
+			g.top = p
+		}
+		g.count++
+		perGPU[p.GPU] = g
+	}
+	return perGPU
+}
+
+func (g gpuProcesses) String() string {
+	if g.count == 0 {
+		return "idle"
+	}
+	procs := "procs"
+	if g.count == 1 {
+		procs = "proc"
+	}
+	return fmt.Sprintf("%d %s, top: %s %.1f GB", g.count, procs, g.top.Name, float64(g.top.VRAMBytes)/(1<<30))
+}
+
+// fitTitle cuts a title that would run past the border of a chart width
+// columns wide; the end goes first, so put the least important parts last
+func fitTitle(title string, width int) string {
+	// The title starts two columns in and needs a border column after it
+	if room := width - 3; room > 0 && runewidth.StringWidth(title) > room {
+		return runewidth.Truncate(title, room, "…")
+	}
+	return title
+}
+
+// idleColor dims the charts of idle GPUs
+const idleColor = ui.Color(240)
+
+// updateIdle tracks whether a GPU has been idle for the configured window:
+// utilization under the threshold and none of the processes on it.
+// processes is the GPU's process count, or -1 when it isn't known. Any
+// activity ends idleness at once.
+func (h *hostView) updateIdle(m GPUMetrics, processes int, t time.Time, c IdleConfig) bool {
+	if c.Window <= 0 || m.GFXUtil >= c.Util || processes > 0 {
+		delete(h.idleSince, m.ID)
+		h.idle[m.ID] = false
+		return false
+	}
+	since, ok := h.idleSince[m.ID]
+	if !ok {
+		since = t
+		h.idleSince[m.ID] = t
+	}
+	h.idle[m.ID] = t.Sub(since) >= c.Window
+	return h.idle[m.ID]
+}
+
+// chartStyle is the title and border style of a chart: colored when the GPU
+// or HBM temperature crosses the GPU's thresholds, otherwise dimmed while
+// the GPU is idle
+func chartStyle(m GPUMetrics, t ThresholdsConfig, idle bool) (title, border ui.Style) {
+	title, border = tempStyle(m, t), ui.NewStyle(ui.ColorWhite)
+	if idle && title == ui.NewStyle(ui.ColorWhite) {
+		title, border = ui.NewStyle(idleColor), ui.NewStyle(idleColor)
+	}
+	return title, border
+}
+
+func tempStyle(m GPUMetrics, t ThresholdsConfig) ui.Style {
+	state := grade(m.GPUTemp, t.GPUTempWarn, t.GPUTempCrit)
+	if m.hasMemTemp() {
+		state = max(state, grade(m.MemTemp, t.MemTempWarn, t.MemTempCrit))
+	}
+	switch state {
+	case checkCritical:
+		return ui.NewStyle(ui.ColorRed, ui.ColorClear, ui.ModifierBold)
+	case checkWarning:
+		return ui.NewStyle(ui.ColorYellow)
+	}
+	return ui.NewStyle(ui.ColorWhite)
+}
+
+// setReachable greys out the host's charts while it cannot be sampled
+func (h *hostView) setReachable(reachable bool) {
+	h.reachable = reachable
+	debugLog.Info("host reachability changed", "host", h.name, "reachable", reachable)
+	style := ui.NewStyle(ui.ColorWhite)
+	if !reachable {
+		style = unreachableStyle
+	}
+	for _, chart := range h.charts {
+		chart.BorderStyle = style
+		chart.TitleStyle = style
+		if !reachable && !strings.HasSuffix(chart.Title, " (unreachable)") {
+			chart.Title += " (unreachable)"
+		}
+	}
+}
+
+type hostSample struct {
+	host   *hostView
+	sample Sample
+	err    error
+	static map[int]StaticInfo // set when amd-smi static was queried again
+}
+
+// runHostSampler collects from one host every interval until stop is closed.
+// Hosts are sampled independently so an unreachable one can't hold up the rest.
+func runHostSampler(h *hostView, interval time.Duration, out chan<- hostSample, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	static := newStaticCache(h.name, h.runner)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			sample, err := h.backend.collect(h.runner)
+			sample.Host = h.name
+			for i := range sample.Processes {
+				sample.Processes[i].Host = h.name
+			}
+			// Parents are read from this machine's /proc
+			if h.name == "" {
+				groupProcesses(sample.Processes)
+			}
+			hs := hostSample{host: h, err: err}
+			if err == nil {
+				hs.static = static.update(sample, h.refreshStatic.Swap(false))
+				static.label(&sample)
+				h.aliases.label(&sample)
+			}
+			hs.sample = sample
+			select {
+			case out <- hs:
+			case <-stop:
+				return
+			}
+		}
+	}
+}
+
+// hostSummary aggregates the latest samples of all reachable hosts
+func hostSummary(hosts []*hostView) string {
+	var up, gpus int
+	var util, power, vramUsed, vramTotal float64
+	for _, h := range hosts {
+		if !h.reachable {
+			continue
+		}
+		up++
+		for _, m := range h.last.GPUs {
+			gpus++
+			util += m.GFXUtil
+			power += m.Power
+			vramUsed += m.VRAMUsed
+			vramTotal += m.VRAMTotal
+		}
+	}
+	avg := 0.0
+	if gpus > 0 {
+		avg = util / float64(gpus)
+	}
+	return fmt.Sprintf("hosts %d/%d up │ %d GPUs │ avg util %.1f%% │ %.0f W │ VRAM %.1f/%.1f GB",
+		up, len(hosts), gpus, avg, power, vramUsed/1024, vramTotal/1024)
+}
+
+// hostWarnings joins the warnings of the hosts for the footer, so one
+// host's sample doesn't hide another's
+func hostWarnings(hosts []*hostView) string {
+	var warnings []string
+	for _, h := range hosts {
+		if h.warning != "" {
+			warnings = append(warnings, h.warning)
+		}
+	}
+	return strings.Join(warnings, "; ")
+}
+
+// gttPressure sums the GTT the hosts' processes hold against the GTT size
+// of their GPUs, for the footer. GPUs whose GTT size is unknown don't
+// count; with none known it returns "". With colors, it is marked once it
+// crosses the GTT thresholds.
+func gttPressure(hosts []*hostView, t ThresholdsConfig, colors bool) string {
+	var used, total uint64
+	for _, h := range hosts {
+		for _, s := range h.static {
+			total += s.GTTTotal
+		}
+		for _, p := range h.processes {
+			if h.static[p.GPU].GTTTotal > 0 {
+				used += p.GTTBytes
+			}
+		}
+	}
+	if total == 0 {
+		return ""
+	}
+	pct := float64(used) / float64(total) * 100
+	text := fmt.Sprintf("GTT %.1f/%.1f GB (%.0f%%)", mib(used)/1024, mib(total)/1024, pct)
+	if colors && grade(pct, t.GTTWarn, t.GTTCrit) != checkOK {
+		text = "[" + text + gradeStyle(pct, t.GTTWarn, t.GTTCrit)
+	}
+	return text
+}
+
+// markAlerts puts the rules firing on each GPU in front of its chart title
+// and colors the chart by the worst of their severities. Quiet mode only
+// marks critical rules.
+func (h *hostView) markAlerts(a *Alerter, gpus []GPUMetrics, criticalOnly bool) {
+	for _, m := range gpus {
+		i, ok := h.slot(m.ID)
+		alerts := a.firing(h.name, m.ID)
+		if criticalOnly {
+			alerts = slices.DeleteFunc(alerts, func(f firingAlert) bool { return f.rule.Severity != "critical" })
+		}
+		if !ok || len(alerts) == 0 {
+			continue
+		}
+		names := make([]string, len(alerts))
+		critical := false
+		for j, f := range alerts {
+			names[j] = fmt.Sprintf("%s %.1f", f.rule.Name, f.value)
+			critical = critical || f.rule.Severity == "critical"
+		}
+		style := ui.NewStyle(ui.ColorYellow)
+		if critical {
+			style = ui.NewStyle(ui.ColorRed, ui.ColorClear, ui.ModifierBold)
+		}
+		h.charts[i].Title = fitTitle("ALERT "+strings.Join(names, ", ")+" │ "+h.charts[i].Title, h.charts[i].Dx())
+		h.charts[i].TitleStyle, h.charts[i].BorderStyle = style, style
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHostWarnings(t *testing.T) {
+	a, b := newHostView("a", nil), newHostView("b", nil)
+	hosts := []*hostView{a, b}
+	if got := hostWarnings(hosts); got != "" {
+		t.Errorf("no warnings = %q, want none", got)
+	}
+	a.warning = "connection to a lost, reconnecting…"
+	b.warning = "b: database is falling behind, dropping samples"
+	want := "connection to a lost, reconnecting…; b: database is falling behind, dropping samples"
+	if got := hostWarnings(hosts); got != want {
+		t.Errorf("warnings = %q, want %q", got, want)
+	}
+	// A good sample from b leaves a's warning alone
+	b.warning = ""
+	if got, want := hostWarnings(hosts), "connection to a lost, reconnecting…"; got != want {
+		t.Errorf("warnings = %q, want %q", got, want)
+	}
+}
+
+// wedgedRunner is amd-smi on a wedged GPU: every command hangs until
+// released, then fails
+type wedgedRunner struct {
+	release chan struct{}
+	started atomic.Int32
+}
+
+func (r *wedgedRunner) Start(name string, args ...string) (io.ReadCloser, error) {
+	r.started.Add(1)
+	<-r.release
+	return nil, errors.New("amd-smi timed out")
+}
+
+// TestSlowProviderKeepsUILive runs a sampler against a hung amd-smi beside
+// a loop shaped like main's: key presses are handled at once while the
+// sampler is stuck, quitting doesn't wait for it, and it ends once released
+func TestSlowProviderKeepsUILive(t *testing.T) {
+	r := &wedgedRunner{release: make(chan struct{})}
+	h := newHostView("", r)
+	h.backend = &backendSelector{candidates: []metricsBackend{{name: "amd-smi", collect: collectSample}}}
+	hostSamples := make(chan hostSample)
+	stop := make(chan struct{})
+	sampler := make(chan struct{})
+	go func() {
+		runHostSampler(h, time.Millisecond, hostSamples, stop)
+		close(sampler)
+	}()
+
+	keys, handled := make(chan string), make(chan string)
+	loop := make(chan struct{})
+	go func() {
+		defer close(loop)
+		for {
+			select {
+			case key := <-keys:
+				handled <- key
+				if key == "q" {
+					return
+				}
+			case hs := <-hostSamples:
+				t.Errorf("sample from a hung amd-smi: %+v", hs)
+			}
+		}
+	}()
+	for r.started.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i, key := range []string{"j", "j", "k", "<Resize>", "s", "q"} {
+		select {
+		case keys <- key:
+		case <-time.After(time.Second):
+			t.Fatalf("key %d (%s) not taken while amd-smi hangs", i, key)
+		}
+		if got := <-handled; got != key {
+			t.Fatalf("handled %q, want %q", got, key)
+		}
+	}
+	<-loop
+	if r.started.Load() != 1 {
+		t.Errorf("amd-smi started %d times, want the one call still hanging", r.started.Load())
+	}
+
+	close(stop)
+	close(r.release)
+	select {
+	case <-sampler:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sampler still running after amd-smi returned")
+	}
+}
+
+// TestGappyGPUIDs routes samples of GPUs 0, 2 and 5, then a late GPU 3 and
+// a sample without GPU 2, the way main does: each chart's history holds
+// its own GPU's values only
+func TestGappyGPUIDs(t *testing.T) {
+	present := map[int][]int{ // sample -> GPU IDs reported
+		0: {0, 2, 5}, 1: {0, 2, 5}, 2: {0, 2, 5}, 3: {0, 2, 3, 5}, 4: {0, 3, 5}, 5: {0, 2, 3, 5},
+	}
+	h := newHostView("", nil)
+	want := map[int][]float64{}
+	for n := range len(present) {
+		// GFX activity is 10 times the ID plus the sample number
+		out := monitorHeader
+		for _, id := range present[n] {
+			out += fmt.Sprintf("%d,300,65,70,%d,1700,35,1600,0,0,0,0,0,N/A,24560,1000,65520\n", id, 10*id+n)
+			want[id] = append(want[id], float64(10*id+n))
+		}
+		gpus := parseGPUMetrics(strings.NewReader(out))
+		h.addGPUs(gpuIDs(gpus), 10, 80, false)
+		for _, m := range gpus {
+			i, ok := h.slot(m.ID)
+			if !ok {
+				t.Fatalf("sample %d: no chart for GPU %d", n, m.ID)
+			}
+			h.record(i, t0.Add(time.Duration(n)*time.Second), m)
+			h.redraw(i, 0)
+		}
+	}
+	if !slices.Equal(h.ids, []int{0, 2, 3, 5}) {
+		t.Fatalf("charts for GPUs %v, want [0 2 3 5]", h.ids)
+	}
+	for _, id := range h.ids {
+		i, _ := h.slot(id)
+		if title := h.charts[i].Title; title != fmt.Sprintf("GPU %d", id) {
+			t.Errorf("chart %d is titled %q, want GPU %d", i, title, id)
+		}
+		history := h.histories[i][0]
+		var got []float64
+		for j := range history.count {
+			got = append(got, history.at(j))
+		}
+		if !slices.Equal(got, want[id]) {
+			t.Errorf("GPU %d history %v, want %v", id, got, want[id])
+		}
+		data := h.charts[i].Sparklines[0].Data
+		if filled := data[len(data)-len(want[id]):]; !slices.Equal(filled, want[id]) {
+			t.Errorf("GPU %d chart ends %v, want %v", id, filled, want[id])
+		}
+	}
+}
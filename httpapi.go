@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A snapshot older than this makes /api/health report the sampler as stale
+const apiStaleAfter = 10 * time.Second
+
+// APIServer serves the latest sample and per-GPU history as JSON. It is fed
+// as a Sink, so all handlers read from its own mutex-guarded snapshot; the
+// WebSocket stream subscribes to the shared hub. With several --remote
+// hosts, GPU ids repeat, so everything is kept per host.
+type APIServer struct {
+	server     *http.Server
+	host       string
+	historyLen int
+	hub        *broadcaster
+
+	mu        sync.RWMutex
+	latest    map[string]Sample
+	histories map[apiGPU]*GPUHistory
+}
+
+// apiGPU identifies a GPU across hosts
+type apiGPU struct {
+	host string
+	id   int
+}
+
+// apiGPUMetrics is a GPU in /api/gpus, with the host it is on
+type apiGPUMetrics struct {
+	Host string `json:"host"`
+	GPUMetrics
+}
+
+type historyPoint struct {
+	Time    time.Time `json:"timestamp"`
+	GFXUtil float64   `json:"gfx_util"`
+}
+
+func newAPIServer(addr string, historyLen int, hub *broadcaster) (*APIServer, error) {
+	if historyLen < 1 {
+		return nil, fmt.Errorf("--http-history must be at least 1")
+	}
+	// Listen up front so a busy port is reported at startup
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start HTTP API: %v", err)
+	}
+	s := &APIServer{
+		host:       hostname(),
+		historyLen: historyLen,
+		hub:        hub,
+		latest:     make(map[string]Sample),
+		histories:  make(map[apiGPU]*GPUHistory),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handleIndex)
+	mux.HandleFunc("/ws", s.handleWebSocket)
+	mux.HandleFunc("/api/gpus", s.handleGPUs)
+	mux.HandleFunc("/api/processes", s.handleProcesses)
+	mux.HandleFunc("/api/history/", s.handleHistory)
+	mux.HandleFunc("/api/health", s.handleHealth)
+	s.server = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go s.server.Serve(ln)
+	return s, nil
+}
+
+func (s *APIServer) Write(sample Sample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	host := sampleHost(sample, s.host)
+	s.latest[host] = sample
+	for _, m := range sample.GPUs {
+		key := apiGPU{host, m.ID}
+		h, ok := s.histories[key]
+		if !ok {
+			h = newGPUHistory(s.historyLen)
+			s.histories[key] = h
+		}
+		h.add(sample.Time, m.GFXUtil)
+	}
+	return nil
+}
+
+// Close stops accepting connections and waits briefly for in-flight requests
+func (s *APIServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// hosts lists the hosts samples came from, in order
+func (s *APIServer) hosts() []string {
+	hosts := make([]string, 0, len(s.latest))
+	for host := range s.latest {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+func (s *APIServer) handleGPUs(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	gpus := []apiGPUMetrics{}
+	for _, host := range s.hosts() {
+		for _, m := range s.latest[host].GPUs {
+			gpus = append(gpus, apiGPUMetrics{Host: host, GPUMetrics: m})
+		}
+	}
+	writeJSON(w, http.StatusOK, gpus)
+}
+
+func (s *APIServer) handleProcesses(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	processes := []ProcessInfo{}
+	for _, host := range s.hosts() {
+		for _, p := range s.latest[host].Processes {
+			p.Host = host
+			processes = append(processes, p)
+		}
+	}
+	writeJSON(w, http.StatusOK, processes)
+}
+
+// handleHistory serves /api/history/<id>, which needs ?host= when more
+// than one host has a GPU with that id
+func (s *APIServer) handleHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/history/"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid GPU id"})
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	host := r.URL.Query().Get("host")
+	if host == "" {
+		var found []string
+		for _, h := range s.hosts() {
+			if _, ok := s.histories[apiGPU{h, id}]; ok {
+				found = append(found, h)
+			}
+		}
+		if len(found) > 1 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("GPU %d is on several hosts, pick one with ?host= (%s)", id, strings.Join(found, ", ")),
+			})
+			return
+		}
+		if len(found) == 1 {
+			host = found[0]
+		}
+	}
+	h, ok := s.histories[apiGPU{host, id}]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("no history for GPU %d", id)})
+		return
+	}
+	values, times := h.getData(), h.getTimes()
+	points := make([]historyPoint, 0, len(values))
+	for i, v := range values {
+		points = append(points, historyPoint{Time: zoned(times[i]), GFXUtil: v})
+	}
+	writeJSON(w, http.StatusOK, points)
+}
+
+func (s *APIServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	var last time.Time
+	for _, sample := range s.latest {
+		if sample.Time.After(last) {
+			last = sample.Time
+		}
+	}
+	s.mu.RUnlock()
+	status, code := "ok", http.StatusOK
+	if last.IsZero() || time.Since(last) > apiStaleAfter {
+		status, code = "stale", http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]interface{}{
+		"status":      status,
+		"last_sample": last,
+		"version":     Version,
+	})
+}
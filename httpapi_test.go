@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func getJSON(t *testing.T, handler http.HandlerFunc, url string, v interface{}) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("%s: %v: %s", url, err, rec.Body)
+	}
+	return rec.Code
+}
+
+// TestAPIHosts keeps GPU 0 of two hosts apart in every endpoint
+func TestAPIHosts(t *testing.T) {
+	s, err := newAPIServer("127.0.0.1:0", 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, sample := range []Sample{
+		{Host: "node-a", Time: t0, GPUs: []GPUMetrics{{GPUDevice: GPUDevice{ID: 0}, GFXUtil: 10}},
+			Processes: []ProcessInfo{{GPU: 0, Name: "a", Pid: 1}}},
+		{Host: "node-b", Time: t0, GPUs: []GPUMetrics{{GPUDevice: GPUDevice{ID: 0}, GFXUtil: 90}, {GPUDevice: GPUDevice{ID: 1}, GFXUtil: 50}},
+			Processes: []ProcessInfo{{GPU: 1, Name: "b", Pid: 2}}},
+	} {
+		s.Write(sample)
+	}
+
+	var gpus []struct {
+		Host    string  `json:"host"`
+		ID      int     `json:"id"`
+		GFXUtil float64 `json:"gfx_util"`
+	}
+	getJSON(t, s.handleGPUs, "/api/gpus", &gpus)
+	if len(gpus) != 3 || gpus[0].Host != "node-a" || gpus[0].GFXUtil != 10 ||
+		gpus[1].Host != "node-b" || gpus[1].ID != 0 || gpus[1].GFXUtil != 90 || gpus[2].ID != 1 {
+		t.Errorf("/api/gpus = %+v", gpus)
+	}
+
+	var processes []ProcessInfo
+	getJSON(t, s.handleProcesses, "/api/processes", &processes)
+	if len(processes) != 2 || processes[0].Host != "node-a" || processes[1].Host != "node-b" {
+		t.Errorf("/api/processes = %+v", processes)
+	}
+
+	for _, tc := range []struct {
+		url  string
+		code int
+		util float64
+	}{
+		{"/api/history/0", http.StatusBadRequest, 0},
+		{"/api/history/0?host=node-a", http.StatusOK, 10},
+		{"/api/history/0?host=node-b", http.StatusOK, 90},
+		{"/api/history/1", http.StatusOK, 50},
+		{"/api/history/1?host=node-a", http.StatusNotFound, 0},
+	} {
+		var body json.RawMessage
+		code := getJSON(t, s.handleHistory, tc.url, &body)
+		if code != tc.code {
+			t.Errorf("%s: status %d, want %d: %s", tc.url, code, tc.code, body)
+			continue
+		}
+		var points []historyPoint
+		if code == http.StatusOK && (json.Unmarshal(body, &points) != nil || len(points) != 1 || points[0].GFXUtil != tc.util) {
+			t.Errorf("%s = %s, want one point at %v", tc.url, body, tc.util)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	influxBatchLines    = 5000
+	influxFlushInterval = 10 * time.Second
+	influxMaxPending    = 100000
+	influxRetries       = 3
+)
+
+// influxBackoff is the wait before the first retry of a batch, doubled
+// for each one after
+var influxBackoff = time.Second
+
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// influxLines renders a sample in InfluxDB line protocol with second precision
+func influxLines(host string, s Sample) []string {
+	ts := s.Time.Unix()
+	host = influxTagEscaper.Replace(host)
+	lines := make([]string, 0, len(s.GPUs)+len(s.Processes))
+	for _, m := range s.GPUs {
+		lines = append(lines, fmt.Sprintf(
+			"gpu,host=%s,gpu=%d%s gfx_util=%s,power=%s,gpu_temp=%s,mem_temp=%s,gfx_clock=%s,mem_util=%s,mem_clock=%s,vram_used=%s,vram_total=%s %d",
+			host, m.ID, influxDeviceTags(m),
+			formatFloat(m.GFXUtil), formatFloat(m.Power), formatFloat(m.GPUTemp), formatFloat(m.MemTemp),
+			formatFloat(m.GFXClock), formatFloat(m.MemUtil), formatFloat(m.MemClock),
+			formatFloat(m.VRAMUsed), formatFloat(m.VRAMTotal), ts))
+	}
+	for _, p := range s.Processes {
+		// pid stays a string field so existing buckets keep their schema
+		lines = append(lines, fmt.Sprintf(
+			"gpu_process,host=%s,gpu=%d%s,name=%s pid=\"%d\",gfx_usage=%s,vram_mb=%s,gtt_mb=%s,cpu_mb=%s,total_mb=%s %d",
+			host, p.GPU, influxBDFTag(p.BDF)+influxAliasTag(p.GPUAlias), influxTagEscaper.Replace(p.Name), p.Pid,
+			formatFloat(p.UsagePercent), formatFloat(mib(p.VRAMBytes)), formatFloat(mib(p.GTTBytes)),
+			formatFloat(mib(p.CPUBytes)), formatFloat(mib(p.TotalBytes)), ts))
+	}
+	return lines
+}
+
+// influxBDFTag is the bus address tag, left out when it isn't known
+func influxBDFTag(bdf string) string {
+	if bdf == "" {
+		return ""
+	}
+	return ",bdf=" + influxTagEscaper.Replace(bdf)
+}
+
+// influxAliasTag is the GPU's alias tag, left out when it has none
+func influxAliasTag(alias string) string {
+	if alias == "" {
+		return ""
+	}
+	return ",alias=" + influxTagEscaper.Replace(alias)
+}
+
+// influxDeviceTags identifies the device beyond its index, with the tags
+// that are known
+func influxDeviceTags(m GPUMetrics) string {
+	tags := influxBDFTag(m.BDF)
+	if m.UUID != "" {
+		tags += ",uuid=" + influxTagEscaper.Replace(m.UUID)
+	}
+	if m.Serial != "" {
+		tags += ",serial=" + influxTagEscaper.Replace(m.Serial)
+	}
+	return tags + influxAliasTag(m.Alias)
+}
+
+// InfluxWriterSink writes line protocol to a local writer (a file or stdout)
+type InfluxWriterSink struct {
+	host string
+	w    io.WriteCloser
+}
+
+func newInfluxWriterSink(path string) (*InfluxWriterSink, error) {
+	if path == "-" {
+		return &InfluxWriterSink{host: hostname(), w: os.Stdout}, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open influx output: %v", err)
+	}
+	return &InfluxWriterSink{host: hostname(), w: f}, nil
+}
+
+func (s *InfluxWriterSink) Write(sample Sample) error {
+	lines := influxLines(sampleHost(sample, s.host), sample)
+	if len(lines) == 0 {
+		return nil
+	}
+	if _, err := io.WriteString(s.w, strings.Join(lines, "\n")+"\n"); err != nil {
+		return fmt.Errorf("influx output write failed: %v", err)
+	}
+	return nil
+}
+
+func (s *InfluxWriterSink) Close() error {
+	if s.w == os.Stdout {
+		return nil
+	}
+	return s.w.Close()
+}
+
+// InfluxHTTPSink batches line protocol and POSTs it to an InfluxDB v2 write
+// endpoint from a background goroutine, so a slow or flaky server never
+// stalls the sampler. Lines that cannot be queued are dropped.
+type InfluxHTTPSink struct {
+	host     string
+	writeURL string
+	token    string
+	client   *http.Client
+	queue    chan []string
+	done     chan struct{}
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+func newInfluxHTTPSink(baseURL, token, org, bucket string) (*InfluxHTTPSink, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("--influx-bucket is required with --influx-url")
+	}
+	u, err := url.Parse(strings.TrimRight(baseURL, "/") + "/api/v2/write")
+	if err != nil {
+		return nil, fmt.Errorf("invalid influx url: %v", err)
+	}
+	q := u.Query()
+	q.Set("bucket", bucket)
+	q.Set("org", org)
+	q.Set("precision", "s")
+	u.RawQuery = q.Encode()
+	s := &InfluxHTTPSink{
+		host:     hostname(),
+		writeURL: u.String(),
+		token:    token,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan []string, 64),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *InfluxHTTPSink) Write(sample Sample) error {
+	select {
+	case s.queue <- influxLines(sampleHost(sample, s.host), sample):
+	default:
+		// Sender is backed up; dropping keeps the UI responsive
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
+
+// Close flushes whatever is pending and stops the sender
+func (s *InfluxHTTPSink) Close() error {
+	close(s.queue)
+	<-s.done
+	return nil
+}
+
+func (s *InfluxHTTPSink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(influxFlushInterval)
+	defer ticker.Stop()
+	var pending []string
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		retry, err := s.post(pending)
+		switch {
+		case err == nil:
+			pending = pending[:0]
+		case !retry:
+			// The server rejected the batch, e.g. a malformed line or a
+			// bad token: sending it again would fail the same way and
+			// hold up every point behind it
+			debugLog.Warn("influx rejected a batch, dropping it", "lines", len(pending), "err", err)
+			pending = pending[:0]
+		case len(pending) > influxMaxPending:
+			// Keep the newest lines while the server is unreachable
+			pending = append(pending[:0], pending[len(pending)-influxMaxPending:]...)
+		}
+		s.mu.Lock()
+		s.lastErr = err
+		s.mu.Unlock()
+	}
+	for {
+		select {
+		case lines, ok := <-s.queue:
+			if !ok {
+				flush()
+				return
+			}
+			pending = append(pending, lines...)
+			if len(pending) >= influxBatchLines {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// post sends one batch, retrying network errors, 429 and 5xx with backoff.
+// It reports whether a failed batch is worth sending again later.
+func (s *InfluxHTTPSink) post(lines []string) (bool, error) {
+	body := []byte(strings.Join(lines, "\n"))
+	backoff := influxBackoff
+	var retry bool
+	var err error
+	for attempt := 0; attempt < influxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		retry, err = s.postOnce(body)
+		if err == nil || !retry {
+			return retry, err
+		}
+	}
+	return retry, err
+}
+
+func (s *InfluxHTTPSink) postOnce(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, s.writeURL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("influx write failed: %v", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("influx write failed: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("influx write failed: %s", resp.Status)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// influxServer answers writes with the given statuses in turn, then 204,
+// and keeps the bodies it was sent
+type influxServer struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   []string
+}
+
+func (s *influxServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bodies = append(s.bodies, string(body))
+	status := http.StatusNoContent
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+// TestInfluxRejectedBatch sends a batch big enough to flush at once, then
+// a small one that Close flushes. A rejected batch is dropped; one that
+// failed for a reason that may pass is sent again with the next.
+func TestInfluxRejectedBatch(t *testing.T) {
+	influxBackoff = time.Millisecond
+	t.Cleanup(func() { influxBackoff = time.Second })
+	big := Sample{Host: "node1", Time: t0}
+	for pid := range influxBatchLines {
+		big.Processes = append(big.Processes, ProcessInfo{Name: "worker", Pid: pid + 1})
+	}
+	small := Sample{Host: "node1", Time: t0.Add(time.Second), GPUs: []GPUMetrics{{GPUDevice: GPUDevice{ID: 7}}}}
+
+	for _, tc := range []struct {
+		name     string
+		statuses []int
+		resent   bool
+	}{
+		{"bad request", []int{http.StatusBadRequest}, false},
+		{"unauthorized", []int{http.StatusUnauthorized}, false},
+		{"not found", []int{http.StatusNotFound}, false},
+		{"unavailable", []int{503, 503, 503}, true},
+		{"too many requests", []int{429, 429, 429}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := &influxServer{statuses: tc.statuses}
+			ts := httptest.NewServer(srv)
+			defer ts.Close()
+			s, err := newInfluxHTTPSink(ts.URL, "", "org", "bucket")
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.Write(big)
+			// Wait for the first batch to fail for good
+			for {
+				s.mu.Lock()
+				failed := s.lastErr != nil
+				s.mu.Unlock()
+				if failed {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			s.Write(small)
+			s.Close()
+
+			srv.mu.Lock()
+			defer srv.mu.Unlock()
+			last := srv.bodies[len(srv.bodies)-1]
+			want := 1
+			if tc.resent {
+				want += influxBatchLines
+			}
+			if got := strings.Count(last, "\n") + 1; got != want {
+				t.Errorf("last write has %d lines, want %d", got, want)
+			}
+			if !strings.HasSuffix(last, "gpu,host=node1,gpu=7 gfx_util=0,power=0,gpu_temp=0,mem_temp=0,gfx_clock=0,mem_util=0,mem_clock=0,vram_used=0,vram_total=0 1704067201") {
+				t.Errorf("last write ends %q, want the new point", last[max(len(last)-80, 0):])
+			}
+		})
+	}
+}
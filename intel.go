@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// intelDevice is what `xpu-smi discovery` says about a GPU; it doesn't
+// change, so it is asked once per host
+type intelDevice struct {
+	id        int
+	bdf       string
+	name      string
+	uuid      string
+	vramTotal float64 // MB
+}
+
+// intelDevices caches the discovered GPUs by runner
+var intelDevices sync.Map
+
+// intelDumpMetrics are the `xpu-smi dump` metric IDs read: utilization,
+// power, frequency, core and memory temperature, memory bandwidth
+// utilization and memory used
+const intelDumpMetrics = "0,1,2,3,4,17,18"
+
+// intelSysfsQuery prints the frequency and temperatures the i915 and xe
+// drivers expose for each Intel display device, in the format of
+// sysfsQuery. Utilization and power need xpu-smi.
+const intelSysfsQuery = `for d in /sys/bus/pci/devices/*; do
+  [ "$(cat "$d/vendor" 2>/dev/null)" = 0x8086 ] && [ -d "$d/drm" ] || continue
+  echo "gpu ${d##*/}"
+  for f in "$d"/drm/card*/gt_act_freq_mhz "$d"/tile0/gt0/freq0/act_freq "$d"/hwmon/hwmon*/temp*_input "$d"/hwmon/hwmon*/temp*_label; do
+    if [ -r "$f" ]; then echo "${f##*/} $(cat "$f")"; fi
+  done
+done`
+
+// intelFdinfoQuery lists the DRM clients of every process from fdinfo,
+// for per-process memory; the kernel prints the same keys for i915 and xe
+const intelFdinfoQuery = `grep -H -E '^(drm-driver|drm-pdev|drm-client-id|drm-resident-(local|vram)0):' /proc/[0-9]*/fdinfo/* 2>/dev/null; true`
+
+// addIntel appends the host's Intel GPUs, read with xpu-smi, or from sysfs
+// when it isn't installed, and their processes from fdinfo as far as the
+// drivers report them. Without either, the error wraps exec.ErrNotFound.
+func addIntel(r commandRunner, s *Sample, base int) error {
+	gpus, err := intelXPU(r, base)
+	if errors.Is(err, exec.ErrNotFound) {
+		gpus, err = intelSysfs(r, base)
+	}
+	if err != nil {
+		return err
+	}
+	s.GPUs = append(s.GPUs, gpus...)
+	if !collectProcesses.Load() || s.ProcessErr != nil {
+		return nil
+	}
+	out, err := runCommand(r, "sh", "-c", intelFdinfoQuery)
+	if err != nil {
+		return fmt.Errorf("failed to read fdinfo: %w", err)
+	}
+	processes := parseIntelFdinfo(out, gpus)
+	nameProcesses(r, processes)
+	s.Processes = append(s.Processes, processes...)
+	return nil
+}
+
+// intelXPU reads the GPUs with xpu-smi
+func intelXPU(r commandRunner, base int) ([]GPUMetrics, error) {
+	devices, err := discoverIntel(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return nil, errors.New("xpu-smi lists no GPU")
+	}
+	ids := make([]string, len(devices))
+	for i, d := range devices {
+		ids[i] = strconv.Itoa(d.id)
+	}
+	out, err := runCommand(r, "xpu-smi", "dump", "-d", strings.Join(ids, ","), "-m", intelDumpMetrics, "-n", "1")
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute xpu-smi dump: %w", err)
+	}
+	return parseIntelDump(out, devices, base), nil
+}
+
+func discoverIntel(r commandRunner) ([]intelDevice, error) {
+	if devices, ok := intelDevices.Load(r); ok {
+		return devices.([]intelDevice), nil
+	}
+	out, err := runCommand(r, "xpu-smi", "discovery", "-j")
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute xpu-smi discovery: %w", err)
+	}
+	var list struct {
+		Devices []struct {
+			ID   int    `json:"device_id"`
+			Name string `json:"device_name"`
+			BDF  string `json:"pci_bdf_address"`
+			UUID string `json:"uuid"`
+		} `json:"device_list"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse xpu-smi discovery output: %v", err)
+	}
+	devices := make([]intelDevice, 0, len(list.Devices))
+	for _, d := range list.Devices {
+		device := intelDevice{id: d.ID, bdf: strings.ToLower(d.BDF), name: d.Name, uuid: d.UUID}
+		// The memory size is only in a device's own discovery
+		if out, err := runCommand(r, "xpu-smi", "discovery", "-d", strconv.Itoa(d.ID), "-j"); err == nil {
+			var detail struct {
+				Memory string `json:"memory_physical_size_byte"`
+			}
+			if json.Unmarshal(out, &detail) == nil {
+				b, _ := strconv.ParseFloat(detail.Memory, 64)
+				device.vramTotal = b / 1024 / 1024
+			}
+		} else {
+			debugLog.Debug("Intel GPU memory size unavailable", "device", d.ID, "err", err)
+		}
+		devices = append(devices, device)
+	}
+	intelDevices.Store(r, devices)
+	return devices, nil
+}
+
+// parseIntelDump reads `xpu-smi dump` CSV by its header names, e.g.
+// "Timestamp, DeviceId, GPU Utilization (%), GPU Power (W), ...". Values
+// a device doesn't report are N/A and leave the row partial.
+func parseIntelDump(out []byte, devices []intelDevice, base int) []GPUMetrics {
+	records := nvidiaRecords(out)
+	if len(records) < 2 {
+		return nil
+	}
+	columns := map[string]func(*GPUMetrics) *float64{
+		"GPU Utilization (%)":                     func(m *GPUMetrics) *float64 { return &m.GFXUtil },
+		"GPU Power (W)":                           func(m *GPUMetrics) *float64 { return &m.Power },
+		"GPU Frequency (MHz)":                     func(m *GPUMetrics) *float64 { return &m.GFXClock },
+		"GPU Core Temperature (Celsius Degree)":   func(m *GPUMetrics) *float64 { return &m.GPUTemp },
+		"GPU Memory Temperature (Celsius Degree)": func(m *GPUMetrics) *float64 { return &m.MemTemp },
+		"GPU Memory Bandwidth Utilization (%)":    func(m *GPUMetrics) *float64 { return &m.MemUtil },
+		"GPU Memory Used (MiB)":                   func(m *GPUMetrics) *float64 { return &m.VRAMUsed },
+	}
+	caps := map[string]gpuCaps{
+		"GPU Power (W)": capPower,
+		"GPU Memory Temperature (Celsius Degree)": capMemTemp,
+		"GPU Memory Bandwidth Utilization (%)":    capMemBusy,
+	}
+	header := records[0]
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+	device := slices.Index(header, "DeviceId")
+	if device < 0 {
+		debugLog.Warn("xpu-smi dump: no DeviceId column", "header", header)
+		return nil
+	}
+	var gpus []GPUMetrics
+	for _, record := range records[1:] {
+		if len(record) != len(header) {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(record[device]))
+		if err != nil {
+			continue
+		}
+		i := slices.IndexFunc(devices, func(d intelDevice) bool { return d.id == id })
+		if i < 0 {
+			continue
+		}
+		d := devices[i]
+		m := GPUMetrics{GPUDevice: GPUDevice{ID: base + i, Vendor: vendorIntel, BDF: d.bdf, UUID: d.uuid, ASIC: d.name}, VRAMTotal: d.vramTotal}
+		for col, name := range header {
+			field, ok := columns[name]
+			if !ok {
+				continue
+			}
+			v, ok := nvidiaValue(record[col])
+			// Only HBM parts have a memory sensor
+			if !ok && name != "GPU Memory Temperature (Celsius Degree)" {
+				m.Partial = true
+			}
+			if ok {
+				m.Caps |= caps[name]
+			}
+			*field(&m) = v
+		}
+		gpus = append(gpus, m)
+	}
+	return gpus
+}
+
+// intelSysfs reads what the drivers expose without xpu-smi. The rows are
+// always partial; no Intel GPU at all counts as the tools being missing.
+func intelSysfs(r commandRunner, base int) ([]GPUMetrics, error) {
+	out, err := runCommand(r, "sh", "-c", intelSysfsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Intel GPUs from sysfs: %w", err)
+	}
+	gpus := parseIntelSysfs(out, base)
+	if len(gpus) == 0 {
+		return nil, fmt.Errorf("no Intel GPU: %w", exec.ErrNotFound)
+	}
+	return gpus, nil
+}
+
+func parseIntelSysfs(out []byte, base int) []GPUMetrics {
+	var gpus []GPUMetrics
+	labels, temps := map[string]string{}, map[string]float64{}
+	finish := func() {
+		if len(gpus) == 0 {
+			return
+		}
+		m := &gpus[len(gpus)-1]
+		for n, t := range temps {
+			label := strings.ToLower(labels[n])
+			if strings.Contains(label, "vram") || strings.Contains(label, "mem") {
+				m.MemTemp = max(m.MemTemp, t)
+				m.Caps |= capMemTemp
+			} else {
+				m.GPUTemp = max(m.GPUTemp, t)
+			}
+		}
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		name, value, _ := strings.Cut(scanner.Text(), " ")
+		value = strings.TrimSpace(value)
+		if name == "gpu" {
+			finish()
+			gpus = append(gpus, GPUMetrics{GPUDevice: GPUDevice{ID: base + len(gpus), Vendor: vendorIntel, BDF: value}, Partial: true})
+			clear(labels)
+			clear(temps)
+			continue
+		}
+		if len(gpus) == 0 {
+			continue
+		}
+		m := &gpus[len(gpus)-1]
+		v, _ := strconv.ParseFloat(value, 64)
+		switch {
+		case name == "gt_act_freq_mhz" || name == "act_freq":
+			m.GFXClock = v
+		case strings.HasSuffix(name, "_input"):
+			temps[strings.TrimSuffix(name, "_input")] = v / 1000
+		case strings.HasSuffix(name, "_label"):
+			labels[strings.TrimSuffix(name, "_label")] = value
+		}
+	}
+	finish()
+	return gpus
+}
+
+// parseIntelFdinfo sums the local memory of each process's DRM clients on
+// the Intel GPUs, from intelFdinfoQuery lines such as
+// "/proc/123/fdinfo/7:drm-resident-vram0:\t1024 KiB". A client shared by
+// several file descriptors counts once.
+func parseIntelFdinfo(out []byte, gpus []GPUMetrics) []ProcessInfo {
+	type client struct {
+		driver, pdev, id string
+		bytes            uint64
+	}
+	clients := map[string]*client{} // by fdinfo file
+	var order []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		file, rest, ok := strings.Cut(scanner.Text(), ":drm-")
+		if !ok {
+			continue
+		}
+		key, value, _ := strings.Cut(rest, ":")
+		value = strings.TrimSpace(value)
+		c, ok := clients[file]
+		if !ok {
+			c = &client{}
+			clients[file] = c
+			order = append(order, file)
+		}
+		switch key {
+		case "driver":
+			c.driver = value
+		case "pdev":
+			c.pdev = strings.ToLower(value)
+		case "client-id":
+			c.id = value
+		default:
+			c.bytes += parseFdinfoSize(value)
+		}
+	}
+	type procKey struct {
+		pid, gpu int
+	}
+	seen := map[string]bool{}
+	byProc := map[procKey]*ProcessInfo{}
+	var processes []ProcessInfo
+	var keys []procKey
+	for _, file := range order {
+		c := clients[file]
+		if c.driver != "i915" && c.driver != "xe" {
+			continue
+		}
+		i := slices.IndexFunc(gpus, func(m GPUMetrics) bool { return m.BDF == c.pdev })
+		pid, err := strconv.Atoi(strings.Split(strings.TrimPrefix(file, "/proc/"), "/")[0])
+		if i < 0 || err != nil || seen[c.pdev+"/"+c.id] {
+			continue
+		}
+		seen[c.pdev+"/"+c.id] = true
+		key := procKey{pid, gpus[i].ID}
+		p, ok := byProc[key]
+		if !ok {
+			p = &ProcessInfo{GPU: gpus[i].ID, BDF: c.pdev, Pid: pid}
+			byProc[key] = p
+			keys = append(keys, key)
+		}
+		p.VRAMBytes += c.bytes
+		p.TotalBytes += c.bytes
+	}
+	for _, key := range keys {
+		processes = append(processes, *byProc[key])
+	}
+	return processes
+}
+
+// parseFdinfoSize reads a size such as "1024 KiB"
+func parseFdinfoSize(value string) uint64 {
+	num, unit, _ := strings.Cut(value, " ")
+	n, err := strconv.ParseUint(num, 10, 64)
+	if err != nil {
+		return 0
+	}
+	switch unit {
+	case "KiB":
+		return n << 10
+	case "MiB":
+		return n << 20
+	case "GiB":
+		return n << 30
+	}
+	return n
+}
+
+// nameProcesses fills in process names with one ps call
+func nameProcesses(r commandRunner, processes []ProcessInfo) {
+	if len(processes) == 0 {
+		return
+	}
+	pids := make([]string, len(processes))
+	for i, p := range processes {
+		pids[i] = strconv.Itoa(p.Pid)
+	}
+	// ps exits 1 when a process is gone, but lists the rest
+	out, err := runCommand(r, "sh", "-c", "ps -o pid=,comm= -p "+strings.Join(pids, ",")+"; true")
+	if err != nil {
+		debugLog.Debug("process names unavailable", "err", err)
+		return
+	}
+	names := map[int]string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		pid, name, _ := strings.Cut(strings.TrimSpace(line), " ")
+		if n, err := strconv.Atoi(pid); err == nil {
+			names[n] = strings.TrimSpace(name)
+		}
+	}
+	for i := range processes {
+		processes[i].Name = names[processes[i].Pid]
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// keyAction is something a key does. Its name is what the [keys] config
+// section binds keys to.
+type keyAction string
+
+const (
+	actionQuit       keyAction = "quit"
+	actionHelp       keyAction = "help"
+	actionProcesses  keyAction = "processes"
+	actionReset      keyAction = "reset"
+	actionFans       keyAction = "fans"
+	actionInfo       keyAction = "info"
+	actionStatic     keyAction = "static"
+	actionVRAM       keyAction = "vram"
+	actionOverlay    keyAction = "overlay"
+	actionMetric     keyAction = "metric"
+	actionExport     keyAction = "export"
+	actionCopy       keyAction = "copy"
+	actionUp         keyAction = "up"
+	actionDown       keyAction = "down"
+	actionSortPrev   keyAction = "sort_prev"
+	actionSortNext   keyAction = "sort_next"
+	actionReverse    keyAction = "reverse"
+	actionExpand     keyAction = "expand"
+	actionTop        keyAction = "top"
+	actionMinVRAM    keyAction = "min_vram"
+	actionFilter     keyAction = "filter"
+	actionNarrow     keyAction = "narrow"
+	actionWiden      keyAction = "widen"
+	actionQuiet      keyAction = "quiet"
+	actionBench      keyAction = "bench"
+	actionDownsample keyAction = "downsample"
+	actionSmooth     keyAction = "smooth"
+	actionEvents     keyAction = "events"
+	// Sort by one process list column, see sortActions
+	actionSort1 keyAction = "sort_1"
+	actionSort2 keyAction = "sort_2"
+	actionSort3 keyAction = "sort_3"
+	actionSort4 keyAction = "sort_4"
+	actionSort5 keyAction = "sort_5"
+	actionSort6 keyAction = "sort_6"
+	// Replay only; these go before the others while replaying
+	actionPause   keyAction = "pause"
+	actionFaster  keyAction = "faster"
+	actionSlower  keyAction = "slower"
+	actionBack    keyAction = "back"
+	actionForward keyAction = "forward"
+)
+
+// sortActions sort by the process list column of the same index
+var sortActions = []keyAction{actionSort1, actionSort2, actionSort3, actionSort4, actionSort5, actionSort6}
+
+// keyBinding is an action's keys, as termui event IDs, and its help line
+type keyBinding struct {
+	action keyAction
+	keys   []string
+	help   string
+	replay bool
+}
+
+// defaultKeyBindings are the keys mi-top has always used, in help order
+func defaultKeyBindings() []keyBinding {
+	return []keyBinding{
+		{action: actionQuit, keys: []string{"q", "<C-c>"}, help: "quit"},
+		{action: actionHelp, keys: []string{"?"}, help: "show or hide this help"},
+		{action: actionProcesses, keys: []string{"p"}, help: "turn process collection on or off"},
+		{action: actionReset, keys: []string{"r"}, help: "reset session averages and peaks"},
+		{action: actionMetric, keys: []string{"m"}, help: "chart the next metric"},
+		{action: actionOverlay, keys: []string{"O"}, help: "plot all GPUs in one chart"},
+		{action: actionDownsample, keys: []string{"d"}, help: "fit long chart history by max, mean, min-max or LTTB"},
+		{action: actionSmooth, keys: []string{"e"}, help: "smooth the charts and utilization or show raw samples"},
+		{action: actionInfo, keys: []string{"i"}, help: "session peaks panel"},
+		{action: actionFans, keys: []string{"f"}, help: "fan panel"},
+		{action: actionStatic, keys: []string{"s"}, help: "GPU info panel"},
+		{action: actionVRAM, keys: []string{"b"}, help: "VRAM by process panel"},
+		{action: actionEvents, keys: []string{"E"}, help: "event log panel, with the legend of the chart marks"},
+		{action: actionBench, keys: []string{"B"}, help: "open or close a benchmark bracket and report its statistics"},
+		{action: actionQuiet, keys: []string{"z"}, help: "quiet mode: charts and critical alerts only"},
+		{action: actionUp, keys: []string{"<Up>"}, help: "select the previous row"},
+		{action: actionDown, keys: []string{"<Down>"}, help: "select the next row"},
+		{action: actionSortPrev, keys: []string{"<Left>"}, help: "sort by the previous column"},
+		{action: actionSortNext, keys: []string{"<Right>"}, help: "sort by the next column"},
+		{action: actionReverse, keys: []string{"<Space>"}, help: "reverse the sort"},
+		{action: actionExpand, keys: []string{"<Enter>"}, help: "open or close a process group, or reverse the sort"},
+		{action: actionSort1, help: "sort by GPU"},
+		{action: actionSort2, help: "sort by name"},
+		{action: actionSort3, help: "sort by PID"},
+		{action: actionSort4, help: "sort by usage"},
+		{action: actionSort5, help: "sort by VRAM"},
+		{action: actionSort6, help: "sort by VRAM share of the GPU"},
+		{action: actionTop, keys: []string{"t"}, help: "list the top processes or all of them"},
+		{action: actionFilter, keys: []string{"/"}, help: "filter by name, command or PID; /re/ is a regexp"},
+		{action: actionMinVRAM, keys: []string{"v"}, help: "hide or show processes below --min-vram"},
+		{action: actionNarrow, keys: []string{"<"}, help: "narrow the sorted column"},
+		{action: actionWiden, keys: []string{">"}, help: "widen the sorted column"},
+		{action: actionExport, keys: []string{"X"}, help: "save the process list as CSV"},
+		{action: actionCopy, keys: []string{"y"}, help: "copy the selected process"},
+		{action: actionPause, keys: []string{"<Space>"}, help: "pause or resume playback", replay: true},
+		{action: actionFaster, keys: []string{"+", "="}, help: "play faster", replay: true},
+		{action: actionSlower, keys: []string{"-"}, help: "play slower", replay: true},
+		{action: actionBack, keys: []string{"<Left>"}, help: "seek back 10s", replay: true},
+		{action: actionForward, keys: []string{"<Right>"}, help: "seek forward 10s", replay: true},
+	}
+}
+
+// fallbackKeys work on every terminal, including the minimal containers
+// and serial consoles where termui never sees arrow keys. They are added
+// to whatever the config binds unless it uses the key for something else.
+var fallbackKeys = []struct {
+	key    string
+	action keyAction
+	replay bool
+}{
+	{"k", actionUp, false}, {"<C-p>", actionUp, false},
+	{"j", actionDown, false}, {"<C-n>", actionDown, false},
+	{"h", actionSortPrev, false}, {"l", actionSortNext, false},
+	{"1", actionSort1, false}, {"2", actionSort2, false}, {"3", actionSort3, false},
+	{"4", actionSort4, false}, {"5", actionSort5, false},
+	{"6", actionSort6, false},
+	{"h", actionBack, true}, {"l", actionForward, true},
+}
+
+// basicKey reports whether a key arrives as a plain byte, which --keys=basic
+// limits bindings to: no escape sequences (arrows, F-keys) and no Ctrl
+func basicKey(id string) bool {
+	switch id {
+	case "<Space>", "<Enter>", "<Tab>", "<Backspace>":
+		return true
+	}
+	return !strings.HasPrefix(id, "<")
+}
+
+// keymap looks up the action bound to a key. Replay bindings are kept
+// apart since they take over some keys only while replaying.
+type keymap struct {
+	bindings     []keyBinding
+	main, replay map[string]keyAction
+}
+
+// keyMap is the effective keymap, set from the [keys] config section at
+// startup and only read after that
+var keyMap, _ = newKeymap(nil, false)
+
+// newKeymap applies overrides (action name to keys) to the defaults. An
+// override replaces all of the action's keys. Two actions sharing a key is
+// an error naming both. With basic, keys that need special-key or
+// modifier reporting are dropped.
+func newKeymap(overrides map[string][]string, basic bool) (*keymap, error) {
+	k := &keymap{bindings: defaultKeyBindings(), main: map[string]keyAction{}, replay: map[string]keyAction{}}
+	index := map[keyAction]int{}
+	for i, b := range k.bindings {
+		index[b.action] = i
+	}
+	// Sorted so the first error is the same every run
+	actions := make([]string, 0, len(overrides))
+	for action := range overrides {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	for _, action := range actions {
+		i, ok := index[keyAction(action)]
+		if !ok {
+			return nil, fmt.Errorf("keys: unknown action %q", action)
+		}
+		keys := make([]string, 0, len(overrides[action]))
+		for _, name := range overrides[action] {
+			key, err := parseKey(name)
+			if err != nil {
+				return nil, fmt.Errorf("keys: %s: %v", action, err)
+			}
+			keys = append(keys, key)
+		}
+		k.bindings[i].keys = keys
+	}
+	for _, b := range k.bindings {
+		m := k.main
+		if b.replay {
+			m = k.replay
+		}
+		for _, key := range b.keys {
+			if other, ok := m[key]; ok && other != b.action {
+				return nil, fmt.Errorf("keys: %s is bound to both %s and %s", keyName(key), other, b.action)
+			}
+			m[key] = b.action
+		}
+	}
+	for _, f := range fallbackKeys {
+		m := k.main
+		if f.replay {
+			m = k.replay
+		}
+		if _, ok := m[f.key]; !ok {
+			m[f.key] = f.action
+			i := index[f.action]
+			k.bindings[i].keys = append(k.bindings[i].keys, f.key)
+		}
+	}
+	if basic {
+		for i := range k.bindings {
+			k.bindings[i].keys = slices.DeleteFunc(k.bindings[i].keys, func(key string) bool { return !basicKey(key) })
+		}
+		maps.DeleteFunc(k.main, func(key string, _ keyAction) bool { return !basicKey(key) })
+		maps.DeleteFunc(k.replay, func(key string, _ keyAction) bool { return !basicKey(key) })
+	}
+	return k, nil
+}
+
+// action returns what a key does outside replay, or "" for nothing
+func (k *keymap) action(id string) keyAction {
+	return k.main[id]
+}
+
+// replayAction returns what a key does while replaying, or "" when the
+// key does what it does elsewhere
+func (k *keymap) replayAction(id string) keyAction {
+	return k.replay[id]
+}
+
+// keys lists an action's keys for display, like "q, C-c"
+func (k *keymap) keys(action keyAction) string {
+	for _, b := range k.bindings {
+		if b.action == action {
+			names := make([]string, len(b.keys))
+			for i, key := range b.keys {
+				names[i] = keyName(key)
+			}
+			return strings.Join(names, ", ")
+		}
+	}
+	return ""
+}
+
+// hint names an action's first key for titles, like "'b'", or "unbound"
+func (k *keymap) hint(action keyAction) string {
+	keys, _, _ := strings.Cut(k.keys(action), ", ")
+	if keys == "" {
+		return "unbound"
+	}
+	return "'" + keys + "'"
+}
+
+// specialKey matches the termui names of keys that aren't characters
+var specialKey = regexp.MustCompile(`^(F[1-9]|F1[0-2]|Insert|Delete|Home|End|PageUp|PageDown|Up|Down|Left|Right|Tab|Enter|Escape|Space|Backspace|C-[a-z])$`)
+
+// parseKey turns a config key name ("y", "F9", "Ctrl-n", "<Up>") into a
+// termui event ID
+func parseKey(name string) (string, error) {
+	if len([]rune(name)) == 1 {
+		return name, nil
+	}
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "<"), ">")
+	if rest, ok := strings.CutPrefix(name, "Ctrl-"); ok {
+		name = "C-" + rest
+	}
+	if !specialKey.MatchString(name) {
+		return "", fmt.Errorf("unknown key %q", name)
+	}
+	return "<" + name + ">", nil
+}
+
+// keyName is the inverse of parseKey
+func keyName(id string) string {
+	if len([]rune(id)) == 1 {
+		return id
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(id, "<"), ">")
+}
+
+// keyProbe watches the first keys for signs of a terminal that doesn't
+// report special keys, and says so in the debug log: arrow keys that
+// arrive as Escape followed by the rest of their escape sequence. It
+// belongs to the UI goroutine.
+type keyProbe struct {
+	escape   time.Time
+	reported bool
+}
+
+func (p *keyProbe) observe(id string, now time.Time) {
+	if p.reported {
+		return
+	}
+	if id == "<Escape>" {
+		p.escape = now
+		return
+	}
+	if (id == "[" || id == "O") && now.Sub(p.escape) < 50*time.Millisecond {
+		p.reported = true
+		debugLog.Warn("special keys arrive as raw escape sequences; the terminal's TERM or terminfo is likely wrong",
+			"term", os.Getenv("TERM"), "hint", "j/k/h/l, Ctrl-n/Ctrl-p and 1-6 work everywhere; --keys=basic drops the special-key bindings")
+	}
+}
+
+// logTerminal notes terminals known to report few special keys
+func logTerminal(basic bool) {
+	term := os.Getenv("TERM")
+	switch term {
+	case "", "dumb", "vt100", "vt102", "vt220", "ansi":
+		debugLog.Warn("terminal may not report arrow or function keys", "term", term, "basic_keys", basic)
+	default:
+		debugLog.Info("terminal", "term", term, "basic_keys", basic)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestFallbackKeys(t *testing.T) {
+	for _, basic := range []bool{false, true} {
+		k, err := newKeymap(nil, basic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, want := range map[string]keyAction{
+			"k": actionUp, "<C-p>": actionUp, "j": actionDown, "<C-n>": actionDown,
+			"h": actionSortPrev, "l": actionSortNext,
+			"1": actionSort1, "2": actionSort2, "3": actionSort3, "4": actionSort4,
+		} {
+			if basic && !basicKey(key) {
+				continue
+			}
+			if got := k.action(key); got != want {
+				t.Errorf("basic=%v: %s does %q, want %q", basic, key, got, want)
+			}
+		}
+	}
+}
+
+func TestBasicKeysOnly(t *testing.T) {
+	k, err := newKeymap(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := range k.main {
+		if !basicKey(id) {
+			t.Errorf("--keys=basic binds %s", id)
+		}
+	}
+}
+
+func TestSortKeysSelectColumn(t *testing.T) {
+	for i, action := range sortActions {
+		v := newProcessView(0, false, false)
+		if !v.handleEvent(action) {
+			t.Fatalf("%s not handled", action)
+		}
+		if v.selectedColumn != i {
+			t.Errorf("%s selects column %d, want %d", action, v.selectedColumn, i)
+		}
+	}
+}
@@ -3,11 +3,20 @@
 package main
 
 import (
+	"cmp"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/exec"
+	"os/signal"
+	"runtime/debug"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	ui "github.com/gizak/termui/v3"
@@ -16,139 +25,207 @@ import (
 
 // Global variables
 var (
-	processList    *widgets.List
-	selectedColumn int
-	sortReverse    bool
-	columns        = []string{"GPU", "Name", "PID", "Usage"}
+	footer *widgets.Paragraph
 )
 
-// ProcessListItem for sorting
-type ProcessListItem struct {
-	gpu     int
-	name    string
-	pid     string
-	usage   string
-	display string
-}
-
-func updateProcessList(processes []ProcessInfo) {
-	items := make([]ProcessListItem, 0)
-	// Find the longest name length for alignment
-	maxNameLen := 20 // Default minimum width
-	maxPIDLen := 8   // PID width
-	for _, proc := range processes {
-		if len(proc.Name) > maxNameLen {
-			maxNameLen = len(proc.Name)
-		}
-		item := ProcessListItem{
-			gpu:   proc.GPU,
-			name:  proc.Name,
-			pid:   proc.PID,
-			usage: proc.GFXUsage,
-			// Update display format to include memory information
-			display: fmt.Sprintf("[%2d] %-*s │ PID: %-*s │ MEM: %6.1f MB (VRAM: %6.1f MB, GTT: %6.1f MB, CPU: %6.1f MB) │ GFX: %6s",
-				proc.GPU,
-				maxNameLen, proc.Name,
-				maxPIDLen, proc.PID,
-				proc.TotalMem,
-				proc.VRAMMem,
-				proc.GTTMem,
-				proc.CPUMem,
-				proc.GFXUsage),
-		}
-		items = append(items, item)
-	}
-	// Update header format
-	header := fmt.Sprintf("[GPU] %-*s │ %-*s │ %-58s │ %-10s",
-		maxNameLen, "NAME",
-		maxPIDLen+5, "PID",
-		"MEMORY USAGE",
-		"GPU USAGE")
-	// Sort based on selected column
-	sort.Slice(items, func(i, j int) bool {
-		var result bool
-		switch selectedColumn {
-		case 0: // GPU
-			result = items[i].gpu < items[j].gpu
-		case 1: // Name
-			result = items[i].name < items[j].name
-		case 2: // PID
-			result = items[i].pid < items[j].pid
-		case 3: // Usage
-			result = items[i].usage < items[j].usage
-		}
-		if sortReverse {
-			return !result
-		}
-		return result
-	})
-	// Update list display
-	processList.Rows = make([]string, len(items)+2) // +2 for header and separator
-	processList.Rows[0] = header
-	processList.Rows[1] = strings.Repeat("─", len(header))
-	for i, item := range items {
-		processList.Rows[i+2] = item.display
-	}
-}
-func handleProcessListEvents(e ui.Event) {
-	switch e.ID {
-	case "<Up>":
-		if processList.SelectedRow > 0 {
-			processList.SelectedRow--
-		}
-	case "<Down>":
-		if processList.SelectedRow < len(processList.Rows)-1 {
-			processList.SelectedRow++
-		}
-	case "<Left>":
-		if selectedColumn > 0 {
-			selectedColumn--
-			processList.Title = fmt.Sprintf("Process List (Sort: %s%s)",
-				columns[selectedColumn],
-				map[bool]string{true: " ↓", false: " ↑"}[sortReverse])
-		}
-	case "<Right>":
-		if selectedColumn < len(columns)-1 {
-			selectedColumn++
-			processList.Title = fmt.Sprintf("Process List (Sort: %s%s)",
-				columns[selectedColumn],
-				map[bool]string{true: " ↓", false: " ↑"}[sortReverse])
-		}
-	case "<Enter>", "<Space>":
-		sortReverse = !sortReverse
-	}
-}
+// Command line flags
+var (
+	remoteTargets    stringList
+	showVersion      = flag.Bool("version", false, "print version information and exit")
+	logCSVPath       = flag.String("log-csv", "", "append GPU samples to this CSV file (processes go to <name>.processes.csv, benchmark runs to <name>.runs.csv)")
+	logMaxMB         = flag.Int("log-max-mb", 100, "rotate the CSV log once it exceeds this size in MB (0 disables rotation)")
+	logKeep          = flag.Int("log-keep", 5, "number of rotated CSV log files to keep")
+	influxStdout     = flag.Bool("influx-stdout", false, "write samples to stdout in InfluxDB line protocol")
+	influxFile       = flag.String("influx-file", "", "append samples in InfluxDB line protocol to this file")
+	influxURL        = flag.String("influx-url", "", "InfluxDB v2 base URL to POST samples to, e.g. http://localhost:8086")
+	influxToken      = flag.String("influx-token", "", "InfluxDB API token")
+	influxOrg        = flag.String("influx-org", "", "InfluxDB organization")
+	influxBucket     = flag.String("influx-bucket", "", "InfluxDB bucket")
+	statsdAddr       = flag.String("statsd", "", "emit DogStatsD gauges over UDP to host:port")
+	statsdPrefix     = flag.String("statsd-prefix", "mitop.", "prefix for StatsD metric names")
+	statsdSampleRate = flag.Float64("statsd-sample-rate", 1, "StatsD sample rate in (0, 1]")
+	graphiteAddr     = flag.String("graphite", "", "send metrics to a Graphite plaintext listener at host:port")
+	graphitePrefix   = flag.String("graphite-prefix", "mitop", "prefix for Graphite metric paths")
+	otlpEndpoint     = flag.String("otlp-endpoint", "", "export metrics to an OpenTelemetry collector over OTLP/HTTP, e.g. http://localhost:4318")
+	otlpInterval     = flag.Duration("otlp-interval", 10*time.Second, "OTLP export interval, a multiple of the sampling interval")
+	dbPath           = flag.String("db", "", "store samples in this SQLite database (requires the sqlite3 tool)")
+	dbRetention      = flag.Duration("db-retention", 7*24*time.Hour, "delete database rows older than this (0 keeps everything)")
+	dbQuery          = flag.String("query", "", "with --db, print a summary for a range like \"last 1h\" and exit")
+	dbQueryGPU       = flag.Int("gpu", -1, "with --query, only summarize this GPU")
+	hostsFile        = flag.String("hosts-file", "", "monitor every ssh target listed in this file, one per line")
+	sshKey           = flag.String("ssh-key", "", "private key file for --remote (the ssh agent is used otherwise)")
+	mqttBroker       = flag.String("mqtt-broker", "", "publish GPU state to this MQTT broker, e.g. tcp://broker:1883 or ssl://broker:8883")
+	mqttTopicPrefix  = flag.String("mqtt-topic-prefix", "mitop", "MQTT topic prefix; state goes to <prefix>/<host>/gpu<N>/state")
+	mqttDiscovery    = flag.String("mqtt-discovery-prefix", "homeassistant", "Home Assistant discovery prefix (empty disables discovery)")
+	mqttUsername     = flag.String("mqtt-username", "", "MQTT username")
+	mqttPassword     = flag.String("mqtt-password", "", "MQTT password")
+	mqttTLS          = flag.Bool("mqtt-tls", false, "connect to the MQTT broker with TLS")
+	mqttInterval     = flag.Duration("mqtt-interval", 10*time.Second, "MQTT publish interval")
+	httpAddr         = flag.String("http", "", "serve a read-only JSON API on this address, e.g. :8080")
+	grpcAddr         = flag.String("grpc", "", "serve the gRPC Monitor API (proto/mitop.proto) on this address, e.g. :7070")
+	httpHistory      = flag.Int("http-history", 600, "number of samples per GPU kept for /api/history")
+	logFile          = flag.String("log-file", "", "write structured debug logs to this file")
+	timezone         = flag.String("timezone", "local", "zone timestamps are shown and exported in: local, UTC or an IANA name such as Europe/Berlin; exports are RFC 3339 with the offset either way")
+	logLevel         = flag.String("log-level", "info", "log level for --log-file: debug, info, warn or error")
+	recordPath       = flag.String("record", "", "append every sample to this recording file")
+	replayPath       = flag.String("replay", "", "play back a recording instead of querying amd-smi")
+	vendors          = flag.String("vendors", vendorAMD, "GPU vendors to monitor, e.g. amd,nvidia,intel: NVIDIA GPUs are read with nvidia-smi, Intel ones with xpu-smi or sysfs; vendors whose tools are missing are skipped")
+	backendName      = flag.String("backend", "auto", "read GPU metrics with "+strings.Join(backendNames()[1:], " or ")+" instead of the first that works (auto)")
+	headless         = flag.Bool("headless", false, "run without the terminal UI, only feeding the configured outputs")
+	streamFormat     = flag.String("stream", "", "run without the terminal UI and write every sample to stdout: ndjson (one JSON object per line), or csv (a row per GPU, "+csvSchemaHelp(csvStreamHeader)+")")
+	textfileDir      = flag.String("textfile-dir", "", "keep "+textfileName+" in this node_exporter textfile collector directory up to date, e.g. /var/lib/node_exporter/textfile")
+	streamProcesses  = flag.String("stream-processes", "", "with --stream=csv, write process rows to this file ("+csvSchemaHelp(csvStreamProcessHeader)+")")
+	pidFile          = flag.String("pidfile", "", "write the process id to this file in headless mode")
+	refreshInterval  = flag.Duration("interval", time.Second, "refresh period, e.g. 500ms, 2s or 10s (overrides the config file)")
+	configPath       = flag.String("config", "", "read settings from this TOML file instead of the default locations")
+	noProcesses      = flag.Bool("no-processes", false, "skip process collection and hide the process list ('p' toggles it)")
+	sortSpec         = flag.String("sort", "", "initial process sort as column[,asc|desc]: gpu, name, pid, usage, vram or vram% (default usage,desc, or the last session's)")
+	fresh            = flag.Bool("fresh", false, "don't restore the last session's process sort, filters and views")
+	noFreeze         = flag.Bool("no-freeze", false, "re-sort the process list on every refresh, even while moving through it")
+	minVRAM          = flag.String("min-vram", "", "hide processes using less VRAM than this, e.g. 100MB; 'v' shows them again (without it 'v' hides those below 100MB)")
+	topProcesses     = flag.Int("top", 0, "list only the top N processes under the current sort, 't' switches to all and back (0 lists all)")
+	keysMode         = flag.String("keys", "full", "key bindings: full, or basic to leave out arrow, function and Ctrl keys for terminals that don't report them")
+	sortGPUs         = flag.String("sort-gpus", "id", "order GPUs by id, or by bdf (PCIe bus address) to keep charts and process groups stable across reboots; --gpu-order=bdf does the same")
+	gpuOrderFlag     = flag.String("gpu-order", gpuOrderIndex, "order GPU charts by index, bdf (PCIe bus address), busy (most utilized first, re-evaluated every 10s) or a list of GPU indices such as 3,1,0,2")
+	writeConfig      = flag.Bool("write-default-config", false, "print a commented config file template and exit")
+	enableControl    = flag.Bool("enable-control", false, "allow changing fan speeds and power profiles from the fan ('f') and GPU info ('s') panels; off by default so mi-top stays read-only")
+	notifyDesktop    = flag.Bool("notify", false, "show desktop notifications (notify-send) when alert rules fire")
+	plainOutput      = flag.Bool("plain", false, "print a text block per interval instead of the terminal UI (the default when stdout isn't a terminal)")
+	iterations       = flag.Int("iterations", 0, "with --plain, stop after this many samples (0 runs until interrupted)")
+	forceTUI         = flag.Bool("force-tui", false, "start the terminal UI even when stdout isn't a terminal")
+	noSummary        = flag.Bool("no-summary", false, "don't print the session summary when the terminal UI exits")
+	quietMode        = flag.Bool("quiet", false, "show only the charts and critical alerts, for wall dashboards ('z' toggles it)")
+)
 
 // Store GPU utilization history
 type GPUHistory struct {
 	values []float64
+	times  []time.Time
 	maxLen int
 	index  int // Track current position
+	count  int // Samples added so far, up to maxLen
 }
 
 func newGPUHistory(maxLen int) *GPUHistory {
 	return &GPUHistory{
 		values: make([]float64, maxLen), // Create a fixed size array
+		times:  make([]time.Time, maxLen),
 		maxLen: maxLen,
 		index:  0,
 	}
 }
-func (gh *GPUHistory) add(value float64) {
+func (gh *GPUHistory) add(t time.Time, value float64) {
 	gh.values[gh.index] = value
+	gh.times[gh.index] = t
 	gh.index = (gh.index + 1) % gh.maxLen
+	if gh.count < gh.maxLen {
+		gh.count++
+	}
 }
 
-// Get ordered data
+// Len is the number of samples actually recorded
+func (gh *GPUHistory) Len() int {
+	return gh.count
+}
+
+// Get ordered data, oldest first. Only recorded samples are returned.
 func (gh *GPUHistory) getData() []float64 {
-	if gh.index == 0 {
-		return gh.values
+	return gh.appendData(make([]float64, 0, gh.count))
+}
+
+// appendData appends the recorded samples, oldest first, to dst so callers
+// refreshing every tick can reuse one buffer
+func (gh *GPUHistory) appendData(dst []float64) []float64 {
+	if gh.count < gh.maxLen {
+		return append(dst, gh.values[:gh.count]...)
+	}
+	dst = append(dst, gh.values[gh.index:]...)
+	return append(dst, gh.values[:gh.index]...)
+}
+
+// mean is the average of the samples taken since a time, or 0 without any
+func (gh *GPUHistory) mean(since time.Time) float64 {
+	var sum float64
+	n := 0
+	for k := range gh.count {
+		if !gh.times[k].Before(since) {
+			sum += gh.values[k]
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+// Get sample timestamps in the same order as getData
+func (gh *GPUHistory) getTimes() []time.Time {
+	result := make([]time.Time, gh.count)
+	if gh.count < gh.maxLen {
+		copy(result, gh.times[:gh.count])
+		return result
+	}
+	copy(result, gh.times[gh.index:])
+	copy(result[gh.maxLen-gh.index:], gh.times[:gh.index])
+	return result
+}
+
+// column is the chart column of the sample taken at or right after t, or
+// -1 once t has scrolled out of the history
+func (gh *GPUHistory) column(t time.Time) int {
+	at := func(k int) time.Time {
+		if gh.count < gh.maxLen {
+			return gh.times[k]
+		}
+		return gh.times[(gh.index+k)%gh.maxLen]
+	}
+	if gh.count == 0 || t.Before(at(0)) {
+		return -1
+	}
+	k := sort.Search(gh.count, func(k int) bool { return !at(k).Before(t) })
+	if k == gh.count {
+		return -1
+	}
+	return gh.maxLen - gh.count + k
+}
+
+// resized copies the recorded samples into a history of a new length,
+// keeping the most recent ones when shrinking
+func (gh *GPUHistory) resized(maxLen int) *GPUHistory {
+	result := newGPUHistory(maxLen)
+	data, times := gh.getData(), gh.getTimes()
+	start := max(0, len(data)-maxLen)
+	for j := start; j < len(data); j++ {
+		result.add(times[j], data[j])
 	}
-	result := make([]float64, gh.maxLen)
-	copy(result, gh.values[gh.index:])
-	copy(result[gh.maxLen-gh.index:], gh.values[:gh.index])
 	return result
 }
 
+// chartBlank is charted as an empty column. termui still draws a baseline
+// for values just below zero, so it has to be far below any MaxVal.
+const chartBlank = -1e9
+
+// chartData right-aligns the history in a sparkline of maxLen columns.
+// The unfilled columns on the left stay blank instead of showing a fake
+// zero baseline.
+func (gh *GPUHistory) chartData() []float64 {
+	return gh.fillChart(nil)
+}
+
+// fillChart is chartData writing into dst, which is reused when it is
+// large enough
+func (gh *GPUHistory) fillChart(dst []float64) []float64 {
+	if cap(dst) < gh.maxLen {
+		dst = make([]float64, 0, gh.maxLen)
+	}
+	dst = dst[:0]
+	for i := gh.count; i < gh.maxLen; i++ {
+		dst = append(dst, chartBlank)
+	}
+	return gh.appendData(dst)
+}
+
 // Helper function to calculate appropriate number of data points
 func calculateDataPoints(width int) int {
 	// Consider borders and other UI elements for actual usable width
@@ -161,131 +238,1040 @@ func calculateDataPoints(width int) int {
 	}
 	return usableWidth
 }
+
+// flagWasSet reports whether a flag was given on the command line, so it can
+// take precedence over the config file
+func flagWasSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// layout places the grid above a one line footer
+func layout(grid *ui.Grid, width, height int) {
+	grid.SetRect(0, 0, width, height-1)
+	footer.SetRect(0, height-1, width, height)
+}
+
+// shutdownOnce returns a function that runs steps in order the first time
+// it is called and does nothing after that
+func shutdownOnce(steps ...func()) func() {
+	return sync.OnceFunc(func() {
+		for _, step := range steps {
+			step()
+		}
+	})
+}
+
 func main() {
+	// `mi-top check` has its own flags and never touches the terminal
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(runCheck(os.Args[2:], os.Stdout))
+	}
+	// A panic in the UI loop exits nonzero, once everything deferred has
+	// run: the terminal is restored and the outputs are flushed
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+	flag.BoolVar(showVersion, "v", false, "print version information and exit")
+	flag.Var(&remoteTargets, "remote", "monitor user@host by running amd-smi over ssh (repeat or comma separate for several hosts)")
+	flag.Parse()
 	// Check for version flag
-	if len(os.Args) > 1 && (os.Args[1] == "-v" || os.Args[1] == "--version") {
+	if *showVersion {
 		fmt.Printf("amdtop version %s\n", Version)
 		fmt.Printf("Git commit: %s\n", GitCommit)
 		fmt.Printf("Build time: %s\n", BuildTime)
 		return
 	}
+	if *writeConfig {
+		fmt.Print(defaultConfigTemplate)
+		return
+	}
+	if zone, err := parseTimezone(*timezone); err != nil {
+		log.Fatalf("--timezone: %v", err)
+	} else {
+		displayZone = zone
+	}
+	if *logFile != "" {
+		f, err := openDebugLog(*logFile, *logLevel)
+		if err != nil {
+			log.Fatalf("failed to open log file: %v", err)
+		}
+		defer f.Close()
+	}
+	if *dbQuery != "" {
+		if *dbPath == "" {
+			log.Fatalf("--query requires --db")
+		}
+		if err := runDBQuery(os.Stdout, *dbPath, *dbQuery, *dbQueryGPU); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+	collectProcesses.Store(!*noProcesses)
+	if *headless && *replayPath != "" {
+		log.Fatalf("--replay cannot be combined with --headless")
+	}
+	if *hostsFile != "" {
+		targets, err := readHostsFile(*hostsFile)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		remoteTargets = append(remoteTargets, targets...)
+	}
+	var hosts []*hostView
+	for _, target := range remoteTargets {
+		hosts = append(hosts, newHostView(targetHost(target), newSSHRunner(target, *sshKey)))
+	}
+	if len(hosts) == 0 {
+		hosts = append(hosts, newHostView("", smiRunner))
+	}
+	if len(hosts) > 1 && (*headless || *replayPath != "") {
+		log.Fatalf("several --remote hosts are only supported in the interactive view")
+	}
+	var replay *Replayer
+	if *replayPath != "" {
+		samples, err := loadRecording(*replayPath)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		replay = newReplayer(samples)
+	}
+	cfg := defaultConfig()
+	if path := findConfig(*configPath); path != "" {
+		var warnings []string
+		var err error
+		cfg, warnings, err = loadConfig(path)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		debugLog.Info("loaded config", "path", path)
+		for _, w := range warnings {
+			log.Printf("%s: %s", path, w)
+			debugLog.Warn("config", "path", path, "warning", w)
+		}
+	}
+	if *keysMode != "full" && *keysMode != "basic" {
+		log.Fatalf("--keys: unknown mode %q (want full or basic)", *keysMode)
+	}
+	if km, err := newKeymap(cfg.Keys, *keysMode == "basic"); err != nil {
+		log.Fatalf("%v", err)
+	} else {
+		keyMap = km
+	}
+	// The last session's views, sort and filters; a broken state file is
+	// ignored rather than keeping mi-top from starting
+	var state *uiState
+	var stateWarning string
+	if path := statePath(); path != "" && !*fresh && !*headless {
+		var err error
+		if state, err = loadState(path); err != nil {
+			stateWarning = "saved state ignored: " + err.Error()
+			log.Print(stateWarning)
+			debugLog.Warn("saved state ignored", "err", err)
+		}
+	}
+	if flagWasSet("sort") {
+		cfg.Sort = *sortSpec
+	} else if state != nil {
+		cfg.Sort = state.Sort
+	}
+	sortColumn, sortDescending := 0, false
+	if cfg.Sort != "" {
+		var err error
+		if sortColumn, sortDescending, err = parseSort(cfg.Sort); err != nil {
+			log.Fatalf("--sort: %v", err)
+		}
+	}
+	if *sortGPUs != "id" && *sortGPUs != "bdf" {
+		log.Fatalf("--sort-gpus: unknown order %q (want id or bdf)", *sortGPUs)
+	}
+	gpuOrder, err := parseGPUOrder(*gpuOrderFlag)
+	if err != nil {
+		log.Fatalf("--gpu-order: %v", err)
+	}
+	if *sortGPUs == "bdf" && gpuOrder.by == gpuOrderIndex {
+		gpuOrder.by = gpuOrderBDF
+	}
+	byBDF := gpuOrder.by == gpuOrderBDF
+	if *topProcesses < 0 {
+		log.Fatalf("--top: must not be negative")
+	}
+	if *streamFormat != "" && !slices.Contains(streamFormats, *streamFormat) {
+		log.Fatalf("--stream: unknown format %q (want %s)", *streamFormat, strings.Join(streamFormats, " or "))
+	}
+	if *streamProcesses != "" && *streamFormat != "csv" {
+		log.Fatalf("--stream-processes: only works with --stream=csv")
+	}
+	if !slices.Contains(backendNames(), *backendName) {
+		log.Fatalf("--backend: unknown backend %q (want %s)", *backendName, strings.Join(backendNames(), ", "))
+	}
+	others, err := parseVendors(*vendors)
+	if err != nil {
+		log.Fatalf("--vendors: %v", err)
+	}
+	aliases := newGPUAliases(cfg.Aliases)
+	// validate has checked it
+	downsample, _ := parseDownsample(cfg.Downsample)
+	for _, h := range hosts {
+		h.backend = newBackendSelector(*backendName)
+		h.backend.vendors = others
+		h.aliases = aliases
+		h.downsample = downsample
+		h.smoothing = cfg.Smoothing
+	}
+	// What 'e' turns smoothing on at
+	smoothing := cmp.Or(cfg.Smoothing, defaultSmoothing)
+	if *iterations < 0 {
+		log.Fatalf("--iterations: must not be negative")
+	}
+	minVRAMBytes := uint64(100 << 20)
+	if *minVRAM != "" {
+		var err error
+		if minVRAMBytes, err = parseMemorySize(*minVRAM); err != nil {
+			log.Fatalf("--min-vram: %v", err)
+		}
+	}
+	if flagWasSet("interval") {
+		cfg.Interval = *refreshInterval
+		if err := cfg.validate(); err != nil {
+			log.Fatalf("--interval: %v", err)
+		}
+	}
+	// Pick the backend before the outputs are opened and termui takes over
+	// the terminal; remote hosts report their own failures on their charts.
+	// amd-smi is checked by the preflight, which says what to do about it.
+	var pre preflight
+	if replay == nil && len(remoteTargets) == 0 {
+		err := hosts[0].backend.choose(hosts[0].runner, func(b metricsBackend) error {
+			if b.name != "amd-smi" {
+				return b.probe(hosts[0].runner)
+			}
+			var err error
+			pre, err = runPreflight(hosts[0].runner)
+			return err
+		})
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if pre.noGPUs != "" && hosts[0].backend.name() == "amd-smi" {
+			log.Print(pre.noGPUs)
+		}
+		if status := hosts[0].backend.status(); status != "" {
+			log.Print(status)
+		}
+	}
+	sinks, err := openSinks(cfg)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer closeSinks(sinks)
+	// Firing alert rules are marked on the charts
+	var alerter *Alerter
+	for _, s := range sinks {
+		if a, ok := s.(*Alerter); ok {
+			alerter = a
+		}
+	}
+	if *headless || *streamFormat != "" {
+		if err := runHeadless(hosts[0], sinks, cfg.Interval); err != nil {
+			closeSinks(sinks)
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+	// termui needs a terminal; `watch`, CI logs and pipes get plain text
+	if *plainOutput || !*forceTUI && replay == nil && !stdoutIsTerminal() {
+		if replay != nil {
+			log.Fatalf("--plain: recordings only play back in the terminal UI")
+		}
+		runPlain(os.Stdout, hosts, sinks, cfg.Interval, *iterations, *topProcesses)
+		return
+	}
 	if err := ui.Init(); err != nil {
 		log.Fatalf("failed to initialize termui: %v", err)
 	}
-	defer ui.Close()
+	// shutdown restores the terminal, then writes the session summary on
+	// the normal screen, once, whichever of quitting, a signal or a panic
+	// gets there first
+	steps := []func(){ui.Close}
+	if !*noSummary {
+		steps = append(steps, func() { writeSessionSummary(os.Stdout, hosts, len(hosts) > 1, time.Now()) })
+	}
+	shutdown := shutdownOnce(steps...)
+	defer shutdown()
+	debugLog.Info("terminal UI started", "hosts", len(hosts), "interval", cfg.Interval, "replay", replay != nil)
+	defer debugLog.Info("terminal UI stopped")
+	logTerminal(*keysMode == "basic")
 	// Get terminal dimensions early
 	termWidth, termHeight := ui.TerminalDimensions()
-	dataPoints := calculateDataPoints(termWidth)
+	dataPoints := historyPoints(calculateDataPoints(termWidth), cfg.ChartHistory, cfg.Interval)
+	multiHost := len(hosts) > 1
 	// Get number of GPUs
-	metrics, err := getGPUMetrics()
-	if err != nil {
-		log.Fatalf("failed to get GPU metrics: %v", err)
-	}
-	numGPUs := len(metrics)
-	// Create GPU charts
-	gpuCharts := make([]*widgets.SparklineGroup, numGPUs)
-	gpuHistories := make([]*GPUHistory, numGPUs)
-	for i := 0; i < numGPUs; i++ {
-		sparkline := widgets.NewSparkline()
-		sparkline.LineColor = ui.ColorGreen
-		sparkline.TitleStyle = ui.NewStyle(ui.ColorWhite)
-		sparkline.MaxVal = 100
-		// Use calculated number of data points
-		initialData := make([]float64, dataPoints)
-		sparkline.Data = initialData
-		spGroup := widgets.NewSparklineGroup()
-		spGroup.Title = fmt.Sprintf("GPU %d", i)
-		spGroup.Sparklines = []*widgets.Sparkline{sparkline}
-		spGroup.BorderStyle = ui.NewStyle(ui.ColorWhite)
-		spGroup.BorderLeft = true
-		spGroup.BorderRight = true
-		spGroup.BorderTop = true
-		spGroup.BorderBottom = true
-		// Set minimum height
-		spGroup.SetRect(0, 0, termWidth, 10)
-		gpuCharts[i] = spGroup
-		gpuHistories[i] = newGPUHistory(dataPoints)
-		// Initialize history data to 0
-		for j := 0; j < dataPoints; j++ {
-			gpuHistories[i].add(0)
+	if replay != nil {
+		hosts[0].addGPUs(gpuIDs(replay.first().GPUs), dataPoints, termWidth, false)
+	} else if !multiHost {
+		// No GPUs, or an amd-smi that fails because of that, is not fatal:
+		// the sampler keeps polling for a device to be attached
+		sample, err := hosts[0].backend.collect(hosts[0].runner)
+		if errors.Is(err, exec.ErrNotFound) {
+			ui.Close()
+			log.Fatalf("failed to get GPU metrics: %v", err)
+		}
+		if err != nil {
+			debugLog.Warn("no GPUs at startup", "err", err)
+		}
+		hosts[0].addGPUs(gpuIDs(sample.GPUs), dataPoints, termWidth, false)
+	} else {
+		// Probe all hosts at once; unreachable ones get a placeholder chart
+		var wg sync.WaitGroup
+		ids := make([][]int, len(hosts))
+		failed := make([]bool, len(hosts))
+		for i, h := range hosts {
+			wg.Add(1)
+			go func(i int, h *hostView) {
+				defer wg.Done()
+				if sample, err := h.backend.collect(h.runner); err == nil {
+					ids[i] = gpuIDs(sample.GPUs)
+				} else {
+					failed[i] = true
+				}
+			}(i, h)
+		}
+		wg.Wait()
+		for i, h := range hosts {
+			if failed[i] {
+				h.setPlaceholder(dataPoints, termWidth, true)
+				h.setReachable(false)
+				continue
+			}
+			h.addGPUs(ids[i], dataPoints, termWidth, true)
 		}
 	}
 	// Initialize process list
-	processList = widgets.NewList()
-	processList.Title = fmt.Sprintf("Process List (Sort: %s%s)",
-		columns[selectedColumn],
-		map[bool]string{true: " ↓", false: " ↑"}[sortReverse])
-	processList.TextStyle = ui.NewStyle(ui.ColorWhite)
-	processList.WrapText = false
-	processList.SelectedRow = 0
-	processList.BorderStyle = ui.NewStyle(ui.ColorWhite)
-	// Set selected row color
-	processList.SelectedRowStyle = ui.NewStyle(ui.ColorBlack, ui.ColorGreen)
+	procView := newProcessView(sortColumn, sortDescending, !*noFreeze)
+	procView.byBDF = byBDF
+	for _, h := range hosts {
+		h.order(gpuOrder, time.Now())
+	}
+	// The last run's charts, restored with each host's first sample
+	if path := historyPath(); path != "" && replay == nil && !*fresh && cfg.HistoryMaxAge > 0 {
+		saved, err := loadHistory(path, cfg.HistoryMaxAge, time.Now())
+		if err != nil {
+			debugLog.Warn("saved history ignored", "err", err)
+		}
+		for i := 0; saved != nil && i < len(saved.Hosts); i++ {
+			host := &saved.Hosts[i]
+			j := slices.IndexFunc(hosts, func(h *hostView) bool { return h.name == host.Name })
+			if j < 0 {
+				debugLog.Info("saved history ignored: host not monitored", "host", host.Name)
+				continue
+			}
+			hosts[j].saved = host
+		}
+	}
+	procView.layout = newTableLayout(cfg.Columns)
+	// Quiet mode leaves the charts and critical alerts; collection and
+	// outputs carry on as usual
+	quiet := *quietMode
+	procView.setQuiet(quiet)
+	if cfg.Theme != "monochrome" && os.Getenv("NO_COLOR") == "" {
+		procView.rows.colors = true
+		procView.rows.thresholds = cfg.Thresholds
+	}
+	// Without --top, 't' shows the top 20
+	procView.limit, procView.topN = *topProcesses, cmp.Or(*topProcesses, 20)
+	procView.minVRAM, procView.filterVRAM = minVRAMBytes, *minVRAM != "" && minVRAMBytes > 0
+	procView.list.Title = procView.title()
+	// Footer for status and warnings
+	footer = widgets.NewParagraph()
+	footer.Border = false
+	footer.WrapText = false
+	footer.TextStyle = ui.NewStyle(ui.ColorYellow)
 	// Layout
+	// Exec collectors get their own table, only when configured
+	var collectorTable *widgets.Table
+	collectors := newExecCollectors(cfg.Collectors)
+	if len(collectors) > 0 {
+		collectorTable = newCollectorTable()
+		stop := make(chan struct{})
+		runCollectors(collectors, cfg.Interval, stop)
+		defer close(stop)
+	}
+	// The info view shares the process list's row while it is open
+	peaksTable := newPeaksTable()
+	showInfo := false
+	// Changing GPU settings is opt-in, and never offered for recordings
+	var control *controller
+	var controlResults chan controlResult
+	if *enableControl && replay == nil {
+		control = newController()
+		controlResults = control.results
+	}
+	fans := newFanPanel(control)
+	showFans := false
+	static := newStaticPanel(control)
+	static.thresholds, static.rules = cfg.Thresholds, cfg.Alerts.Rules
+	static.smiVersion = pre.version
+	static.backend = hosts[0].backend
+	showStatic := false
+	vram := newVRAMBars()
+	showVRAM := false
+	// The event log lists what the chart marks stand for
+	eventTable := newEventTable()
+	showEvents := false
+	// One plot of all GPUs replaces the per-GPU charts while it is open
+	overlay := newOverlayView()
+	showOverlay := false
+	if state != nil {
+		if state.Top && procView.limit == 0 {
+			procView.limit = procView.topN
+			procView.list.Title = procView.title()
+		}
+		if *minVRAM == "" {
+			procView.filterVRAM = state.MinVRAM
+		}
+		procView.setFilter(state.Filter)
+		procView.setColumnWidths(state.Widths)
+		if !flagWasSet("no-processes") {
+			collectProcesses.Store(state.Processes)
+		}
+		showInfo, showFans, showStatic, showVRAM = state.Panel == "info", state.Panel == "fans", state.Panel == "static", state.Panel == "vram"
+		showEvents = state.Panel == "events"
+		collectFans.Store(showFans)
+		showOverlay = state.Overlay
+	}
+	// Stands in for the charts until a GPU shows up
+	noGPUs := widgets.NewParagraph()
+	noGPUs.Text = "\n  No AMD GPUs detected — waiting…"
+	if pre.noGPUs != "" {
+		noGPUs.Text += "\n\n  " + pre.noGPUs
+	}
+	noGPUs.TextStyle = ui.NewStyle(ui.ColorYellow)
+	noGPUs.BorderStyle = unreachableStyle
+	// 'B' brackets a benchmark run; its boundaries are marked on the charts
+	bench := newBenchBracket(*logCSVPath)
+	// The time axis goes by the recording's pace when replaying
+	var axisInterval time.Duration
+	if cfg.TimeAxis {
+		axisInterval = cfg.Interval
+		if replay != nil {
+			axisInterval = replay.interval()
+		}
+	}
 	grid := ui.NewGrid()
-	grid.SetRect(0, 0, termWidth, termHeight)
-	// Adjust grid layout to use more space
-	gridItems := make([]interface{}, 0)
-	chartHeight := float64(0.8) / float64(numGPUs)
-	for i := 0; i < numGPUs; i++ {
-		gridItems = append(gridItems, ui.NewRow(chartHeight, ui.NewCol(1.0, gpuCharts[i])))
-	}
-	gridItems = append(gridItems, ui.NewRow(0.2, ui.NewCol(1.0, processList)))
-	grid.Set(gridItems...)
-	ticker := time.NewTicker(1 * time.Second)
+	layout(grid, termWidth, termHeight)
+	buildGrid := func() {
+		// Adjust grid layout to use more space
+		gridItems := make([]interface{}, 0)
+		numCharts := 0
+		for _, h := range hosts {
+			numCharts += len(h.charts)
+		}
+		// Without processes the charts get the whole screen
+		showProcesses := collectProcesses.Load() && !(quiet && cfg.Quiet.HideProcesses)
+		chartSpace := 1.0
+		if showProcesses || showInfo || showFans || showStatic || showVRAM || showEvents {
+			chartSpace -= 0.2
+		}
+		if collectorTable != nil {
+			chartSpace -= 0.15
+		}
+		if numCharts == 0 {
+			gridItems = append(gridItems, ui.NewRow(chartSpace, ui.NewCol(1.0, noGPUs)))
+		} else if showOverlay {
+			gridItems = append(gridItems, ui.NewRow(chartSpace, ui.NewCol(0.85, overlay.plot), ui.NewCol(0.15, overlay.legend)))
+		} else {
+			for _, h := range hosts {
+				for i, chart := range h.charts {
+					marked := &markedChart{SparklineGroup: chart, host: h, slot: i, bench: bench, interval: axisInterval}
+					gridItems = append(gridItems, ui.NewRow(chartSpace/float64(numCharts), ui.NewCol(1.0, marked)))
+				}
+			}
+		}
+		if collectorTable != nil {
+			gridItems = append(gridItems, ui.NewRow(0.15, ui.NewCol(1.0, collectorTable)))
+		}
+		if showInfo {
+			gridItems = append(gridItems, ui.NewRow(0.2, ui.NewCol(1.0, peaksTable)))
+		} else if showFans {
+			gridItems = append(gridItems, ui.NewRow(0.2, ui.NewCol(1.0, fans.table)))
+		} else if showStatic {
+			gridItems = append(gridItems, ui.NewRow(0.2, ui.NewCol(1.0, static.table)))
+		} else if showVRAM {
+			gridItems = append(gridItems, ui.NewRow(0.2, ui.NewCol(1.0, vram)))
+		} else if showEvents {
+			gridItems = append(gridItems, ui.NewRow(0.2, ui.NewCol(1.0, eventTable)))
+		} else if showProcesses {
+			gridItems = append(gridItems, ui.NewRow(0.2, ui.NewCol(1.0, procView.list)))
+		}
+		grid.Items = nil
+		grid.Set(gridItems...)
+	}
+	buildGrid()
+	if control != nil {
+		control.layout(termWidth, termHeight)
+	}
+	help := newHelpOverlay(keyMap, replay != nil)
+	// The '/' filter is typed on a line over the footer
+	filterIn := newFilterInput()
+	help.layout(termWidth, termHeight)
+	// render draws the screen, with the confirmation dialog on top
+	render := func() {
+		ui.Render(grid, footer)
+		if control.confirming() {
+			ui.Render(control.dialog)
+		}
+		if help.shown {
+			ui.Render(help.text)
+		}
+		if bench.shown {
+			ui.Render(bench.report)
+		}
+		if filterIn.active {
+			filterIn.SetRect(footer.Min.X, footer.Min.Y, footer.Max.X, footer.Max.Y)
+			ui.Render(filterIn)
+		}
+	}
+	// notice confirms an action in the footer for a few seconds
+	notice, noticeAt := stateWarning, time.Now()
+	// chartMetric indexes chartMetrics
+	chartMetric := 0
+	if state != nil {
+		chartMetric = state.Metric
+	}
+	// Remember the views for the next session
+	defer func() {
+		path := statePath()
+		if path == "" {
+			return
+		}
+		s := &uiState{
+			Sort:      formatSort(procView.selectedColumn, procView.sortReverse),
+			Top:       procView.limit > 0,
+			MinVRAM:   procView.filterVRAM,
+			Filter:    procView.filter.pattern,
+			Processes: collectProcesses.Load(),
+			Overlay:   showOverlay,
+			Metric:    chartMetric,
+			Widths:    procView.resized,
+		}
+		switch {
+		case showInfo:
+			s.Panel = "info"
+		case showFans:
+			s.Panel = "fans"
+		case showStatic:
+			s.Panel = "static"
+		case showVRAM:
+			s.Panel = "vram"
+		case showEvents:
+			s.Panel = "events"
+		}
+		if err := saveState(path, s); err != nil {
+			debugLog.Warn("failed to save state", "err", err)
+		}
+	}()
+	defer func() {
+		path := historyPath()
+		if path == "" || replay != nil || cfg.HistoryMaxAge == 0 {
+			return
+		}
+		if err := saveHistory(path, hosts, time.Now()); err != nil {
+			debugLog.Warn("failed to save history", "err", err)
+		}
+	}()
+	// updateFooter also refreshes the collector table, which changes
+	// independently of GPU samples
+	updateFooter := func() {
+		parts := make([]string, 0, 4)
+		if replay != nil {
+			parts = append(parts, replay.status())
+		} else {
+			parts = append(parts, fmt.Sprintf("every %v, %v history", cfg.Interval, cfg.Interval*time.Duration(dataPoints)))
+		}
+		if multiHost {
+			parts = append(parts, hostSummary(hosts))
+		}
+		if status := hosts[0].backend.status(); status != "" && replay == nil {
+			parts = append(parts, status)
+		}
+		if gtt := gttPressure(hosts, cfg.Thresholds, procView.rows.colors); gtt != "" && collectProcesses.Load() {
+			parts = append(parts, gtt)
+		}
+		if bench.run != nil {
+			parts = append(parts, "benchmark bracket open, "+keyMap.hint(actionBench)+" closes")
+		}
+		if notice != "" && time.Since(noticeAt) < 10*time.Second {
+			parts = append(parts, notice)
+		}
+		if control != nil && control.status != "" {
+			parts = append(parts, "control: "+control.status)
+		}
+		warning := hostWarnings(hosts)
+		if warning != "" {
+			parts = append(parts, "WARNING: "+warning)
+		}
+		if collectorTable != nil {
+			updateCollectorTable(collectorTable, collectors)
+		}
+		if showInfo {
+			updatePeaksTable(peaksTable, hosts, multiHost)
+		}
+		if showFans {
+			fans.update(hosts, multiHost)
+		}
+		if showStatic {
+			static.update(hosts, multiHost)
+		}
+		if showOverlay {
+			overlay.update(hosts, chartMetric, multiHost)
+		}
+		if showVRAM {
+			vram.update(hosts, multiHost)
+		}
+		if showEvents {
+			updateEventTable(eventTable, hosts, multiHost)
+		}
+		footer.Text = strings.Join(parts, "  ")
+		// Quiet mode keeps the footer for what needs attention
+		if quiet {
+			footer.Text = ""
+			if warning != "" {
+				footer.Text = "WARNING: " + warning
+			}
+		}
+	}
+	// processSample updates a host's charts, the process list and sinks from one sample
+	processSample := func(h *hostView, sample Sample, err error) {
+		if err == nil {
+			if multiHost {
+				h.last = sample
+				if !h.reachable {
+					h.setReachable(true)
+				}
+			}
+			sample.GPUs = h.caps.apply(sample.GPUs, cfg.Capabilities)
+			h.events.observeGPUs(sample.Time, h.lastGPUs, sample.GPUs)
+			h.events.observeProcesses(sample.Time, sample.Processes, collectProcesses.Load() && sample.ProcessErr == nil)
+			h.lastGPUs, h.fans, h.fanErr = sample.GPUs, sample.Fans, sample.FanErr
+			bench.observe(sample)
+			if sample.ProcessErr == nil {
+				h.processes = sample.Processes
+				h.addProcesses(sample.Time, sample.Processes)
+			}
+			// GPUs can appear later: hot-attached, or on a host that was down at startup
+			if h.addGPUs(gpuIDs(sample.GPUs), dataPoints, termWidth, multiHost) {
+				buildGrid()
+			}
+			h.restoreHistory(sample.GPUs)
+			var perGPU map[int]gpuProcesses
+			if collectProcesses.Load() && sample.ProcessErr == nil {
+				perGPU = summarizeProcesses(sample.Processes)
+			}
+			for _, metric := range sample.GPUs {
+				i, ok := h.slot(metric.ID)
+				if !ok {
+					continue
+				}
+				// Add new utilization data
+				h.record(i, sample.Time, metric)
+				processes := -1
+				if perGPU != nil {
+					processes = perGPU[metric.ID].count
+				}
+				idle := h.updateIdle(metric, processes, sample.Time, cfg.Idle)
+				// Update chart data in order, right-aligned
+				h.redraw(i, chartMetric)
+				// Update title, add current utilization
+				session := h.addSession(sample.Time, metric)
+				h.charts[i].Title = h.chartTitle(metric, session.avgUtil(), multiHost)
+				if h.clockLimited(metric, cfg.ClockLimit) && !quiet {
+					h.charts[i].Title += " clock-limited"
+				}
+				h.charts[i].TitleStyle, h.charts[i].BorderStyle = chartStyle(metric, cfg.Thresholds.forGPU(metric.ID, metric.BDF, metric.ASIC), idle)
+				if metric.Partial && !quiet {
+					h.charts[i].Title += " (partial data)"
+				}
+				if perGPU != nil && !quiet {
+					h.charts[i].Title += " │ " + perGPU[metric.ID].String()
+				}
+				h.charts[i].Title = fitTitle(h.charts[i].Title, h.charts[i].Dx())
+			}
+		} else if multiHost && h.reachable {
+			h.setReachable(false)
+		}
+		// Update process list; its order holds while a dialog is open
+		procView.hold = control.confirming() || help.shown
+		procView.setGPUTotals(hosts)
+		if multiHost {
+			if sample.ProcessErr == nil {
+				h.last.Processes = sample.Processes
+			}
+			var processes []ProcessInfo
+			for _, host := range hosts {
+				if host.reachable {
+					processes = append(processes, host.last.Processes...)
+				}
+			}
+			procView.update(processes)
+		} else if sample.ProcessErr == nil {
+			procView.update(sample.Processes)
+		}
+		// Feed the sinks; a failing disk or network only produces a warning
+		h.warning = ""
+		if errors.Is(err, errRemoteDown) {
+			h.warning = fmt.Sprintf("connection to %s lost, reconnecting…", h.name)
+		}
+		if err == nil {
+			if serr := writeSinks(sinks, sample); serr != nil {
+				h.warning = h.titlePrefix(multiHost) + serr.Error()
+			}
+			if alerter != nil {
+				h.markAlerts(alerter, sample.GPUs, quiet)
+				h.events.observeAlerts(sample.Time, alerter, h.name, sample.GPUs)
+			}
+		}
+	}
+	// rebuildFromReplay refills the charts after a seek
+	rebuildFromReplay := func() {
+		h := hosts[0]
+		h.resetHistory()
+		// Averages restart at the new position rather than counting samples twice
+		h.resetSession()
+		window := replay.window(dataPoints)
+		for j, sample := range window {
+			if j == len(window)-1 {
+				processSample(h, sample, nil)
+				break
+			}
+			for _, metric := range sample.GPUs {
+				if i, ok := h.slot(metric.ID); ok {
+					h.record(i, sample.Time, metric)
+				}
+			}
+		}
+		for i := range h.charts {
+			h.redraw(i, chartMetric)
+		}
+	}
+	interval := cfg.Interval
+	if replay != nil {
+		// Poll the virtual clock often so fast playback stays smooth
+		interval = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
+	// Collection runs in sampler goroutines so a hung amd-smi never blocks
+	// keys like 'q'. Quitting doesn't wait for them: they only talk to this
+	// loop, and an exec stuck until its timeout would hold up the exit.
+	hostSamples := make(chan hostSample)
+	if replay == nil {
+		stop := make(chan struct{})
+		for _, h := range hosts {
+			go runHostSampler(h, interval, hostSamples, stop)
+		}
+		defer close(stop)
+	}
 	uiEvents := ui.PollEvents()
+	var probe keyProbe
+	// Alerts ring the terminal bell once no input is open, so a keystroke
+	// typed into the filter isn't met by a flash
+	bell := &terminalBell{out: os.Stdout}
+	defer func() {
+		if bell.flashed != nil {
+			bell.endFlash()
+		}
+	}()
+	ringBells := func() {
+		if alerter != nil && !filterIn.active && !control.confirming() {
+			bell.ring(alerter.takeBells())
+		}
+	}
+	// Treat SIGTERM and a closed terminal (SIGHUP) like 'q' so deferred
+	// log flushing still happens
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	// Registered last so it runs first: the terminal has to be back to
+	// normal before the panic and its stack are printed
+	defer func() {
+		if r := recover(); r != nil {
+			shutdown()
+			debugLog.Error("panic in the UI loop", "err", r)
+			fmt.Fprintf(os.Stderr, "mi-top: panic: %v\n\n%s", r, debug.Stack())
+			exitCode = 2
+		}
+	}()
 	for {
 		select {
+		case sig := <-sigCh:
+			debugLog.Info("shutting down", "signal", sig)
+			return
+		case <-bell.flashed:
+			bell.endFlash()
 		case e := <-uiEvents:
-			switch e.ID {
-			case "q", "<C-c>":
-				return
-			case "<Resize>":
+			if e.Type == ui.ResizeEvent {
 				payload := e.Payload.(ui.Resize)
-				newDataPoints := calculateDataPoints(payload.Width)
+				debugLog.Debug("terminal resized", "width", payload.Width, "height", payload.Height)
+				dataPoints = historyPoints(calculateDataPoints(payload.Width), cfg.ChartHistory, cfg.Interval)
+				termWidth = payload.Width
 				// Update number of data points for each chart
-				for i := 0; i < numGPUs; i++ {
-					newHistory := newGPUHistory(newDataPoints)
-					// Copy existing data to new history
-					oldData := gpuHistories[i].getData()
-					for _, v := range oldData {
-						newHistory.add(v)
+				for _, h := range hosts {
+					h.resize(dataPoints, payload.Width, chartMetric)
+				}
+				layout(grid, payload.Width, payload.Height)
+				if control != nil {
+					control.layout(payload.Width, payload.Height)
+				}
+				help.layout(payload.Width, payload.Height)
+				bench.layout(payload.Width, payload.Height)
+				ui.Clear()
+				render()
+				continue
+			}
+			if e.Type != ui.KeyboardEvent {
+				continue
+			}
+			probe.observe(e.ID, time.Now())
+			// Keys go through the keymap; any key closes the help or a run report
+			if help.shown || bench.shown {
+				help.shown, bench.shown = false, false
+				ui.Clear()
+				render()
+				continue
+			}
+			// While the filter line is open, keys are typed into it and the
+			// list follows every change
+			if filterIn.active {
+				if filterIn.handleKey(e.ID) {
+					filterIn.err = procView.setFilter(filterIn.text)
+					procView.refresh()
+				}
+				render()
+				continue
+			}
+			if replay != nil {
+				handled := true
+				switch keyMap.replayAction(e.ID) {
+				case actionPause:
+					replay.togglePause()
+				case actionFaster:
+					replay.faster()
+				case actionSlower:
+					replay.slower()
+				case actionBack:
+					replay.seek(-10 * time.Second)
+					rebuildFromReplay()
+				case actionForward:
+					replay.seek(10 * time.Second)
+					rebuildFromReplay()
+				default:
+					handled = false
+				}
+				if handled {
+					updateFooter()
+					render()
+					continue
+				}
+			}
+			if control.confirming() {
+				control.handleEvent(e)
+				updateFooter()
+				render()
+				continue
+			}
+			action := keyMap.action(e.ID)
+			if showFans && fans.handleEvent(e, action) || showStatic && static.handleEvent(e, action) {
+				updateFooter()
+				render()
+				continue
+			}
+			switch action {
+			case actionQuit:
+				debugLog.Info("quit requested", "key", e.ID)
+				return
+			case actionHelp:
+				help.shown = true
+				render()
+			case actionProcesses:
+				// Re-enabling takes effect from the next collection
+				collectProcesses.Store(!collectProcesses.Load())
+				debugLog.Info("process collection toggled", "enabled", collectProcesses.Load())
+				if !collectProcesses.Load() {
+					procView.update(nil)
+				}
+				buildGrid()
+				ui.Clear()
+				render()
+			case actionReset:
+				// Session totals restart; they show from the next sample
+				for _, h := range hosts {
+					h.resetSession()
+				}
+				debugLog.Info("session reset")
+				updateFooter()
+				render()
+			case actionFans:
+				showFans, showInfo, showStatic, showVRAM, showEvents = !showFans, false, false, false, false
+				collectFans.Store(showFans)
+				updateFooter()
+				buildGrid()
+				ui.Clear()
+				render()
+			case actionInfo:
+				showInfo, showFans, showStatic, showVRAM, showEvents = !showInfo, false, false, false, false
+				collectFans.Store(false)
+				updateFooter()
+				buildGrid()
+				ui.Clear()
+				render()
+			case actionStatic:
+				showStatic, showInfo, showFans, showVRAM, showEvents = !showStatic, false, false, false, false
+				collectFans.Store(false)
+				updateFooter()
+				buildGrid()
+				ui.Clear()
+				render()
+			case actionBench:
+				if run := bench.toggle(); run != nil {
+					if err := bench.writeRunLog(run); err != nil {
+						notice, noticeAt = "WARNING: "+err.Error(), time.Now()
 					}
-					gpuHistories[i] = newHistory
-					gpuCharts[i].SetRect(0, 0, payload.Width, 10)
-					gpuCharts[i].Sparklines[0].Data = make([]float64, newDataPoints)
+					bench.show(run, multiHost)
+					bench.layout(ui.TerminalDimensions())
 				}
-				grid.SetRect(0, 0, payload.Width, payload.Height)
+				updateFooter()
+				render()
+			case actionQuiet:
+				quiet = !quiet
+				procView.setQuiet(quiet)
+				updateFooter()
+				buildGrid()
 				ui.Clear()
-				ui.Render(grid)
+				render()
+			case actionSmooth:
+				for _, h := range hosts {
+					if h.smoothing > 0 {
+						h.smoothing = 0
+					} else {
+						h.smoothing = smoothing
+					}
+					for i := range h.charts {
+						h.redraw(i, chartMetric)
+					}
+				}
+				render()
+			case actionDownsample:
+				for _, h := range hosts {
+					h.downsample = h.downsample.next()
+					for i := range h.charts {
+						h.redraw(i, chartMetric)
+					}
+				}
+				render()
+			case actionMetric:
+				// Memory activity shows bandwidth-bound work that GFX
+				// utilization hides; HBM is often the first to throttle
+				chartMetric = (chartMetric + 1) % len(chartMetrics)
+				for _, h := range hosts {
+					for i := range h.charts {
+						h.redraw(i, chartMetric)
+					}
+				}
+				if showOverlay {
+					overlay.update(hosts, chartMetric, multiHost)
+				}
+				render()
+			case actionVRAM:
+				showVRAM, showInfo, showFans, showStatic, showEvents = !showVRAM, false, false, false, false
+				collectFans.Store(false)
+				updateFooter()
+				buildGrid()
+				ui.Clear()
+				render()
+			case actionEvents:
+				showEvents, showInfo, showFans, showStatic, showVRAM = !showEvents, false, false, false, false
+				collectFans.Store(false)
+				updateFooter()
+				buildGrid()
+				ui.Clear()
+				render()
+			case actionExport:
+				if path, err := procView.export(".", time.Now()); err != nil {
+					notice = err.Error()
+				} else {
+					notice = "processes saved to " + path
+				}
+				noticeAt = time.Now()
+				debugLog.Info("process export", "result", notice)
+				updateFooter()
+				render()
+			case actionCopy:
+				notice = "no process selected"
+				if p, ok := procView.selected(); ok && collectProcesses.Load() {
+					notice = "copied"
+					if err := copyToClipboard(clipboardText(p)); err != nil {
+						notice = err.Error()
+					}
+				}
+				noticeAt = time.Now()
+				updateFooter()
+				render()
+			case actionOverlay:
+				showOverlay = !showOverlay
+				updateFooter()
+				buildGrid()
+				ui.Clear()
+				render()
+			case actionFilter:
+				filterIn.active, filterIn.text, filterIn.err = true, procView.filter.pattern, nil
+				render()
 			default:
-				handleProcessListEvents(e)
+				if procView.handleEvent(action) {
+					procView.refresh()
+					render()
+				}
+			}
+		case r := <-controlResults:
+			control.finish(r)
+			updateFooter()
+			render()
+		case hs := <-hostSamples:
+			if hs.static != nil {
+				hs.host.static = hs.static
 			}
+			processSample(hs.host, hs.sample, hs.err)
+			if hs.host.order(gpuOrder, time.Now()) {
+				buildGrid()
+				ui.Clear()
+			}
+			updateFooter()
+			render()
+			ringBells()
 		case <-ticker.C:
-			// Update metrics
-			metrics, err := getGPUMetrics()
-			if err == nil {
-				for i, metric := range metrics {
-					if i >= len(gpuCharts) {
-						break
-					}
-					// Add new utilization data
-					gpuHistories[i].add(metric.GFXUtil)
-					// Update chart data using getData() to get correct order
-					gpuCharts[i].Sparklines[0].Data = gpuHistories[i].getData()
-					gpuCharts[i].Sparklines[0].MaxVal = 100
-					// Update title, add current utilization
-					gpuCharts[i].Title = fmt.Sprintf("GPU %d - %0.1fW, %0.1f°C, %0.1f%% Util, VRAM: %0.0f/%0.0f MB",
-						metric.ID, metric.Power, metric.GPUTemp, metric.GFXUtil, metric.VRAMUsed, metric.VRAMTotal)
-				}
+			if replay == nil {
+				continue
+			}
+			samples := replay.advance(time.Now())
+			for _, sample := range samples {
+				processSample(hosts[0], sample, nil)
+			}
+			if len(samples) > 0 && hosts[0].order(gpuOrder, samples[len(samples)-1].Time) {
+				buildGrid()
+				ui.Clear()
 			}
-			// Update process list
-			processes, err := getProcessInfo()
-			if err == nil {
-				updateProcessList(processes)
+			if len(samples) > 0 || footer.Text == "" {
+				updateFooter()
+				render()
 			}
-			ui.Render(grid)
+			ringBells()
 		}
 	}
 }
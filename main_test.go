@@ -0,0 +1,81 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestShutdownOnce ends a session from 'q', a signal and a panic at the
+// same time and again afterwards: the terminal is restored and the summary
+// written exactly once, in that order
+func TestShutdownOnce(t *testing.T) {
+	var mu sync.Mutex
+	var ran []string
+	step := func(name string) func() {
+		return func() {
+			mu.Lock()
+			defer mu.Unlock()
+			ran = append(ran, name)
+		}
+	}
+	shutdown := shutdownOnce(step("restore terminal"), step("summary"))
+
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			shutdown()
+		}()
+	}
+	// A panic recovered the way main does it
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				shutdown()
+			}
+		}()
+		panic("render loop")
+	}()
+	wg.Wait()
+	// The deferred call in main comes last
+	shutdown()
+
+	if want := []string{"restore terminal", "summary"}; !slices.Equal(ran, want) {
+		t.Errorf("ran %q, want %q", ran, want)
+	}
+}
+
+// TestShutdownOnceWaits keeps a second caller from going on, e.g. to print
+// a panic, before the terminal is back to normal
+func TestShutdownOnceWaits(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	restored := false
+	shutdown := shutdownOnce(func() {
+		close(started)
+		<-release
+		restored = true
+	})
+	go shutdown()
+	<-started
+	done := make(chan struct{})
+	go func() {
+		shutdown()
+		close(done)
+	}()
+	// Give the second call time to get into shutdown and wait there
+	select {
+	case <-done:
+		t.Fatal("second call returned while the first was restoring the terminal")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-done
+	if !restored {
+		t.Error("second call returned before the terminal was restored")
+	}
+}
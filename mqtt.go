@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal MQTT 3.1.1 publisher: QoS 0 retained publishes, keepalive pings
+// and reconnects. Enough for Home Assistant and most home-lab brokers.
+
+const (
+	mqttKeepAlive    = 30 * time.Second
+	mqttDialTimeout  = 5 * time.Second
+	mqttWriteTimeout = 5 * time.Second
+	mqttMaxBackoff   = time.Minute
+)
+
+type mqttConfig struct {
+	broker          string
+	topicPrefix     string
+	discoveryPrefix string
+	username        string
+	password        string
+	tls             bool
+	interval        time.Duration
+}
+
+// mqttBrokerAddr accepts host:port, tcp://, mqtt://, ssl://, tls:// and mqtts:// URLs
+func mqttBrokerAddr(broker string, forceTLS bool) (string, bool, error) {
+	useTLS := forceTLS
+	if strings.Contains(broker, "://") {
+		u, err := url.Parse(broker)
+		if err != nil {
+			return "", false, fmt.Errorf("invalid --mqtt-broker: %v", err)
+		}
+		switch u.Scheme {
+		case "tcp", "mqtt":
+		case "ssl", "tls", "mqtts":
+			useTLS = true
+		default:
+			return "", false, fmt.Errorf("invalid --mqtt-broker scheme %q", u.Scheme)
+		}
+		broker = u.Host
+	}
+	if _, _, err := net.SplitHostPort(broker); err != nil {
+		port := "1883"
+		if useTLS {
+			port = "8883"
+		}
+		broker = net.JoinHostPort(broker, port)
+	}
+	return broker, useTLS, nil
+}
+
+func mqttString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// mqttPacket prepends the fixed header with the variable length encoding
+func mqttPacket(header byte, body []byte) []byte {
+	pkt := []byte{header}
+	n := len(body)
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		pkt = append(pkt, digit)
+		if n == 0 {
+			break
+		}
+	}
+	return append(pkt, body...)
+}
+
+type mqttConn struct {
+	conn net.Conn
+	mu   sync.Mutex
+}
+
+func mqttConnect(addr string, useTLS bool, clientID, username, password string) (*mqttConn, error) {
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: mqttDialTimeout}
+	if useTLS {
+		host, _, _ := net.SplitHostPort(addr)
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var flags byte = 0x02 // clean session
+	var body []byte
+	body = mqttString(body, "MQTT")
+	body = append(body, 4) // protocol level 3.1.1
+	if username != "" {
+		flags |= 0x80
+		if password != "" {
+			flags |= 0x40
+		}
+	}
+	body = append(body, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(mqttKeepAlive/time.Second))
+	body = mqttString(body, clientID)
+	if username != "" {
+		body = mqttString(body, username)
+		if password != "" {
+			body = mqttString(body, password)
+		}
+	}
+	conn.SetDeadline(time.Now().Add(mqttDialTimeout))
+	if _, err := conn.Write(mqttPacket(0x10, body)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ack := make([]byte, 4)
+	if _, err := io.ReadFull(conn, ack); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("no CONNACK: %v", err)
+	}
+	if ack[0] != 0x20 || ack[3] != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("broker refused connection (code %d)", ack[3])
+	}
+	conn.SetDeadline(time.Time{})
+	c := &mqttConn{conn: conn}
+	// Drain PINGRESPs; a read error means the connection is gone
+	go io.Copy(io.Discard, bufio.NewReader(conn))
+	return c, nil
+}
+
+func (c *mqttConn) write(pkt []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(mqttWriteTimeout))
+	_, err := c.conn.Write(pkt)
+	return err
+}
+
+func (c *mqttConn) publish(topic string, payload []byte, retain bool) error {
+	header := byte(0x30)
+	if retain {
+		header |= 0x01
+	}
+	body := mqttString(nil, topic)
+	return c.write(mqttPacket(header, append(body, payload...)))
+}
+
+func (c *mqttConn) ping() error {
+	return c.write([]byte{0xC0, 0x00})
+}
+
+func (c *mqttConn) close() {
+	c.write([]byte{0xE0, 0x00})
+	c.conn.Close()
+}
+
+// haSensor is a Home Assistant MQTT discovery payload
+type haSensor struct {
+	Name              string   `json:"name"`
+	UniqueID          string   `json:"unique_id"`
+	StateTopic        string   `json:"state_topic"`
+	ValueTemplate     string   `json:"value_template"`
+	UnitOfMeasurement string   `json:"unit_of_measurement,omitempty"`
+	DeviceClass       string   `json:"device_class,omitempty"`
+	StateClass        string   `json:"state_class"`
+	Device            haDevice `json:"device"`
+}
+
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+var haSensors = []struct {
+	field, name, unit, class string
+}{
+	{"gfx_util", "Utilization", "%", ""},
+	{"power", "Power", "W", "power"},
+	{"gpu_temp", "Temperature", "°C", "temperature"},
+	{"mem_temp", "Memory temperature", "°C", "temperature"},
+	{"gfx_clock", "GFX clock", "MHz", "frequency"},
+	{"vram_used", "VRAM used", "MB", "data_size"},
+}
+
+// mqttState is the retained per-GPU state message
+type mqttState struct {
+	GPUMetrics
+	Processes int       `json:"processes"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// MQTTSink publishes the latest sample on its own interval from a
+// background goroutine, reconnecting with backoff when the broker drops.
+type MQTTSink struct {
+	cfg     mqttConfig
+	addr    string
+	useTLS  bool
+	host    string
+	stop    chan struct{}
+	done    chan struct{}
+	mu      sync.Mutex
+	latest  Sample
+	lastErr error
+}
+
+func newMQTTSink(cfg mqttConfig) (*MQTTSink, error) {
+	addr, useTLS, err := mqttBrokerAddr(cfg.broker, cfg.tls)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.interval <= 0 {
+		return nil, errors.New("--mqtt-interval must be positive")
+	}
+	s := &MQTTSink{
+		cfg:    cfg,
+		addr:   addr,
+		useTLS: useTLS,
+		host:   hostname(),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *MQTTSink) Write(sample Sample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latest = sample
+	return s.lastErr
+}
+
+func (s *MQTTSink) Close() error {
+	close(s.stop)
+	<-s.done
+	return nil
+}
+
+func (s *MQTTSink) setErr(err error) {
+	s.mu.Lock()
+	s.lastErr = err
+	s.mu.Unlock()
+}
+
+func (s *MQTTSink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.cfg.interval)
+	defer ticker.Stop()
+	pinger := time.NewTicker(mqttKeepAlive / 2)
+	defer pinger.Stop()
+	var conn *mqttConn
+	var nextDial time.Time
+	backoff := time.Second
+	announced := map[string]bool{}
+	drop := func(err error) {
+		s.setErr(fmt.Errorf("mqtt: %v", err))
+		if conn != nil {
+			conn.conn.Close()
+			conn = nil
+		}
+	}
+	for {
+		select {
+		case <-s.stop:
+			if conn != nil {
+				conn.close()
+			}
+			return
+		case <-pinger.C:
+			if conn != nil {
+				if err := conn.ping(); err != nil {
+					drop(err)
+				}
+			}
+		case <-ticker.C:
+			s.mu.Lock()
+			sample := s.latest
+			s.mu.Unlock()
+			if sample.Time.IsZero() {
+				continue
+			}
+			if conn == nil {
+				if time.Now().Before(nextDial) {
+					continue
+				}
+				c, err := mqttConnect(s.addr, s.useTLS, "mi-top-"+s.host, s.cfg.username, s.cfg.password)
+				if err != nil {
+					drop(err)
+					nextDial = time.Now().Add(backoff)
+					backoff = min(backoff*2, mqttMaxBackoff)
+					continue
+				}
+				conn = c
+				backoff = time.Second
+				// Discovery is retained, but re-announce after a reconnect in
+				// case the broker lost its retained messages
+				announced = map[string]bool{}
+			}
+			if err := s.publish(conn, sample, announced); err != nil {
+				drop(err)
+				continue
+			}
+			s.setErr(nil)
+		}
+	}
+}
+
+func (s *MQTTSink) publish(conn *mqttConn, sample Sample, announced map[string]bool) error {
+	host := sampleHost(sample, s.host)
+	processes := map[int]int{}
+	for _, p := range sample.Processes {
+		processes[p.GPU]++
+	}
+	for _, m := range sample.GPUs {
+		base := fmt.Sprintf("%s/%s/gpu%d", s.cfg.topicPrefix, host, m.ID)
+		stateTopic := base + "/state"
+		if s.cfg.discoveryPrefix != "" && !announced[base] {
+			if err := s.announce(conn, host, m.GPUDevice, stateTopic); err != nil {
+				return err
+			}
+			announced[base] = true
+		}
+		payload, err := json.Marshal(mqttState{GPUMetrics: m, Processes: processes[m.ID], Timestamp: zoned(sample.Time)})
+		if err != nil {
+			return err
+		}
+		if err := conn.publish(stateTopic, payload, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MQTTSink) announce(conn *mqttConn, host string, gpu GPUDevice, stateTopic string) error {
+	objectID := strings.NewReplacer(".", "_", "-", "_").Replace(fmt.Sprintf("mitop_%s_gpu%d", host, gpu.ID))
+	device := haDevice{
+		Identifiers:  []string{objectID},
+		Name:         host + " " + gpu.name(),
+		Manufacturer: "AMD",
+		Model:        "mi-top",
+	}
+	for _, sensor := range haSensors {
+		payload, err := json.Marshal(haSensor{
+			Name:              sensor.name,
+			UniqueID:          objectID + "_" + sensor.field,
+			StateTopic:        stateTopic,
+			ValueTemplate:     "{{ value_json." + sensor.field + " }}",
+			UnitOfMeasurement: sensor.unit,
+			DeviceClass:       sensor.class,
+			StateClass:        "measurement",
+			Device:            device,
+		})
+		if err != nil {
+			return err
+		}
+		topic := fmt.Sprintf("%s/sensor/%s_%s/config", s.cfg.discoveryPrefix, objectID, sensor.field)
+		if err := conn.publish(topic, payload, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// notifyUrgency maps alert severities to notify-send urgency levels
+var notifyUrgency = map[string]string{
+	"info":     "low",
+	"warning":  "normal",
+	"critical": "critical",
+}
+
+// desktopNotifier shows alerts through notify-send. When the binary is
+// missing the notifier disables itself and reports that exactly once.
+type desktopNotifier struct {
+	path     string
+	disabled error
+	reported bool
+}
+
+func newDesktopNotifier() *desktopNotifier {
+	path, err := exec.LookPath("notify-send")
+	if err != nil {
+		return &desktopNotifier{disabled: fmt.Errorf("desktop notifications disabled: notify-send not found")}
+	}
+	return &desktopNotifier{path: path}
+}
+
+func (d *desktopNotifier) send(e AlertEvent) {
+	if d.disabled != nil {
+		return
+	}
+	summary := fmt.Sprintf("mi-top: %s %s %s", GPUDevice{ID: e.GPU, Alias: e.Alias}.name(), e.Rule, e.State)
+	body := fmt.Sprintf("%s on %s: %s is %.1f (threshold %g)", e.Severity, e.Hostname, e.Metric, e.Value, e.Threshold)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, d.path, "--app-name=mi-top", "--urgency="+notifyUrgency[e.Severity], summary, body)
+		if err := cmd.Run(); err != nil {
+			debugLog.Warn("notify-send failed", "err", err)
+		}
+	}()
+}
+
+func (d *desktopNotifier) err() error {
+	if d.disabled == nil || d.reported {
+		return nil
+	}
+	d.reported = true
+	debugLog.Warn("desktop notifications disabled", "err", d.disabled)
+	return d.disabled
+}
+
+func (d *desktopNotifier) close() {}
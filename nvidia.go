@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// nvidiaGPUQuery lists the nvidia-smi fields in the order parseNVIDIAGPUs
+// reads them. NVIDIA reports no hotspot temperature; the GPU temperature
+// stands in for it.
+var nvidiaGPUQuery = []string{"index", "pci.bus_id", "uuid", "name", "power.draw", "temperature.gpu", "temperature.memory",
+	"utilization.gpu", "clocks.gr", "utilization.memory", "clocks.mem", "memory.used", "memory.total"}
+
+// addNVIDIA appends the host's NVIDIA GPUs and their processes to a
+// sample, numbered from base. NVIDIA has no GTT; those processes show it
+// as unavailable.
+func addNVIDIA(r commandRunner, s *Sample, base int) error {
+	out, err := runCommand(r, "nvidia-smi", "--query-gpu="+strings.Join(nvidiaGPUQuery, ","), "--format=csv,noheader,nounits")
+	if err != nil {
+		return fmt.Errorf("failed to execute nvidia-smi: %w", err)
+	}
+	gpus := parseNVIDIAGPUs(out, base)
+	s.GPUs = append(s.GPUs, gpus...)
+	if !collectProcesses.Load() || s.ProcessErr != nil {
+		return nil
+	}
+	out, err = runCommand(r, "nvidia-smi", "--query-compute-apps=pid,process_name,used_memory,gpu_bus_id", "--format=csv,noheader,nounits")
+	if err != nil {
+		return fmt.Errorf("failed to list NVIDIA processes: %w", err)
+	}
+	s.Processes = append(s.Processes, parseNVIDIAProcesses(out, gpus)...)
+	return nil
+}
+
+// nvidiaBDF shortens nvidia-smi's bus ID, e.g. 00000000:01:00.0, to the
+// form amd-smi and sysfs use, 0000:01:00.0
+func nvidiaBDF(id string) string {
+	id = strings.ToLower(strings.TrimSpace(id))
+	if domain, rest, ok := strings.Cut(id, ":"); ok && len(domain) > 4 {
+		id = domain[len(domain)-4:] + ":" + rest
+	}
+	return id
+}
+
+// nvidiaValue reads a number, or 0 and false for [N/A] and [Not Supported]
+func nvidiaValue(field string) (float64, bool) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+	return v, err == nil
+}
+
+// parseNVIDIAGPUs reads the --query-gpu CSV. Memory is in MiB, power in
+// watts and clocks in MHz already. A row missing a value is partial.
+func parseNVIDIAGPUs(out []byte, base int) []GPUMetrics {
+	var gpus []GPUMetrics
+	for _, record := range nvidiaRecords(out) {
+		if len(record) < len(nvidiaGPUQuery) {
+			debugLog.Warn("nvidia-smi: skipping short row", "fields", len(record), "want", len(nvidiaGPUQuery))
+			continue
+		}
+		index, err := strconv.Atoi(strings.TrimSpace(record[0]))
+		if err != nil {
+			debugLog.Debug("nvidia-smi: skipping row without index", "record", record)
+			continue
+		}
+		m := GPUMetrics{GPUDevice: GPUDevice{ID: base + index, Vendor: vendorNVIDIA, BDF: nvidiaBDF(record[1]),
+			UUID: strings.TrimSpace(record[2]), ASIC: strings.TrimSpace(record[3]), Caps: capMedia}}
+		for i, field := range []*float64{&m.Power, &m.GPUTemp, &m.MemTemp, &m.GFXUtil, &m.GFXClock,
+			&m.MemUtil, &m.MemClock, &m.VRAMUsed, &m.VRAMTotal} {
+			v, ok := nvidiaValue(record[4+i])
+			switch {
+			// Most cards have no memory sensor, which reads as no HBM
+			case !ok && field != &m.MemTemp:
+				m.Partial = true
+			case ok && field == &m.Power:
+				m.Caps |= capPower
+			case ok && field == &m.MemTemp:
+				m.Caps |= capMemTemp
+			case ok && field == &m.MemUtil:
+				m.Caps |= capMemBusy
+			}
+			*field = v
+		}
+		gpus = append(gpus, m)
+	}
+	return gpus
+}
+
+// parseNVIDIAProcesses reads the --query-compute-apps CSV, joining
+// processes to gpus by bus address
+func parseNVIDIAProcesses(out []byte, gpus []GPUMetrics) []ProcessInfo {
+	var processes []ProcessInfo
+	for _, record := range nvidiaRecords(out) {
+		if len(record) < 4 {
+			continue
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(record[0]))
+		if err != nil {
+			continue
+		}
+		// The name is the only field that may hold the separator
+		n := len(record)
+		name, used, bdf := strings.Join(record[1:n-2], ", "), record[n-2], nvidiaBDF(record[n-1])
+		gpu := -1
+		for _, m := range gpus {
+			if m.BDF == bdf {
+				gpu = m.ID
+			}
+		}
+		if gpu < 0 {
+			debugLog.Debug("nvidia-smi: process on an unknown GPU", "pid", pid, "bus", bdf)
+			continue
+		}
+		mb, _ := nvidiaValue(used)
+		vram := uint64(mb * 1024 * 1024)
+		processes = append(processes, ProcessInfo{GPU: gpu, BDF: bdf, Name: strings.TrimSpace(name), Pid: pid,
+			VRAMBytes: vram, TotalBytes: vram})
+	}
+	return processes
+}
+
+// nvidiaRecords splits nvidia-smi's CSV, whose fields are separated by
+// ", " and never quoted
+func nvidiaRecords(out []byte) [][]string {
+	var records [][]string
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		if line := strings.TrimSpace(string(line)); line != "" {
+			records = append(records, strings.Split(line, ", "))
+		}
+	}
+	return records
+}
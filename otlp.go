@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const otlpMaxBackoff = 5 * time.Minute
+
+// OTLP/HTTP JSON encoding of the metrics data model, limited to gauges
+type otlpAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpDataPoint struct {
+	Attributes   []otlpKeyValue `json:"attributes"`
+	TimeUnixNano string         `json:"timeUnixNano"`
+	AsDouble     float64        `json:"asDouble"`
+}
+
+type otlpMetric struct {
+	Name  string `json:"name"`
+	Unit  string `json:"unit"`
+	Gauge struct {
+		DataPoints []otlpDataPoint `json:"dataPoints"`
+	} `json:"gauge"`
+}
+
+type otlpScopeMetrics struct {
+	Scope struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"scope"`
+	Metrics []*otlpMetric `json:"metrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+func otlpString(key, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: &value}}
+}
+
+func otlpInt(key string, value int) otlpKeyValue {
+	s := strconv.Itoa(value)
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{IntValue: &s}}
+}
+
+// otlpPayload converts one sample to an export request
+func otlpPayload(host string, s Sample) otlpRequest {
+	ts := strconv.FormatInt(s.Time.UnixNano(), 10)
+	var order []string
+	metrics := map[string]*otlpMetric{}
+	add := func(name, unit string, value float64, attrs ...otlpKeyValue) {
+		m, ok := metrics[name]
+		if !ok {
+			m = &otlpMetric{Name: name, Unit: unit}
+			metrics[name] = m
+			order = append(order, name)
+		}
+		m.Gauge.DataPoints = append(m.Gauge.DataPoints, otlpDataPoint{Attributes: attrs, TimeUnixNano: ts, AsDouble: value})
+	}
+	const mb = 1024 * 1024
+	for _, g := range s.GPUs {
+		id := []otlpKeyValue{otlpInt("gpu.id", g.ID)}
+		if g.BDF != "" {
+			id = append(id, otlpString("gpu.pci.bdf", g.BDF))
+		}
+		if g.UUID != "" {
+			id = append(id, otlpString("gpu.uuid", g.UUID))
+		}
+		if g.Serial != "" {
+			id = append(id, otlpString("gpu.serial", g.Serial))
+		}
+		if g.Alias != "" {
+			id = append(id, otlpString("gpu.alias", g.Alias))
+		}
+		add("gpu.utilization", "%", g.GFXUtil, id...)
+		add("gpu.power", "W", g.Power, id...)
+		add("gpu.temperature", "Cel", g.GPUTemp, id...)
+		add("gpu.memory.temperature", "Cel", g.MemTemp, id...)
+		add("gpu.clock.gfx", "MHz", g.GFXClock, id...)
+		add("gpu.memory.utilization", "%", g.MemUtil, id...)
+		add("gpu.clock.memory", "MHz", g.MemClock, id...)
+		add("gpu.vram.used", "By", g.VRAMUsed*mb, id...)
+		add("gpu.vram.total", "By", g.VRAMTotal*mb, id...)
+	}
+	for _, p := range s.Processes {
+		attrs := []otlpKeyValue{otlpInt("gpu.id", p.GPU), otlpInt("process.pid", p.Pid),
+			otlpString("process.executable.name", p.Name)}
+		if p.BDF != "" {
+			attrs = append(attrs, otlpString("gpu.pci.bdf", p.BDF))
+		}
+		if p.GPUAlias != "" {
+			attrs = append(attrs, otlpString("gpu.alias", p.GPUAlias))
+		}
+		for _, mem := range []struct {
+			kind  string
+			value uint64
+		}{{"vram", p.VRAMBytes}, {"gtt", p.GTTBytes}, {"cpu", p.CPUBytes}, {"total", p.TotalBytes}} {
+			add("gpu.process.memory", "By", float64(mem.value), append(attrs, otlpString("memory.type", mem.kind))...)
+		}
+	}
+	var sm otlpScopeMetrics
+	sm.Scope.Name = "mi-top"
+	sm.Scope.Version = Version
+	for _, name := range order {
+		sm.Metrics = append(sm.Metrics, metrics[name])
+	}
+	var rm otlpResourceMetrics
+	rm.Resource.Attributes = []otlpKeyValue{
+		otlpString("host.name", host),
+		otlpString("gpu.vendor", "amd"),
+		otlpString("service.name", "mi-top"),
+	}
+	rm.ScopeMetrics = []otlpScopeMetrics{sm}
+	return otlpRequest{ResourceMetrics: []otlpResourceMetrics{rm}}
+}
+
+// OTLPSink exports gauges to an OpenTelemetry collector using OTLP/HTTP
+// with JSON encoding. Only every interval-th sample is exported; failures
+// back off exponentially and never block the sampler.
+type OTLPSink struct {
+	url    string
+	host   string
+	every  int
+	ticks  int
+	client *http.Client
+	queue  chan Sample
+	done   chan struct{}
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+func newOTLPSink(endpoint string, interval, sampleInterval time.Duration) (*OTLPSink, error) {
+	if interval < sampleInterval || interval%sampleInterval != 0 {
+		return nil, fmt.Errorf("--otlp-interval must be a multiple of the %v sampling interval", sampleInterval)
+	}
+	url := strings.TrimRight(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/metrics") {
+		url += "/v1/metrics"
+	}
+	s := &OTLPSink{
+		url:    url,
+		host:   hostname(),
+		every:  int(interval / sampleInterval),
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan Sample, 1),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *OTLPSink) Write(sample Sample) error {
+	s.ticks++
+	if s.ticks%s.every == 0 {
+		select {
+		case s.queue <- sample:
+		default:
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
+
+func (s *OTLPSink) Close() error {
+	close(s.queue)
+	<-s.done
+	return nil
+}
+
+func (s *OTLPSink) run() {
+	defer close(s.done)
+	var retryAt time.Time
+	backoff := time.Second
+	for sample := range s.queue {
+		if time.Now().Before(retryAt) {
+			continue
+		}
+		err := s.export(sample)
+		if err != nil {
+			debugLog.Warn("otlp export failed", "err", err, "retry", backoff)
+			retryAt = time.Now().Add(backoff)
+			backoff = min(backoff*2, otlpMaxBackoff)
+		} else {
+			backoff = time.Second
+		}
+		s.mu.Lock()
+		s.lastErr = err
+		s.mu.Unlock()
+	}
+}
+
+func (s *OTLPSink) export(sample Sample) error {
+	body, err := json.Marshal(otlpPayload(sampleHost(sample, s.host), sample))
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("OTLP export failed: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("OTLP export failed: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"image"
+
+	ui "github.com/gizak/termui/v3"
+	"github.com/gizak/termui/v3/widgets"
+	"github.com/mattn/go-runewidth"
+)
+
+// overlayPalette gives each GPU's line its own color; past 16 GPUs the
+// colors repeat
+var overlayPalette = []ui.Color{196, 46, 33, 226, 201, 51, 208, 129, 118, 213, 37, 130, 117, 142, 250, 15}
+
+// overlayView plots every GPU's history of the charted metric in one chart
+// ('O'), so imbalance across data-parallel ranks stands out. It belongs to
+// the UI goroutine.
+type overlayView struct {
+	plot   *overlayPlot
+	legend *legend
+}
+
+func newOverlayView() *overlayView {
+	v := &overlayView{plot: &overlayPlot{Plot: *widgets.NewPlot()}, legend: &legend{Block: *ui.NewBlock()}}
+	v.plot.LineColors = overlayPalette
+	v.plot.AxesColor = ui.ColorWhite
+	v.legend.Title = "GPUs"
+	return v
+}
+
+// update copies the histories of the given chart metric, reusing the
+// series buffers between ticks
+func (v *overlayView) update(hosts []*hostView, metric int, multi bool) {
+	m := chartMetrics[metric]
+	label := m.label
+	if label == "" {
+		label = "GFX utilization (%)"
+	}
+	v.plot.Title = "All GPUs: " + label + " (" + keyMap.hint(actionOverlay) + " shows each GPU)"
+	v.plot.MaxVal = m.max
+	series := v.plot.series[:0]
+	v.legend.entries = v.legend.entries[:0]
+	for _, h := range hosts {
+		if h.placeholder {
+			continue
+		}
+		for i, id := range h.ids {
+			var data []float64
+			if n := len(series); n < len(v.plot.series) {
+				data = v.plot.series[n][:0]
+			}
+			data = h.histories[i][metric].appendData(data)
+			// Blank columns (a GPU without the sensor) and values off the
+			// scale would be drawn outside the plot
+			for j, value := range data {
+				data[j] = min(max(value, 0), m.max)
+			}
+			series = append(series, data)
+			v.legend.entries = append(v.legend.entries, legendEntry{
+				label: fmt.Sprintf("%sGPU %d", h.titlePrefix(multi), id),
+				color: overlayPalette[(len(series)-1)%len(overlayPalette)],
+			})
+		}
+	}
+	v.plot.series = series
+}
+
+// overlayPlot keeps the full histories and plots as much of their recent
+// end as fits, which is only known once the grid has sized it
+type overlayPlot struct {
+	widgets.Plot
+	series [][]float64
+}
+
+// plotAxesWidth is the room termui's plot takes for the y axis labels
+const plotAxesWidth = 5
+
+func (p *overlayPlot) Draw(buf *ui.Buffer) {
+	width := p.Inner.Dx() - plotAxesWidth
+	p.Data = p.Data[:0]
+	for _, s := range p.series {
+		// A line needs two points
+		if len(s) >= 2 {
+			p.Data = append(p.Data, s[max(0, len(s)-width):])
+		}
+	}
+	p.Plot.Draw(buf)
+}
+
+// legend maps line colors to GPUs, wrapping entries onto as many lines as
+// the width needs
+type legend struct {
+	ui.Block
+	entries []legendEntry
+}
+
+type legendEntry struct {
+	label string
+	color ui.Color
+}
+
+func (l *legend) Draw(buf *ui.Buffer) {
+	l.Block.Draw(buf)
+	x, y := l.Inner.Min.X, l.Inner.Min.Y
+	for _, e := range l.entries {
+		text := "■ " + e.label
+		w := runewidth.StringWidth(text) + 2
+		if x > l.Inner.Min.X && x+w > l.Inner.Max.X {
+			x, y = l.Inner.Min.X, y+1
+		}
+		if y >= l.Inner.Max.Y {
+			return
+		}
+		buf.SetString(runewidth.Truncate(text, l.Inner.Max.X-x, "…"), ui.NewStyle(e.color), image.Pt(x, y))
+		x += w
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// unattributed is the GPU of a process row whose GPU column amd-smi left
+// blank, until corroborateProcessGPUs finds the right one
+const unattributed = -1
+
+// noPIDGPUs remembers the runners rocm-smi isn't available on, so it isn't
+// tried again every sample
+var noPIDGPUs sync.Map
+
+// pidGPUsUnsupported tells a rocm-smi that is missing, or too old for
+// --showpidgpus, from one that failed this time, e.g. timed out
+func pidGPUsUnsupported(err error) bool {
+	if errors.Is(err, exec.ErrNotFound) {
+		return true
+	}
+	msg := err.Error()
+	// A missing command on a remote host, and argparse's complaint
+	return strings.Contains(msg, "command not found") || strings.Contains(msg, "unknown option") ||
+		strings.Contains(msg, "unrecognized arguments")
+}
+
+// corroborateProcessGPUs checks the GPUs amd-smi attributed processes to
+// against `rocm-smi --showpidgpus`. Some driver versions leave amd-smi's
+// GPU column blank, or put every process on GPU 0. When a sample looks
+// like that, rocm-smi's answer wins for each PID it knows; rows that
+// still have no GPU are dropped, as they were before.
+func corroborateProcessGPUs(r commandRunner, s *Sample) {
+	if !suspectAttribution(s.Processes, len(s.GPUs)) {
+		return
+	}
+	if _, missing := noPIDGPUs.Load(r); !missing {
+		out, err := runCommand(r, "rocm-smi", "--showpidgpus")
+		switch {
+		case err != nil && pidGPUsUnsupported(err):
+			debugLog.Info("rocm-smi unavailable, process GPUs can't be corroborated", "err", err)
+			noPIDGPUs.Store(r, true)
+		case err != nil:
+			debugLog.Debug("rocm-smi failed, trying again next sample", "err", err)
+		default:
+			reattribute(s.Processes, parsePIDGPUs(out))
+		}
+	}
+	s.Processes = slices.DeleteFunc(s.Processes, func(p ProcessInfo) bool {
+		if p.GPU == unattributed {
+			debugLog.Debug("dropping process without GPU", "pid", p.Pid, "name", p.Name)
+			return true
+		}
+		return false
+	})
+}
+
+// suspectAttribution reports whether amd-smi's GPU column can't be taken
+// at its word: a row has none, or with several GPUs every process is on
+// GPU 0
+func suspectAttribution(processes []ProcessInfo, gpus int) bool {
+	if len(processes) == 0 {
+		return false
+	}
+	allZero := true
+	for _, p := range processes {
+		if p.GPU == unattributed {
+			return true
+		}
+		allZero = allZero && p.GPU == 0
+	}
+	return allZero && gpus > 1
+}
+
+// parsePIDGPUs reads `rocm-smi --showpidgpus`, which prints
+//
+//	PID 1234 is using 2 DRM device(s):
+//	0 1
+//
+// for each process, into the GPU indices by PID
+func parsePIDGPUs(out []byte) map[int][]int {
+	gpus := map[int][]int{}
+	pid := 0
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if rest, ok := strings.CutPrefix(line, "PID "); ok {
+			pid = 0
+			if num, _, _ := strings.Cut(rest, " "); strings.Contains(rest, "DRM device") {
+				pid, _ = strconv.Atoi(num)
+			}
+			continue
+		}
+		if pid == 0 {
+			continue
+		}
+		for _, field := range strings.Fields(line) {
+			if id, err := strconv.Atoi(field); err == nil {
+				gpus[pid] = append(gpus[pid], id)
+			}
+		}
+		pid = 0
+	}
+	return gpus
+}
+
+// reattribute moves each PID's rows onto the GPUs rocm-smi lists for it.
+// amd-smi prints a row per GPU a process uses, so a row on a listed GPU
+// keeps it, and the others, including a second row on the same GPU, take
+// the listed GPUs left over in order.
+func reattribute(processes []ProcessInfo, byPID map[int][]int) {
+	rows := map[int][]int{} // row indices by PID
+	for i, p := range processes {
+		rows[p.Pid] = append(rows[p.Pid], i)
+	}
+	for pid, indices := range rows {
+		listed := byPID[pid]
+		if len(listed) == 0 {
+			continue
+		}
+		claimed := map[int]bool{}
+		var moved []int
+		for _, i := range indices {
+			if gpu := processes[i].GPU; slices.Contains(listed, gpu) && !claimed[gpu] {
+				claimed[gpu] = true
+			} else {
+				moved = append(moved, i)
+			}
+		}
+		for _, id := range listed {
+			if claimed[id] || len(moved) == 0 {
+				continue
+			}
+			debugLog.Debug("process GPU corrected by rocm-smi", "pid", pid, "from", processes[moved[0]].GPU, "to", id)
+			processes[moved[0]].GPU, moved = id, moved[1:]
+		}
+	}
+}
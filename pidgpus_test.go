@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// readFixture is a file in testdata
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// processGPUs is the PID and GPU of each process row
+func processGPUs(processes []ProcessInfo) [][2]int {
+	var out [][2]int
+	for _, p := range processes {
+		out = append(out, [2]int{p.Pid, p.GPU})
+	}
+	return out
+}
+
+// TestReattribution collects from four GPUs whose processes amd-smi
+// printed without a GPU, or all on GPU 0, and corrects them with
+// rocm-smi --showpidgpus. PID 31337 is unknown to rocm-smi: without a GPU
+// it is dropped, on GPU 0 it stays there.
+func TestReattribution(t *testing.T) {
+	monitor := monitorHeader + monitorRow(0) + monitorRow(1) + monitorRow(2) + monitorRow(3)
+	for _, tc := range []struct {
+		fixture string
+		rocmSMI bool
+		want    [][2]int
+	}{
+		{"amd_process_blank_gpu.csv", true, [][2]int{{4242, 1}, {4242, 3}, {977, 2}}},
+		{"amd_process_gpu0.csv", true, [][2]int{{4242, 1}, {4242, 3}, {977, 2}, {31337, 0}}},
+		// Without rocm-smi there is nothing to go by
+		{"amd_process_blank_gpu.csv", false, nil},
+		{"amd_process_gpu0.csv", false, [][2]int{{4242, 0}, {4242, 0}, {977, 0}, {31337, 0}}},
+	} {
+		r := &fakeRunner{
+			"amd-smi monitor --csv": monitor,
+			"amd-smi process --csv": readFixture(t, tc.fixture),
+		}
+		if tc.rocmSMI {
+			(*r)["rocm-smi --showpidgpus"] = readFixture(t, "rocm_smi_showpidgpus.txt")
+		}
+		var sample Sample
+		if err := collectSplit(r, &sample); err != nil || sample.ProcessErr != nil {
+			t.Fatalf("%s: %v, %v", tc.fixture, err, sample.ProcessErr)
+		}
+		corroborateProcessGPUs(r, &sample)
+		if got := processGPUs(sample.Processes); !slices.Equal(got, tc.want) {
+			t.Errorf("%s, rocm-smi %v: PID:GPU %v, want %v", tc.fixture, tc.rocmSMI, got, tc.want)
+		}
+		// The rest of each row is left alone
+		for _, p := range sample.Processes {
+			if p.Pid == 977 && (p.Name != "llama-server" || p.VRAMBytes != 8<<30 || p.UsagePercent != 12) {
+				t.Errorf("%s: PID 977 = %+v", tc.fixture, p)
+			}
+		}
+	}
+}
+
+// TestTrustedAttribution leaves GPUs amd-smi spread over several devices
+// alone, without asking rocm-smi
+func TestTrustedAttribution(t *testing.T) {
+	r := &fakeRunner{}
+	sample := Sample{
+		GPUs:      []GPUMetrics{{GPUDevice: GPUDevice{ID: 0}}, {GPUDevice: GPUDevice{ID: 1}}},
+		Processes: []ProcessInfo{{Pid: 1, GPU: 0}, {Pid: 2, GPU: 1}},
+	}
+	corroborateProcessGPUs(r, &sample)
+	if got, want := processGPUs(sample.Processes), [][2]int{{1, 0}, {2, 1}}; !slices.Equal(got, want) {
+		t.Errorf("PID:GPU %v, want %v", got, want)
+	}
+	if _, asked := noPIDGPUs.Load(r); asked {
+		t.Error("rocm-smi was run for a trustworthy sample")
+	}
+	// One GPU has every process on GPU 0 for good reason
+	sample.GPUs, sample.Processes = sample.GPUs[:1], []ProcessInfo{{Pid: 1}, {Pid: 2}}
+	if suspectAttribution(sample.Processes, len(sample.GPUs)) {
+		t.Error("a single GPU's processes are suspect")
+	}
+}
+
+func TestParsePIDGPUs(t *testing.T) {
+	got := parsePIDGPUs([]byte(readFixture(t, "rocm_smi_showpidgpus.txt")))
+	want := map[int][]int{4242: {1, 3}, 977: {2}}
+	if len(got) != len(want) {
+		t.Fatalf("parsed %v, want %v", got, want)
+	}
+	for pid, gpus := range want {
+		if !slices.Equal(got[pid], gpus) {
+			t.Errorf("PID %d on %v, want %v", pid, got[pid], gpus)
+		}
+	}
+}
+
+// flakyRunner fails a command with err the first time it is run
+type flakyRunner struct {
+	*fakeRunner
+	name string
+	err  error
+}
+
+func (r *flakyRunner) Start(name string, args ...string) (io.ReadCloser, error) {
+	if name == r.name && r.err != nil {
+		err := r.err
+		r.err = nil
+		return nil, err
+	}
+	return r.fakeRunner.Start(name, args...)
+}
+
+// TestPIDGPUsRetry gives up on rocm-smi only when it is missing or lacks
+// --showpidgpus, and asks again after any other failure
+func TestPIDGPUsRetry(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		err   error
+		retry bool
+	}{
+		{"timeout", errors.New("rocm-smi timed out after 10s"), true},
+		{"missing", fmt.Errorf("rocm-smi: %w", exec.ErrNotFound), false},
+		{"missing remotely", errors.New("exit status 127: bash: rocm-smi: command not found"), false},
+		{"too old", errors.New("exit status 2: rocm-smi: error: unrecognized arguments: --showpidgpus"), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &flakyRunner{&fakeRunner{
+				"amd-smi monitor --csv":  monitorHeader + monitorRow(0) + monitorRow(1) + monitorRow(2) + monitorRow(3),
+				"amd-smi process --csv":  readFixture(t, "amd_process_gpu0.csv"),
+				"rocm-smi --showpidgpus": readFixture(t, "rocm_smi_showpidgpus.txt"),
+			}, "rocm-smi", tc.err}
+			var got [][2]int
+			for range 2 {
+				var sample Sample
+				if err := collectSplit(r, &sample); err != nil {
+					t.Fatal(err)
+				}
+				corroborateProcessGPUs(r, &sample)
+				got = processGPUs(sample.Processes)
+			}
+			if reattributed := got[0] == [2]int{4242, 1}; reattributed != tc.retry {
+				t.Errorf("second sample PID:GPU %v, want rocm-smi asked again: %v", got, tc.retry)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// plainProcesses is how many processes a plain block lists without --top
+const plainProcesses = 10
+
+// stdoutIsTerminal reports whether termui can draw on stdout
+func stdoutIsTerminal() bool {
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// runPlain prints a text block per host and interval instead of the
+// terminal UI, for `watch`, CI logs and dumb terminals. It feeds the sinks
+// like the UI does and stops after iterations samples (0 runs until
+// SIGTERM or SIGINT).
+func runPlain(w io.Writer, hosts []*hostView, sinks []Sink, interval time.Duration, iterations, top int) {
+	debugLog.Info("plain output started", "hosts", len(hosts), "interval", interval, "iterations", iterations)
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(sigCh)
+	statics := make([]*staticCache, len(hosts))
+	for i, h := range hosts {
+		statics[i] = newStaticCache(h.name, h.runner)
+	}
+	if top <= 0 {
+		top = plainProcesses
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for n := 0; iterations == 0 || n < iterations; n++ {
+		// The first block goes out right away, then one per interval
+		if n > 0 {
+			select {
+			case sig := <-sigCh:
+				debugLog.Info("shutting down", "signal", sig)
+				return
+			case <-ticker.C:
+			}
+		}
+		for i, h := range hosts {
+			sample, err := h.backend.collect(h.runner)
+			sample.Host = h.name
+			if err != nil {
+				fmt.Fprintf(w, "%s%s: failed to get GPU metrics: %v\n\n", h.titlePrefix(len(hosts) > 1), zoned(time.Now()).Format("2006-01-02 15:04:05"), err)
+				continue
+			}
+			statics[i].update(sample, false)
+			statics[i].label(&sample)
+			h.aliases.label(&sample)
+			writePlainSample(w, h.titlePrefix(len(hosts) > 1), sample, top)
+			if err := writeSinks(sinks, sample); err != nil {
+				logError("%v", err)
+			}
+		}
+	}
+}
+
+// writePlainSample prints one sample: the GPU table, then the processes
+// using the most VRAM
+func writePlainSample(w io.Writer, prefix string, s Sample, top int) {
+	fmt.Fprintf(w, "%s%s\n", prefix, zoned(s.Time).Format("2006-01-02 15:04:05"))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "GPU\tUTIL\tPOWER\tTEMP\tHBM\tGFX CLOCK\tMEM BUSY\tVRAM USED\tVRAM TOTAL\t")
+	for _, m := range s.GPUs {
+		// A plain sample has no history to detect capabilities from;
+		// what this reading lacks is N/A
+		power, hbm, busy := "N/A", "N/A", "N/A"
+		if m.Caps.has(capPower) {
+			power = fmt.Sprintf("%.1f W", m.Power)
+		}
+		if m.Caps.has(capMemTemp) && m.hasMemTemp() {
+			hbm = fmt.Sprintf("%.0f°C", m.MemTemp)
+		}
+		if m.Caps.has(capMemBusy) {
+			busy = fmt.Sprintf("%.0f%%", m.MemUtil)
+		}
+		fmt.Fprintf(tw, "%s\t%.1f%%\t%s\t%.0f°C\t%s\t%.0f MHz\t%s\t%.0f MB\t%.0f MB\t\n",
+			plainGPU(m.ID, m.Alias), m.GFXUtil, power, m.GPUTemp, hbm, m.GFXClock, busy, m.VRAMUsed, m.VRAMTotal)
+	}
+	tw.Flush()
+	if s.ProcessErr == nil && len(s.Processes) > 0 {
+		procs := slices.Clone(s.Processes)
+		slices.SortFunc(procs, func(a, b ProcessInfo) int {
+			return cmp.Or(cmp.Compare(b.VRAMBytes, a.VRAMBytes), cmp.Compare(a.Pid, b.Pid))
+		})
+		fmt.Fprintln(w)
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "GPU\tPID\tNAME\tUSAGE\tVRAM\tGTT")
+		for _, p := range procs[:min(top, len(procs))] {
+			gtt := fmt.Sprintf("%.0f MB", mib(p.GTTBytes))
+			if i := slices.IndexFunc(s.GPUs, func(m GPUMetrics) bool { return m.ID == p.GPU }); i >= 0 && !s.GPUs[i].Caps.has(capGTT) {
+				gtt = "n/a"
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%.1f%%\t%.0f MB\t%s\n", plainGPU(p.GPU, p.GPUAlias), p.Pid, p.Name, p.UsagePercent, mib(p.VRAMBytes), gtt)
+		}
+		tw.Flush()
+	}
+	fmt.Fprintln(w)
+}
+
+// plainGPU is a GPU's index, after its alias if it has one
+func plainGPU(id int, alias string) string {
+	if alias == "" {
+		return strconv.Itoa(id)
+	}
+	return fmt.Sprintf("%s (%d)", alias, id)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// rocmBin is where ROCm installs amd-smi when it isn't on the PATH
+const rocmBin = "/opt/rocm/bin"
+
+// preflight is what the startup checks found out about amd-smi
+type preflight struct {
+	version string // amd-smi's own version, empty when it didn't say
+	// noGPUs explains what to check when amd-smi lists no GPU. It isn't
+	// fatal: mi-top waits for one to be attached.
+	noGPUs string
+}
+
+// runPreflight makes sure amd-smi can be used before anything draws on
+// the terminal. Each way it can fail gets an error that says what to do
+// about it, rather than a bare exit status from the first sample.
+func runPreflight(r commandRunner) (preflight, error) {
+	var p preflight
+	if _, err := exec.LookPath("amd-smi"); err != nil {
+		if _, serr := os.Stat(filepath.Join(rocmBin, "amd-smi")); serr == nil {
+			return p, fmt.Errorf("amd-smi is not on the PATH, but ROCm has it in %s: add that to PATH, e.g. export PATH=$PATH:%s", rocmBin, rocmBin)
+		}
+		return p, fmt.Errorf("amd-smi not found: install the amd-smi package that comes with ROCm (amd-smi-lib on Debian and Ubuntu) and make sure it is on the PATH")
+	}
+	// amd-smi may work but see no GPU without access to the devices
+	if problem := devicePermissionProblem(); problem != "" {
+		return p, fmt.Errorf("%s", problem)
+	}
+	out, err := runCommand(r, "amd-smi", "version")
+	if err != nil {
+		return p, fmt.Errorf("amd-smi is installed but `amd-smi version` failed: %v\n"+
+			"The ROCm install is likely incomplete, e.g. amd-smi's Python package or libamd_smi is missing; reinstall amd-smi", err)
+	}
+	p.version = parseSMIVersion(out)
+	debugLog.Info("amd-smi", "version", p.version)
+	out, err = runCommand(r, "amd-smi", "list", "--json")
+	var gpus []json.RawMessage
+	if err == nil {
+		err = json.Unmarshal(out, &gpus)
+	}
+	if err != nil || len(gpus) == 0 {
+		p.noGPUs = "amd-smi lists no GPU: check that the amdgpu driver is loaded (lsmod | grep amdgpu, dmesg | grep amdgpu)"
+		if err != nil {
+			p.noGPUs = fmt.Sprintf("`amd-smi list` failed (%v): check that the amdgpu driver is loaded (lsmod | grep amdgpu, dmesg | grep amdgpu)", err)
+		}
+		debugLog.Warn("no GPUs at preflight", "err", err)
+	}
+	return p, nil
+}
+
+// parseSMIVersion picks the tool version out of `amd-smi version`, which
+// prints e.g. "AMDSMI Tool: 24.6.2+2b02a07 | AMDSMI Library version: 24.6.2.0 | ROCm version: 6.2.0"
+func parseSMIVersion(out []byte) string {
+	text := strings.TrimSpace(string(out))
+	for _, field := range strings.Split(text, "|") {
+		if name, value, ok := strings.Cut(field, ":"); ok && strings.TrimSpace(name) == "AMDSMI Tool" {
+			return strings.TrimSpace(value)
+		}
+	}
+	line, _, _ := strings.Cut(text, "\n")
+	return line
+}
+
+// devicePermissionProblem explains how to get access to the GPU devices
+// when the current user can't open them, the usual reason amd-smi sees no
+// GPU when not run as root
+func devicePermissionProblem() string {
+	paths, _ := filepath.Glob("/dev/dri/renderD*")
+	paths = append([]string{"/dev/kfd"}, paths...)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		// 6 is R_OK|W_OK; the devices are opened read-write
+		if err := syscall.Access(path, 6); err == nil || !os.IsPermission(err) {
+			continue
+		}
+		group := "render"
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			if g, err := user.LookupGroupId(strconv.Itoa(int(st.Gid))); err == nil {
+				group = g.Name
+			}
+		}
+		return fmt.Sprintf("no permission to use %s, which belongs to the %s group: add yourself to it "+
+			"(sudo usermod -aG %s $USER) and log in again", path, group, group)
+	}
+	return ""
+}
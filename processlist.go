@@ -0,0 +1,977 @@
+package main
+
+import (
+	"cmp"
+	"encoding/csv"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	ui "github.com/gizak/termui/v3"
+	"github.com/gizak/termui/v3/widgets"
+	"github.com/mattn/go-runewidth"
+)
+
+var columns = []string{"GPU", "Name", "PID", "Usage", "VRAM", "VRAM%"}
+
+// processListHeaderRows are the header and separator above the data rows
+const processListHeaderRows = 2
+
+// processFreezeIdle is how long after the last move the row order stays
+const processFreezeIdle = 3 * time.Second
+
+// processKey identifies a process across refreshes and re-sorts
+type processKey struct {
+	host  string
+	pid   int
+	group bool // the row of a process tree group, keyed by its root PID
+}
+
+// treeBranch indents the members of an expanded group
+const treeBranch = "  └ "
+
+// processView is the process list widget with its sort and selection. It
+// belongs to the UI goroutine: samplers hand samples to the event loop over
+// channels and never touch it, so it needs no locking.
+type processView struct {
+	list           *processList
+	selectedColumn int
+	sortReverse    bool
+	byBDF          bool // the GPU column sorts by bus address (--sort-gpus=bdf)
+	// limit is how many processes are listed, 0 for all; actionTop switches
+	// between all and topN
+	limit, topN int
+	// keys identifies the process shown on each data row, so the
+	// selection can follow a process across refreshes and re-sorts
+	keys []processKey
+	// expanded holds the process tree groups opened with Enter
+	expanded map[processKey]bool
+	// sorted is the whole list in display order, before --top, for actionExport
+	sorted []ProcessListItem
+	// freeze keeps the row order while the selection was moved in the last
+	// processFreezeIdle or hold is set (a dialog is open); pending means a
+	// re-sort waits for that to end. Changing the sort ends it at once.
+	freeze, hold, pending bool
+	movedAt               time.Time
+	// minVRAM hides processes using less VRAM than this while filterVRAM
+	// is on; they still count in exports and the --top summary
+	minVRAM    uint64
+	filterVRAM bool
+	// filter hides processes that don't match the '/' pattern
+	filter   processFilter
+	cmdlines map[int]string // of local processes, read while filtering
+	last     []ProcessInfo  // the processes of the last update, for refresh
+	// layout is the table's columns, widths their widths as of the last
+	// update. resized holds the widths set with '<' and '>', by column.
+	layout  []tableColumn
+	widths  []int
+	resized map[string]int
+	// Buffers reused between refreshes
+	items, shown []ProcessListItem
+	rows         rowWriter
+	header       string
+	headerWidths []int // column widths the header was built for
+}
+
+func newProcessView(column int, reverse, freeze bool) *processView {
+	v := &processView{selectedColumn: column, sortReverse: reverse, freeze: freeze, expanded: map[processKey]bool{}, resized: map[string]int{}}
+	v.rows.vramTotals, v.rows.gttTotals, v.rows.apus, v.rows.noGTT = map[gpuKey]float64{}, map[gpuKey]uint64{}, map[gpuKey]bool{}, map[gpuKey]bool{}
+	v.layout = newTableLayout(ColumnsConfig{})
+	v.list = &processList{List: *widgets.NewList()}
+	v.list.TextStyle = ui.NewStyle(ui.ColorWhite)
+	v.list.WrapText = false
+	v.list.SelectedRow = 0
+	v.list.BorderStyle = ui.NewStyle(ui.ColorWhite)
+	v.setQuiet(false)
+	v.list.Title = v.title()
+	return v
+}
+
+// setQuiet drops the selected row highlight for quiet mode
+func (v *processView) setQuiet(quiet bool) {
+	v.list.SelectedRowStyle = ui.NewStyle(ui.ColorBlack, ui.ColorGreen)
+	if quiet {
+		v.list.SelectedRowStyle = v.list.TextStyle
+	}
+}
+
+// processList is the list widget with the filter pattern in the title set
+// apart in its own style
+type processList struct {
+	widgets.List
+	pattern string
+}
+
+func (l *processList) Draw(buf *ui.Buffer) {
+	l.List.Draw(buf)
+	if l.pattern == "" {
+		return
+	}
+	if i := strings.LastIndex(l.Title, l.pattern); i >= 0 {
+		x := l.Min.X + 2 + runewidth.StringWidth(l.Title[:i])
+		buf.SetString(l.pattern, ui.NewStyle(ui.ColorBlack, ui.ColorYellow), image.Pt(x, l.Min.Y))
+	}
+}
+
+// ProcessListItem for sorting
+type ProcessListItem struct {
+	gpu   int
+	bdf   string
+	name  string
+	pid   int
+	host  string
+	usage float64
+	vram  uint64
+	// vramPct is the share of the GPU's VRAM, -1 while its total is unknown
+	vramPct float64
+	proc    ProcessInfo
+	// A group's top-level item aggregates its members, listed below it
+	members []ProcessListItem
+	procs   int // processes the item stands for
+}
+
+// compare orders items by the selected column
+func (v *processView) compare(a, b ProcessListItem) int {
+	var result int
+	switch v.selectedColumn {
+	case 5: // VRAM%
+		// Unknown shares go last either way
+		if (a.vramPct < 0) != (b.vramPct < 0) {
+			return cmp.Compare(b.vramPct, a.vramPct)
+		}
+		result = cmp.Compare(a.vramPct, b.vramPct)
+	case 0: // GPU
+		if v.byBDF {
+			result = strings.Compare(a.bdf, b.bdf)
+		}
+		if result == 0 {
+			result = cmp.Compare(a.gpu, b.gpu)
+		}
+	case 1: // Name
+		result = strings.Compare(a.name, b.name)
+	case 2: // PID
+		result = cmp.Compare(a.pid, b.pid)
+	case 3: // Usage
+		result = cmp.Compare(a.usage, b.usage)
+	case 4: // VRAM
+		result = cmp.Compare(a.vram, b.vram)
+	}
+	if v.sortReverse {
+		return -result
+	}
+	return result
+}
+
+// group folds the members of each process tree group into one top-level
+// item, keeping their sorted order. Ungrouped processes stay as they are.
+func group(items []ProcessListItem) []ProcessListItem {
+	top := items[:0:0]
+	index := map[processKey]int{}
+	for _, item := range items {
+		if item.proc.Group == 0 {
+			top = append(top, item)
+			continue
+		}
+		key := processKey{host: item.host, pid: item.proc.Group, group: true}
+		i, ok := index[key]
+		if !ok {
+			i = len(top)
+			index[key] = i
+			top = append(top, ProcessListItem{gpu: item.gpu, bdf: item.bdf, name: item.proc.GroupName, pid: item.proc.Group, host: item.host,
+				proc: ProcessInfo{Host: item.host, GPU: item.gpu, Name: item.proc.GroupName, Pid: item.proc.Group}})
+		}
+		g := &top[i]
+		g.gpu, g.bdf = min(g.gpu, item.gpu), min(g.bdf, item.bdf)
+		g.usage += item.usage
+		g.vram += item.vram
+		g.proc.GPU = g.gpu
+		g.proc.UsagePercent += item.proc.UsagePercent
+		g.proc.VRAMBytes += item.proc.VRAMBytes
+		g.proc.GTTBytes += item.proc.GTTBytes
+		g.proc.CPUBytes += item.proc.CPUBytes
+		g.proc.TotalBytes += item.proc.TotalBytes
+		g.members = append(g.members, item)
+	}
+	return top
+}
+
+// update rebuilds the rows from the latest processes. It runs every tick,
+// so the item, row and key slices are reused between calls.
+func (v *processView) update(processes []ProcessInfo) {
+	v.last = processes
+	// Find the longest name length for alignment
+	maxNameLen := 20 // Default minimum width
+	maxPIDLen := 8   // PID width
+	// Processes from several hosts get a HOST column
+	// Widths are display columns, not bytes, so CJK and emoji names line up.
+	// Measure everything before formatting any row.
+	maxHostLen := 0
+	maxGPULen := 0 // aliases widen the GPU column
+	grouped := false
+	// sorted shares its array with items, which is about to be reused
+	var frozen []ProcessListItem
+	if v.freeze && (v.hold || time.Since(v.movedAt) < processFreezeIdle) {
+		frozen = slices.Clone(v.sorted)
+	}
+	for _, proc := range processes {
+		maxHostLen = max(maxHostLen, runewidth.StringWidth(proc.Host))
+		if proc.GPUAlias != "" {
+			maxGPULen = max(maxGPULen, runewidth.StringWidth(proc.GPUAlias)+len("[]"))
+		}
+		maxNameLen = max(maxNameLen, runewidth.StringWidth(proc.Name))
+		if proc.Group != 0 {
+			grouped = true
+			// Members are indented under a "▾ name (n)" group row
+			maxNameLen = max(maxNameLen, runewidth.StringWidth(proc.Name)+len(treeBranch), runewidth.StringWidth(proc.GroupName)+8)
+		}
+	}
+	items := v.items[:0]
+	for _, proc := range processes {
+		items = append(items, ProcessListItem{
+			gpu:     proc.GPU,
+			bdf:     proc.BDF,
+			name:    proc.Name,
+			pid:     proc.Pid,
+			host:    proc.Host,
+			usage:   proc.UsagePercent,
+			vram:    proc.VRAMBytes,
+			vramPct: v.vramPercent(proc),
+			proc:    proc,
+			procs:   1,
+		})
+	}
+	v.items = items
+	// The header only changes with the column widths
+	v.widths = columnWidths(v.layout, v.widths, maxHostLen, maxGPULen, maxNameLen, maxPIDLen, len(v.rows.gttTotals) > 0, v.rows.caps)
+	if v.header == "" || !slices.Equal(v.headerWidths, v.widths) {
+		v.header = v.rows.header(v.layout, v.widths)
+		v.headerWidths = slices.Clone(v.widths)
+	}
+	// Sort based on selected column. Groups sort by their aggregate values,
+	// and their members among themselves.
+	slices.SortFunc(items, v.compare)
+	if grouped {
+		items = group(items)
+		for i := range items {
+			items[i].procs = max(1, len(items[i].members))
+			if items[i].members != nil {
+				items[i].vramPct = v.vramPercent(items[i].proc)
+			}
+		}
+		slices.SortStableFunc(items, v.compare)
+	}
+	pending := false
+	if frozen != nil {
+		items, pending = keepOrder(items, frozen)
+	}
+	if pending != v.pending {
+		v.pending = pending
+		v.list.Title = v.title()
+	}
+	v.sorted = items
+	// Processes that don't match the filter, and then small ones, are left
+	// out before --top picks the largest
+	var small []ProcessListItem
+	if v.filter.active() || v.filterVRAM && v.minVRAM > 0 {
+		v.readCmdlines(processes)
+		shown := v.shown[:0]
+		for _, item := range items {
+			switch {
+			case !v.matches(item):
+			case v.filterVRAM && item.vram < v.minVRAM:
+				small = append(small, item)
+			default:
+				shown = append(shown, item)
+			}
+		}
+		items, v.shown = shown, shown
+	}
+	// Only the top rows under the current sort are listed, then a summary
+	// of the rest
+	var hidden []ProcessListItem
+	if v.limit > 0 && len(items) > v.limit {
+		items, hidden = items[:v.limit], items[v.limit:]
+	}
+	// Remember which process is selected before the rows change
+	selected, hadSelection := processKey{}, false
+	if row := v.list.SelectedRow - processListHeaderRows; row >= 0 && row < len(v.keys) {
+		selected, hadSelection = v.keys[row], true
+	}
+	// Update list display
+	v.rows.highlight = v.filter
+	rows, keys := v.list.Rows, v.keys[:0]
+	if len(rows) < processListHeaderRows || rows[0] != v.header {
+		rows = append(rows[:0], v.header, strings.Repeat("─", runewidth.StringWidth(v.header)))
+	}
+	rows = rows[:processListHeaderRows]
+	for _, item := range items {
+		if item.members == nil {
+			rows = append(rows, v.rows.process(item.proc, v.layout, v.widths))
+			keys = append(keys, processKey{host: item.host, pid: item.pid})
+			continue
+		}
+		key := processKey{host: item.host, pid: item.pid, group: true}
+		marker := "▸ "
+		if v.expanded[key] {
+			marker = "▾ "
+		}
+		header := item.proc
+		header.Name = fmt.Sprintf("%s%s (%d)", marker, item.name, len(item.members))
+		rows = append(rows, v.rows.process(header, v.layout, v.widths))
+		keys = append(keys, key)
+		if !v.expanded[key] {
+			continue
+		}
+		for _, member := range item.members {
+			member.proc.Name = treeBranch + member.proc.Name
+			rows = append(rows, v.rows.process(member.proc, v.layout, v.widths))
+			keys = append(keys, processKey{host: member.host, pid: member.pid})
+		}
+	}
+	if len(hidden) > 0 {
+		var vram uint64
+		procs := 0
+		for _, item := range hidden {
+			vram += item.vram
+			procs += item.procs
+		}
+		rows = append(rows, fmt.Sprintf("… and %d more (%.1f GB total)", procs, mib(vram)/1024))
+	}
+	if len(small) > 0 {
+		var vram uint64
+		procs := 0
+		for _, item := range small {
+			vram += item.vram
+			procs += item.procs
+		}
+		rows = append(rows, fmt.Sprintf("%d processes below %s hidden (%.0f MB total)", procs, formatMemorySize(v.minVRAM), mib(vram)))
+	}
+	v.list.Rows, v.keys = rows, keys
+	// Follow the selected process to its new row; if it exited, stay in range
+	if hadSelection {
+		for i, key := range v.keys {
+			if key == selected {
+				v.list.SelectedRow = i + processListHeaderRows
+				return
+			}
+		}
+	}
+	if v.list.SelectedRow > len(v.list.Rows)-1 {
+		v.list.SelectedRow = len(v.list.Rows) - 1
+	}
+}
+
+// setGPUTotals records each GPU's VRAM from the hosts' latest samples and
+// GTT size from their static info, by GPU ID, for the VRAM% and GTT%
+// columns and coloring memory cells. An APU counts its carve-out and GTT.
+func (v *processView) setGPUTotals(hosts []*hostView) {
+	clear(v.rows.vramTotals)
+	clear(v.rows.gttTotals)
+	clear(v.rows.apus)
+	clear(v.rows.noGTT)
+	v.rows.caps = unionCaps(hosts)
+	for _, h := range hosts {
+		for _, m := range h.lastGPUs {
+			key := gpuKey{host: h.name, gpu: m.ID}
+			if _, total := m.memory(); total > 0 {
+				v.rows.vramTotals[key] = total
+			}
+			if m.APU {
+				v.rows.apus[key] = true
+			}
+			if !m.Caps.has(capGTT) {
+				v.rows.noGTT[key] = true
+			}
+		}
+		for id, s := range h.static {
+			if s.GTTTotal > 0 {
+				v.rows.gttTotals[gpuKey{host: h.name, gpu: id}] = s.GTTTotal
+			}
+		}
+	}
+}
+
+// vramPercent is the share of its GPU's VRAM a process uses, or -1 when
+// the GPU's total is unknown
+func (v *processView) vramPercent(p ProcessInfo) float64 {
+	total, ok := v.rows.vramTotals[gpuKey{host: p.Host, gpu: p.GPU}]
+	if !ok {
+		return -1
+	}
+	return mib(v.rows.memory(p)) / total * 100
+}
+
+// matches reports whether the filter matches an item, or for a group any
+// of its members
+func (v *processView) matches(item ProcessListItem) bool {
+	if v.filter.match(item.proc, v.cmdlines[item.pid]) {
+		return true
+	}
+	for _, member := range item.members {
+		if v.filter.match(member.proc, v.cmdlines[member.pid]) {
+			return true
+		}
+	}
+	return false
+}
+
+// readCmdlines caches the command lines of local processes for the
+// filter, dropping those of processes that are gone
+func (v *processView) readCmdlines(processes []ProcessInfo) {
+	if !v.filter.active() {
+		return
+	}
+	seen := make(map[int]string, len(processes))
+	for _, p := range processes {
+		if p.Host != "" {
+			continue
+		}
+		cmdline, ok := v.cmdlines[p.Pid]
+		if !ok {
+			cmdline = procCmdline(p.Pid)
+		}
+		seen[p.Pid] = cmdline
+		// Group rows match on their root's command line
+		if p.Group != 0 {
+			if _, ok := seen[p.Group]; !ok {
+				seen[p.Group] = cmp.Or(v.cmdlines[p.Group], procCmdline(p.Group))
+			}
+		}
+	}
+	v.cmdlines = seen
+}
+
+// refresh rebuilds the rows after a key changed the sort or filter,
+// without waiting for the next sample
+func (v *processView) refresh() {
+	v.update(v.last)
+}
+
+// setFilter applies a '/' pattern; an invalid regexp filters nothing
+func (v *processView) setFilter(pattern string) error {
+	v.filter = newProcessFilter(pattern)
+	v.list.Title = v.title()
+	return v.filter.err
+}
+
+// keepOrder lays items out in the order of prev, updating the values of
+// the processes still there. New processes wait for the next re-sort and
+// exited ones keep their last values, so no row moves. It reports whether
+// that differs from the fresh sort.
+func keepOrder(items, prev []ProcessListItem) ([]ProcessListItem, bool) {
+	key := func(item ProcessListItem) processKey {
+		return processKey{host: item.host, pid: item.pid, group: item.members != nil}
+	}
+	fresh := make(map[processKey]ProcessListItem, len(items))
+	for _, item := range items {
+		fresh[key(item)] = item
+	}
+	out := make([]ProcessListItem, 0, len(prev))
+	differs := len(items) != len(prev)
+	for i, old := range prev {
+		item, ok := fresh[key(old)]
+		if !ok {
+			item, differs = old, true
+		} else if item.members != nil {
+			var d bool
+			item.members, d = keepOrder(item.members, old.members)
+			differs = differs || d
+		}
+		differs = differs || i >= len(items) || key(items[i]) != key(old)
+		out = append(out, item)
+	}
+	return out, differs
+}
+
+// export writes the whole list in its current order to a CSV file in dir
+// and returns its path. Values are raw (bytes, percent as a float) so the
+// file can be analyzed in a spreadsheet.
+func (v *processView) export(dir string, now time.Time) (string, error) {
+	path := filepath.Join(dir, "mitop-processes-"+zoned(now).Format("20060102-150405")+".csv")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to export processes: %w", err)
+	}
+	fmt.Fprintf(f, "# mi-top processes on %s at %s\n", hostname(), zoned(now).Format(time.RFC3339))
+	w := csv.NewWriter(f)
+	w.Write([]string{"host", "gpu", "bdf", "name", "pid", "group", "gfx_usage", "vram_bytes", "gtt_bytes", "cpu_bytes", "total_bytes"})
+	write := func(p ProcessInfo) {
+		group := ""
+		if p.Group != 0 {
+			group = strconv.Itoa(p.Group)
+		}
+		w.Write([]string{p.Host, strconv.Itoa(p.GPU), p.BDF, p.Name, strconv.Itoa(p.Pid), group,
+			strconv.FormatFloat(p.UsagePercent, 'f', -1, 64),
+			strconv.FormatUint(p.VRAMBytes, 10), strconv.FormatUint(p.GTTBytes, 10),
+			strconv.FormatUint(p.CPUBytes, 10), strconv.FormatUint(p.TotalBytes, 10)})
+	}
+	for _, item := range v.sorted {
+		if item.members == nil {
+			write(item.proc)
+		}
+		for _, member := range item.members {
+			write(member.proc)
+		}
+	}
+	w.Flush()
+	err = w.Error()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to export processes: %w", err)
+	}
+	return path, nil
+}
+
+// selected returns the process on the selected row; a group row stands
+// for its members' totals
+func (v *processView) selected() (ProcessInfo, bool) {
+	row := v.list.SelectedRow - processListHeaderRows
+	if row < 0 || row >= len(v.keys) {
+		return ProcessInfo{}, false
+	}
+	key := v.keys[row]
+	for _, item := range v.sorted {
+		if item.host == key.host && item.pid == key.pid && (item.members != nil) == key.group {
+			return item.proc, true
+		}
+		for _, member := range item.members {
+			if !key.group && member.host == key.host && member.pid == key.pid {
+				return member.proc, true
+			}
+		}
+	}
+	return ProcessInfo{}, false
+}
+
+// clipboardText formats a process as one tab-separated line for actionCopy
+func clipboardText(p ProcessInfo) string {
+	owner := ""
+	if p.Host == "" {
+		owner = procOwner(p.Pid)
+	}
+	gpu := GPUDevice{ID: p.GPU, Alias: p.GPUAlias}.name()
+	if p.Host != "" {
+		gpu = p.Host + " " + gpu
+	}
+	return strings.Join([]string{gpu, p.Name, strconv.Itoa(p.Pid), owner,
+		fmt.Sprintf("VRAM %.0f MB", mib(p.VRAMBytes)), fmt.Sprintf("GTT %.0f MB", mib(p.GTTBytes)),
+		fmt.Sprintf("CPU %.0f MB", mib(p.CPUBytes)), fmt.Sprintf("total %.0f MB", mib(p.TotalBytes)),
+		fmt.Sprintf("GFX %.0f%%", p.UsagePercent)}, "\t")
+}
+
+// handleEvent moves the selection and changes the sort. It reports whether
+// the action was one of the list's.
+func (v *processView) handleEvent(action keyAction) bool {
+	switch action {
+	case actionUp:
+		if v.list.SelectedRow > 0 {
+			v.list.SelectedRow--
+		}
+		v.movedAt = time.Now()
+	case actionDown:
+		if v.list.SelectedRow < len(v.list.Rows)-1 {
+			v.list.SelectedRow++
+		}
+		v.movedAt = time.Now()
+	case actionSortPrev:
+		if v.selectedColumn > 0 {
+			v.selectedColumn--
+			v.movedAt = time.Time{}
+			v.list.Title = v.title()
+		}
+	case actionSortNext:
+		if v.selectedColumn < len(columns)-1 {
+			v.selectedColumn++
+			v.movedAt = time.Time{}
+			v.list.Title = v.title()
+		}
+	case actionExpand, actionReverse:
+		// Expanding on a group row opens or closes it instead
+		if row := v.list.SelectedRow - processListHeaderRows; action == actionExpand && row >= 0 && row < len(v.keys) && v.keys[row].group {
+			v.expanded[v.keys[row]] = !v.expanded[v.keys[row]]
+			return true
+		}
+		v.sortReverse = !v.sortReverse
+		v.movedAt = time.Time{}
+		v.list.Title = v.title()
+	case actionTop:
+		if v.limit > 0 {
+			v.limit = 0
+		} else {
+			v.limit = v.topN
+		}
+		v.list.Title = v.title()
+	case actionMinVRAM:
+		v.filterVRAM = !v.filterVRAM
+		v.list.Title = v.title()
+	case actionNarrow:
+		v.resizeColumn(-columnStep)
+	case actionWiden:
+		v.resizeColumn(columnStep)
+	default:
+		// The number keys sort by the column of the same index
+		column := slices.Index(sortActions, action)
+		if column < 0 {
+			return false
+		}
+		v.selectedColumn = column
+		v.movedAt = time.Time{}
+		v.list.Title = v.title()
+	}
+	return true
+}
+
+func (v *processView) title() string {
+	top := ""
+	if v.limit > 0 {
+		top = fmt.Sprintf(", top %d", v.limit)
+	}
+	if v.filterVRAM && v.minVRAM > 0 {
+		top += ", ≥ " + formatMemorySize(v.minVRAM)
+	}
+	if v.pending {
+		top += ", re-sort pending"
+	}
+	v.list.pattern = ""
+	if v.filter.active() {
+		v.list.pattern = v.filter.pattern
+		top += ", filter " + v.filter.pattern
+	}
+	return fmt.Sprintf("Process List (Sort: %s%s%s)",
+		columns[v.selectedColumn],
+		map[bool]string{true: " ↓", false: " ↑"}[v.sortReverse], top)
+}
+
+// parseSort reads a "column[,asc|desc]" sort spec such as "usage,desc"
+func parseSort(spec string) (column int, reverse bool, err error) {
+	name, order, _ := strings.Cut(spec, ",")
+	column = -1
+	for i, c := range columns {
+		if strings.EqualFold(c, strings.TrimSpace(name)) {
+			column = i
+		}
+	}
+	if column < 0 {
+		valid := make([]string, len(columns))
+		for i, c := range columns {
+			valid[i] = strings.ToLower(c)
+		}
+		return 0, false, fmt.Errorf("unknown sort column %q (valid: %s)", name, strings.Join(valid, ", "))
+	}
+	switch strings.ToLower(strings.TrimSpace(order)) {
+	case "", "asc":
+	case "desc":
+		reverse = true
+	default:
+		return 0, false, fmt.Errorf("unknown sort order %q (valid: asc, desc)", order)
+	}
+	return column, reverse, nil
+}
+
+// parseMemorySize reads a size like "100MB", "1.5GB" or "512" (MB) into
+// bytes. Units are binary, as in the rest of mi-top.
+func parseMemorySize(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	number := strings.TrimRightFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	unit := strings.ToUpper(strings.TrimSpace(s[len(number):]))
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	switch unit {
+	case "", "M", "MB", "MIB":
+		n *= 1 << 20
+	case "K", "KB", "KIB":
+		n *= 1 << 10
+	case "G", "GB", "GIB":
+		n *= 1 << 30
+	default:
+		return 0, fmt.Errorf("invalid size %q (use KB, MB or GB)", s)
+	}
+	return uint64(n), nil
+}
+
+// formatMemorySize prints a size as parseMemorySize reads it
+func formatMemorySize(b uint64) string {
+	if b >= 1<<30 && b%(1<<30) == 0 {
+		return fmt.Sprintf("%d GB", b>>30)
+	}
+	return fmt.Sprintf("%.0f MB", mib(b))
+}
+
+// gpuKey identifies a GPU across hosts
+type gpuKey struct {
+	host string
+	gpu  int
+}
+
+// rowWriter formats process list rows into a reused builder and number
+// buffer, so a row costs a single string allocation
+type rowWriter struct {
+	sb   strings.Builder
+	num  []byte
+	cell []byte
+	// highlight marks what the filter matches in names and PIDs
+	highlight processFilter
+	// vramTotals is each GPU's VRAM in MB and gttTotals its GTT size in
+	// bytes, of those that reported them. With colors, memory cells are
+	// colored by the share they take, graded by thresholds.
+	vramTotals map[gpuKey]float64
+	gttTotals  map[gpuKey]uint64
+	apus       map[gpuKey]bool
+	noGTT      map[gpuKey]bool // GPUs without capGTT
+	caps       gpuCaps         // what some GPU has, for the columns shown
+	colors     bool
+	thresholds ThresholdsConfig
+}
+
+func (w *rowWriter) pad(n int) {
+	for ; n > 0; n-- {
+		w.sb.WriteByte(' ')
+	}
+}
+
+// text writes s cut to width display columns on the column's side and
+// padded on the right, with the filter's matches wrapped in style markup.
+// The markup takes no columns on screen, so only s counts toward the
+// padding. Tree markers at the start of a name are kept when the head or
+// middle is cut.
+func (w *rowWriter) text(s string, c tableColumn, width int) {
+	for _, prefix := range []string{treeBranch, "▸ ", "▾ "} {
+		if rest, ok := strings.CutPrefix(s, prefix); ok && c.truncate != truncateTail && runewidth.StringWidth(s) > width {
+			w.sb.WriteString(prefix)
+			s, width = rest, max(width-runewidth.StringWidth(prefix), 1)
+			break
+		}
+	}
+	s = truncate(s, width, c.truncate)
+	at := 0
+	for _, r := range w.highlight.ranges(s) {
+		w.sb.WriteString(s[at:r[0]])
+		w.sb.WriteByte('[')
+		w.sb.WriteString(s[r[0]:r[1]])
+		w.sb.WriteString("](fg:black,bg:yellow)")
+		at = r[1]
+	}
+	w.sb.WriteString(s[at:])
+	w.pad(width - runewidth.StringWidth(s))
+}
+
+// appendNumber appends the formatted number in w.num right-aligned to
+// width to the cell
+func (w *rowWriter) appendNumber(width int) {
+	for n := width - len(w.num); n > 0; n-- {
+		w.cell = append(w.cell, ' ')
+	}
+	w.cell = append(w.cell, w.num...)
+}
+
+func (w *rowWriter) appendMB(label string, b uint64) {
+	w.cell = append(w.cell[:0], label...)
+	w.num = strconv.AppendFloat(w.num[:0], mib(b), 'f', 1, 64)
+	w.appendNumber(6)
+	w.cell = append(w.cell, " MB"...)
+}
+
+// flush writes the ASCII cell built in w.cell, cut or padded to width
+func (w *rowWriter) flush(c tableColumn, width int) {
+	cell, width := w.cell, max(width, 1)
+	if len(cell) <= width {
+		w.sb.Write(cell)
+		w.pad(width - len(cell))
+		return
+	}
+	switch c.truncate {
+	case truncateHead:
+		w.sb.WriteString("…")
+		w.sb.Write(cell[len(cell)-width+1:])
+	case truncateMiddle:
+		head := min(middleHead, (width-1)/4)
+		w.sb.Write(cell[:head])
+		w.sb.WriteString("…")
+		w.sb.Write(cell[len(cell)-(width-1-head):])
+	default:
+		w.sb.Write(cell[:width-1])
+		w.sb.WriteString("…")
+	}
+}
+
+// memory is the GPU memory a process holds: its VRAM, or on an APU, where
+// the VRAM is a small carve-out, its VRAM and GTT
+func (w *rowWriter) memory(proc ProcessInfo) uint64 {
+	if w.apus[gpuKey{host: proc.Host, gpu: proc.GPU}] {
+		return proc.VRAMBytes + proc.GTTBytes
+	}
+	return proc.VRAMBytes
+}
+
+// showsGTT reports whether the layout has a visible GTT column
+func showsGTT(layout []tableColumn, widths []int) bool {
+	for i, c := range layout {
+		if c.id == "gtt" && widths[i] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// heat returns the style markup for a memory cell of b bytes on proc's
+// GPU, graded by its share of the GPU's VRAM, or "" to leave it white
+func (w *rowWriter) heat(proc ProcessInfo, b uint64) string {
+	total := w.vramTotals[gpuKey{host: proc.Host, gpu: proc.GPU}]
+	if !w.colors || total <= 0 {
+		return ""
+	}
+	return gradeStyle(mib(b)/total*100, w.thresholds.ProcessVRAMWarn, w.thresholds.ProcessVRAMCrit)
+}
+
+// gradeStyle returns the closing style markup for a percentage graded by
+// warn and crit
+func gradeStyle(pct, warn, crit float64) string {
+	switch grade(pct, warn, crit) {
+	case checkCritical:
+		return "](fg:red)"
+	case checkWarning:
+		return "](fg:yellow)"
+	}
+	return "](fg:green)"
+}
+
+// flushStyled is flush with the cell wrapped in style, which takes no
+// columns on screen
+func (w *rowWriter) flushStyled(c tableColumn, width int, style string) {
+	if style == "" {
+		w.flush(c, width)
+		return
+	}
+	w.sb.WriteByte('[')
+	w.flush(c, width)
+	w.sb.WriteString(style)
+}
+
+func (w *rowWriter) process(proc ProcessInfo, layout []tableColumn, widths []int) string {
+	w.sb.Reset()
+	w.sb.Grow(256)
+	first := true
+	for i, c := range layout {
+		width := widths[i]
+		if width == 0 {
+			continue
+		}
+		if !first {
+			w.sb.WriteString(" │ ")
+		}
+		first = false
+		switch c.id {
+		case "host":
+			w.text(proc.Host, c, width)
+		case "name":
+			w.text(proc.Name, c, width)
+		case "pid":
+			w.sb.WriteString("PID: ")
+			w.num = strconv.AppendInt(w.num[:0], int64(proc.Pid), 10)
+			if w.highlight.active() {
+				w.text(string(w.num), c, width-len("PID: "))
+			} else {
+				w.cell = append(w.cell[:0], w.num...)
+				w.flush(c, width-len("PID: "))
+			}
+		case "gpu":
+			if proc.GPUAlias != "" {
+				s := truncate("["+proc.GPUAlias+"]", width, c.truncate)
+				w.sb.WriteString(s)
+				w.pad(width - runewidth.StringWidth(s))
+				break
+			}
+			w.cell = append(w.cell[:0], '[')
+			w.num = strconv.AppendInt(w.num[:0], int64(proc.GPU), 10)
+			w.appendNumber(3)
+			w.cell = append(w.cell, ']')
+			w.flush(c, width)
+		case "mem":
+			w.appendMB("MEM: ", proc.TotalBytes)
+			w.flushStyled(c, width, w.heat(proc, proc.TotalBytes))
+		case "vram":
+			// APU processes allocate from the GTT; a layout without a GTT
+			// column shows that instead of the carve-out
+			if w.apus[gpuKey{host: proc.Host, gpu: proc.GPU}] && !showsGTT(layout, widths) {
+				w.appendMB("GTT: ", proc.GTTBytes)
+				w.flushStyled(c, width, w.heat(proc, proc.GTTBytes))
+				break
+			}
+			w.appendMB("VRAM: ", proc.VRAMBytes)
+			w.flushStyled(c, width, w.heat(proc, proc.VRAMBytes))
+		case "vram_pct":
+			w.cell = append(w.cell[:0], "VRAM%: "...)
+			w.num = append(w.num[:0], '-')
+			if total := w.vramTotals[gpuKey{host: proc.Host, gpu: proc.GPU}]; total > 0 {
+				w.num = strconv.AppendFloat(w.num[:0], mib(w.memory(proc))/total*100, 'f', 1, 64)
+			}
+			w.appendNumber(5)
+			w.flushStyled(c, width, w.heat(proc, w.memory(proc)))
+		case "gtt_pct":
+			w.cell = append(w.cell[:0], "GTT%: "...)
+			w.num = append(w.num[:0], '-')
+			style := ""
+			if total := w.gttTotals[gpuKey{host: proc.Host, gpu: proc.GPU}]; total > 0 {
+				pct := float64(proc.GTTBytes) / float64(total) * 100
+				w.num = strconv.AppendFloat(w.num[:0], pct, 'f', 1, 64)
+				if w.colors {
+					style = gradeStyle(pct, w.thresholds.GTTWarn, w.thresholds.GTTCrit)
+				}
+			}
+			w.appendNumber(5)
+			w.flushStyled(c, width, style)
+		case "gtt":
+			if w.noGTT[gpuKey{host: proc.Host, gpu: proc.GPU}] {
+				w.cell = append(w.cell[:0], "GTT: "...)
+				w.num = append(w.num[:0], "n/a"...)
+				w.appendNumber(6)
+				w.flush(c, width)
+				break
+			}
+			w.appendMB("GTT: ", proc.GTTBytes)
+			w.flush(c, width)
+		case "cpu":
+			w.appendMB("CPU: ", proc.CPUBytes)
+			w.flush(c, width)
+		case "gfx":
+			w.cell = append(w.cell[:0], "GFX: "...)
+			w.num = strconv.AppendFloat(w.num[:0], proc.UsagePercent, 'f', -1, 64)
+			w.num = append(w.num, '%')
+			w.appendNumber(6)
+			w.flush(c, width)
+		}
+	}
+	return w.sb.String()
+}
+
+// columnTitles head the table columns
+var columnTitles = map[string]string{
+	"host": "HOST", "gpu": "[GPU]", "name": "NAME", "pid": "PID", "mem": "MEMORY",
+	"vram": "VRAM", "vram_pct": "VRAM%", "gtt_pct": "GTT%", "gtt": "GTT", "cpu": "CPU", "gfx": "GPU USAGE",
+}
+
+func (w *rowWriter) header(layout []tableColumn, widths []int) string {
+	w.sb.Reset()
+	first := true
+	for i, c := range layout {
+		if widths[i] == 0 {
+			continue
+		}
+		if !first {
+			w.sb.WriteString(" │ ")
+		}
+		first = false
+		w.cell = append(w.cell[:0], columnTitles[c.id]...)
+		w.flush(c, widths[i])
+	}
+	return w.sb.String()
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ui "github.com/gizak/termui/v3"
+	"github.com/mattn/go-runewidth"
+)
+
+// listedPIDs is the PID of each data row, top to bottom
+func listedPIDs(v *processView) []int {
+	pids := make([]int, len(v.keys))
+	for i, k := range v.keys {
+		pids[i] = k.pid
+	}
+	return pids
+}
+
+func TestSortKeyEndsFreeze(t *testing.T) {
+	v := newProcessView(3, false, true) // by usage, ascending
+	v.update([]ProcessInfo{
+		{Name: "a", Pid: 30, UsagePercent: 10},
+		{Name: "b", Pid: 10, UsagePercent: 50},
+		{Name: "c", Pid: 20, UsagePercent: 30},
+	})
+	if got := listedPIDs(v); !slices.Equal(got, []int{30, 20, 10}) {
+		t.Fatalf("rows %v, want [30 20 10]", got)
+	}
+	// A cursor move freezes the order against changed usage...
+	v.handleEvent(actionDown)
+	changed := []ProcessInfo{
+		{Name: "a", Pid: 30, UsagePercent: 90},
+		{Name: "b", Pid: 10, UsagePercent: 50},
+		{Name: "c", Pid: 20, UsagePercent: 30},
+	}
+	v.update(changed)
+	if got := listedPIDs(v); !slices.Equal(got, []int{30, 20, 10}) || !v.pending {
+		t.Fatalf("rows %v, pending %v; want the frozen order with a re-sort pending", got, v.pending)
+	}
+	// ...until a sort key asks for another order
+	v.handleEvent(actionSort3)
+	v.update(changed)
+	if got := listedPIDs(v); !slices.Equal(got, []int{10, 20, 30}) || v.pending {
+		t.Errorf("rows %v, pending %v after sorting by PID; want [10 20 30] and nothing pending", got, v.pending)
+	}
+}
+
+// TestProcessViewStress drives one view with samplers and key presses
+// arriving at the same time, the way the event loop in main does: the
+// producers only send over channels and the loop alone touches the view.
+// Run it with -race.
+func TestProcessViewStress(t *testing.T) {
+	const producers, sends = 4, 300
+	samples := make(chan []ProcessInfo)
+	actions := make(chan keyAction)
+	var wg sync.WaitGroup
+	for p := range producers {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			r := rand.New(rand.NewPCG(uint64(p), 1))
+			for range sends {
+				processes := make([]ProcessInfo, r.IntN(40))
+				for i := range processes {
+					processes[i] = ProcessInfo{
+						GPU:          r.IntN(8),
+						Name:         fmt.Sprintf("proc%d", r.IntN(100)),
+						Pid:          1000 + r.IntN(100)*10 + i%10,
+						UsagePercent: r.Float64() * 100,
+						VRAMBytes:    r.Uint64N(64 << 30),
+					}
+				}
+				samples <- processes
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			r := rand.New(rand.NewPCG(uint64(p), 2))
+			keys := append([]keyAction{actionUp, actionDown, actionSortPrev, actionSortNext, actionReverse, actionExpand, actionTop}, sortActions...)
+			for range sends {
+				actions <- keys[r.IntN(len(keys))]
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	v := newProcessView(3, false, false)
+	v.topN = 10
+	for {
+		select {
+		case processes := <-samples:
+			v.update(processes)
+		case action := <-actions:
+			if v.handleEvent(action) {
+				v.refresh()
+			}
+		case <-done:
+			return
+		}
+		checkProcessView(t, v)
+		if t.Failed() {
+			return
+		}
+	}
+}
+
+// checkProcessView fails t if the rows don't show the last update in sort
+// order, with the selection on a row
+func checkProcessView(t *testing.T, v *processView) {
+	t.Helper()
+	if len(v.list.Rows) < processListHeaderRows+len(v.keys) {
+		t.Fatalf("%d rows for %d processes", len(v.list.Rows), len(v.keys))
+	}
+	if v.list.SelectedRow < 0 || v.list.SelectedRow >= len(v.list.Rows) {
+		t.Fatalf("row %d selected of %d", v.list.SelectedRow, len(v.list.Rows))
+	}
+	if !slices.IsSortedFunc(v.sorted, v.compare) {
+		t.Fatalf("rows are out of order for column %d, reverse %v", v.selectedColumn, v.sortReverse)
+	}
+	if want := len(v.last); v.limit == 0 && len(v.keys) != want {
+		t.Fatalf("%d processes listed, want %d", len(v.keys), want)
+	}
+	if v.limit > 0 && len(v.keys) > v.limit {
+		t.Fatalf("%d processes listed past --top %d", len(v.keys), v.limit)
+	}
+}
+
+// BenchmarkUpdateProcessList rebuilds the list of a node with 128 processes
+// on 8 GPUs, as every tick does
+func BenchmarkUpdateProcessList(b *testing.B) {
+	processes := make([]ProcessInfo, 128)
+	for i := range processes {
+		processes[i] = ProcessInfo{
+			GPU:          i % 8,
+			Name:         fmt.Sprintf("worker-%d", i),
+			Pid:          10000 + i,
+			UsagePercent: float64(i%100) + 0.5,
+			VRAMBytes:    uint64(i+1) << 28,
+			GTTBytes:     uint64(i) << 20,
+		}
+	}
+	v := newProcessView(4, true, false)
+	v.update(processes)
+	b.ReportAllocs()
+	for b.Loop() {
+		v.update(processes)
+	}
+}
+
+// TestRenderingV1Recording renders the processes of a version 1 recording,
+// which kept usage and PIDs as strings and memory in MB, next to the same
+// processes recorded now: the rows match each other and the cells the
+// string-based list used to show
+func TestRenderingV1Recording(t *testing.T) {
+	dir := t.TempDir()
+	v1Path, v2Path := filepath.Join(dir, "v1.jsonl"), filepath.Join(dir, "v2.jsonl")
+	v1 := `{"format":"mtrec","version":1,"host":"node1","started":"2024-01-01T00:00:00Z"}
+{"timestamp":"2024-01-01T00:00:01Z","gpus":[],"processes":[` +
+		`{"gpu":0,"name":"python3 train.py","pid":"4242","gfx_usage":"85.5%","vram_mem":32768,"gtt_mem":512,"cpu_mem":64,"total_mem":33344},` +
+		`{"gpu":1,"name":"llama-server","pid":"977","gfx_usage":"12%","vram_mem":8192,"gtt_mem":16,"cpu_mem":0,"total_mem":8208}]}
+`
+	if err := os.WriteFile(v1Path, []byte(v1), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := newRecorder(v2Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Write(Sample{Time: t0.Add(time.Second), Processes: goldenProcesses[:2]})
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rendered := func(path string) []string {
+		samples, err := loadRecording(path)
+		if err != nil || len(samples) != 1 {
+			t.Fatalf("%s: %d samples, %v", path, len(samples), err)
+		}
+		v := newProcessView(3, true, false)
+		v.setGPUTotals([]*hostView{goldenHost([]GPUMetrics{amdGPU(0, "0000:03:00.0"), amdGPU(1, "0000:83:00.0")})})
+		v.update(samples[0].Processes)
+		return v.list.Rows
+	}
+	old, current := rendered(v1Path), rendered(v2Path)
+	if !slices.Equal(old, current) {
+		t.Fatalf("version 1 rows:\n%s\nversion 2 rows:\n%s", strings.Join(old, "\n"), strings.Join(current, "\n"))
+	}
+	// The cells as the string fields were formatted: "PID: %-*s",
+	// "MEM: %6.1f MB" and "GFX: %6s" of the usage with its "%"
+	for i, cells := range [][]string{
+		{"PID: 4242 ", "GTT:  512.0 MB", "CPU:   64.0 MB", "GFX:  85.5%"},
+		{"PID: 977 ", "MEM: 8208.0 MB", "VRAM: 8192.0 MB", "GTT:   16.0 MB", "GFX:    12%"},
+	} {
+		row := current[processListHeaderRows+i]
+		for _, cell := range cells {
+			if !strings.Contains(row, cell) {
+				t.Errorf("row %q doesn't show %q", row, cell)
+			}
+		}
+	}
+}
+
+// TestSelectionFollowsPID keeps a selection on its process while the list
+// shrinks, grows and is re-sorted, and in range once the process is gone
+func TestSelectionFollowsPID(t *testing.T) {
+	procs := func(pids ...int) []ProcessInfo {
+		var out []ProcessInfo
+		for _, pid := range pids {
+			out = append(out, ProcessInfo{Name: fmt.Sprintf("p%d", pid), Pid: pid, UsagePercent: float64(pid % 97)})
+		}
+		return out
+	}
+	selectedPID := func(v *processView) int {
+		p, ok := v.selected()
+		if !ok {
+			t.Fatalf("nothing selected at row %d of %d", v.list.SelectedRow, len(v.list.Rows))
+		}
+		return p.Pid
+	}
+	var all []int
+	for pid := 1000; pid < 1060; pid++ {
+		all = append(all, pid)
+	}
+	v := newProcessView(2, false, false) // by PID, ascending
+	v.update(procs(all...))
+	v.list.SelectedRow = processListHeaderRows + 40
+	if got := selectedPID(v); got != 1040 {
+		t.Fatalf("selected %d, want 1040", got)
+	}
+
+	// Half exit, the selected one among the survivors
+	var even []int
+	for _, pid := range all {
+		if pid%2 == 0 {
+			even = append(even, pid)
+		}
+	}
+	v.update(procs(even...))
+	if got := selectedPID(v); got != 1040 || v.list.SelectedRow != processListHeaderRows+20 {
+		t.Errorf("after shrinking: %d at row %d, want 1040 at row %d", got, v.list.SelectedRow, processListHeaderRows+20)
+	}
+
+	// New processes sort ahead of it
+	grown := slices.Concat(procs(1, 2, 3, 4, 5), procs(even...))
+	v.update(grown)
+	if got := selectedPID(v); got != 1040 || v.list.SelectedRow != processListHeaderRows+25 {
+		t.Errorf("after growing: %d at row %d, want 1040 at row %d", got, v.list.SelectedRow, processListHeaderRows+25)
+	}
+
+	// Other sort orders move the row, not the selection
+	for _, action := range []keyAction{actionReverse, actionSort4, actionSort2, actionReverse} {
+		v.handleEvent(action)
+		v.update(grown)
+		if got := selectedPID(v); got != 1040 {
+			t.Errorf("after %v: selected %d, want 1040", action, got)
+		}
+		if want := slices.Index(listedPIDs(v), 1040) + processListHeaderRows; v.list.SelectedRow != want {
+			t.Errorf("after %v: row %d, want %d", action, v.list.SelectedRow, want)
+		}
+	}
+
+	// Once it is gone with the tail of the list, the selection stays on
+	// the last row rather than past it
+	v.update(procs(1, 2, 3))
+	if last := len(v.list.Rows) - 1; v.list.SelectedRow != last || last != processListHeaderRows+2 {
+		t.Errorf("after the process exited: row %d of %d rows, want the last", v.list.SelectedRow, len(v.list.Rows))
+	}
+	selectedPID(v)
+}
+
+// TestWideNames lists CJK, emoji and combining-mark names: every row,
+// the header and the separator are as wide on screen, with the column
+// separators in the same cells
+func TestWideNames(t *testing.T) {
+	v := newProcessView(2, false, false) // by PID
+	v.setGPUTotals([]*hostView{goldenHost([]GPUMetrics{amdGPU(0, "0000:03:00.0")})})
+	names := []string{
+		"python3",
+		"训练任务",
+		"推論サーバー-🚀",
+		"🔥🔥🔥 burn",
+		"café-é", // e and a combining accent
+		"모델_학습_스크립트_매우_긴_이름_입니다_정말로", // widens the name column
+		"👩‍💻 dev",
+	}
+	var processes []ProcessInfo
+	for i, name := range names {
+		processes = append(processes, ProcessInfo{Name: name, Pid: 100 + i, UsagePercent: 50, VRAMBytes: 1 << 30, TotalBytes: 1 << 30})
+	}
+	// separators gives the display columns of the │ in a row
+	separators := func(row string) []int {
+		var at []int
+		for i, r := range row {
+			if r == '│' {
+				at = append(at, runewidth.StringWidth(row[:i]))
+			}
+		}
+		return at
+	}
+	check := func(when string) {
+		t.Helper()
+		rows := v.list.Rows
+		if len(rows) != processListHeaderRows+len(names) {
+			t.Fatalf("%s: %d rows, want %d", when, len(rows), processListHeaderRows+len(names))
+		}
+		width := runewidth.StringWidth(rows[0])
+		if w := runewidth.StringWidth(rows[1]); w != width {
+			t.Errorf("%s: separator is %d cells, header %d", when, w, width)
+		}
+		for i, row := range rows[processListHeaderRows:] {
+			if w := runewidth.StringWidth(row); w != width {
+				t.Errorf("%s: row for %q is %d cells, want %d: %q", when, names[i], w, width, row)
+			}
+			if got, want := separators(row), separators(rows[0]); !slices.Equal(got, want) {
+				t.Errorf("%s: row for %q has columns at %v, want %v", when, names[i], got, want)
+			}
+		}
+	}
+	v.update(processes)
+	check("names in full")
+	// A narrowed name column cuts the wide names between characters
+	v.handleEvent(actionSort2)
+	for range 5 {
+		v.handleEvent(actionNarrow)
+	}
+	v.update(processes)
+	check("names cut")
+	i := slices.IndexFunc(v.list.Rows, func(row string) bool { return strings.Contains(row, "모델") })
+	if i < 0 || !strings.Contains(v.list.Rows[i], "…") {
+		t.Errorf("the long name isn't cut: %q", v.list.Rows)
+	}
+}
+
+// TestFilterHighlightAlignment draws the list with filters that match in
+// the names, in part of them and only in the PIDs: the matches are marked
+// in black on yellow, and every row shows the same text in the same cells
+// as without a filter
+func TestFilterHighlightAlignment(t *testing.T) {
+	processes := []ProcessInfo{
+		{Name: "train-llama", Pid: 4242, UsagePercent: 80, VRAMBytes: 1 << 30},
+		{Name: "训练-train", Pid: 977, UsagePercent: 60, VRAMBytes: 1 << 30},
+		{Name: "infer", Pid: 31337, UsagePercent: 40, VRAMBytes: 1 << 30},
+	}
+	const w, h = 150, 8
+	// screen is the text of the data rows by PID, and the cells of each
+	// drawn as a match
+	screen := func(v *processView) (map[int]string, map[int][]int) {
+		lines := strings.Split(render(v.list, w, h), "\n")
+		text, marked := map[int]string{}, map[int][]int{}
+		buf := ui.NewBuffer(image.Rect(0, 0, w, h))
+		v.list.Draw(buf)
+		for i, pid := range listedPIDs(v) {
+			y := 1 + processListHeaderRows + i
+			text[pid] = lines[y]
+			for x := range w {
+				if buf.GetCell(image.Pt(x, y)).Style.Bg == ui.ColorYellow {
+					marked[pid] = append(marked[pid], x)
+				}
+			}
+		}
+		return text, marked
+	}
+	v := newProcessView(3, true, false)
+	v.update(processes)
+	plain, none := screen(v)
+	if len(none) != 0 {
+		t.Fatalf("cells marked without a filter: %v", none)
+	}
+	for _, tc := range []struct {
+		pattern string
+		marked  map[int]int // PID -> cells marked
+	}{
+		{"train", map[int]int{4242: 5, 977: 5}},
+		{"TRAIN", map[int]int{4242: 5, 977: 5}},
+		{"/l+a/", map[int]int{4242: 3}},
+		{"/训练/", map[int]int{977: 2}},  // termui styles the first cell of a wide character
+		{"313", map[int]int{31337: 3}}, // in the PID
+	} {
+		t.Run(tc.pattern, func(t *testing.T) {
+			if err := v.setFilter(tc.pattern); err != nil {
+				t.Fatal(err)
+			}
+			v.update(processes)
+			text, marked := screen(v)
+			if len(text) != len(tc.marked) {
+				t.Fatalf("rows for %v, want %v", slices.Collect(maps.Keys(text)), tc.marked)
+			}
+			for pid, n := range tc.marked {
+				// The row is drawn in the cells it takes without the filter
+				if text[pid] != plain[pid] {
+					t.Errorf("PID %d drawn as\n%q\nwant\n%q", pid, text[pid], plain[pid])
+				}
+				if len(marked[pid]) != n {
+					t.Errorf("PID %d has %d cells marked, want %d", pid, len(marked[pid]), n)
+				}
+			}
+		})
+	}
+}
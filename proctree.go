@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// procDir is where process parents are read from
+var procDir = "/proc"
+
+// procParent returns a process's parent PID from /proc/<pid>/stat
+func procParent(pid int) (int, bool) {
+	data, err := os.ReadFile(procDir + "/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0, false
+	}
+	// The command name in parentheses may itself contain spaces and
+	// parentheses; the state and parent PID follow the last ')'
+	i := strings.LastIndexByte(string(data), ')')
+	if i < 0 {
+		return 0, false
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 2 {
+		return 0, false
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	return ppid, err == nil
+}
+
+func procName(pid int) string {
+	data, err := os.ReadFile(procDir + "/" + strconv.Itoa(pid) + "/comm")
+	if err != nil {
+		return strconv.Itoa(pid)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// groupProcesses sorts local GPU processes into process tree groups. A
+// process goes under its topmost ancestor that uses a GPU too; processes
+// without one are grouped under the launcher they share (torchrun, mpirun),
+// when there are at least two. Anything else stays ungrouped.
+func groupProcesses(processes []ProcessInfo) {
+	names := make(map[int]string, len(processes))
+	for _, p := range processes {
+		names[p.Pid] = p.Name
+	}
+	parents := map[int]int{}
+	parent := func(pid int) int {
+		ppid, ok := parents[pid]
+		if !ok {
+			ppid, _ = procParent(pid)
+			parents[pid] = ppid
+		}
+		return ppid
+	}
+	roots := make([]int, len(processes))
+	members := map[int]int{}
+	for i, p := range processes {
+		// The depth limit guards against a loop from PIDs being reused
+		for pid, depth := parent(p.Pid), 0; pid > 1 && depth < 64; pid, depth = parent(pid), depth+1 {
+			if _, ok := names[pid]; ok {
+				roots[i] = pid
+			}
+		}
+		if roots[i] != 0 {
+			members[roots[i]]++
+		}
+	}
+	launchers := map[int]int{}
+	for i, p := range processes {
+		switch {
+		case roots[i] != 0:
+			processes[i].Group, processes[i].GroupName = roots[i], names[roots[i]]
+		case members[p.Pid] > 0:
+			processes[i].Group, processes[i].GroupName = p.Pid, p.Name
+		default:
+			launchers[parent(p.Pid)]++
+		}
+	}
+	for i, p := range processes {
+		if launcher := parent(p.Pid); p.Group == 0 && launcher > 1 && launchers[launcher] > 1 {
+			processes[i].Group, processes[i].GroupName = launcher, procName(launcher)
+		}
+	}
+}
+
+// procOwner returns the user name a local process runs as, or its UID
+// when the name can't be looked up
+func procOwner(pid int) string {
+	info, err := os.Stat(procDir + "/" + strconv.Itoa(pid))
+	if err != nil {
+		return ""
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	uid := strconv.FormatUint(uint64(st.Uid), 10)
+	if u, err := user.LookupId(uid); err == nil {
+		return u.Username
+	}
+	return uid
+}
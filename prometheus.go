@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// textfileName is what node_exporter's textfile collector reads; the
+// temporary file lacks the .prom suffix so a half-written one is ignored
+const (
+	textfileName = "mitop.prom"
+	textfileTemp = ".mitop.prom.tmp"
+)
+
+// promGauge is a GPU metric in the Prometheus exposition format, carrying
+// the fields the other exporters send
+type promGauge struct {
+	name  string
+	help  string
+	value func(GPUMetrics) (float64, bool)
+}
+
+func always(f func(GPUMetrics) float64) func(GPUMetrics) (float64, bool) {
+	return func(m GPUMetrics) (float64, bool) { return f(m), true }
+}
+
+var promGauges = []promGauge{
+	{"mitop_gpu_utilization_percent", "GFX engine activity.", always(func(m GPUMetrics) float64 { return m.GFXUtil })},
+	{"mitop_gpu_power_watts", "Socket power.", always(func(m GPUMetrics) float64 { return m.Power })},
+	{"mitop_gpu_temperature_celsius", "Hotspot (junction) temperature.", always(func(m GPUMetrics) float64 { return m.GPUTemp })},
+	{"mitop_gpu_memory_temperature_celsius", "HBM temperature, only for cards with HBM.",
+		func(m GPUMetrics) (float64, bool) { return m.MemTemp, m.hasMemTemp() }},
+	{"mitop_gpu_clock_mhz", "GFX clock.", always(func(m GPUMetrics) float64 { return m.GFXClock })},
+	{"mitop_gpu_memory_utilization_percent", "Memory controller activity.", always(func(m GPUMetrics) float64 { return m.MemUtil })},
+	{"mitop_gpu_memory_clock_mhz", "Memory clock.", always(func(m GPUMetrics) float64 { return m.MemClock })},
+	{"mitop_gpu_vram_used_bytes", "VRAM in use.", always(func(m GPUMetrics) float64 { return m.VRAMUsed * 1024 * 1024 })},
+	{"mitop_gpu_vram_total_bytes", "VRAM size.", always(func(m GPUMetrics) float64 { return m.VRAMTotal * 1024 * 1024 })},
+}
+
+// promLabel escapes a label value: backslash, double quote and newline
+var promLabel = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promText renders samples, one per host, in the text exposition format.
+// All series of a metric are written together, as the format requires.
+func promText(samples []Sample, local string, now time.Time) []byte {
+	var b strings.Builder
+	header := func(name, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+	for _, g := range promGauges {
+		header(g.name, g.help)
+		for _, s := range samples {
+			host := promLabel.Replace(sampleHost(s, local))
+			for _, m := range s.GPUs {
+				if v, ok := g.value(m); ok {
+					// An empty label is the same as none to Prometheus
+					fmt.Fprintf(&b, "%s{host=\"%s\",gpu=\"%d\",bdf=\"%s\",uuid=\"%s\",serial=\"%s\",asic=\"%s\",alias=\"%s\"} %s\n",
+						g.name, host, m.ID, promLabel.Replace(m.BDF), promLabel.Replace(m.UUID), promLabel.Replace(m.Serial),
+						promLabel.Replace(m.ASIC), promLabel.Replace(m.Alias), formatFloat(v))
+				}
+			}
+		}
+	}
+	header("mitop_process_vram_bytes", "VRAM held by a process on a GPU.")
+	for _, s := range samples {
+		host := promLabel.Replace(sampleHost(s, local))
+		for _, p := range s.Processes {
+			fmt.Fprintf(&b, "mitop_process_vram_bytes{host=\"%s\",gpu=\"%d\",alias=\"%s\",pid=\"%d\",name=\"%s\"} %d\n",
+				host, p.GPU, promLabel.Replace(p.GPUAlias), p.Pid, promLabel.Replace(p.Name), p.VRAMBytes)
+		}
+	}
+	header("mitop_scrape_timestamp", "Unix time the metrics were written, to spot a stale file.")
+	fmt.Fprintf(&b, "mitop_scrape_timestamp %s\n", strconv.FormatFloat(float64(now.UnixMilli())/1000, 'f', 3, 64))
+	return []byte(b.String())
+}
+
+// TextfileSink keeps mitop.prom in a node_exporter textfile collector
+// directory up to date. The file is replaced atomically every sample, with
+// the latest sample of each host, and removed on shutdown so a stopped
+// mi-top doesn't leave frozen values behind.
+type TextfileSink struct {
+	dir    string
+	host   string
+	latest map[string]Sample // by host
+}
+
+func newTextfileSink(dir string) (*TextfileSink, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("--textfile-dir: %v", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("--textfile-dir: %s is not a directory", dir)
+	}
+	// A crash may have left a temporary file behind
+	os.Remove(filepath.Join(dir, textfileTemp))
+	return &TextfileSink{dir: dir, host: hostname(), latest: map[string]Sample{}}, nil
+}
+
+func (s *TextfileSink) Write(sample Sample) error {
+	s.latest[sample.Host] = sample
+	samples := slices.SortedFunc(maps.Values(s.latest), func(a, b Sample) int { return strings.Compare(a.Host, b.Host) })
+	tmp := filepath.Join(s.dir, textfileTemp)
+	if err := os.WriteFile(tmp, promText(samples, s.host, time.Now()), 0644); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("textfile write failed: %v", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, textfileName)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("textfile write failed: %v", err)
+	}
+	return nil
+}
+
+// Close removes the file, which would otherwise keep reporting the last
+// sample as current
+func (s *TextfileSink) Close() error {
+	if err := os.Remove(filepath.Join(s.dir, textfileName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
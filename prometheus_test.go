@@ -0,0 +1,17 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestPromDeviceLabels identifies each GPU by the same UUID and serial as
+// the influx tags, so a replaced card shows up as a new series
+func TestPromDeviceLabels(t *testing.T) {
+	m := GPUMetrics{GPUDevice: GPUDevice{ID: 1, BDF: "0000:83:00.0", UUID: "a1b2-c3", Serial: `SN"42`, ASIC: "MI300X"}, GFXUtil: 40}
+	out := string(promText([]Sample{{Host: "node-a", Time: t0, GPUs: []GPUMetrics{m}}}, "local", t0))
+	want := `{host="node-a",gpu="1",bdf="0000:83:00.0",uuid="a1b2-c3",serial="SN\"42",asic="MI300X",alias=""}`
+	if !strings.Contains(out, want) {
+		t.Errorf("no series with %s in\n%s", want, out)
+	}
+}
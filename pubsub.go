@@ -0,0 +1,73 @@
+package main
+
+import "sync"
+
+// broadcaster fans samples out to any number of subscribers. Each
+// subscriber has a bounded queue; when it is full the oldest sample is
+// dropped, so a slow consumer can never block the sampler.
+type broadcaster struct {
+	mu     sync.Mutex
+	subs   map[*subscription]struct{}
+	closed bool
+}
+
+type subscription struct {
+	C chan Sample
+}
+
+func newBroadcaster() *broadcaster {
+	return &broadcaster{subs: make(map[*subscription]struct{})}
+}
+
+func (b *broadcaster) subscribe(queueLen int) *subscription {
+	sub := &subscription{C: make(chan Sample, queueLen)}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(sub.C)
+		return sub
+	}
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+func (b *broadcaster) unsubscribe(sub *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.C)
+	}
+}
+
+func (b *broadcaster) publish(s Sample) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		select {
+		case sub.C <- s:
+			continue
+		default:
+		}
+		// Queue is full: drop the oldest and retry once
+		select {
+		case <-sub.C:
+		default:
+		}
+		select {
+		case sub.C <- s:
+		default:
+		}
+	}
+}
+
+// close ends every subscription; their channels are closed
+func (b *broadcaster) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subs {
+		close(sub.C)
+	}
+	b.subs = make(map[*subscription]struct{})
+}
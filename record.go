@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const recordFormat = "mtrec"
+
+// recordHeader is the first line of a recording
+type recordHeader struct {
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	Host    string    `json:"host"`
+	Started time.Time `json:"started"`
+}
+
+// Recorder appends every sample to a JSONL recording that --replay can play back
+type Recorder struct {
+	file *os.File
+	w    *bufio.Writer
+	enc  *json.Encoder
+}
+
+func newRecorder(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %v", err)
+	}
+	r := &Recorder{file: f, w: bufio.NewWriter(f)}
+	r.enc = json.NewEncoder(r.w)
+	if info, err := f.Stat(); err == nil && info.Size() == 0 {
+		r.enc.Encode(recordHeader{Format: recordFormat, Version: 2, Host: hostname(), Started: time.Now()})
+	}
+	return r, nil
+}
+
+func (r *Recorder) Write(s Sample) error {
+	r.enc.Encode(s)
+	if err := r.w.Flush(); err != nil {
+		// Start over with a fresh buffer so a later tick can retry
+		r.w.Reset(r.file)
+		return fmt.Errorf("recording write failed: %v", err)
+	}
+	return nil
+}
+
+func (r *Recorder) Close() error {
+	r.w.Flush()
+	return r.file.Close()
+}
+
+// loadRecording reads every sample from a recording. A truncated last line,
+// as left behind by a crash, is ignored.
+func loadRecording(path string) ([]Sample, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %v", err)
+	}
+	defer f.Close()
+	var samples []Sample
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if line == 1 {
+			var header recordHeader
+			if err := json.Unmarshal(scanner.Bytes(), &header); err == nil && header.Format == recordFormat {
+				continue
+			}
+		}
+		var s Sample
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			// Version 1 recordings stored PIDs and usage as strings
+			if s, err = decodeV1Sample(scanner.Bytes()); err != nil {
+				continue
+			}
+		}
+		if s.Time.IsZero() {
+			continue
+		}
+		// Capabilities aren't recorded; AMD GPUs have those amd-smi reads.
+		// N/A read as zero, which only tells for the memory temperature.
+		for i := range s.GPUs {
+			m := &s.GPUs[i]
+			if m.amd() {
+				m.Caps |= capJunctionTemp | capGTT | capProcessUsage | capProcessCPU
+			}
+			m.Caps |= capPower | capMemBusy
+			if m.hasMemTemp() {
+				m.Caps |= capMemTemp
+			}
+		}
+		samples = append(samples, s)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read recording: %v", err)
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("recording %s contains no samples", path)
+	}
+	// Replay plays into a single host's charts, where GPU ids from several
+	// hosts would collide
+	hosts := make(map[string]bool)
+	for _, s := range samples {
+		hosts[s.Host] = true
+	}
+	if len(hosts) > 1 {
+		names := slices.Sorted(maps.Keys(hosts))
+		return nil, fmt.Errorf("recording %s has samples from %d hosts (%s); only single-host recordings can be replayed",
+			path, len(names), strings.Join(names, ", "))
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+	return samples, nil
+}
+
+// v1Process is a process as written by version 1 recordings: usage as
+// "12%", PID as a string and memory in MB
+type v1Process struct {
+	Host     string  `json:"host"`
+	GPU      int     `json:"gpu"`
+	Name     string  `json:"name"`
+	PID      string  `json:"pid"`
+	GTTMem   float64 `json:"gtt_mem"`
+	CPUMem   float64 `json:"cpu_mem"`
+	VRAMMem  float64 `json:"vram_mem"`
+	TotalMem float64 `json:"total_mem"`
+	GFXUsage string  `json:"gfx_usage"`
+}
+
+func decodeV1Sample(data []byte) (Sample, error) {
+	var v1 struct {
+		Sample
+		Processes []v1Process `json:"processes"`
+	}
+	if err := json.Unmarshal(data, &v1); err != nil {
+		return Sample{}, err
+	}
+	s := v1.Sample
+	s.Processes = make([]ProcessInfo, 0, len(v1.Processes))
+	toBytes := func(mb float64) uint64 { return uint64(mb * 1024 * 1024) }
+	for _, p := range v1.Processes {
+		pid, _ := strconv.Atoi(p.PID)
+		usage, _ := strconv.ParseFloat(strings.TrimSuffix(p.GFXUsage, "%"), 64)
+		s.Processes = append(s.Processes, ProcessInfo{
+			Host: p.Host, GPU: p.GPU, Name: p.Name, Pid: pid, UsagePercent: usage,
+			GTTBytes: toBytes(p.GTTMem), CPUBytes: toBytes(p.CPUMem),
+			VRAMBytes: toBytes(p.VRAMMem), TotalBytes: toBytes(p.TotalMem),
+		})
+	}
+	return s, nil
+}
+
+var replaySpeeds = []float64{0.25, 0.5, 1, 2, 4, 8, 16, 32, 64}
+
+// Replayer plays back recorded samples against a virtual clock
+type Replayer struct {
+	samples []Sample
+	next    int       // index of the next sample to emit
+	pos     time.Time // playback position in recording time
+	last    time.Time // wall clock of the previous advance
+	speed   int       // index into replaySpeeds
+	paused  bool
+}
+
+func newReplayer(samples []Sample) *Replayer {
+	return &Replayer{
+		samples: samples,
+		pos:     samples[0].Time,
+		last:    time.Now(),
+		speed:   2,
+	}
+}
+
+func (r *Replayer) first() Sample {
+	return r.samples[0]
+}
+
+// interval is the mean time between the recorded samples, or 0 with one
+func (r *Replayer) interval() time.Duration {
+	n := len(r.samples)
+	if n < 2 {
+		return 0
+	}
+	return r.samples[n-1].Time.Sub(r.samples[0].Time) / time.Duration(n-1)
+}
+
+// advance moves the virtual clock forward and returns the samples due
+func (r *Replayer) advance(now time.Time) []Sample {
+	elapsed := now.Sub(r.last)
+	r.last = now
+	if r.paused || r.next >= len(r.samples) {
+		return nil
+	}
+	r.pos = r.pos.Add(time.Duration(float64(elapsed) * replaySpeeds[r.speed]))
+	start := r.next
+	for r.next < len(r.samples) && !r.samples[r.next].Time.After(r.pos) {
+		r.next++
+	}
+	return r.samples[start:r.next]
+}
+
+// seek jumps the playback position; the caller should rebuild its state from window
+func (r *Replayer) seek(d time.Duration) {
+	r.pos = r.pos.Add(d)
+	if first := r.samples[0].Time; r.pos.Before(first) {
+		r.pos = first
+	}
+	if last := r.samples[len(r.samples)-1].Time; r.pos.After(last) {
+		r.pos = last
+	}
+	r.next = sort.Search(len(r.samples), func(i int) bool { return r.samples[i].Time.After(r.pos) })
+}
+
+// window returns up to n samples leading up to the playback position
+func (r *Replayer) window(n int) []Sample {
+	start := r.next - n
+	if start < 0 {
+		start = 0
+	}
+	return r.samples[start:r.next]
+}
+
+func (r *Replayer) togglePause() {
+	r.paused = !r.paused
+}
+
+func (r *Replayer) faster() {
+	if r.speed < len(replaySpeeds)-1 {
+		r.speed++
+	}
+}
+
+func (r *Replayer) slower() {
+	if r.speed > 0 {
+		r.speed--
+	}
+}
+
+func (r *Replayer) status() string {
+	state := "playing"
+	if r.paused {
+		state = "paused"
+	} else if r.next >= len(r.samples) {
+		state = "end"
+	}
+	return fmt.Sprintf("REPLAY %s %gx %s [%d/%d] (Space pause, +/- speed, ←/→ seek)",
+		zoned(r.pos).Format("2006-01-02 15:04:05"), replaySpeeds[r.speed], state, r.next, len(r.samples))
+}
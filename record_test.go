@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLoadRecordingHosts replays a recording of one host and refuses one of
+// several, whose GPU ids would land in the same charts
+func TestLoadRecordingHosts(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		hosts []string
+		ok    bool
+	}{
+		{[]string{"node-a", "node-a"}, true},
+		{[]string{"node-a", "node-b"}, false},
+	} {
+		path := filepath.Join(dir, strings.Join(tc.hosts, "-")+".jsonl")
+		var b strings.Builder
+		for _, host := range tc.hosts {
+			b.WriteString(`{"host":"` + host + `","timestamp":"2024-01-01T00:00:01Z","gpus":[{"id":0}],"processes":[]}` + "\n")
+		}
+		if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		samples, err := loadRecording(path)
+		if tc.ok && (err != nil || len(samples) != 2) {
+			t.Errorf("%v: %d samples, %v", tc.hosts, len(samples), err)
+		}
+		if !tc.ok && (err == nil || !strings.Contains(err.Error(), "node-a, node-b")) {
+			t.Errorf("%v: %v, want it refused naming the hosts", tc.hosts, err)
+		}
+	}
+}
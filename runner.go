@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A hung amd-smi must not stall collection forever
+const commandTimeout = 10 * time.Second
+
+// How much of a command's stdout and stderr is kept for errors and the log
+const commandCaptureBytes = 4096
+
+// commandRunner executes the SMI tools, either locally or on another host.
+// Output is streamed so large process lists are parsed as they arrive; the
+// caller must Close the stream, which waits for the command and returns its
+// error.
+type commandRunner interface {
+	Start(name string, args ...string) (io.ReadCloser, error)
+}
+
+// smiRunner is used by every collector
+var smiRunner commandRunner = localRunner{}
+
+// cappedBuffer keeps the first max bytes written to it and drops the rest
+type cappedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+// commandStream is a running command's stdout
+type commandStream struct {
+	io.Reader
+	cmd     *exec.Cmd
+	ctx     context.Context
+	cancel  context.CancelFunc
+	start   time.Time
+	host    string
+	name    string
+	args    []string
+	head    *cappedBuffer
+	stderr  *cappedBuffer
+	mapErr  func(s *commandStream, err error) error
+	closed  bool
+	waitErr error
+}
+
+// startCommand runs cmdName cmdArgs with a timeout; name and args are what
+// gets logged, which differ from the command line for ssh
+func startCommand(host, name string, args []string, cmdName string, cmdArgs []string, mapErr func(*commandStream, error) error) (io.ReadCloser, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	cmd := exec.CommandContext(ctx, cmdName, cmdArgs...)
+	s := &commandStream{
+		cmd: cmd, ctx: ctx, cancel: cancel, start: time.Now(),
+		host: host, name: name, args: args,
+		head:   &cappedBuffer{max: commandCaptureBytes},
+		stderr: &cappedBuffer{max: commandCaptureBytes},
+		mapErr: mapErr,
+	}
+	cmd.Stderr = s.stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		cancel()
+		logExec(host, name, args, s.start, nil, nil, err)
+		return nil, err
+	}
+	s.Reader = io.TeeReader(stdout, s.head)
+	return s, nil
+}
+
+// Close drains unread output so the command can exit, then reaps it
+func (s *commandStream) Close() error {
+	if s.closed {
+		return s.waitErr
+	}
+	s.closed = true
+	io.Copy(io.Discard, s.Reader)
+	err := s.cmd.Wait()
+	s.cancel()
+	logExec(s.host, s.name, s.args, s.start, s.head.Bytes(), s.stderr.Bytes(), err)
+	if err != nil {
+		err = s.mapErr(s, err)
+	}
+	s.waitErr = err
+	return err
+}
+
+// runCommand collects a command's whole output
+func runCommand(r commandRunner, name string, args ...string) ([]byte, error) {
+	stream, err := r.Start(name, args...)
+	if err != nil {
+		return nil, err
+	}
+	out, _ := io.ReadAll(stream)
+	if err := stream.Close(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+type localRunner struct{}
+
+func (localRunner) Start(name string, args ...string) (io.ReadCloser, error) {
+	return startCommand("", name, args, name, args, func(s *commandStream, err error) error {
+		if s.ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("%s %w after %v", name, errTimedOut, commandTimeout)
+		}
+		if stderr := strings.TrimSpace(s.stderr.String()); stderr != "" {
+			return fmt.Errorf("%w: %s", err, stderr)
+		}
+		return err
+	})
+}
+
+// errTimedOut means a local command was killed at commandTimeout
+var errTimedOut = errors.New("timed out")
+
+// errRemoteDown means the SSH link failed rather than the remote command
+var errRemoteDown = errors.New("remote host unreachable")
+
+// sshRunner runs commands through the system ssh client. A control master
+// keeps one connection open so each tick doesn't pay for a new handshake;
+// authentication uses the agent or the given key file.
+type sshRunner struct {
+	target  string
+	keyFile string
+	// controlPath is where the control master's socket goes, empty to
+	// connect anew each time
+	controlPath string
+}
+
+func newSSHRunner(target, keyFile string) *sshRunner {
+	r := &sshRunner{target: target, keyFile: keyFile}
+	if dir, err := sshControlDir(); err != nil {
+		debugLog.Warn("ssh connection sharing disabled", "err", err)
+	} else {
+		r.controlPath = filepath.Join(dir, "ssh-%C")
+	}
+	return r
+}
+
+// sshControlDir is a directory only the user can reach for the control
+// master sockets, since anyone who can connect to one can run commands on
+// the remote host: mi-top in $XDG_RUNTIME_DIR, or in ~/.ssh without one.
+// It is created with mode 0700, and tightened to it if it exists.
+func sshControlDir() (string, error) {
+	base := os.Getenv("XDG_RUNTIME_DIR")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, ".ssh")
+	}
+	dir := filepath.Join(base, "mi-top")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", dir)
+	}
+	if info.Mode().Perm() != 0o700 {
+		if err := os.Chmod(dir, 0o700); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// sshArgs are the arguments of ssh that run name with args on the target
+func (r *sshRunner) sshArgs(name string, args []string) []string {
+	sshArgs := []string{
+		"-o", "BatchMode=yes",
+		"-o", "ConnectTimeout=5",
+		"-o", "ServerAliveInterval=5",
+		"-o", "ServerAliveCountMax=2",
+	}
+	if r.controlPath != "" {
+		sshArgs = append(sshArgs,
+			"-o", "ControlMaster=auto",
+			"-o", "ControlPath="+r.controlPath,
+			"-o", "ControlPersist=60",
+		)
+	}
+	if r.keyFile != "" {
+		sshArgs = append(sshArgs, "-i", r.keyFile)
+	}
+	remote := make([]string, 0, len(args)+1)
+	remote = append(remote, shellQuote(name))
+	for _, a := range args {
+		remote = append(remote, shellQuote(a))
+	}
+	return append(sshArgs, r.target, "--", strings.Join(remote, " "))
+}
+
+func (r *sshRunner) Start(name string, args ...string) (io.ReadCloser, error) {
+	return startCommand(r.target, name, args, "ssh", r.sshArgs(name, args), func(s *commandStream, err error) error {
+		if s.ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("%w: %s timed out after %v", errRemoteDown, r.target, commandTimeout)
+		}
+		var exitErr *exec.ExitError
+		// ssh reports its own failures with exit status 255
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 255 {
+			return fmt.Errorf("%w: %s: %s", errRemoteDown, r.target, strings.TrimSpace(s.stderr.String()))
+		}
+		return err
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSSHControlDir(t *testing.T) {
+	t.Run("runtime dir", func(t *testing.T) {
+		runtime := t.TempDir()
+		t.Setenv("XDG_RUNTIME_DIR", runtime)
+		dir, err := sshControlDir()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := filepath.Join(runtime, "mi-top"); dir != want {
+			t.Errorf("dir = %s, want %s", dir, want)
+		}
+		checkPrivate(t, dir)
+	})
+	t.Run("home", func(t *testing.T) {
+		home := t.TempDir()
+		t.Setenv("XDG_RUNTIME_DIR", "")
+		t.Setenv("HOME", home)
+		dir, err := sshControlDir()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := filepath.Join(home, ".ssh", "mi-top"); dir != want {
+			t.Errorf("dir = %s, want %s", dir, want)
+		}
+		checkPrivate(t, dir)
+		checkPrivate(t, filepath.Dir(dir))
+	})
+	t.Run("tightened", func(t *testing.T) {
+		runtime := t.TempDir()
+		t.Setenv("XDG_RUNTIME_DIR", runtime)
+		if err := os.Mkdir(filepath.Join(runtime, "mi-top"), 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(filepath.Join(runtime, "mi-top"), 0o777); err != nil {
+			t.Fatal(err)
+		}
+		dir, err := sshControlDir()
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkPrivate(t, dir)
+	})
+	t.Run("symlink", func(t *testing.T) {
+		runtime := t.TempDir()
+		t.Setenv("XDG_RUNTIME_DIR", runtime)
+		if err := os.Symlink(t.TempDir(), filepath.Join(runtime, "mi-top")); err != nil {
+			t.Fatal(err)
+		}
+		if dir, err := sshControlDir(); err == nil {
+			t.Errorf("dir = %s, want an error for a symlink", dir)
+		}
+	})
+}
+
+func checkPrivate(t *testing.T, dir string) {
+	t.Helper()
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o700 {
+		t.Errorf("%s has mode %o, want 700", dir, perm)
+	}
+}
+
+func TestSSHRunnerControlPath(t *testing.T) {
+	runtime := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtime)
+	r := newSSHRunner("gpu-node", "")
+	if want := filepath.Join(runtime, "mi-top", "ssh-%C"); r.controlPath != want {
+		t.Errorf("controlPath = %s, want %s", r.controlPath, want)
+	}
+	if args := r.sshArgs("amd-smi", nil); !slices.Contains(args, "ControlPath="+r.controlPath) {
+		t.Errorf("ssh %q doesn't use the control path", args)
+	}
+
+	// Without a private directory, connections aren't shared
+	t.Setenv("XDG_RUNTIME_DIR", filepath.Join(runtime, "file"))
+	if err := os.WriteFile(filepath.Join(runtime, "file"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if r := newSSHRunner("gpu-node", ""); r.controlPath != "" || slices.Contains(r.sshArgs("amd-smi", nil), "ControlMaster=auto") {
+		t.Errorf("controlPath = %q, want connection sharing off", r.controlPath)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// gpuScope picks the GPUs a config override applies to: by index, bus
+// address, or a glob pattern on the ASIC name such as "*MI300X*". Fields
+// left empty match any GPU; an override with none set applies to all.
+type gpuScope struct {
+	gpu  *int
+	bdf  string
+	asic string
+}
+
+// specificity ranks how closely the scope picks the GPU: -1 when it doesn't
+// match, 0 for all GPUs, 1 by ASIC name, 2 by index or bus address. More
+// specific overrides win.
+func (s gpuScope) specificity(id int, bdf, asic string) int {
+	rank := 0
+	if s.asic != "" {
+		if ok, _ := path.Match(strings.ToLower(s.asic), strings.ToLower(asic)); !ok || asic == "" {
+			return -1
+		}
+		rank = 1
+	}
+	if s.gpu != nil {
+		if *s.gpu != id {
+			return -1
+		}
+		rank = 2
+	}
+	if s.bdf != "" {
+		if !strings.EqualFold(s.bdf, bdf) {
+			return -1
+		}
+		rank = 2
+	}
+	return rank
+}
+
+func (s gpuScope) validate() error {
+	if _, err := path.Match(s.asic, ""); err != nil {
+		return fmt.Errorf("invalid asic pattern %q", s.asic)
+	}
+	return nil
+}
+
+// inScope returns the overrides that apply to a GPU, least specific first
+// so applying them in order leaves the most specific values. Overrides of
+// the same rank apply in config order.
+func inScope[T any](overrides []T, scope func(T) gpuScope, id int, bdf, asic string) []T {
+	type ranked struct {
+		o    T
+		rank int
+	}
+	var found []ranked
+	for _, o := range overrides {
+		if rank := scope(o).specificity(id, bdf, asic); rank >= 0 {
+			found = append(found, ranked{o, rank})
+		}
+	}
+	slices.SortStableFunc(found, func(a, b ranked) int { return cmp.Compare(a.rank, b.rank) })
+	out := make([]T, len(found))
+	for i, r := range found {
+		out[i] = r.o
+	}
+	return out
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// systemdNotifier speaks the sd_notify protocol: state strings sent as
+// datagrams to the socket systemd passes in NOTIFY_SOCKET, for Type=notify
+// units. With WatchdogSec= set, watchdog is how often systemd expects a
+// WATCHDOG=1 before it considers the service hung.
+type systemdNotifier struct {
+	conn     *net.UnixConn
+	watchdog time.Duration // 0 without a watchdog
+}
+
+// newSystemdNotifier connects to NOTIFY_SOCKET; it returns nil when mi-top
+// doesn't run under systemd with notify support
+func newSystemdNotifier() (*systemdNotifier, error) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil, nil
+	}
+	// A leading @ names a socket in the abstract namespace
+	if strings.HasPrefix(path, "@") {
+		path = "\x00" + path[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NOTIFY_SOCKET: %v", err)
+	}
+	n := &systemdNotifier{conn: conn}
+	// The watchdog is meant for this process unless WATCHDOG_PID says otherwise
+	if pid := os.Getenv("WATCHDOG_PID"); pid == "" || pid == strconv.Itoa(os.Getpid()) {
+		if usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64); err == nil && usec > 0 {
+			n.watchdog = time.Duration(usec) * time.Microsecond
+		}
+	}
+	return n, nil
+}
+
+func (n *systemdNotifier) notify(state string) error {
+	if _, err := n.conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("sd_notify %s failed: %v", state, err)
+	}
+	return nil
+}
+
+func (n *systemdNotifier) close() {
+	n.conn.Close()
+}
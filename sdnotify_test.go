@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// listenNotify is a fake systemd notify socket, set as NOTIFY_SOCKET
+func listenNotify(t *testing.T) *net.UnixConn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+// readNotify returns the next state sent to the socket
+func readNotify(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestSystemdNotifierEnv(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if n, err := newSystemdNotifier(); n != nil || err != nil {
+		t.Errorf("without NOTIFY_SOCKET: %v, %v; want neither", n, err)
+	}
+
+	conn := listenNotify(t)
+	for _, tc := range []struct {
+		usec, pid string
+		watchdog  time.Duration
+	}{
+		{"", "", 0},
+		{"20000000", "", 20 * time.Second},
+		{"20000000", strconv.Itoa(os.Getpid()), 20 * time.Second},
+		{"20000000", "1", 0}, // meant for another process
+		{"junk", "", 0},
+	} {
+		t.Setenv("WATCHDOG_USEC", tc.usec)
+		t.Setenv("WATCHDOG_PID", tc.pid)
+		n, err := newSystemdNotifier()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.watchdog != tc.watchdog {
+			t.Errorf("WATCHDOG_USEC=%q WATCHDOG_PID=%q: watchdog %v, want %v", tc.usec, tc.pid, n.watchdog, tc.watchdog)
+		}
+		if err := n.notify("STATUS=test"); err != nil {
+			t.Fatal(err)
+		}
+		if got := readNotify(t, conn); got != "STATUS=test" {
+			t.Errorf("socket got %q", got)
+		}
+		n.close()
+	}
+
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "missing"))
+	if _, err := newSystemdNotifier(); err == nil {
+		t.Error("no error for a socket nobody listens on")
+	}
+}
+
+// TestHeadlessNotify runs the headless sampler under a fake systemd with a
+// watchdog: every collection pings it, readiness waits for the first one
+// that succeeds, and SIGTERM ends with STOPPING=1
+func TestHeadlessNotify(t *testing.T) {
+	conn := listenNotify(t)
+	t.Setenv("WATCHDOG_USEC", "10000000")
+	t.Setenv("WATCHDOG_PID", "")
+	// The sampler reports its errors on stderr
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	var calls atomic.Int32
+	h := newHostView("", fakeRunner{})
+	h.backend = &backendSelector{candidates: []metricsBackend{{name: "fake", collect: func(commandRunner) (Sample, error) {
+		if calls.Add(1) == 1 {
+			return Sample{}, errors.New("amd-smi timed out")
+		}
+		return Sample{Time: time.Now(), GPUs: []GPUMetrics{{GPUDevice: GPUDevice{ID: 0}}}}, nil
+	}}}}
+	done := make(chan error, 1)
+	go func() { done <- runHeadless(h, nil, 10*time.Millisecond) }()
+
+	var states []string
+	for !slices.Contains(states, "READY=1") || len(states) < 5 {
+		states = append(states, readNotify(t, conn))
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGTERM didn't stop the sampler")
+	}
+	for {
+		state := readNotify(t, conn)
+		states = append(states, state)
+		if state == "STOPPING=1" {
+			break
+		}
+	}
+
+	// The failed collection pings the watchdog but isn't ready; the next
+	// one is both
+	if want := []string{"WATCHDOG=1", "WATCHDOG=1", "READY=1"}; !slices.Equal(states[:3], want) {
+		t.Errorf("started with %q, want %q", states[:3], want)
+	}
+	for i, state := range states[3 : len(states)-1] {
+		if state != "WATCHDOG=1" {
+			t.Errorf("state %d is %q, want only watchdog pings once ready", i+3, state)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	ui "github.com/gizak/termui/v3"
+	"github.com/gizak/termui/v3/widgets"
+)
+
+// peak is a session maximum and when it was reached
+type peak struct {
+	value float64
+	at    time.Time
+}
+
+func (p *peak) observe(v float64, t time.Time) {
+	if p.at.IsZero() || v > p.value {
+		p.value, p.at = v, t
+	}
+}
+
+func (p peak) format(unit string) string {
+	if p.at.IsZero() {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%s at %s", p.value, unit, zoned(p.at).Format("15:04:05"))
+}
+
+// sessionStats are running totals and peaks for one GPU since launch or
+// the last session reset ('r'), independent of the visible history
+type sessionStats struct {
+	count   int
+	utilSum float64
+	temp    peak
+	memTemp peak
+	power   peak
+	util    peak
+	vram    peak
+	energy  float64 // joules, power integrated over the sample intervals
+	last    time.Time
+}
+
+func (s *sessionStats) avgUtil() float64 {
+	if s.count == 0 {
+		return 0
+	}
+	return s.utilSum / float64(s.count)
+}
+
+// addSession feeds a sample into a GPU's session totals and returns them.
+// Only successful collections reach it; partial rows may lack values and
+// are left out.
+func (h *hostView) addSession(t time.Time, m GPUMetrics) *sessionStats {
+	s, ok := h.session[m.ID]
+	if !ok {
+		s = &sessionStats{}
+		h.session[m.ID] = s
+	}
+	if m.Partial {
+		return s
+	}
+	if !s.last.IsZero() {
+		s.energy += m.Power * t.Sub(s.last).Seconds()
+	}
+	s.last = t
+	s.count++
+	s.utilSum += m.GFXUtil
+	s.temp.observe(m.GPUTemp, t)
+	if m.hasMemTemp() {
+		s.memTemp.observe(m.MemTemp, t)
+	}
+	s.power.observe(m.Power, t)
+	s.util.observe(m.GFXUtil, t)
+	s.vram.observe(m.VRAMUsed, t)
+	return s
+}
+
+// summaryProcesses is how many processes the exit summary lists
+const summaryProcesses = 5
+
+// maxProcessPeaks bounds the processes tracked for the exit summary; past
+// it only the ones that can still make the list are kept
+const maxProcessPeaks = 512
+
+type processPeakKey struct {
+	gpu  int
+	pid  int
+	name string
+}
+
+// processPeak is the most VRAM a process held on a GPU during the session
+type processPeak struct {
+	processPeakKey
+	vram uint64
+	at   time.Time
+}
+
+// addProcesses records the processes' VRAM peaks for the exit summary
+func (h *hostView) addProcesses(t time.Time, processes []ProcessInfo) {
+	for _, p := range processes {
+		key := processPeakKey{gpu: p.GPU, pid: p.Pid, name: p.Name}
+		pk, ok := h.procPeaks[key]
+		if !ok {
+			pk = &processPeak{processPeakKey: key}
+			h.procPeaks[key] = pk
+		}
+		if p.VRAMBytes > pk.vram || pk.at.IsZero() {
+			pk.vram, pk.at = p.VRAMBytes, t
+		}
+	}
+	if len(h.procPeaks) > maxProcessPeaks {
+		for _, pk := range topProcessPeaks([]*hostView{h})[min(summaryProcesses, len(h.procPeaks)):] {
+			delete(h.procPeaks, pk.peak.processPeakKey)
+		}
+	}
+}
+
+// hostPeak is a process peak with the host it ran on
+type hostPeak struct {
+	host *hostView
+	peak *processPeak
+}
+
+// topProcessPeaks orders the hosts' process peaks by VRAM, largest first
+func topProcessPeaks(hosts []*hostView) []hostPeak {
+	var peaks []hostPeak
+	for _, h := range hosts {
+		for _, pk := range h.procPeaks {
+			peaks = append(peaks, hostPeak{host: h, peak: pk})
+		}
+	}
+	slices.SortFunc(peaks, func(a, b hostPeak) int {
+		return cmp.Or(cmp.Compare(b.peak.vram, a.peak.vram), cmp.Compare(a.peak.pid, b.peak.pid))
+	})
+	return peaks
+}
+
+// resetSession starts the session totals over
+func (h *hostView) resetSession() {
+	h.session = map[int]*sessionStats{}
+	h.procPeaks = map[processPeakKey]*processPeak{}
+	h.sessionStart = time.Now()
+}
+
+// newPeaksTable is the info view toggled with 'i'
+func newPeaksTable() *widgets.Table {
+	table := widgets.NewTable()
+	table.Title = "Session peaks (" + keyMap.hint(actionReset) + " resets)"
+	table.TextStyle = ui.NewStyle(ui.ColorWhite)
+	table.RowSeparator = false
+	table.BorderStyle = ui.NewStyle(ui.ColorWhite)
+	return table
+}
+
+// updatePeaksTable shows every GPU's session peaks
+func updatePeaksTable(table *widgets.Table, hosts []*hostView, multi bool) {
+	rows := [][]string{{"GPU", "TEMP", "HBM", "POWER", "UTIL", "VRAM USED"}}
+	table.RowStyles = map[int]ui.Style{0: ui.NewStyle(ui.ColorWhite, ui.ColorClear, ui.ModifierBold)}
+	for _, h := range hosts {
+		for _, id := range h.ids {
+			s, ok := h.session[id]
+			if !ok {
+				continue
+			}
+			rows = append(rows, []string{
+				fmt.Sprintf("%sGPU %d", h.titlePrefix(multi), id),
+				s.temp.format("°C"), s.memTemp.format("°C"), s.power.format(" W"),
+				s.util.format("%"), s.vram.format(" MB"),
+			})
+		}
+	}
+	table.Rows = rows
+}
+
+// formatEnergy shows joules as Wh, or kWh from 1 kWh on
+func formatEnergy(joules float64) string {
+	if wh := joules / 3600; wh < 1000 {
+		return fmt.Sprintf("%.1f Wh", wh)
+	}
+	return fmt.Sprintf("%.2f kWh", joules/3.6e6)
+}
+
+// writeSessionSummary prints the session totals and peaks once the
+// terminal UI is gone, so they stay in the scrollback: per GPU the
+// utilization, peaks and energy, then the processes that held the most
+// VRAM
+func writeSessionSummary(w io.Writer, hosts []*hostView, multi bool, end time.Time) {
+	for _, h := range hosts {
+		if len(h.session) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%ssession %s – %s (%v):\n", h.titlePrefix(multi),
+			zoned(h.sessionStart).Format("2006-01-02 15:04:05"), zoned(end).Format("15:04:05"), end.Sub(h.sessionStart).Round(time.Second))
+		for _, id := range h.ids {
+			s, ok := h.session[id]
+			if !ok || s.count == 0 {
+				continue
+			}
+			parts := []string{fmt.Sprintf("util avg %.1f%% max %s", s.avgUtil(), s.util.format("%")), "temp " + s.temp.format("°C")}
+			if !s.memTemp.at.IsZero() {
+				parts = append(parts, "HBM "+s.memTemp.format("°C"))
+			}
+			parts = append(parts, "power "+s.power.format(" W"), "VRAM "+s.vram.format(" MB"), "energy "+formatEnergy(s.energy))
+			fmt.Fprintf(w, "  GPU %d: %s\n", id, strings.Join(parts, ", "))
+		}
+	}
+	peaks := topProcessPeaks(hosts)
+	if len(peaks) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "top processes by peak VRAM:\n")
+	for _, hp := range peaks[:min(summaryProcesses, len(peaks))] {
+		pk := hp.peak
+		fmt.Fprintf(w, "  %sGPU %d %s (pid %d): %.0f MB at %s\n", hp.host.titlePrefix(multi), pk.gpu, pk.name, pk.pid,
+			mib(pk.vram), zoned(pk.at).Format("15:04:05"))
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// Sink receives every collected sample. Implementations must not block the
+// sampler for long; network sinks should queue and send in the background.
+type Sink interface {
+	Write(Sample) error
+	Close() error
+}
+
+// writeSinks hands the sample to every sink and returns the first failure,
+// which the UI shows as a warning.
+func writeSinks(sinks []Sink, s Sample) error {
+	var first error
+	for _, sink := range sinks {
+		if err := sink.Write(s); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func closeSinks(sinks []Sink) {
+	for _, sink := range sinks {
+		sink.Close()
+	}
+}
+
+// hubSink publishes every sample to the streaming subscribers
+type hubSink struct {
+	hub *broadcaster
+}
+
+func (h hubSink) Write(s Sample) error {
+	h.hub.publish(s)
+	return nil
+}
+
+func (h hubSink) Close() error {
+	h.hub.close()
+	return nil
+}
+
+// sampleHost names the host a sample came from, defaulting to this machine
+func sampleHost(s Sample, local string) string {
+	if s.Host != "" {
+		return s.Host
+	}
+	return local
+}
+
+// hostname is used as a tag/label by the exporting sinks
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return name
+}
+
+// openSinks creates every sink enabled on the command line or in the config
+func openSinks(cfg *Config) ([]Sink, error) {
+	var sinks []Sink
+	add := func(sink Sink, err error) error {
+		if err != nil {
+			closeSinks(sinks)
+			return err
+		}
+		sinks = append(sinks, sink)
+		return nil
+	}
+	if *logCSVPath != "" {
+		if err := add(newCSVLogger(*logCSVPath, *logMaxMB, *logKeep)); err != nil {
+			return nil, err
+		}
+	}
+	if *streamFormat == "ndjson" {
+		if err := add(newNDJSONSink(os.Stdout), nil); err != nil {
+			return nil, err
+		}
+	}
+	if *streamFormat == "csv" {
+		if err := add(newCSVStreamSink(os.Stdout, *streamProcesses)); err != nil {
+			return nil, err
+		}
+	}
+	if *influxStdout {
+		if err := add(newInfluxWriterSink("-")); err != nil {
+			return nil, err
+		}
+	}
+	if *influxFile != "" {
+		if err := add(newInfluxWriterSink(*influxFile)); err != nil {
+			return nil, err
+		}
+	}
+	if *influxURL != "" {
+		if err := add(newInfluxHTTPSink(*influxURL, *influxToken, *influxOrg, *influxBucket)); err != nil {
+			return nil, err
+		}
+	}
+	if *recordPath != "" {
+		if err := add(newRecorder(*recordPath)); err != nil {
+			return nil, err
+		}
+	}
+	if *statsdAddr != "" {
+		if err := add(newStatsdSink(*statsdAddr, *statsdPrefix, *statsdSampleRate)); err != nil {
+			return nil, err
+		}
+	}
+	if *graphiteAddr != "" {
+		if err := add(newGraphiteSink(*graphiteAddr, *graphitePrefix)); err != nil {
+			return nil, err
+		}
+	}
+	if *otlpEndpoint != "" {
+		if err := add(newOTLPSink(*otlpEndpoint, *otlpInterval, cfg.Interval)); err != nil {
+			return nil, err
+		}
+	}
+	if *textfileDir != "" {
+		if err := add(newTextfileSink(*textfileDir)); err != nil {
+			return nil, err
+		}
+	}
+	if *dbPath != "" {
+		if err := add(newSQLiteSink(*dbPath, *dbRetention)); err != nil {
+			return nil, err
+		}
+	}
+	if *mqttBroker != "" {
+		if err := add(newMQTTSink(mqttConfig{
+			broker:          *mqttBroker,
+			topicPrefix:     strings.TrimSuffix(*mqttTopicPrefix, "/"),
+			discoveryPrefix: strings.TrimSuffix(*mqttDiscovery, "/"),
+			username:        *mqttUsername,
+			password:        *mqttPassword,
+			tls:             *mqttTLS,
+			interval:        *mqttInterval,
+		})); err != nil {
+			return nil, err
+		}
+	}
+	// Streaming consumers share one hub; it is closed first so their
+	// subscriptions end before the servers shut down
+	if *httpAddr != "" || *grpcAddr != "" {
+		hub := newBroadcaster()
+		sinks = append(sinks, hubSink{hub})
+		if *httpAddr != "" {
+			if err := add(newAPIServer(*httpAddr, *httpHistory, hub)); err != nil {
+				return nil, err
+			}
+		}
+		if *grpcAddr != "" {
+			if err := add(newGRPCServer(*grpcAddr, hub)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(cfg.Alerts.Rules) > 0 {
+		if err := add(newAlerter(cfg.Alerts, *notifyDesktop)); err != nil {
+			return nil, err
+		}
+	}
+	return sinks, nil
+}
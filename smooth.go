@@ -0,0 +1,46 @@
+package main
+
+// defaultSmoothing is the EMA weight of the newest sample when 'e' turns
+// smoothing on without one configured
+const defaultSmoothing = 0.3
+
+// smooth is the exponential moving average of the history with weight alpha
+// for the newest sample, written into dst when it is as long. Blank
+// samples stay blank and the average starts over after them. Only the
+// display is smoothed; the history itself keeps the raw samples.
+func (gh *GPUHistory) smooth(alpha float64, dst *GPUHistory) *GPUHistory {
+	if dst == nil || dst.maxLen != gh.maxLen {
+		dst = &GPUHistory{values: make([]float64, gh.maxLen), maxLen: gh.maxLen}
+	}
+	// The timestamps don't change and are shared
+	dst.times, dst.index, dst.count = gh.times, gh.index, gh.count
+	avg, started := 0.0, false
+	for k := range gh.count {
+		slot := k
+		if gh.count == gh.maxLen {
+			slot = (gh.index + k) % gh.maxLen
+		}
+		v := gh.values[slot]
+		switch {
+		case v == chartBlank:
+			started = false
+		case !started:
+			avg, started = v, true
+		default:
+			avg = alpha*v + (1-alpha)*avg
+		}
+		if started {
+			v = avg
+		}
+		dst.values[slot] = v
+	}
+	return dst
+}
+
+// latest is the newest sample, or 0 without any
+func (gh *GPUHistory) latest() float64 {
+	if gh.count == 0 {
+		return 0
+	}
+	return gh.at(gh.count - 1)
+}
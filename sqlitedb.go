@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// There is no SQLite driver without cgo, so the history database is
+// maintained through the sqlite3 command line shell.
+
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS gpu_samples (
+	ts INTEGER NOT NULL,
+	host TEXT NOT NULL DEFAULT '',
+	gpu INTEGER NOT NULL,
+	gfx_util REAL, power REAL, gpu_temp REAL, mem_temp REAL,
+	gfx_clock REAL, mem_util REAL, mem_clock REAL,
+	vram_used REAL, vram_total REAL
+);
+CREATE INDEX IF NOT EXISTS gpu_samples_ts ON gpu_samples (ts, gpu);
+CREATE TABLE IF NOT EXISTS process_samples (
+	ts INTEGER NOT NULL,
+	host TEXT NOT NULL DEFAULT '',
+	gpu INTEGER NOT NULL,
+	pid INTEGER, name TEXT, gfx_usage REAL,
+	vram_mb REAL, gtt_mb REAL, cpu_mb REAL, total_mb REAL
+);
+CREATE INDEX IF NOT EXISTS process_samples_ts ON process_samples (ts, gpu);
+`
+
+// sqliteMissingHost lists the tables of a database from before the host
+// column was added
+const sqliteMissingHost = `SELECT m.name FROM sqlite_master m WHERE m.type = 'table'
+	AND m.name IN ('gpu_samples', 'process_samples')
+	AND NOT EXISTS (SELECT 1 FROM pragma_table_info(m.name) p WHERE p.name = 'host');`
+
+// sqliteMigrations returns the statements that bring an existing database
+// up to sqliteSchema. A database that can't be read needs none here; the
+// shell reports why once it starts.
+func sqliteMigrations(sqlite, path string) string {
+	out, err := exec.Command(sqlite, "-batch", path, sqliteMissingHost).Output()
+	if err != nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, table := range strings.Fields(string(out)) {
+		fmt.Fprintf(&b, "ALTER TABLE %s ADD COLUMN host TEXT NOT NULL DEFAULT '';\n", table)
+	}
+	return b.String()
+}
+
+// sqliteMarker is selected after each batch of statements; reaching it
+// without a complaint in between means the batch went through
+const (
+	sqliteMarker     = "mi-top:ok"
+	sqliteMarkerStmt = "SELECT '" + sqliteMarker + "';\n"
+)
+
+// Prune old rows once every this many ticks
+const sqlitePruneEvery = 60
+
+func sqlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// SQLiteSink keeps a sqlite3 shell running and feeds it one transaction per
+// tick from a background goroutine.
+type SQLiteSink struct {
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	host      string
+	retention time.Duration
+	ticks     int
+	queue     chan string
+	done      chan struct{}
+	exited    chan struct{} // closed once sqlite3 exits and exitErr is set
+
+	mu      sync.Mutex
+	lastErr error    // from the last batch that failed, until one succeeds
+	stderr  []string // the last lines sqlite3 complained with
+	exitErr error
+}
+
+// sqliteStderrLines is how many of sqlite3's complaints are kept to explain
+// why it exited
+const sqliteStderrLines = 5
+
+func newSQLiteSink(path string, retention time.Duration) (*SQLiteSink, error) {
+	sqlite, err := exec.LookPath("sqlite3")
+	if err != nil {
+		return nil, fmt.Errorf("--db needs the sqlite3 command line tool: %v", err)
+	}
+	migrations := sqliteMigrations(sqlite, path)
+	cmd := exec.Command(sqlite, "-batch", path)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	// One pipe for both keeps complaints in order with the markers
+	output, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout, cmd.Stderr = w, w
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		output.Close()
+		return nil, fmt.Errorf("failed to start sqlite3: %v", err)
+	}
+	s := &SQLiteSink{
+		cmd:       cmd,
+		stdin:     stdin,
+		host:      hostname(),
+		retention: retention,
+		queue:     make(chan string, 16),
+		done:      make(chan struct{}),
+		exited:    make(chan struct{}),
+	}
+	go s.wait(output)
+	go s.run()
+	s.queue <- migrations + sqliteSchema + sqliteMarkerStmt
+	return s, nil
+}
+
+func (s *SQLiteSink) setErr(err error) {
+	s.mu.Lock()
+	s.lastErr = err
+	s.mu.Unlock()
+}
+
+// wait surfaces anything sqlite3 complains about, clears it once a later
+// batch goes through, and once sqlite3 exits, reports its exit status along
+// with the last of its complaints
+func (s *SQLiteSink) wait(output io.ReadCloser) {
+	defer close(s.exited)
+	defer output.Close()
+	scanner := bufio.NewScanner(output)
+	failed := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		s.mu.Lock()
+		if line == sqliteMarker {
+			if !failed {
+				s.lastErr = nil
+			}
+			failed = false
+		} else {
+			debugLog.Warn("sqlite3", "msg", line)
+			failed = true
+			s.lastErr = fmt.Errorf("database: %s", line)
+			s.stderr = append(s.stderr, line)
+			if len(s.stderr) > sqliteStderrLines {
+				s.stderr = s.stderr[1:]
+			}
+		}
+		s.mu.Unlock()
+	}
+	err := s.cmd.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.exitErr = fmt.Errorf("sqlite3 %v", err)
+		if len(s.stderr) > 0 {
+			s.exitErr = fmt.Errorf("sqlite3 %v: %s", err, strings.Join(s.stderr, "; "))
+		}
+	}
+}
+
+func (s *SQLiteSink) run() {
+	defer close(s.done)
+	for stmt := range s.queue {
+		if _, err := io.WriteString(s.stdin, stmt); err != nil {
+			s.setErr(fmt.Errorf("database write failed: %v", err))
+		}
+	}
+}
+
+func (s *SQLiteSink) Write(sample Sample) error {
+	var b strings.Builder
+	ts := sample.Time.Unix()
+	host := sqlQuote(sampleHost(sample, s.host))
+	b.WriteString("BEGIN;\n")
+	for _, m := range sample.GPUs {
+		fmt.Fprintf(&b, "INSERT INTO gpu_samples (ts, host, gpu, gfx_util, power, gpu_temp, mem_temp, "+
+			"gfx_clock, mem_util, mem_clock, vram_used, vram_total) VALUES (%d, %s, %d, %s, %s, %s, %s, %s, %s, %s, %s, %s);\n",
+			ts, host, m.ID, formatFloat(m.GFXUtil), formatFloat(m.Power), formatFloat(m.GPUTemp), formatFloat(m.MemTemp),
+			formatFloat(m.GFXClock), formatFloat(m.MemUtil), formatFloat(m.MemClock),
+			formatFloat(m.VRAMUsed), formatFloat(m.VRAMTotal))
+	}
+	for _, p := range sample.Processes {
+		fmt.Fprintf(&b, "INSERT INTO process_samples (ts, host, gpu, pid, name, gfx_usage, "+
+			"vram_mb, gtt_mb, cpu_mb, total_mb) VALUES (%d, %s, %d, %d, %s, %s, %s, %s, %s, %s);\n",
+			ts, host, p.GPU, p.Pid, sqlQuote(p.Name), formatFloat(p.UsagePercent),
+			formatFloat(mib(p.VRAMBytes)), formatFloat(mib(p.GTTBytes)), formatFloat(mib(p.CPUBytes)), formatFloat(mib(p.TotalBytes)))
+	}
+	s.ticks++
+	if s.retention > 0 && s.ticks%sqlitePruneEvery == 0 {
+		cutoff := sample.Time.Add(-s.retention).Unix()
+		fmt.Fprintf(&b, "DELETE FROM gpu_samples WHERE ts < %d;\nDELETE FROM process_samples WHERE ts < %d;\n", cutoff, cutoff)
+	}
+	b.WriteString("COMMIT;\n" + sqliteMarkerStmt)
+	// Once sqlite3 is gone, nothing more is queued and why it exited is the error
+	select {
+	case <-s.exited:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.exitErr == nil {
+			return fmt.Errorf("sqlite3 exited")
+		}
+		return s.exitErr
+	default:
+	}
+	select {
+	case s.queue <- b.String():
+	default:
+		return fmt.Errorf("database is falling behind, dropping samples")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
+
+// Close lets sqlite3 finish the queued transactions and exit, and reports
+// its exit status
+func (s *SQLiteSink) Close() error {
+	close(s.queue)
+	<-s.done
+	s.stdin.Close()
+	<-s.exited
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.exitErr
+}
+
+// parseQueryRange understands "last <duration>" and "since <time>"
+func parseQueryRange(q string, now time.Time) (time.Time, error) {
+	fields := strings.Fields(q)
+	if len(fields) < 2 {
+		return time.Time{}, fmt.Errorf("invalid query %q: use \"last 1h\" or \"since 2006-01-02 15:04\"", q)
+	}
+	rest := strings.Join(fields[1:], " ")
+	switch fields[0] {
+	case "last":
+		d, err := time.ParseDuration(rest)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid duration in query: %v", err)
+		}
+		return now.Add(-d), nil
+	case "since":
+		for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+			if t, err := time.ParseInLocation(layout, rest, displayZone); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid time in query: %q", rest)
+	}
+	return time.Time{}, fmt.Errorf("invalid query %q: use \"last 1h\" or \"since 2006-01-02 15:04\"", q)
+}
+
+// runDBQuery prints a per-host, per-GPU summary of the samples in the database to out
+func runDBQuery(out io.Writer, path, query string, gpu int) error {
+	since, err := parseQueryRange(query, time.Now())
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("cannot open database: %v", err)
+	}
+	sqlite, err := exec.LookPath("sqlite3")
+	if err != nil {
+		return fmt.Errorf("--query needs the sqlite3 command line tool: %v", err)
+	}
+	where := fmt.Sprintf("ts >= %d", since.Unix())
+	if gpu >= 0 {
+		where += fmt.Sprintf(" AND gpu = %d", gpu)
+	}
+	sql := "SELECT host, gpu, COUNT(*), MIN(ts), MAX(ts), AVG(gfx_util), MAX(gfx_util), AVG(power), MAX(power), " +
+		"MAX(gpu_temp), MAX(mem_temp), AVG(vram_used), MAX(vram_used) FROM gpu_samples WHERE " + where +
+		" GROUP BY host, gpu ORDER BY host, gpu;"
+	var stderr bytes.Buffer
+	cmd := exec.Command(sqlite, "-batch", "-separator", "\t", path, sql)
+	cmd.Stderr = &stderr
+	result, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("query failed: sqlite3 %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "HOST\tGPU\tSAMPLES\tFROM\tTO\tAVG UTIL\tMAX UTIL\tAVG W\tMAX W\tMAX °C\tMAX MEM °C\tAVG VRAM MB\tMAX VRAM MB\t")
+	rows := 0
+	for _, line := range strings.Split(strings.TrimRight(string(result), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		f := strings.Split(line, "\t")
+		if len(f) != 13 {
+			return fmt.Errorf("query failed: unexpected sqlite3 output %q", line)
+		}
+		num := func(i int) float64 {
+			v, _ := strconv.ParseFloat(f[i], 64)
+			return v
+		}
+		stamp := func(i int) string {
+			sec, _ := strconv.ParseInt(f[i], 10, 64)
+			return zoned(time.Unix(sec, 0)).Format("01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%.1f%%\t%.1f%%\t%.1f\t%.1f\t%.1f\t%.1f\t%.0f\t%.0f\t\n",
+			f[0], f[1], f[2], stamp(3), stamp(4), num(5), num(6), num(7), num(8), num(9), num(10), num(11), num(12))
+		rows++
+	}
+	w.Flush()
+	if rows == 0 {
+		fmt.Fprintf(out, "no samples since %s\n", zoned(since).Format("2006-01-02 15:04:05"))
+	}
+	return nil
+}
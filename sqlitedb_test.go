@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func needSQLite(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 is not installed")
+	}
+}
+
+func TestSQLiteSinkRoundTrip(t *testing.T) {
+	needSQLite(t)
+	path := filepath.Join(t.TempDir(), "samples.db")
+	s, err := newSQLiteSink(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	sample := Sample{
+		Time: now,
+		GPUs: []GPUMetrics{{GPUDevice: GPUDevice{ID: 0}, GFXUtil: 42, Power: 150.5}, {GPUDevice: GPUDevice{ID: 1}, GFXUtil: 7}},
+		Processes: []ProcessInfo{
+			{GPU: 1, Pid: 1234, Name: "it's a 'quoted'; name", UsagePercent: 7},
+		},
+	}
+	if err := s.Write(sample); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	out, err := exec.Command("sqlite3", "-batch", path, "SELECT name FROM process_samples;").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(out)); got != sample.Processes[0].Name {
+		t.Errorf("process name = %q, want %q", got, sample.Processes[0].Name)
+	}
+
+	var summary strings.Builder
+	if err := runDBQuery(&summary, path, "last 1h", -1); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(summary.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("summary has %d lines, want a header and 2 GPUs:\n%s", len(lines), summary.String())
+	}
+	if !strings.Contains(lines[1], "42.0%") || !strings.Contains(lines[1], "150.5") {
+		t.Errorf("GPU 0 summary %q is missing its utilization or power", lines[1])
+	}
+}
+
+func TestSQLiteSinkSurfacesExit(t *testing.T) {
+	needSQLite(t)
+	// sqlite3 can't create a database in a missing directory and exits
+	s, err := newSQLiteSink(filepath.Join(t.TempDir(), "missing", "samples.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-s.exited
+	err = s.Write(Sample{Time: time.Now(), GPUs: []GPUMetrics{{GPUDevice: GPUDevice{ID: 0}}}})
+	if err == nil || !strings.Contains(err.Error(), "exit status 1") || !strings.Contains(err.Error(), "unable to open database") {
+		t.Errorf("Write after sqlite3 exited = %v, want its exit status and stderr", err)
+	}
+	if err := s.Close(); err == nil || !strings.Contains(err.Error(), "exit status 1") {
+		t.Errorf("Close = %v, want the exit status", err)
+	}
+}
+
+func TestDBQueryBadDatabase(t *testing.T) {
+	needSQLite(t)
+	path := filepath.Join(t.TempDir(), "junk.db")
+	if err := os.WriteFile(path, []byte("junk\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	err := runDBQuery(&out, path, "last 1h", -1)
+	if err == nil || !strings.Contains(err.Error(), "not a database") {
+		t.Errorf("runDBQuery = %v, want sqlite3's complaint", err)
+	}
+}
+
+// TestSQLiteHost adds the host column to a database from before it, and
+// keeps GPU 0 of two hosts apart in the summary
+func TestSQLiteHost(t *testing.T) {
+	needSQLite(t)
+	path := filepath.Join(t.TempDir(), "samples.db")
+	old := "CREATE TABLE gpu_samples (ts INTEGER NOT NULL, gpu INTEGER NOT NULL, gfx_util REAL, power REAL, " +
+		"gpu_temp REAL, mem_temp REAL, gfx_clock REAL, mem_util REAL, mem_clock REAL, vram_used REAL, vram_total REAL);" +
+		"CREATE TABLE process_samples (ts INTEGER NOT NULL, gpu INTEGER NOT NULL, pid TEXT, name TEXT, " +
+		"gfx_usage REAL, vram_mb REAL, gtt_mb REAL, cpu_mb REAL, total_mb REAL);" +
+		fmt.Sprintf("INSERT INTO gpu_samples VALUES (%d, 0, 5, 0, 0, 0, 0, 0, 0, 0, 0);", time.Now().Unix())
+	if out, err := exec.Command("sqlite3", "-batch", path, old).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	s, err := newSQLiteSink(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"node-a", "node-b"} {
+		sample := Sample{
+			Host:      host,
+			Time:      time.Now(),
+			GPUs:      []GPUMetrics{{GPUDevice: GPUDevice{ID: 0}, GFXUtil: 42}},
+			Processes: []ProcessInfo{{GPU: 0, Pid: 1234, Name: "train"}},
+		}
+		if err := s.Write(sample); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	out, err := exec.Command("sqlite3", "-batch", path, "SELECT host FROM process_samples ORDER BY host;").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(out)); len(got) != 2 || got[0] != "node-a" || got[1] != "node-b" {
+		t.Errorf("process hosts = %q", got)
+	}
+	var summary strings.Builder
+	if err := runDBQuery(&summary, path, "last 1h", -1); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(summary.String()), "\n")
+	if len(lines) != 4 || !strings.Contains(lines[2], "node-a") || !strings.Contains(lines[3], "node-b") {
+		t.Errorf("summary:\n%s\nwant the old rows and a line for each host", summary.String())
+	}
+}
+
+// TestSQLiteErrorClears reports a failed transaction until one goes through,
+// and stores PIDs as integers
+func TestSQLiteErrorClears(t *testing.T) {
+	needSQLite(t)
+	path := filepath.Join(t.TempDir(), "samples.db")
+	s, err := newSQLiteSink(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	sample := Sample{Time: time.Now(), Processes: []ProcessInfo{{GPU: 0, Pid: 1234, Name: "train"}}}
+	// Write reports what sqlite3 said about earlier ticks, so wait for it
+	waitFor := func(what string, done func(error) bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			if err := s.Write(sample); done(err) {
+				return
+			} else if time.Now().After(deadline) {
+				t.Fatalf("still %v, want %s", err, what)
+			}
+		}
+	}
+	sqlite := func(sql string) string {
+		t.Helper()
+		out, err := exec.Command("sqlite3", "-batch", "-cmd", ".timeout 5000", path, sql).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s: %v: %s", sql, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	waitFor("a row", func(err error) bool {
+		out, _ := exec.Command("sqlite3", "-batch", path, "SELECT COUNT(*) FROM process_samples;").Output()
+		return err == nil && strings.TrimSpace(string(out)) > "0"
+	})
+	if got := sqlite("SELECT DISTINCT typeof(pid) FROM process_samples;"); got != "integer" {
+		t.Errorf("pid is stored as %s", got)
+	}
+	sqlite("ALTER TABLE process_samples RENAME TO moved;")
+	waitFor("the missing table", func(err error) bool { return err != nil && strings.Contains(err.Error(), "no such table") })
+	sqlite("ALTER TABLE moved RENAME TO process_samples;")
+	waitFor("the error cleared", func(err error) bool { return err == nil })
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// uiState is what the terminal UI remembers between runs: the process
+// sort and filters and which views were open. --fresh starts without it.
+type uiState struct {
+	Sort      string `json:"sort"` // as --sort takes it, e.g. "usage,desc"
+	Top       bool   `json:"top,omitempty"`
+	MinVRAM   bool   `json:"min_vram,omitempty"` // the --min-vram filter is on
+	Filter    string `json:"filter,omitempty"`   // the '/' pattern
+	Processes bool   `json:"processes"`
+	// Panel is the bottom panel open instead of the process list: info,
+	// fans, static, vram or events
+	Panel   string `json:"panel,omitempty"`
+	Overlay bool   `json:"overlay,omitempty"`
+	Metric  int    `json:"chart_metric,omitempty"` // index into chartMetrics
+	// Widths are the process table column widths set with '<' and '>'
+	Widths map[string]int `json:"column_widths,omitempty"`
+}
+
+// statePath is $XDG_STATE_HOME/mi-top/state.json, or "" without a home
+func statePath() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "mi-top", "state.json")
+}
+
+// loadState reads the state file. A missing file is no state; a file that
+// doesn't parse or holds values this version doesn't know is an error for
+// the caller to warn about and ignore.
+func loadState(path string) (*uiState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state: %v", err)
+	}
+	var s uiState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if _, _, err := parseSort(s.Sort); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	switch s.Panel {
+	case "", "info", "fans", "static", "vram", "events":
+	default:
+		return nil, fmt.Errorf("%s: unknown panel %q", path, s.Panel)
+	}
+	if s.Metric < 0 || s.Metric >= len(chartMetrics) {
+		return nil, fmt.Errorf("%s: chart metric %d out of range", path, s.Metric)
+	}
+	for id, w := range s.Widths {
+		if !slices.Contains(tableColumns, id) {
+			return nil, fmt.Errorf("%s: unknown column %q", path, id)
+		}
+		if w < minColumnWidth {
+			return nil, fmt.Errorf("%s: column %s narrower than %d", path, id, minColumnWidth)
+		}
+	}
+	return &s, nil
+}
+
+// saveState writes the state file through a temporary file, so a crash
+// mid-write leaves the previous state
+func saveState(path string, s *uiState) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeStateFile(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to save state: %v", err)
+	}
+	return nil
+}
+
+// writeStateFile replaces a file in the state directory with data
+func writeStateFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// formatSort formats a process sort the way parseSort reads it
+func formatSort(column int, reverse bool) string {
+	order := "asc"
+	if reverse {
+		order = "desc"
+	}
+	return strings.ToLower(columns[column]) + "," + order
+}
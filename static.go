@@ -0,0 +1,705 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	ui "github.com/gizak/termui/v3"
+	"github.com/gizak/termui/v3/widgets"
+)
+
+// StaticInfo is what changes rarely, if ever, for a GPU. It is queried when
+// the set of GPUs changes, every staticRefresh samples, and after a setting
+// was changed, rather than every tick.
+type StaticInfo struct {
+	BDF          string
+	MaxClock     float64 // top GFX clock level in MHz
+	PowerProfile string  // active power profile, empty when unknown
+	PerfLevel    string  // DPM performance level, e.g. auto or low; empty when unknown
+	Overdrive    Overdrive
+	Profiles     []string // power profiles the driver offers
+	NUMANode     int      // -1 when the platform doesn't report one
+	// GTTTotal is the GTT size in bytes, the system memory the GPU may map
+	// (set with amdgpu.gttsize); 0 when unknown
+	GTTTotal uint64
+	DeviceInfo
+}
+
+// DeviceInfo never changes for a device, so it is read once per bus address
+type DeviceInfo struct {
+	VRAM   VRAMInfo
+	Serial string // board serial; empty for cards that don't report one
+	UUID   string
+	ASIC   string  // market name, e.g. AMD Instinct MI300X; empty when unknown
+	APU    bool    // integrated graphics sharing system memory
+	Caps   gpuCaps // XGMI and media engines, from sysfs
+}
+
+// apuDevices are the PCI device IDs of the Ryzen APUs' integrated graphics
+var apuDevices = map[string]bool{
+	"0x15dd": true, "0x15d8": true, // Raven, Picasso
+	"0x1636": true, "0x164c": true, // Renoir, Lucienne
+	"0x1638": true, "0x15e7": true, // Cezanne, Barcelo
+	"0x163f": true,                 // Van Gogh
+	"0x164d": true, "0x1681": true, // Rembrandt
+	"0x164e": true, "0x13c0": true, // Raphael, Granite Ridge
+	"0x1506": true,                 // Mendocino
+	"0x15bf": true, "0x15c8": true, // Phoenix
+	"0x150e": true, "0x1586": true, // Strix Point, Strix Halo
+}
+
+// orNA shows an identifier a card refused to report as n/a
+func orNA(id string) string {
+	if id == "" {
+		return "n/a"
+	}
+	return id
+}
+
+// asicCell names the ASIC, marking integrated graphics
+func asicCell(s StaticInfo) string {
+	if s.APU {
+		return orNA(s.ASIC) + " (APU)"
+	}
+	return orNA(s.ASIC)
+}
+
+// VRAMInfo describes the memory chips; it never changes for a device
+type VRAMInfo struct {
+	Type     string // e.g. HBM3
+	Vendor   string
+	BitWidth int // memory bus width
+}
+
+func (v VRAMInfo) String() string {
+	var parts []string
+	if v.Type != "" {
+		parts = append(parts, v.Type)
+	}
+	if v.Vendor != "" {
+		parts = append(parts, v.Vendor)
+	}
+	if v.BitWidth > 0 {
+		parts = append(parts, fmt.Sprintf("%d-bit", v.BitWidth))
+	}
+	if len(parts) == 0 {
+		return "N/A"
+	}
+	return strings.Join(parts, " ")
+}
+
+// staticRefresh is how many samples pass between static queries
+const staticRefresh = 60
+
+// staticCache keeps one host's static info current and labels its samples
+// with it. It belongs to the goroutine sampling the host.
+type staticCache struct {
+	host    string
+	runner  commandRunner
+	ids     []int // GPUs the info was last read for
+	age     int
+	devices map[string]DeviceInfo // by bus address, read once per device
+	info    map[int]StaticInfo
+}
+
+func newStaticCache(host string, r commandRunner) *staticCache {
+	return &staticCache{host: host, runner: r, devices: map[string]DeviceInfo{}}
+}
+
+// update reads the static info again when the set of GPUs changed, every
+// staticRefresh samples, or when forced. It returns the new info, or nil
+// when nothing was read.
+func (c *staticCache) update(s Sample, force bool) map[int]StaticInfo {
+	c.age++
+	ids := gpuIDs(s.GPUs)
+	if slices.Equal(ids, c.ids) && c.age < staticRefresh && !force {
+		return nil
+	}
+	c.ids, c.age = ids, 0
+	info, err := getStaticInfo(c.runner, c.devices)
+	if err != nil {
+		debugLog.Warn("static info unavailable", "host", c.host, "err", err)
+		return nil
+	}
+	c.info = info
+	return info
+}
+
+// label adds the bus address, UUID, serial and ASIC name to a sample's GPUs, and the
+// bus address to its processes, so outputs can identify devices regardless
+// of enumeration order. APUs also get their GTT use, which takes a read of
+// the driver's counter each sample.
+func (c *staticCache) label(s *Sample) {
+	for i := range s.GPUs {
+		// Other vendors' tools label their GPUs themselves
+		if !s.GPUs[i].amd() {
+			continue
+		}
+		info := c.info[s.GPUs[i].ID]
+		// The sysfs backend knows the bus address without amd-smi
+		if info.BDF != "" {
+			s.GPUs[i].BDF = info.BDF
+		}
+		s.GPUs[i].UUID, s.GPUs[i].Serial = info.UUID, info.Serial
+		s.GPUs[i].ASIC = info.ASIC
+		s.GPUs[i].Caps |= info.Caps
+		if info.APU && info.BDF != "" {
+			s.GPUs[i].APU, s.GPUs[i].GTTTotal = true, mib(info.GTTTotal)
+			if used, err := runCommand(c.runner, "cat", sysfsPCIDevices+"/"+info.BDF+"/mem_info_gtt_used"); err == nil {
+				b, _ := strconv.ParseUint(strings.TrimSpace(string(used)), 10, 64)
+				s.GPUs[i].GTTUsed = mib(b)
+			} else {
+				debugLog.Debug("GTT use unavailable", "gpu", s.GPUs[i].ID, "err", err)
+			}
+		}
+	}
+	for i := range s.Processes {
+		if info, ok := c.info[s.Processes[i].GPU]; ok {
+			s.Processes[i].BDF = info.BDF
+		}
+	}
+}
+
+// sysfsPCIDevices is where the driver's per-device files live
+var sysfsPCIDevices = "/sys/bus/pci/devices"
+
+// getStaticInfo reads the bus address and clock levels from
+// `amd-smi static --bus --clock --json`, then each GPU's power profile,
+// performance level, overdrive settings, NUMA node and GTT size from sysfs. A GPU whose sysfs
+// files can't be read keeps the rest of its info. Device details are only
+// queried for devices missing from devices, which caches them by bus
+// address so re-enumerating the same device doesn't ask again.
+func getStaticInfo(r commandRunner, devices map[string]DeviceInfo) (map[int]StaticInfo, error) {
+	out, err := runCommand(r, "amd-smi", "static", "--bus", "--clock", "--json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute amd-smi static: %w", err)
+	}
+	info, err := parseStaticInfo(out)
+	if err != nil {
+		return nil, err
+	}
+	readDeviceInfo(r, info, devices)
+	for id, s := range info {
+		s.DeviceInfo = devices[s.BDF]
+		s.NUMANode = -1
+		if s.BDF == "" {
+			info[id] = s
+			continue
+		}
+		dir := sysfsPCIDevices + "/" + s.BDF
+		if modes, err := runCommand(r, "cat", dir+"/pp_power_profile_mode"); err == nil {
+			s.PowerProfile, s.Profiles = parsePowerProfiles(string(modes))
+		} else {
+			debugLog.Debug("power profile unavailable", "gpu", id, "err", err)
+		}
+		if level, err := runCommand(r, "cat", dir+"/power_dpm_force_performance_level"); err == nil {
+			s.PerfLevel = parsePerfLevel(string(level))
+		} else {
+			debugLog.Debug("performance level unavailable", "gpu", id, "err", err)
+		}
+		s.Overdrive = readOverdrive(r, id, dir)
+		if node, err := runCommand(r, "cat", dir+"/numa_node"); err == nil {
+			if n, err := strconv.Atoi(strings.TrimSpace(string(node))); err == nil {
+				s.NUMANode = n
+			}
+		} else {
+			debugLog.Debug("NUMA node unavailable", "gpu", id, "err", err)
+		}
+		if gtt, err := runCommand(r, "cat", dir+"/mem_info_gtt_total"); err == nil {
+			s.GTTTotal, _ = strconv.ParseUint(strings.TrimSpace(string(gtt)), 10, 64)
+		} else {
+			debugLog.Debug("GTT size unavailable", "gpu", id, "err", err)
+		}
+		info[id] = s
+	}
+	return info, nil
+}
+
+// readDeviceInfo fills devices for the ones it doesn't know yet: VRAM,
+// board serial and ASIC name from `amd-smi static`, UUIDs from `amd-smi
+// list`. Failures are cached too: the answer wouldn't change on a second try.
+func readDeviceInfo(r commandRunner, info map[int]StaticInfo, devices map[string]DeviceInfo) {
+	var missing []string
+	for _, s := range info {
+		if _, ok := devices[s.BDF]; !ok && s.BDF != "" {
+			missing = append(missing, s.BDF)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+	out, err := runCommand(r, "amd-smi", "static", "--bus", "--asic", "--vram", "--board", "--json")
+	var found map[int]StaticInfo
+	if err == nil {
+		found, err = parseStaticInfo(out)
+	}
+	if err != nil {
+		debugLog.Warn("device info unavailable", "err", err)
+	}
+	uuids := map[string]string{}
+	if out, err := runCommand(r, "amd-smi", "list", "--json"); err == nil {
+		uuids = parseUUIDs(out)
+	} else {
+		debugLog.Warn("GPU UUIDs unavailable", "err", err)
+	}
+	for _, bdf := range missing {
+		var d DeviceInfo
+		for _, s := range found {
+			if s.BDF == bdf {
+				d = s.DeviceInfo
+			}
+		}
+		d.UUID = uuids[bdf]
+		if out, err := runCommand(r, "sh", "-c", fmt.Sprintf(deviceQuery, sysfsPCIDevices+"/"+bdf)); err == nil {
+			d.APU, d.Caps = parseDeviceQuery(out)
+		} else {
+			debugLog.Debug("PCI device ID unavailable", "bdf", bdf, "err", err)
+		}
+		devices[bdf] = d
+	}
+}
+
+// deviceQuery prints a device's PCI device ID, its XGMI ID, which is 0
+// without XGMI links, and whether the driver found a VCN (hardware ID 12)
+// among its IP blocks, the media engines
+const deviceQuery = `d=%q
+cat "$d/device"
+cat "$d/xgmi_device_id" 2>/dev/null || echo 0
+if [ -d "$d/ip_discovery/die/0/12" ]; then echo media; fi`
+
+func parseDeviceQuery(out []byte) (apu bool, caps gpuCaps) {
+	lines := strings.Fields(string(out))
+	if len(lines) > 0 {
+		apu = apuDevices[lines[0]]
+	}
+	if len(lines) > 1 && strings.Trim(lines[1], "0x") != "" {
+		caps |= capXGMI
+	}
+	if len(lines) > 2 && lines[2] == "media" {
+		caps |= capMedia
+	}
+	return apu, caps
+}
+
+// parseUUIDs maps bus addresses to UUIDs from `amd-smi list --json`
+func parseUUIDs(data []byte) map[string]string {
+	var gpus []struct {
+		BDF  string `json:"bdf"`
+		UUID string `json:"uuid"`
+	}
+	if err := json.Unmarshal(data, &gpus); err != nil {
+		debugLog.Warn("failed to parse amd-smi list output", "err", err)
+		return nil
+	}
+	uuids := make(map[string]string, len(gpus))
+	for _, g := range gpus {
+		if uuid := knownValue(g.UUID); uuid != "" {
+			uuids[strings.TrimSpace(g.BDF)] = uuid
+		}
+	}
+	return uuids
+}
+
+// parseStaticInfo accepts both the bare list amd-smi 24.x prints and the
+// {"gpu_data": [...]} wrapper of newer releases. Clock levels are printed
+// as strings such as "2100 MHz"; the top "sys" (GFX) level is the max clock.
+func parseStaticInfo(data []byte) (map[int]StaticInfo, error) {
+	type gpuStatic struct {
+		GPU int `json:"gpu"`
+		Bus struct {
+			BDF string `json:"bdf"`
+		} `json:"bus"`
+		Clock map[string]struct {
+			Levels map[string]interface{} `json:"frequency_levels"`
+		} `json:"clock"`
+		VRAM struct {
+			Type     string      `json:"type"`
+			Vendor   string      `json:"vendor"`
+			BitWidth interface{} `json:"bit_width"` // a number, or N/A
+		} `json:"vram"`
+		Board struct {
+			Serial string `json:"product_serial"`
+		} `json:"board"`
+		ASIC struct {
+			Name string `json:"market_name"`
+		} `json:"asic"`
+	}
+	var gpus []gpuStatic
+	if err := json.Unmarshal(data, &gpus); err != nil {
+		var wrapped struct {
+			GPUs []gpuStatic `json:"gpu_data"`
+		}
+		if json.Unmarshal(data, &wrapped) != nil {
+			return nil, fmt.Errorf("failed to parse amd-smi static output: %v", err)
+		}
+		gpus = wrapped.GPUs
+	}
+	info := make(map[int]StaticInfo, len(gpus))
+	for _, g := range gpus {
+		s := StaticInfo{BDF: strings.TrimSpace(g.Bus.BDF)}
+		s.VRAM.Type = knownValue(g.VRAM.Type)
+		s.VRAM.Vendor = knownValue(g.VRAM.Vendor)
+		s.Serial = knownValue(g.Board.Serial)
+		s.ASIC = knownValue(g.ASIC.Name)
+		if width, ok := g.VRAM.BitWidth.(float64); ok {
+			s.VRAM.BitWidth = int(width)
+		}
+		for _, level := range g.Clock["sys"].Levels {
+			str, ok := level.(string)
+			if !ok {
+				continue
+			}
+			mhz, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(str), "MHz")), 64)
+			if err != nil {
+				debugLog.Debug("amd-smi static: unparsable clock level", "gpu", g.GPU, "value", str)
+				continue
+			}
+			s.MaxClock = max(s.MaxClock, mhz)
+		}
+		info[g.GPU] = s
+	}
+	if len(info) == 0 {
+		return nil, fmt.Errorf("amd-smi static reported no GPUs")
+	}
+	return info, nil
+}
+
+// knownValue drops the placeholders amd-smi prints for missing fields
+func knownValue(v string) string {
+	v = strings.TrimSpace(v)
+	if v == "N/A" || strings.EqualFold(v, "unknown") {
+		return ""
+	}
+	return v
+}
+
+// profileLine matches a profile in pp_power_profile_mode, e.g. " 5 COMPUTE*"
+// or " 1 3D_FULL_SCREEN :"; the star marks the active one
+var profileLine = regexp.MustCompile(`^\s*\d+\s+([A-Z0-9_]+)\s*(\*)?`)
+
+// parsePowerProfiles returns the active profile and all offered ones.
+// CUSTOM is left out since it needs heuristics parameters to be useful.
+func parsePowerProfiles(text string) (active string, profiles []string) {
+	for _, line := range strings.Split(text, "\n") {
+		m := profileLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		if m[2] != "" {
+			active = m[1]
+		}
+		if m[1] != "CUSTOM" {
+			profiles = append(profiles, m[1])
+		}
+	}
+	return active, profiles
+}
+
+// Overdrive is what pp_od_clk_voltage reports as applied. Kernels and
+// generations print it differently: RDNA3 and newer have offsets
+// (OD_SCLK_OFFSET, OD_VDDGFX_OFFSET), older cards list clock levels
+// (OD_SCLK) with the top one being the max.
+type Overdrive struct {
+	State      string  // "stock" or "unavailable"; empty when read
+	VoltOffset float64 // GFX voltage offset in mV
+	SclkOffset float64 // GFX clock offset in MHz
+	SclkMax    float64 // top GFX clock level in MHz
+	hasVolt    bool
+	hasOffset  bool
+}
+
+func (o Overdrive) String() string {
+	if o.State != "" {
+		return o.State
+	}
+	var parts []string
+	if o.hasVolt {
+		parts = append(parts, fmt.Sprintf("GFX offset: %.0f mV", o.VoltOffset))
+	}
+	if o.hasOffset {
+		parts = append(parts, fmt.Sprintf("sclk offset: %+.0f MHz", o.SclkOffset))
+	}
+	if o.SclkMax > 0 {
+		parts = append(parts, fmt.Sprintf("sclk max: %.0f MHz", o.SclkMax))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// readOverdrive reads pp_od_clk_voltage. The file only exists while
+// overdrive is enabled (amdgpu.ppfeaturemask), so a missing or empty one
+// means stock settings.
+func readOverdrive(r commandRunner, id int, dir string) Overdrive {
+	out, err := runCommand(r, "cat", dir+"/pp_od_clk_voltage")
+	if err != nil {
+		if strings.Contains(err.Error(), "No such file") {
+			return Overdrive{State: "stock"}
+		}
+		debugLog.Debug("overdrive unavailable", "gpu", id, "err", err)
+		return Overdrive{State: "unavailable"}
+	}
+	if strings.TrimSpace(string(out)) == "" {
+		return Overdrive{State: "stock"}
+	}
+	od, ok := parseOverdrive(string(out))
+	if !ok {
+		debugLog.Debug("unparsable pp_od_clk_voltage", "gpu", id, "text", string(out))
+		return Overdrive{State: "unavailable"}
+	}
+	return od
+}
+
+// odValue matches a value line such as "1: 2400Mhz", "-75mV" or "0Mhz"
+var odValue = regexp.MustCompile(`^\s*(?:\d+:)?\s*(-?\d+(?:\.\d+)?)\s*(?i:mhz|mv)\s*$`)
+
+// parseOverdrive reads the sections it knows and ignores the rest
+// (OD_MCLK, OD_RANGE, curves); ok is false when none was found
+func parseOverdrive(text string) (od Overdrive, ok bool) {
+	section := ""
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "OD_") {
+			section = strings.TrimSuffix(line, ":")
+			continue
+		}
+		m := odValue.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		v, err := strconv.ParseFloat(m[1], 64)
+		if err != nil {
+			continue
+		}
+		switch section {
+		case "OD_SCLK":
+			od.SclkMax, ok = max(od.SclkMax, v), true
+		case "OD_SCLK_OFFSET":
+			od.SclkOffset, od.hasOffset, ok = v, true, true
+		case "OD_VDDGFX_OFFSET":
+			od.VoltOffset, od.hasVolt, ok = v, true, true
+		}
+	}
+	return od, ok
+}
+
+// perfLevels are the values power_dpm_force_performance_level takes
+var perfLevels = []string{"auto", "low", "high", "manual", "profile_standard",
+	"profile_min_sclk", "profile_min_mclk", "profile_peak", "perf_determinism"}
+
+// parsePerfLevel returns the level, or "" for anything unrecognized
+func parsePerfLevel(text string) string {
+	level := strings.ToLower(strings.TrimSpace(text))
+	if !slices.Contains(perfLevels, level) {
+		debugLog.Debug("unknown performance level", "value", text)
+		return ""
+	}
+	return level
+}
+
+// perfLevelCell shows the performance level, in yellow unless it is auto:
+// a card left in low after a driver hiccup is stuck at its lowest clocks
+func perfLevelCell(level string) string {
+	switch level {
+	case "":
+		return "N/A"
+	case "auto":
+		return level
+	}
+	return "[" + level + "](fg:yellow)"
+}
+
+// nextProfile is the profile after the active one, wrapping around
+func (s StaticInfo) nextProfile() string {
+	if len(s.Profiles) == 0 {
+		return ""
+	}
+	i := slices.Index(s.Profiles, s.PowerProfile)
+	return s.Profiles[(i+1)%len(s.Profiles)]
+}
+
+// staticRow is a GPU shown in the static panel
+type staticRow struct {
+	host *hostView
+	gpu  int
+}
+
+// profileAction switches a GPU to another power profile, then has its
+// static info read again so the panel shows the outcome
+func profileAction(row staticRow, profile string) controlAction {
+	return controlAction{host: row.host, gpu: row.gpu,
+		prompt: fmt.Sprintf("Switch GPU %d power profile to %s?", row.gpu, profile),
+		args:   []string{"set", "--gpu", strconv.Itoa(row.gpu), "--profile", profile},
+		done:   func() { row.host.refreshStatic.Store(true) }}
+}
+
+// staticPanel shows each GPU's static info. With a controller
+// (--enable-control), 'c' cycles the selected GPU's power profile. It
+// belongs to the UI goroutine. Each GPU's effective thresholds are listed
+// so a per-GPU override that doesn't match shows up.
+type staticPanel struct {
+	table      *widgets.Table
+	control    *controller
+	selected   int
+	rows       []staticRow
+	thresholds ThresholdsConfig
+	rules      []AlertRule
+	smiVersion string // empty when unknown
+	backend    *backendSelector
+}
+
+func newStaticPanel(control *controller) *staticPanel {
+	p := &staticPanel{control: control}
+	p.table = widgets.NewTable()
+	p.table.TextStyle = ui.NewStyle(ui.ColorWhite)
+	p.table.RowSeparator = false
+	p.table.BorderStyle = ui.NewStyle(ui.ColorWhite)
+	p.table.ColumnResizer = func() { fitColumns(p.table) }
+	return p
+}
+
+// numaSuffix labels a GPU title with its NUMA node, only when the host's
+// GPUs aren't all on node 0 (or -1, no NUMA), as on single-socket systems
+func (h *hostView) numaSuffix(id int) string {
+	for _, s := range h.static {
+		if s.NUMANode > 0 {
+			if node := h.static[id].NUMANode; node >= 0 {
+				return fmt.Sprintf(" (NUMA %d)", node)
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// fitColumns sizes columns to their widest cell, so short ones like the GPU
+// leave room for summaries; the last column gets whatever is left
+func fitColumns(t *widgets.Table) {
+	if len(t.Rows) == 0 {
+		return
+	}
+	widths := make([]int, len(t.Rows[0]))
+	for _, row := range t.Rows {
+		for i, cell := range row {
+			if i < len(widths) {
+				widths[i] = max(widths[i], len(ui.ParseStyles(cell, t.TextStyle))+2)
+			}
+		}
+	}
+	room := t.Inner.Dx()
+	for i := range widths[:len(widths)-1] {
+		widths[i] = min(widths[i], max(room, 0))
+		room -= widths[i]
+	}
+	widths[len(widths)-1] = max(room, 0)
+	t.ColumnWidths = widths
+}
+
+// thresholdsCell lists the temperature thresholds and alert rule
+// thresholds in effect for a GPU
+func (p *staticPanel) thresholdsCell(id int, s StaticInfo) string {
+	t := p.thresholds.forGPU(id, s.BDF, s.ASIC)
+	parts := []string{fmt.Sprintf("temp %.0f/%.0f°C, HBM %.0f/%.0f°C", t.GPUTempWarn, t.GPUTempCrit, t.MemTempWarn, t.MemTempCrit)}
+	for _, rule := range p.rules {
+		rule = rule.forGPU(id, s.BDF, s.ASIC)
+		parts = append(parts, fmt.Sprintf("%s %s %g", rule.Name, rule.Op, rule.Threshold))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// update refreshes the table from each host's static info
+func (p *staticPanel) update(hosts []*hostView, multi bool) {
+	p.table.Title = "GPU info"
+	if p.smiVersion != "" {
+		p.table.Title += ", amd-smi " + p.smiVersion
+	}
+	if p.backend != nil {
+		p.table.Title += ", " + p.backend.info()
+	}
+	if p.control != nil {
+		p.table.Title += " (↑/↓ select, c next power profile)"
+	}
+	rows := [][]string{{"GPU", "BUS", "ASIC", "MAX CLOCK", "POWER PROFILE", "PERF LEVEL", "VRAM", "NUMA", "OVERDRIVE", "SERIAL", "UUID", "THRESHOLDS"}}
+	p.table.RowStyles = map[int]ui.Style{0: ui.NewStyle(ui.ColorWhite, ui.ColorClear, ui.ModifierBold)}
+	// The selection stays on its GPU when the charts are reordered
+	var selected *staticRow
+	if p.selected < len(p.rows) {
+		row := p.rows[p.selected]
+		selected = &row
+	}
+	p.rows = p.rows[:0]
+	// Only shown when some GPU has XGMI links or media engines
+	features := false
+	for _, h := range hosts {
+		for _, s := range h.static {
+			features = features || s.Caps.String() != ""
+		}
+	}
+	if features {
+		rows[0] = slices.Insert(rows[0], len(rows[0])-1, "FEATURES")
+	}
+	for _, h := range hosts {
+		for _, id := range h.ids {
+			s, ok := h.static[id]
+			if !ok {
+				continue
+			}
+			clock, profile := "N/A", s.PowerProfile
+			if s.MaxClock > 0 {
+				clock = fmt.Sprintf("%.0f MHz", s.MaxClock)
+			}
+			if profile == "" {
+				profile = "N/A"
+			}
+			numa := "N/A"
+			if s.NUMANode >= 0 {
+				numa = strconv.Itoa(s.NUMANode)
+			}
+			row := []string{fmt.Sprintf("%sGPU %d", h.titlePrefix(multi), id), s.BDF, asicCell(s), clock, profile, perfLevelCell(s.PerfLevel), s.VRAM.String(), numa, s.Overdrive.String(), orNA(s.Serial), orNA(s.UUID), p.thresholdsCell(id, s)}
+			if features {
+				row = slices.Insert(row, len(row)-1, cmp.Or(s.Caps.String(), "none"))
+			}
+			rows = append(rows, row)
+			if selected != nil && *selected == (staticRow{host: h, gpu: id}) {
+				p.selected = len(p.rows)
+			}
+			p.rows = append(p.rows, staticRow{host: h, gpu: id})
+		}
+	}
+	if len(p.rows) == 0 {
+		rows = append(rows, make([]string, len(rows[0])))
+		rows[1][0] = "waiting for amd-smi static…"
+	}
+	p.selected = min(p.selected, max(len(p.rows)-1, 0))
+	if p.control != nil && len(p.rows) > 0 {
+		p.table.RowStyles[p.selected+1] = ui.NewStyle(ui.ColorBlack, ui.ColorGreen)
+	}
+	p.table.Rows = rows
+}
+
+// handleEvent reports whether the panel consumed the key
+func (p *staticPanel) handleEvent(e ui.Event, action keyAction) bool {
+	if p.control == nil {
+		return false
+	}
+	switch {
+	case action == actionUp:
+		p.selected = max(p.selected-1, 0)
+	case action == actionDown:
+		p.selected = min(p.selected+1, max(len(p.rows)-1, 0))
+	case e.ID == "c":
+		if p.selected >= len(p.rows) {
+			return true
+		}
+		row := p.rows[p.selected]
+		if next := row.host.static[row.gpu].nextProfile(); next != "" {
+			p.control.ask(profileAction(row, next))
+		}
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// TestPerfLevel reads each performance level from sysfs through to the info
+// panel, where anything but auto is yellow
+func TestPerfLevel(t *testing.T) {
+	const bdf = "0000:03:00.0"
+	levelFile := "cat " + sysfsPCIDevices + "/" + bdf + "/power_dpm_force_performance_level"
+	for _, tc := range []struct {
+		file  string // contents; "-" for no file
+		level string
+		cell  string
+	}{
+		{"auto\n", "auto", "auto"},
+		{"low\n", "low", "[low](fg:yellow)"},
+		{"high\n", "high", "[high](fg:yellow)"},
+		{"manual\n", "manual", "[manual](fg:yellow)"},
+		{"profile_standard\n", "profile_standard", "[profile_standard](fg:yellow)"},
+		{"profile_min_sclk\n", "profile_min_sclk", "[profile_min_sclk](fg:yellow)"},
+		{"profile_min_mclk\n", "profile_min_mclk", "[profile_min_mclk](fg:yellow)"},
+		{"profile_peak\n", "profile_peak", "[profile_peak](fg:yellow)"},
+		{"perf_determinism\n", "perf_determinism", "[perf_determinism](fg:yellow)"},
+		{" LOW \n", "low", "[low](fg:yellow)"},
+		{"turbo\n", "", "N/A"},
+		{"-", "", "N/A"},
+	} {
+		t.Run(tc.file, func(t *testing.T) {
+			r := fakeRunner{"amd-smi static --bus --clock --json": `[{"gpu": 0, "bus": {"bdf": "` + bdf + `"}}]`}
+			if tc.file != "-" {
+				r[levelFile] = tc.file
+			}
+			info, err := getStaticInfo(r, map[string]DeviceInfo{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := info[0].PerfLevel; got != tc.level {
+				t.Errorf("level %q, want %q", got, tc.level)
+			}
+			h := goldenHost([]GPUMetrics{amdGPU(0, bdf)})
+			h.static = info
+			p := newStaticPanel(nil)
+			p.update([]*hostView{h}, false)
+			if len(p.table.Rows) != 2 || !slices.Contains(p.table.Rows[1], tc.cell) {
+				t.Errorf("info panel %q doesn't show %q", p.table.Rows, tc.cell)
+			}
+		})
+	}
+}
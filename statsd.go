@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Keep datagrams below a typical MTU so packets are never fragmented
+const statsdMaxPacket = 1432
+
+// statsdRedialMax caps the wait between attempts to reach a statsd
+// address that doesn't resolve
+var statsdRedialMax = time.Minute
+
+// StatsdSink emits DogStatsD gauges over UDP. It is fire-and-forget: errors
+// are reported once and otherwise ignored so the TUI is never affected.
+// The address is resolved in the background, since a DNS lookup can block
+// for seconds and Write runs on the UI goroutine.
+type StatsdSink struct {
+	addr       string
+	prefix     string
+	sampleRate float64
+	host       string
+	conn       net.Conn
+	buf        bytes.Buffer
+	warned     bool
+
+	connected chan net.Conn // receives the connection once dial succeeds
+	ctx       context.Context
+	cancel    context.CancelFunc
+	dialed    chan struct{} // closed once dial returns
+
+	mu      sync.Mutex
+	dialErr error
+}
+
+func newStatsdSink(addr, prefix string, sampleRate float64) (*StatsdSink, error) {
+	if sampleRate <= 0 || sampleRate > 1 {
+		return nil, fmt.Errorf("--statsd-sample-rate must be in (0, 1], got %v", sampleRate)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid --statsd address %q: %v", addr, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &StatsdSink{
+		addr:       addr,
+		prefix:     prefix,
+		sampleRate: sampleRate,
+		host:       hostname(),
+		connected:  make(chan net.Conn, 1),
+		ctx:        ctx,
+		cancel:     cancel,
+		dialed:     make(chan struct{}),
+	}
+	go s.dial()
+	return s, nil
+}
+
+// dial connects, retrying with backoff until it succeeds or the sink is
+// closed
+func (s *StatsdSink) dial() {
+	defer close(s.dialed)
+	dialer := net.Dialer{Timeout: 5 * time.Second}
+	backoff := time.Second
+	for {
+		conn, err := dialer.DialContext(s.ctx, "udp", s.addr)
+		if err == nil {
+			s.connected <- conn
+			return
+		}
+		s.mu.Lock()
+		s.dialErr = err
+		s.mu.Unlock()
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, statsdRedialMax)
+	}
+}
+
+func (s *StatsdSink) Write(sample Sample) error {
+	if s.conn == nil {
+		select {
+		case s.conn = <-s.connected:
+			s.warned = false
+		default:
+			// Samples are dropped until the address resolves
+			s.mu.Lock()
+			err := s.dialErr
+			s.mu.Unlock()
+			if err != nil {
+				return s.warnOnce(err)
+			}
+			return nil
+		}
+	}
+	host := sampleHost(sample, s.host)
+	for _, m := range sample.GPUs {
+		if s.sampleRate < 1 && rand.Float64() >= s.sampleRate {
+			continue
+		}
+		tags := "gpu:" + strconv.Itoa(m.ID) + ",host:" + host
+		if m.BDF != "" {
+			tags += ",bdf:" + m.BDF
+		}
+		if m.UUID != "" {
+			tags += ",uuid:" + m.UUID
+		}
+		if m.Serial != "" {
+			tags += ",serial:" + m.Serial
+		}
+		if m.Alias != "" {
+			tags += ",alias:" + m.Alias
+		}
+		s.gauge("gpu.util", m.GFXUtil, tags)
+		s.gauge("gpu.power", m.Power, tags)
+		s.gauge("gpu.temp", m.GPUTemp, tags)
+		s.gauge("gpu.vram.used", m.VRAMUsed, tags)
+	}
+	return s.flush()
+}
+
+// gauge appends one metric, sending the buffered packet first if it would overflow
+func (s *StatsdSink) gauge(name string, value float64, tags string) {
+	line := s.prefix + name + ":" + formatFloat(value) + "|g"
+	if s.sampleRate < 1 {
+		line += "|@" + formatFloat(s.sampleRate)
+	}
+	line += "|#" + tags
+	if s.buf.Len() > 0 && s.buf.Len()+1+len(line) > statsdMaxPacket {
+		s.flush()
+	}
+	if s.buf.Len() > 0 {
+		s.buf.WriteByte('\n')
+	}
+	s.buf.WriteString(line)
+}
+
+func (s *StatsdSink) flush() error {
+	if s.buf.Len() == 0 {
+		return nil
+	}
+	_, err := s.conn.Write(s.buf.Bytes())
+	s.buf.Reset()
+	if err != nil {
+		return s.warnOnce(err)
+	}
+	s.warned = false
+	return nil
+}
+
+func (s *StatsdSink) warnOnce(err error) error {
+	if s.warned {
+		return nil
+	}
+	s.warned = true
+	return fmt.Errorf("statsd disabled until it recovers: %v", err)
+}
+
+func (s *StatsdSink) Close() error {
+	s.cancel()
+	<-s.dialed
+	if s.conn == nil {
+		select {
+		case s.conn = <-s.connected:
+		default:
+			return nil
+		}
+	}
+	return s.conn.Close()
+}
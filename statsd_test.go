@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestStatsdDial sends once the background dial connects, and never holds
+// up Write while the address doesn't resolve
+func TestStatsdDial(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	s, err := newStatsdSink(pc.LocalAddr().String(), "mitop.", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sample := Sample{Time: t0, GPUs: []GPUMetrics{{GPUDevice: GPUDevice{ID: 2}, GFXUtil: 40}}}
+	buf := make([]byte, statsdMaxPacket)
+	var got string
+	for deadline := time.Now().Add(5 * time.Second); got == "" && time.Now().Before(deadline); {
+		if err := s.Write(sample); err != nil {
+			t.Fatal(err)
+		}
+		pc.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		if n, _, err := pc.ReadFrom(buf); err == nil {
+			got = string(buf[:n])
+		}
+	}
+	if !strings.Contains(got, "mitop.gpu.util:40|g|#gpu:2,") {
+		t.Errorf("packet %q", got)
+	}
+	if err := s.Close(); err != nil {
+		t.Error(err)
+	}
+
+	s, err = newStatsdSink("statsd.invalid:8125", "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for range 10 {
+		s.Write(sample)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("10 writes to an unresolved address took %v", elapsed)
+	}
+	s.Close()
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// streamFormats are the --stream outputs
+var streamFormats = []string{"ndjson", "csv"}
+
+// The --stream=csv schema. Columns are only ever appended, never renamed,
+// removed or reordered, so existing parsers keep working; adding one bumps
+// csvStreamVersion. Both are listed in --help.
+const csvStreamVersion = 1
+
+var (
+	csvStreamHeader = []string{"timestamp", "host", "gpu", "bdf", "util", "power", "temp_junction", "temp_mem",
+		"gfx_clock", "mem_util", "mem_clock", "vram_used", "vram_total"}
+	csvStreamProcessHeader = []string{"timestamp", "host", "gpu", "bdf", "pid", "name", "gfx_usage",
+		"vram_bytes", "gtt_bytes", "cpu_bytes"}
+)
+
+// csvSchemaHelp documents a CSV stream's columns in a flag's usage
+func csvSchemaHelp(header []string) string {
+	return fmt.Sprintf("schema v%d: %s", csvStreamVersion, strings.Join(header, ","))
+}
+
+// streamRecord is one --stream=ndjson line
+type streamRecord struct {
+	Timestamp time.Time     `json:"timestamp"`
+	Host      string        `json:"host"`
+	GPUs      []GPUMetrics  `json:"gpus"`
+	Processes []ProcessInfo `json:"processes"`
+}
+
+// NDJSONSink writes a JSON object per sample, one per line. Each line goes
+// out in a single write, so a reader never sees half a record, even when
+// mi-top is interrupted.
+type NDJSONSink struct {
+	w    io.Writer
+	host string
+	line []byte
+}
+
+func newNDJSONSink(w io.Writer) *NDJSONSink {
+	return &NDJSONSink{w: w, host: hostname()}
+}
+
+func (s *NDJSONSink) Write(sample Sample) error {
+	rec := streamRecord{Timestamp: zoned(sample.Time), Host: sampleHost(sample, s.host), GPUs: sample.GPUs, Processes: sample.Processes}
+	// Empty lists stay lists for consumers like jq
+	if rec.GPUs == nil {
+		rec.GPUs = []GPUMetrics{}
+	}
+	if rec.Processes == nil {
+		rec.Processes = []ProcessInfo{}
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("ndjson stream: %v", err)
+	}
+	s.line = append(append(s.line[:0], data...), '\n')
+	if _, err := s.w.Write(s.line); err != nil {
+		return fmt.Errorf("ndjson stream write failed: %v", err)
+	}
+	return nil
+}
+
+// Close leaves stdout open
+func (s *NDJSONSink) Close() error {
+	return nil
+}
+
+// CSVStreamSink writes a header, then a row per GPU and sample, with raw
+// values: percentages, watts, °C, MHz and MB as amd-smi reports them.
+// Unknown values, like the memory temperature of GDDR cards, are empty.
+// Process rows go to their own file when one is given.
+type CSVStreamSink struct {
+	w         *csv.Writer
+	host      string
+	processes *rotatingFile
+}
+
+func newCSVStreamSink(w io.Writer, processPath string) (*CSVStreamSink, error) {
+	s := &CSVStreamSink{w: csv.NewWriter(w), host: hostname()}
+	if processPath != "" {
+		var err error
+		if s.processes, err = openRotatingFile(processPath, csvStreamProcessHeader, 0, 0); err != nil {
+			return nil, fmt.Errorf("failed to open process stream: %v", err)
+		}
+	}
+	s.w.Write(csvStreamHeader)
+	s.w.Flush()
+	return s, nil
+}
+
+func (s *CSVStreamSink) Write(sample Sample) error {
+	ts, host := zoned(sample.Time).Format(time.RFC3339Nano), sampleHost(sample, s.host)
+	for _, m := range sample.GPUs {
+		memTemp := ""
+		if m.hasMemTemp() {
+			memTemp = formatFloat(m.MemTemp)
+		}
+		s.w.Write([]string{ts, host, strconv.Itoa(m.ID), m.BDF, formatFloat(m.GFXUtil), formatFloat(m.Power),
+			formatFloat(m.GPUTemp), memTemp, formatFloat(m.GFXClock), formatFloat(m.MemUtil), formatFloat(m.MemClock),
+			formatFloat(m.VRAMUsed), formatFloat(m.VRAMTotal)})
+	}
+	s.w.Flush()
+	if err := s.w.Error(); err != nil {
+		return fmt.Errorf("csv stream write failed: %v", err)
+	}
+	if s.processes == nil || sample.ProcessErr != nil {
+		return nil
+	}
+	rows := make([][]string, 0, len(sample.Processes))
+	for _, p := range sample.Processes {
+		rows = append(rows, []string{ts, host, strconv.Itoa(p.GPU), p.BDF, strconv.Itoa(p.Pid), p.Name,
+			formatFloat(p.UsagePercent), strconv.FormatUint(p.VRAMBytes, 10), strconv.FormatUint(p.GTTBytes, 10),
+			strconv.FormatUint(p.CPUBytes, 10)})
+	}
+	if err := s.processes.writeRows(rows); err != nil {
+		return fmt.Errorf("process stream write failed: %v", err)
+	}
+	return nil
+}
+
+// Close flushes the process stream and leaves stdout open
+func (s *CSVStreamSink) Close() error {
+	if s.processes != nil {
+		return s.processes.close()
+	}
+	return nil
+}
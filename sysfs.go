@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sysfsQuery prints the amdgpu driver's metric files for every AMD GPU
+// (PCI vendor 0x1002) as "name value" lines after a "gpu <bdf>" line, and
+// "denied <path>" for files it may not read. The bus addresses come out
+// sorted, which is the order amd-smi numbers GPUs in. One shell keeps it
+// to a single exec per tick, over ssh too.
+const sysfsQuery = `for d in /sys/bus/pci/devices/*; do
+  [ "$(cat "$d/vendor" 2>/dev/null)" = 0x1002 ] && [ -e "$d/gpu_busy_percent" ] || continue
+  echo "gpu ${d##*/}"
+  for f in "$d"/gpu_busy_percent "$d"/mem_busy_percent "$d"/mem_info_vram_used "$d"/mem_info_vram_total \
+      "$d"/hwmon/hwmon*/power1_average "$d"/hwmon/hwmon*/power1_input "$d"/hwmon/hwmon*/temp*_input "$d"/hwmon/hwmon*/temp*_label; do
+    if [ -r "$f" ]; then echo "${f##*/} $(cat "$f")"; elif [ -e "$f" ]; then echo "denied $f"; fi
+  done
+  for f in pp_dpm_sclk pp_dpm_mclk; do
+    if [ -r "$d/$f" ]; then echo "$f $(grep '\*' "$d/$f")"; fi
+  done
+done`
+
+// errSysfsDenied is returned when the driver's files exist but can't be read
+var errSysfsDenied = errors.New("permission denied")
+
+// collectSysfs reads GPU metrics straight from the amdgpu driver's sysfs
+// files, for hosts where amd-smi is missing or broken. The driver has no
+// per-process accounting there, so samples come without processes.
+func collectSysfs(r commandRunner) (Sample, error) {
+	sample := Sample{Time: time.Now()}
+	if collectProcesses.Load() {
+		sample.ProcessErr = errors.New("the sysfs backend doesn't list processes")
+	}
+	if collectFans.Load() {
+		sample.FanErr = errors.New("the sysfs backend doesn't read fans")
+	}
+	out, err := runCommand(r, "sh", "-c", sysfsQuery)
+	if err != nil {
+		return sample, fmt.Errorf("failed to read sysfs: %w", err)
+	}
+	sample.GPUs, err = parseSysfs(out)
+	// Unreadable files leave partial rows, which are still worth showing
+	if errors.Is(err, errSysfsDenied) {
+		debugLog.Debug("sysfs partially readable", "err", err)
+		err = nil
+	}
+	return sample, err
+}
+
+// parseSysfs turns sysfsQuery output into metrics. A GPU with unreadable
+// files is marked partial; the error names the first such file.
+func parseSysfs(out []byte) ([]GPUMetrics, error) {
+	var gpus []GPUMetrics
+	var denied string
+	// temp<N>_label names temp<N>_input: edge, junction or mem
+	var temps, labels map[string]string
+	finish := func() {
+		if len(gpus) == 0 {
+			return
+		}
+		m := &gpus[len(gpus)-1]
+		for n, label := range labels {
+			v, err := strconv.ParseFloat(temps[n], 64)
+			if err != nil {
+				continue
+			}
+			switch label {
+			case "junction":
+				m.GPUTemp = v / 1000
+				m.Caps |= capJunctionTemp
+			case "edge":
+				// Older cards only have the edge sensor
+				if m.GPUTemp == 0 {
+					m.GPUTemp = v / 1000
+				}
+			case "mem":
+				m.MemTemp = v / 1000
+				m.Caps |= capMemTemp
+			}
+		}
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		name, value, _ := strings.Cut(scanner.Text(), " ")
+		value = strings.TrimSpace(value)
+		if name == "gpu" {
+			finish()
+			gpus = append(gpus, GPUMetrics{GPUDevice: GPUDevice{ID: len(gpus), BDF: value, Caps: capGTT}})
+			temps, labels = map[string]string{}, map[string]string{}
+			continue
+		}
+		if len(gpus) == 0 {
+			continue
+		}
+		m := &gpus[len(gpus)-1]
+		num := func() float64 {
+			v, _ := strconv.ParseFloat(value, 64)
+			return v
+		}
+		switch {
+		case name == "denied":
+			m.Partial = true
+			if denied == "" {
+				denied = value
+			}
+		case name == "gpu_busy_percent":
+			m.GFXUtil = num()
+		case name == "mem_busy_percent":
+			m.MemUtil = num()
+			m.Caps |= capMemBusy
+		case name == "mem_info_vram_used":
+			m.VRAMUsed = num() / 1024 / 1024
+		case name == "mem_info_vram_total":
+			m.VRAMTotal = num() / 1024 / 1024
+		case name == "power1_average" || name == "power1_input" && m.Power == 0:
+			// Microwatts
+			m.Power = num() / 1e6
+			m.Caps |= capPower
+		case name == "pp_dpm_sclk":
+			m.GFXClock = parseDPMLevel(value)
+		case name == "pp_dpm_mclk":
+			m.MemClock = parseDPMLevel(value)
+		case strings.HasSuffix(name, "_input"):
+			temps[strings.TrimSuffix(name, "_input")] = value
+		case strings.HasSuffix(name, "_label"):
+			labels[strings.TrimSuffix(name, "_label")] = value
+		}
+	}
+	finish()
+	if len(gpus) == 0 {
+		return nil, errors.New("no AMD GPU in sysfs")
+	}
+	if denied != "" {
+		return gpus, fmt.Errorf("%w on %s", errSysfsDenied, denied)
+	}
+	return gpus, nil
+}
+
+// parseDPMLevel reads the MHz of the active DPM level, e.g. "2: 1800Mhz *"
+func parseDPMLevel(line string) float64 {
+	_, level, _ := strings.Cut(line, ":")
+	level = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(level), "*"))
+	mhz, _ := strconv.ParseFloat(strings.TrimSuffix(strings.ToLower(level), "mhz"), 64)
+	return mhz
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"time"
+	// IANA names work without the zoneinfo files, which minimal containers lack
+	_ "time/tzdata"
+)
+
+// displayZone is the zone timestamps are shown and exported in (--timezone).
+// Times are kept as time.Time everywhere and only put in it for output.
+var displayZone = time.Local
+
+// parseTimezone takes local, UTC or an IANA name such as Europe/Berlin
+func parseTimezone(name string) (*time.Location, error) {
+	switch strings.ToLower(name) {
+	case "local":
+		return time.Local, nil
+	case "utc":
+		return time.UTC, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// zoned is t in the display zone
+func zoned(t time.Time) time.Time {
+	return t.In(displayZone)
+}
@@ -0,0 +1,570 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// A small TOML reader covering what mi-top's config needs: tables, arrays
+// of tables, dotted keys, strings, integers, floats, booleans, arrays and
+// inline tables. Dates and multi-line strings are not supported.
+
+type tomlValue struct {
+	value interface{} // string, int64, float64, bool, []*tomlValue, tomlTable or []tomlTable
+	line  int
+}
+
+type tomlTable map[string]*tomlValue
+
+type tomlParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func parseTOML(src string) (tomlTable, error) {
+	p := &tomlParser{src: src, line: 1}
+	root := tomlTable{}
+	current := root
+	for {
+		p.skipSpaceAndComments(true)
+		if p.eof() {
+			return root, nil
+		}
+		var err error
+		if p.peek() == '[' {
+			current, err = p.parseHeader(root)
+		} else {
+			err = p.parseKeyValue(current)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *tomlParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *tomlParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *tomlParser) next() byte {
+	c := p.src[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return c
+}
+
+// skipSpaceAndComments skips blanks and comments, and newlines if allowed
+func (p *tomlParser) skipSpaceAndComments(newlines bool) {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.next()
+		case c == '\n' && newlines:
+			p.next()
+		case c == '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.next()
+			}
+		default:
+			return
+		}
+	}
+}
+
+// expectLineEnd requires that nothing but a comment follows on the line
+func (p *tomlParser) expectLineEnd() error {
+	p.skipSpaceAndComments(false)
+	if p.eof() || p.peek() == '\n' {
+		return nil
+	}
+	return p.errorf("unexpected %q after value", p.peek())
+}
+
+func (p *tomlParser) parseHeader(root tomlTable) (tomlTable, error) {
+	line := p.line
+	p.next()
+	array := p.peek() == '['
+	if array {
+		p.next()
+	}
+	keys, err := p.parseKey()
+	if err != nil {
+		return nil, err
+	}
+	closing := "]"
+	if array {
+		closing = "]]"
+	}
+	if !strings.HasPrefix(p.src[p.pos:], closing) {
+		return nil, p.errorf("unterminated table header")
+	}
+	p.pos += len(closing)
+	if err := p.expectLineEnd(); err != nil {
+		return nil, err
+	}
+	parent, err := descend(root, keys[:len(keys)-1], line)
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	last := keys[len(keys)-1]
+	existing, ok := parent[last]
+	if array {
+		table := tomlTable{}
+		if !ok {
+			parent[last] = &tomlValue{value: []tomlTable{table}, line: line}
+			return table, nil
+		}
+		tables, isArray := existing.value.([]tomlTable)
+		if !isArray {
+			return nil, p.errorf("%s is not an array of tables", strings.Join(keys, "."))
+		}
+		existing.value = append(tables, table)
+		return table, nil
+	}
+	if !ok {
+		table := tomlTable{}
+		parent[last] = &tomlValue{value: table, line: line}
+		return table, nil
+	}
+	table, isTable := existing.value.(tomlTable)
+	if !isTable {
+		return nil, p.errorf("%s is already defined as a value", strings.Join(keys, "."))
+	}
+	return table, nil
+}
+
+// descend walks (and creates) nested tables; for an array of tables the
+// most recently defined element is used
+func descend(t tomlTable, keys []string, line int) (tomlTable, error) {
+	for _, k := range keys {
+		v, ok := t[k]
+		if !ok {
+			child := tomlTable{}
+			t[k] = &tomlValue{value: child, line: line}
+			t = child
+			continue
+		}
+		switch tv := v.value.(type) {
+		case tomlTable:
+			t = tv
+		case []tomlTable:
+			t = tv[len(tv)-1]
+		default:
+			return nil, fmt.Errorf("%s is not a table", k)
+		}
+	}
+	return t, nil
+}
+
+func (p *tomlParser) parseKeyValue(t tomlTable) error {
+	line := p.line
+	keys, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+	if p.eof() || p.next() != '=' {
+		return p.errorf("expected '=' after key %s", strings.Join(keys, "."))
+	}
+	p.skipSpaceAndComments(false)
+	value, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+	if err := p.expectLineEnd(); err != nil {
+		return err
+	}
+	parent, err := descend(t, keys[:len(keys)-1], line)
+	if err != nil {
+		return p.errorf("%v", err)
+	}
+	last := keys[len(keys)-1]
+	if _, ok := parent[last]; ok {
+		return fmt.Errorf("line %d: duplicate key %s", line, strings.Join(keys, "."))
+	}
+	value.line = line
+	parent[last] = value
+	return nil
+}
+
+func isBareKeyChar(c byte) bool {
+	return c == '_' || c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// parseKey reads a possibly dotted key and the whitespace after it
+func (p *tomlParser) parseKey() ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpaceAndComments(false)
+		var key string
+		switch c := p.peek(); {
+		case c == '"':
+			s, err := p.parseBasicString()
+			if err != nil {
+				return nil, err
+			}
+			key = s
+		case c == '\'':
+			s, err := p.parseLiteralString()
+			if err != nil {
+				return nil, err
+			}
+			key = s
+		case isBareKeyChar(c):
+			start := p.pos
+			for !p.eof() && isBareKeyChar(p.peek()) {
+				p.next()
+			}
+			key = p.src[start:p.pos]
+		default:
+			return nil, p.errorf("invalid key")
+		}
+		keys = append(keys, key)
+		p.skipSpaceAndComments(false)
+		if p.peek() != '.' {
+			return keys, nil
+		}
+		p.next()
+	}
+}
+
+func (p *tomlParser) parseValue() (*tomlValue, error) {
+	line := p.line
+	switch c := p.peek(); {
+	case c == '"':
+		s, err := p.parseBasicString()
+		return &tomlValue{value: s, line: line}, err
+	case c == '\'':
+		s, err := p.parseLiteralString()
+		return &tomlValue{value: s, line: line}, err
+	case c == '[':
+		return p.parseArray()
+	case c == '{':
+		return p.parseInlineTable()
+	}
+	start := p.pos
+	for !p.eof() {
+		c := p.peek()
+		if c == ',' || c == ']' || c == '}' || c == '#' || c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			break
+		}
+		p.next()
+	}
+	raw := p.src[start:p.pos]
+	switch raw {
+	case "true":
+		return &tomlValue{value: true, line: line}, nil
+	case "false":
+		return &tomlValue{value: false, line: line}, nil
+	case "":
+		return nil, p.errorf("missing value")
+	}
+	clean := strings.ReplaceAll(raw, "_", "")
+	if i, err := strconv.ParseInt(clean, 0, 64); err == nil {
+		return &tomlValue{value: i, line: line}, nil
+	}
+	if f, err := strconv.ParseFloat(clean, 64); err == nil {
+		return &tomlValue{value: f, line: line}, nil
+	}
+	return nil, p.errorf("invalid value %q", raw)
+}
+
+func (p *tomlParser) parseBasicString() (string, error) {
+	p.next()
+	var b strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.next()
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.eof() {
+				return "", p.errorf("unterminated string")
+			}
+			switch e := p.next(); e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '"', '\\':
+				b.WriteByte(e)
+			case 'u', 'U':
+				n := 4
+				if e == 'U' {
+					n = 8
+				}
+				if p.pos+n > len(p.src) {
+					return "", p.errorf("invalid unicode escape")
+				}
+				r, err := strconv.ParseUint(p.src[p.pos:p.pos+n], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(r)) {
+					return "", p.errorf("invalid unicode escape")
+				}
+				p.pos += n
+				b.WriteRune(rune(r))
+			default:
+				return "", p.errorf("invalid escape \\%c", e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+func (p *tomlParser) parseLiteralString() (string, error) {
+	p.next()
+	start := p.pos
+	for !p.eof() && p.peek() != '\'' {
+		if p.peek() == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		p.next()
+	}
+	if p.eof() {
+		return "", p.errorf("unterminated string")
+	}
+	s := p.src[start:p.pos]
+	p.next()
+	return s, nil
+}
+
+func (p *tomlParser) parseArray() (*tomlValue, error) {
+	line := p.line
+	p.next()
+	var items []*tomlValue
+	for {
+		p.skipSpaceAndComments(true)
+		if p.eof() {
+			return nil, p.errorf("unterminated array")
+		}
+		if p.peek() == ']' {
+			p.next()
+			return &tomlValue{value: items, line: line}, nil
+		}
+		item, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		p.skipSpaceAndComments(true)
+		if p.peek() == ',' {
+			p.next()
+		} else if p.peek() != ']' {
+			return nil, p.errorf("expected ',' or ']' in array")
+		}
+	}
+}
+
+func (p *tomlParser) parseInlineTable() (*tomlValue, error) {
+	line := p.line
+	p.next()
+	table := tomlTable{}
+	p.skipSpaceAndComments(false)
+	if p.peek() == '}' {
+		p.next()
+		return &tomlValue{value: table, line: line}, nil
+	}
+	for {
+		if err := p.parseInlineKeyValue(table); err != nil {
+			return nil, err
+		}
+		p.skipSpaceAndComments(false)
+		switch p.peek() {
+		case ',':
+			p.next()
+		case '}':
+			p.next()
+			return &tomlValue{value: table, line: line}, nil
+		default:
+			return nil, p.errorf("expected ',' or '}' in inline table")
+		}
+	}
+}
+
+func (p *tomlParser) parseInlineKeyValue(t tomlTable) error {
+	line := p.line
+	keys, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+	if p.eof() || p.next() != '=' {
+		return p.errorf("expected '=' after key %s", strings.Join(keys, "."))
+	}
+	p.skipSpaceAndComments(false)
+	value, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+	parent, err := descend(t, keys[:len(keys)-1], line)
+	if err != nil {
+		return p.errorf("%v", err)
+	}
+	value.line = line
+	parent[keys[len(keys)-1]] = value
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// decodeTOML copies a parsed table into a struct using `toml` field tags.
+// Keys without a matching field are returned as warnings rather than errors
+// so an older binary can still read a newer config.
+func decodeTOML(t tomlTable, v interface{}) ([]string, error) {
+	var warnings []string
+	err := decodeTable(t, reflect.ValueOf(v).Elem(), "", &warnings)
+	sort.Slice(warnings, func(i, j int) bool {
+		var li, lj int
+		fmt.Sscanf(warnings[i], "line %d", &li)
+		fmt.Sscanf(warnings[j], "line %d", &lj)
+		return li < lj
+	})
+	return warnings, err
+}
+
+func decodeTable(t tomlTable, rv reflect.Value, prefix string, warnings *[]string) error {
+	if rv.Kind() == reflect.Map {
+		if rv.IsNil() {
+			rv.Set(reflect.MakeMap(rv.Type()))
+		}
+		for key, val := range t {
+			elem := reflect.New(rv.Type().Elem()).Elem()
+			if err := decodeValue(val, elem, prefix+key, warnings); err != nil {
+				return err
+			}
+			rv.SetMapIndex(reflect.ValueOf(key), elem)
+		}
+		return nil
+	}
+	fields := map[string]reflect.Value{}
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		if tag := rt.Field(i).Tag.Get("toml"); tag != "" && tag != "-" {
+			fields[tag] = rv.Field(i)
+		}
+	}
+	for key, val := range t {
+		field, ok := fields[key]
+		if !ok {
+			*warnings = append(*warnings, fmt.Sprintf("line %d: unknown key %s%s", val.line, prefix, key))
+			continue
+		}
+		if err := decodeValue(val, field, prefix+key, warnings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeValue(val *tomlValue, rv reflect.Value, name string, warnings *[]string) error {
+	mismatch := func() error {
+		return fmt.Errorf("line %d: invalid type for %s", val.line, name)
+	}
+	if rv.Type() == durationType {
+		s, ok := val.value.(string)
+		if !ok {
+			return mismatch()
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid duration for %s: %v", val.line, name, err)
+		}
+		rv.SetInt(int64(d))
+		return nil
+	}
+	switch rv.Kind() {
+	case reflect.String:
+		s, ok := val.value.(string)
+		if !ok {
+			return mismatch()
+		}
+		rv.SetString(s)
+	case reflect.Bool:
+		b, ok := val.value.(bool)
+		if !ok {
+			return mismatch()
+		}
+		rv.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		i, ok := val.value.(int64)
+		if !ok {
+			return mismatch()
+		}
+		rv.SetInt(i)
+	case reflect.Float64:
+		switch n := val.value.(type) {
+		case int64:
+			rv.SetFloat(float64(n))
+		case float64:
+			rv.SetFloat(n)
+		default:
+			return mismatch()
+		}
+	case reflect.Ptr:
+		elem := reflect.New(rv.Type().Elem())
+		if err := decodeValue(val, elem.Elem(), name, warnings); err != nil {
+			return err
+		}
+		rv.Set(elem)
+	case reflect.Struct, reflect.Map:
+		t, ok := val.value.(tomlTable)
+		if !ok {
+			return mismatch()
+		}
+		return decodeTable(t, rv, name+".", warnings)
+	case reflect.Slice:
+		// A lone string stands for a list of one
+		if s, ok := val.value.(string); ok && rv.Type().Elem().Kind() == reflect.String {
+			rv.Set(reflect.ValueOf([]string{s}).Convert(rv.Type()))
+			return nil
+		}
+		var n int
+		switch items := val.value.(type) {
+		case []*tomlValue:
+			n = len(items)
+		case []tomlTable:
+			n = len(items)
+		default:
+			return mismatch()
+		}
+		slice := reflect.MakeSlice(rv.Type(), n, n)
+		for i := 0; i < n; i++ {
+			item := &tomlValue{line: val.line}
+			switch items := val.value.(type) {
+			case []*tomlValue:
+				item = items[i]
+			case []tomlTable:
+				item.value = items[i]
+			}
+			if err := decodeValue(item, slice.Index(i), fmt.Sprintf("%s[%d]", name, i), warnings); err != nil {
+				return err
+			}
+		}
+		rv.Set(slice)
+	default:
+		return mismatch()
+	}
+	return nil
+}
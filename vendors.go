@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Vendors for --vendors. GPUs are only tagged with theirs when several
+// are monitored, so the AMD-only default looks as it always did.
+const (
+	vendorAMD    = "amd"
+	vendorNVIDIA = "nvidia"
+	vendorIntel  = "intel"
+)
+
+// vendorCollector adds another vendor's GPUs, and their processes while
+// those are collected, to a sample of the AMD ones. Its GPUs are numbered
+// from base, after those already in the sample, so chart slots and
+// process GPU columns stay unique.
+type vendorCollector struct {
+	name  string // as in --vendors
+	label string // for messages
+	add   func(r commandRunner, s *Sample, base int) error
+}
+
+var vendorCollectors = []vendorCollector{
+	{vendorNVIDIA, "NVIDIA", addNVIDIA},
+	{vendorIntel, "Intel", addIntel},
+}
+
+// parseVendors reads --vendors into the collectors to run besides the AMD
+// backend, which always runs
+func parseVendors(list string) ([]vendorCollector, error) {
+	var collectors []vendorCollector
+	amd := false
+	for _, v := range strings.Split(list, ",") {
+		v = strings.TrimSpace(v)
+		if v == vendorAMD {
+			amd = true
+			continue
+		}
+		found := false
+		for _, c := range vendorCollectors {
+			if c.name == v {
+				collectors, found = append(collectors, c), true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown vendor %q (want amd, %s or %s)", v, vendorNVIDIA, vendorIntel)
+		}
+	}
+	if !amd {
+		return nil, errors.New("amd can't be left out")
+	}
+	return collectors, nil
+}
+
+// addVendors runs the collectors after the AMD backend and returns what
+// went wrong, e.g. "NVIDIA GPUs unavailable". A vendor whose tool isn't
+// installed is skipped without a word: --vendors can then be the same on
+// every node.
+func addVendors(r commandRunner, s *Sample, collectors []vendorCollector) []string {
+	if len(collectors) == 0 {
+		return nil
+	}
+	for i := range s.GPUs {
+		s.GPUs[i].Vendor = vendorAMD
+	}
+	var problems []string
+	for _, c := range collectors {
+		base := 0
+		for _, m := range s.GPUs {
+			base = max(base, m.ID+1)
+		}
+		err := c.add(r, s, base)
+		switch {
+		case errors.Is(err, exec.ErrNotFound):
+			debugLog.Debug("vendor tool not installed", "vendor", c.name, "err", err)
+		case err != nil:
+			debugLog.Debug("vendor GPUs unavailable", "vendor", c.name, "err", err)
+			problems = append(problems, c.label+" GPUs unavailable")
+		}
+	}
+	return problems
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"image"
+	"slices"
+
+	ui "github.com/gizak/termui/v3"
+	"github.com/mattn/go-runewidth"
+)
+
+// vramTopProcesses is how many of a GPU's processes get their own segment
+const vramTopProcesses = 3
+
+var (
+	vramOtherColor = ui.Color(244)
+	vramFreeColor  = ui.Color(236)
+)
+
+// vramBars shows how each GPU's VRAM divides among its largest processes,
+// everything else, and free memory, as one stacked bar per GPU ('b'). An
+// APU's bar is its carve-out and GTT together, which is where its
+// processes allocate. It belongs to the UI goroutine.
+type vramBars struct {
+	ui.Block
+	rows   []vramRow
+	legend []legendEntry // process names, in the colors their segments use
+}
+
+type vramRow struct {
+	label       string
+	used, total float64 // MB
+	top         []vramSegment
+}
+
+type vramSegment struct {
+	mb    float64
+	color ui.Color
+}
+
+func newVRAMBars() *vramBars {
+	return &vramBars{Block: *ui.NewBlock()}
+}
+
+// update joins each host's processes to its GPUs by GPU ID. A process name
+// keeps one color across GPUs.
+func (b *vramBars) update(hosts []*hostView, multi bool) {
+	b.Title = "VRAM by process (" + keyMap.hint(actionVRAM) + " closes)"
+	if !collectProcesses.Load() {
+		b.Title = "VRAM by process (process collection is off, " + keyMap.hint(actionProcesses) + " turns it on)"
+	}
+	b.rows, b.legend = b.rows[:0], b.legend[:0]
+	colors := map[string]ui.Color{}
+	for _, h := range hosts {
+		byGPU := map[int][]ProcessInfo{}
+		for _, p := range h.processes {
+			byGPU[p.GPU] = append(byGPU[p.GPU], p)
+		}
+		for _, m := range h.lastGPUs {
+			row := vramRow{label: h.titlePrefix(multi) + m.name()}
+			row.used, row.total = m.memory()
+			size := func(p ProcessInfo) uint64 { return p.VRAMBytes }
+			if m.APU {
+				row.label += " APU"
+				size = func(p ProcessInfo) uint64 { return p.VRAMBytes + p.GTTBytes }
+			}
+			processes := byGPU[m.ID]
+			slices.SortFunc(processes, func(a, b ProcessInfo) int { return cmp.Compare(size(b), size(a)) })
+			for _, p := range processes[:min(len(processes), vramTopProcesses)] {
+				if size(p) == 0 {
+					break
+				}
+				color, ok := colors[p.Name]
+				if !ok {
+					color = overlayPalette[len(colors)%len(overlayPalette)]
+					colors[p.Name] = color
+					b.legend = append(b.legend, legendEntry{label: p.Name, color: color})
+				}
+				row.top = append(row.top, vramSegment{mb: mib(size(p)), color: color})
+			}
+			b.rows = append(b.rows, row)
+		}
+	}
+	b.legend = append(b.legend, legendEntry{label: "other", color: vramOtherColor}, legendEntry{label: "free", color: vramFreeColor})
+}
+
+func (b *vramBars) Draw(buf *ui.Buffer) {
+	b.Block.Draw(buf)
+	labelWidth := 0
+	for _, row := range b.rows {
+		labelWidth = max(labelWidth, runewidth.StringWidth(row.label))
+	}
+	const sizeWidth = 16 // " 191.9/192.0 GB"
+	barWidth := b.Inner.Dx() - labelWidth - 1 - sizeWidth
+	y := b.Inner.Min.Y
+	for _, row := range b.rows {
+		// Leave the last line for the legend
+		if y >= b.Inner.Max.Y-1 {
+			break
+		}
+		buf.SetString(row.label, ui.NewStyle(ui.ColorWhite), image.Pt(b.Inner.Min.X, y))
+		if barWidth > 0 && row.total > 0 {
+			b.drawBar(buf, row, image.Pt(b.Inner.Min.X+labelWidth+1, y), barWidth)
+		}
+		size := fmt.Sprintf(" %.1f/%.1f GB", row.used/1024, row.total/1024)
+		buf.SetString(size, ui.NewStyle(ui.ColorWhite), image.Pt(b.Inner.Max.X-runewidth.StringWidth(size), y))
+		y++
+	}
+	x := b.Inner.Min.X
+	for _, e := range b.legend {
+		text := "■ " + e.label + "  "
+		if x+runewidth.StringWidth(text) > b.Inner.Max.X {
+			break
+		}
+		buf.SetString(text, ui.NewStyle(e.color), image.Pt(x, b.Inner.Max.Y-1))
+		x += runewidth.StringWidth(text)
+	}
+}
+
+// drawBar lays segments out by their share of the GPU's VRAM. Segment
+// edges are rounded from the running total so rounding never adds up past
+// the bar; a process too small for a cell of its own counts as other.
+func (b *vramBars) drawBar(buf *ui.Buffer, row vramRow, at image.Point, width int) {
+	cell := func(mb float64) int {
+		return min(width, int(mb/row.total*float64(width)+0.5))
+	}
+	x, sum := 0, 0.0
+	for _, s := range row.top {
+		end := cell(sum + s.mb)
+		if end == x {
+			continue
+		}
+		sum += s.mb
+		b.fill(buf, at, x, end, '█', s.color)
+		x = end
+	}
+	used := cell(max(row.used, sum))
+	b.fill(buf, at, x, used, '█', vramOtherColor)
+	b.fill(buf, at, used, width, '░', vramFreeColor)
+}
+
+func (b *vramBars) fill(buf *ui.Buffer, at image.Point, from, to int, r rune, color ui.Color) {
+	for x := from; x < to; x++ {
+		buf.SetCell(ui.NewCell(r, ui.NewStyle(color)), image.Pt(at.X+x, at.Y))
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	wsGUID         = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsClientQueue  = 8
+	wsWriteTimeout = 5 * time.Second
+	wsMaxFrameRead = 64 * 1024
+
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xA
+)
+
+// wsConn is a minimal server side RFC 6455 connection: it only sends text
+// frames and reads client frames to answer pings and notice closes.
+type wsConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+	wmu  sync.Mutex
+}
+
+func headerContains(h http.Header, key, token string) bool {
+	for _, v := range strings.Split(h.Get(key), ",") {
+		if strings.EqualFold(strings.TrimSpace(v), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// upgradeWebSocket performs the opening handshake and hijacks the connection
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return nil, errors.New("not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("unsupported websocket version")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("response writer cannot hijack")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, rw: rw}, nil
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	c.rw.Write(header)
+	c.rw.Write(payload)
+	return c.rw.Flush()
+}
+
+// readLoop consumes client frames until the client closes or errors
+func (c *wsConn) readLoop() {
+	header := make([]byte, 2)
+	for {
+		if _, err := io.ReadFull(c.rw, header); err != nil {
+			return
+		}
+		opcode := header[0] & 0x0F
+		masked := header[1]&0x80 != 0
+		length := uint64(header[1] & 0x7F)
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+				return
+			}
+			length = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+				return
+			}
+			length = binary.BigEndian.Uint64(ext[:])
+		}
+		if length > wsMaxFrameRead {
+			return
+		}
+		var mask [4]byte
+		if masked {
+			if _, err := io.ReadFull(c.rw, mask[:]); err != nil {
+				return
+			}
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.rw, payload); err != nil {
+			return
+		}
+		if masked {
+			for i := range payload {
+				payload[i] ^= mask[i%4]
+			}
+		}
+		switch opcode {
+		case wsOpClose:
+			c.writeFrame(wsOpClose, nil)
+			return
+		case wsOpPing:
+			c.writeFrame(wsOpPong, payload)
+		}
+	}
+}
+
+// handleWebSocket streams every published sample to the client as JSON
+func (s *APIServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		debugLog.Warn("websocket handshake failed", "remote", r.RemoteAddr, "err", err)
+		return
+	}
+	debugLog.Info("websocket client connected", "remote", r.RemoteAddr)
+	sub := s.hub.subscribe(wsClientQueue)
+	closed := make(chan struct{})
+	go func() {
+		ws.readLoop()
+		close(closed)
+		s.hub.unsubscribe(sub)
+	}()
+	// Send the current snapshot so the page is not empty until the next tick
+	s.mu.RLock()
+	latest := make([]Sample, 0, len(s.latest))
+	for _, host := range s.hosts() {
+		latest = append(latest, s.latest[host])
+	}
+	s.mu.RUnlock()
+	for _, sample := range latest {
+		sample.Time = zoned(sample.Time)
+		if data, err := json.Marshal(sample); err == nil {
+			ws.writeFrame(wsOpText, data)
+		}
+	}
+	for sample := range sub.C {
+		sample.Time = zoned(sample.Time)
+		data, err := json.Marshal(sample)
+		if err != nil {
+			continue
+		}
+		if err := ws.writeFrame(wsOpText, data); err != nil {
+			break
+		}
+	}
+	s.hub.unsubscribe(sub)
+	select {
+	case <-closed:
+	default:
+		// Server side shutdown or write failure
+		ws.writeFrame(wsOpClose, nil)
+	}
+	ws.conn.Close()
+	debugLog.Info("websocket client disconnected", "remote", r.RemoteAddr)
+}
+
+func handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	io.WriteString(w, indexHTML)
+}
+
+const indexHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>mi-top</title>
+<style>
+body { background: #111; color: #ddd; font-family: monospace; margin: 1em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { padding: 2px 10px; text-align: right; border-bottom: 1px solid #333; }
+th { color: #8f8; }
+td.name { text-align: left; }
+.bar { display: inline-block; height: 0.8em; background: #4c4; }
+#status { color: #888; }
+</style>
+</head>
+<body>
+<h2>mi-top <span id="status">connecting…</span></h2>
+<table id="gpus"></table>
+<table id="procs"></table>
+<script>
+var MB = 1024 * 1024;
+function row(cells, tag) {
+  return "<tr>" + cells.map(function (c) { return "<" + tag + ">" + c + "</" + tag + ">"; }).join("") + "</tr>";
+}
+function esc(s) {
+  return String(s).replace(/[&<>"]/g, function (c) { return {"&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;"}[c]; });
+}
+function render(s) {
+  document.getElementById("status").textContent = new Date(s.timestamp).toLocaleTimeString();
+  var g = row(["GPU", "Util", "", "Power W", "Temp °C", "Mem °C", "GFX MHz", "VRAM MB"], "th");
+  (s.gpus || []).forEach(function (m) {
+    g += row([m.id, m.gfx_util.toFixed(1) + "%",
+      '<span class="bar" style="width:' + Math.round(m.gfx_util) + 'px"></span>',
+      m.power.toFixed(1), m.gpu_temp.toFixed(1), m.mem_temp.toFixed(1), m.gfx_clock.toFixed(0),
+      m.vram_used.toFixed(0) + " / " + m.vram_total.toFixed(0)], "td");
+  });
+  document.getElementById("gpus").innerHTML = g;
+  var p = row(["GPU", "Name", "PID", "VRAM MB", "GTT MB", "Total MB", "GFX"], "th");
+  (s.processes || []).forEach(function (pr) {
+    p += row([pr.gpu, '<span class="name">' + esc(pr.name) + "</span>", pr.pid,
+      (pr.vram_bytes / MB).toFixed(1), (pr.gtt_bytes / MB).toFixed(1), (pr.total_bytes / MB).toFixed(1),
+      pr.gfx_usage.toFixed(0) + "%"], "td");
+  });
+  document.getElementById("procs").innerHTML = p;
+}
+function connect() {
+  var ws = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/ws");
+  ws.onmessage = function (e) { render(JSON.parse(e.data)); };
+  ws.onclose = function () {
+    document.getElementById("status").textContent = "disconnected, retrying…";
+    setTimeout(connect, 2000);
+  };
+}
+connect();
+</script>
+</body>
+</html>
+`